@@ -0,0 +1,104 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// inflight tracks the named timers that dispatched but not acknowledged.
+//
+// The named timers are fired at least once: a timer is added when its task is
+// dispatched, and removed by Timer.Ack once the task is confirmed completed.
+// SaveTo writes the timers still in flight, and RestoreFrom fires them again,
+// thus a task interrupted by a crash is not lost.
+type inflight struct {
+	mu     sync.Mutex
+	timers map[uint64]*Timer
+}
+
+func newInflight() *inflight {
+	return &inflight{timers: make(map[uint64]*Timer)}
+}
+
+func (f *inflight) add(t *Timer) {
+	f.mu.Lock()
+	f.timers[t.ID()] = t
+	f.mu.Unlock()
+}
+
+// remove removes t, it returns false if t is not in flight.
+func (f *inflight) remove(t *Timer) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.timers[t.ID()] != t {
+		return false
+	}
+	delete(f.timers, t.ID())
+	return true
+}
+
+// contains returns whether t is in flight.
+func (f *inflight) contains(t *Timer) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.timers[t.ID()] == t
+}
+
+// snapshot returns the timers in flight.
+func (f *inflight) snapshot() []*Timer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	timers := make([]*Timer, 0, len(f.timers))
+	for _, t := range f.timers {
+		timers = append(timers, t)
+	}
+	return timers
+}
+
+// Ack confirms that the task of the named timer (see AfterTask) is completed,
+// so that it's no longer written by SaveTo as in flight. It returns false if
+// the timer is not in flight, e.g. it's not dispatched or already acknowledged.
+//
+// The task is acknowledged automatically once it returns normally, the Ack is
+// for the caller that confirms the completion by other means. A task that
+// panicked stays in flight.
+func (t *Timer) Ack() bool {
+	if t.tw == nil {
+		return false
+	}
+	if !t.tw.root.inflight.remove(t) {
+		return false
+	}
+	if atomic.LoadPointer(&t.done) == unsafe.Pointer(&closedC) {
+		// Acknowledged after finished, see finish.
+		t.tw.storeDelete(t)
+	}
+	return true
+}
+
+// Redeliveries returns the number of times that the timer is fired again
+// since the previous fires are not acknowledged.
+func (t *Timer) Redeliveries() uint32 {
+	return t.getAttrs().redeliveries
+}
+
+type timerInfoKey struct{}
+
+// TimerInfoFromContext returns the TimerInfo of the named timer whose task
+// receives the ctx. The handler may check its Redeliveries to give up the
+// task that keeps failing.
+func TimerInfoFromContext(ctx context.Context) (TimerInfo, bool) {
+	t, ok := ctx.Value(timerInfoKey{}).(*Timer)
+	if !ok {
+		return TimerInfo{}, false
+	}
+	return TimerInfo{Tag: t.Tag(), Expiration: time.Unix(0, t.getExpiration()), Redeliveries: t.Redeliveries()}, true
+}
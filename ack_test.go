@@ -0,0 +1,135 @@
+package timewheel
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/yu31/timewheel/timerspec"
+)
+
+func TestTimer_Ack(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	r := NewTaskRegistry()
+	r.Register("block", func(context.Context, []byte) {
+		close(started)
+		<-release
+	})
+
+	tw := New(time.Millisecond, 8, WithTaskRegistry(r))
+	tw.Start()
+	defer tw.Stop()
+
+	timer, err := tw.AfterTask(time.Millisecond, "block", nil)
+	require.NoError(t, err)
+	require.False(t, timer.Ack())
+
+	<-started
+	require.Len(t, tw.root.inflight.snapshot(), 1)
+	require.True(t, timer.Ack())
+	require.False(t, timer.Ack())
+	require.Len(t, tw.root.inflight.snapshot(), 0)
+
+	close(release)
+	<-timer.Done()
+	require.Len(t, tw.root.inflight.snapshot(), 0)
+	require.False(t, tw.AfterFunc(time.Hour, func() {}).Ack())
+	require.False(t, (&Timer{}).Ack())
+}
+
+func TestTimer_Ack_Return(t *testing.T) {
+	r := NewTaskRegistry()
+	r.Register("noop", func(context.Context, []byte) {})
+
+	tw := New(time.Millisecond, 8, WithTaskRegistry(r))
+	tw.Start()
+	defer tw.Stop()
+
+	timer, err := tw.AfterTask(time.Millisecond, "noop", nil)
+	require.NoError(t, err)
+	<-timer.Done()
+	require.Len(t, tw.root.inflight.snapshot(), 0)
+}
+
+// crashingRegistry registers the "job" that reports its TimerInfo and never
+// completes until the crashed is closed, as if the process crashed while it's running.
+func crashingRegistry(infoC chan<- TimerInfo, crashed <-chan struct{}) *TaskRegistry {
+	r := NewTaskRegistry()
+	r.Register("job", func(ctx context.Context, _ []byte) {
+		info, _ := TimerInfoFromContext(ctx)
+		infoC <- info
+		<-crashed
+	})
+	return r
+}
+
+// TestTimer_Ack_Crash simulates the crashes while the task is running: the
+// state is saved before the task returned, and the process is gone without
+// the acknowledgement.
+func TestTimer_Ack_Crash(t *testing.T) {
+	infoC := make(chan TimerInfo, 1)
+	crashed := make(chan struct{})
+	defer close(crashed)
+	var buf bytes.Buffer
+
+	// Crash in the first fire and in the redelivery.
+	for i := uint32(0); i < 2; i++ {
+		tw := New(time.Millisecond, 8, WithTaskRegistry(crashingRegistry(infoC, crashed)))
+		tw.Start()
+		if i == 0 {
+			_, err := tw.AfterTask(time.Millisecond, "job", []byte("work"), WithTag("poison"))
+			require.NoError(t, err)
+			_, err = tw.AfterTask(time.Hour, "job", nil)
+			require.NoError(t, err)
+		} else {
+			n, err := tw.RestoreFrom(bytes.NewReader(buf.Bytes()))
+			require.NoError(t, err)
+			require.Equal(t, 2, n)
+		}
+
+		info := <-infoC
+		require.Equal(t, "poison", info.Tag)
+		require.Equal(t, i, info.Redeliveries)
+
+		buf.Reset()
+		n, err := tw.SaveTo(&buf)
+		require.NoError(t, err)
+		require.Equal(t, 2, n)
+		tw.Stop()
+	}
+
+	// Recover finally, the task completes and is acknowledged.
+	done := make(chan TimerInfo, 1)
+	r := NewTaskRegistry()
+	r.Register("job", func(ctx context.Context, payload []byte) {
+		require.Equal(t, []byte("work"), payload)
+		info, ok := TimerInfoFromContext(ctx)
+		require.True(t, ok)
+		done <- info
+	})
+	tw := New(time.Millisecond, 8, WithTaskRegistry(r))
+	tw.Start()
+	defer tw.Stop()
+	_, err := tw.RestoreFrom(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), (<-done).Redeliveries)
+	require.Eventually(t, func() bool { return len(tw.root.inflight.snapshot()) == 0 }, time.Second, time.Millisecond)
+
+	buf.Reset()
+	n, err := tw.SaveTo(&buf)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	spec, err := timerspec.NewReader(&buf).Read()
+	require.NoError(t, err)
+	require.False(t, spec.InFlight)
+	require.Equal(t, uint32(0), spec.Redeliveries)
+}
+
+func TestTimerInfoFromContext(t *testing.T) {
+	_, ok := TimerInfoFromContext(context.Background())
+	require.False(t, ok)
+}
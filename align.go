@@ -0,0 +1,48 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"time"
+)
+
+// AlignTo aligns the TimeWheel to the wall-clock multiples of d, e.g. the
+// minute boundaries. The d must be a multiple of the tick, so that the buckets
+// of the lowest level expire exactly at each boundary, otherwise New panics
+// with ErrInvalidAlign.
+//
+// The recurrences built by Every without StartingAt execute for the first time
+// at the next boundary rather than one interval after Do, and the following
+// executions are every interval from there. Thus, an Every(time.Minute) on a
+// TimeWheel aligned to the minute executes at the :00 second of each minute,
+// starting from the end of the current partial minute.
+//
+// The boundaries are the multiples of d since the Unix epoch, i.e. in UTC.
+// Since the local time zones are offset by whole hours or minutes in general,
+// it makes no difference for d up to a minute or an hour.
+func AlignTo(d time.Duration) Option {
+	if d <= 0 {
+		panic("timewheel: alignment must be greater than 0")
+	}
+	return func(o *options) {
+		o.align = d
+	}
+}
+
+// nextBoundary returns the first boundary set by AlignTo that is after t,
+// or t itself if the TimeWheel is not aligned.
+func (tw *TimeWheel) nextBoundary(t time.Time) time.Time {
+	d := int64(tw.root.opts.align)
+	if d <= 0 {
+		return t
+	}
+	ns := t.UnixNano()
+	next := ns - ns%d
+	if ns < 0 && ns%d != 0 {
+		next -= d
+	}
+	return time.Unix(0, next+d)
+}
@@ -0,0 +1,60 @@
+package timewheel
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeWheel_nextBoundary(t *testing.T) {
+	tw := New(time.Millisecond, 8, AlignTo(time.Minute))
+
+	base := time.Date(2024, 1, 1, 10, 30, 0, 0, time.UTC)
+	next := base.Add(time.Minute).UnixNano()
+	require.Equal(t, next, tw.nextBoundary(base).UnixNano())
+	require.Equal(t, next, tw.nextBoundary(base.Add(time.Nanosecond)).UnixNano())
+	require.Equal(t, next, tw.nextBoundary(base.Add(time.Second*59)).UnixNano())
+	require.Equal(t, int64(0), tw.nextBoundary(time.Unix(-1, 0)).UnixNano())
+
+	// Not aligned.
+	require.Equal(t, base.Add(time.Second), New(time.Millisecond, 8).nextBoundary(base.Add(time.Second)))
+}
+
+func TestAlignTo(t *testing.T) {
+	align := time.Millisecond * 50
+	tw := New(time.Millisecond, 8, AlignTo(align))
+	tw.Start()
+	defer tw.Stop()
+
+	var mu sync.Mutex
+	var fired []time.Time
+	do := time.Now()
+	timer, err := tw.Every(align * 2).Times(3).Do(func() {
+		mu.Lock()
+		fired = append(fired, time.Now())
+		mu.Unlock()
+	})
+	require.NoError(t, err)
+	<-timer.Done()
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, fired, 3)
+	// The first execution is at the end of the partial period.
+	require.Less(t, int64(fired[0].Sub(do)), int64(align+time.Millisecond*20))
+	for _, at := range fired {
+		require.Less(t, at.UnixNano()%int64(align), int64(time.Millisecond*20), at)
+	}
+}
+
+func TestAlignTo_Invalid(t *testing.T) {
+	_, err := TryNew(time.Millisecond*10, 8, AlignTo(time.Millisecond*15))
+	require.True(t, errors.Is(err, ErrInvalidAlign))
+	require.Panics(t, func() { AlignTo(0) })
+
+	_, err = TryNew(time.Millisecond*10, 8, AlignTo(time.Second))
+	require.NoError(t, err)
+}
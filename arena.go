@@ -0,0 +1,223 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// Handle identifies a timer armed in the arena of a TimeWheel created with
+// WithCapacity, see Arm. It's the index of the slot with the generation of the
+// slot, thus a stale Handle never refers to the timer that reuses the slot.
+// The zero Handle is never returned by a successful Arm.
+type Handle uint64
+
+func newHandle(index, gen uint32) Handle {
+	return Handle(uint64(gen)<<32 | uint64(index))
+}
+
+func (h Handle) index() uint32 { return uint32(h) }
+
+func (h Handle) gen() uint32 { return uint32(h >> 32) }
+
+// arena holds the preallocated timers of a TimeWheel created with WithCapacity.
+// The slots are never moved or freed, a finished slot is put back to the free
+// list by its index and reused by the next Arm.
+type arena struct {
+	slots []arenaSlot
+
+	// The mu protects the free list and the generations of the slots.
+	mu   sync.Mutex
+	free []uint32
+}
+
+// arenaSlot is a timer with everything it needs to be scheduled, such that
+// arming it allocates nothing.
+type arenaSlot struct {
+	timer   Timer
+	attrs   attrs
+	element timerElement
+
+	// The generation is increased each time the slot is released, it's
+	// protected by the mu of arena but also read atomically by Armed.
+	gen uint32
+	// The func of the current arming, it's only written before the timer is
+	// scheduled.
+	f func()
+	// The run is passed to dispatch, it's created once with the slot.
+	run func(ctx context.Context)
+}
+
+func newArena(tw *TimeWheel, n int) *arena {
+	a := &arena{slots: make([]arenaSlot, n), free: make([]uint32, n)}
+	for i := range a.slots {
+		s := &a.slots[i]
+		t := &s.timer
+		index := uint32(i)
+
+		s.gen = 1
+		s.attrs.element = &s.element
+		s.attrs.onFinish = func() { a.release(index) }
+		s.run = func(ctx context.Context) {
+			s.f()
+			t.complete()
+		}
+		t.tw = tw
+		t.attrs = &s.attrs
+		t.task = func() {
+			tw.dispatch(context.Background(), t, s.run)
+		}
+		// The slots are taken from the front in order.
+		a.free[n-1-i] = index
+	}
+	return a
+}
+
+// acquire takes a free slot, it returns false if the arena is exhausted.
+func (a *arena) acquire() (*arenaSlot, Handle, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	n := len(a.free)
+	if n == 0 {
+		return nil, 0, false
+	}
+	index := a.free[n-1]
+	a.free = a.free[:n-1]
+	s := &a.slots[index]
+	return s, newHandle(index, s.gen), true
+}
+
+// release puts the finished slot back to the free list, the handles of it are
+// invalid since then.
+func (a *arena) release(index uint32) {
+	s := &a.slots[index]
+	a.mu.Lock()
+	gen := s.gen + 1
+	if gen == 0 {
+		gen = 1
+	}
+	atomic.StoreUint32(&s.gen, gen)
+	s.f = nil
+	a.free = append(a.free, index)
+	a.mu.Unlock()
+}
+
+// lookup returns the slot that h refers to, it returns nil if h is out of range.
+func (a *arena) lookup(h Handle) *arenaSlot {
+	if i := h.index(); int(i) < len(a.slots) {
+		return &a.slots[i]
+	}
+	return nil
+}
+
+// Arm takes a preallocated timer from the arena to execute f in its own
+// goroutine (see DispatchPolicy) after duration d, and returns the Handle of
+// it. It returns ErrFull if all the timers of the arena are in use, or the
+// error why the timer is rejected, e.g. ErrShed.
+//
+// The timer is put back to the arena once it's finished, i.e. its task
+// returned or it's disarmed, and the Handle is invalid since then. Arm and
+// Disarm allocate nothing, but the delay queue allocates once per bucket that
+// enqueued (not per timer), each bucket is allocated on the first insert into
+// its slot, and the DispatchGoroutine creates a goroutine per task; use
+// DispatchInline with WithClock for no allocation once the slots are in use.
+//
+// It panics if the TimeWheel is created without the WithCapacity option.
+func (tw *TimeWheel) Arm(d time.Duration, f func()) (Handle, error) {
+	a := tw.root.arena
+	if a == nil {
+		panic("timewheel: Arm requires the WithCapacity option")
+	}
+	s, h, ok := a.acquire()
+	if !ok {
+		atomic.AddUint64(&tw.root.rejected, 1)
+		return 0, ErrFull
+	}
+
+	t := &s.timer
+	s.f = f
+	s.attrs.err = nil
+	atomic.StoreUint64(&t.meta, newMeta(tw.nextID(), 0, EndNone))
+	atomic.StorePointer(&t.done, unsafe.Pointer(nil))
+	t.setExpiration(tw.expireAfter(d))
+
+	if err := tw.enter(); err != nil {
+		tw.reject(t, err)
+		return 0, err
+	}
+	if err := tw.admit(t); err != nil {
+		tw.leave()
+		tw.reject(t, err)
+		return 0, err
+	}
+	tw.schedule(t)
+	tw.leave()
+	return h, nil
+}
+
+// Disarm prevents the timer of h from firing like Timer.Close, and puts it
+// back to the arena. It returns false if the timer has been finished or its
+// task has been dispatched, or h is invalid.
+func (tw *TimeWheel) Disarm(h Handle) bool {
+	a := tw.root.arena
+	if a == nil {
+		return false
+	}
+	s := a.lookup(h)
+	if s == nil {
+		return false
+	}
+
+	// Hold the mu while cancelling, so that the slot can't be released and
+	// reused in the meantime by another Arm.
+	a.mu.Lock()
+	ok := s.gen == h.gen() && s.timer.cancel(true)
+	a.mu.Unlock()
+	if ok {
+		s.timer.finish()
+	}
+	return ok
+}
+
+// Rearm changes the pending timer of h to expire after duration d like
+// Timer.RescheduleIfPending. It returns false if the timer has expired or been
+// finished, or h is invalid, e.g. its slot has been reused by another Arm.
+func (tw *TimeWheel) Rearm(h Handle, d time.Duration) bool {
+	a := tw.root.arena
+	if a == nil {
+		return false
+	}
+	s := a.lookup(h)
+	if s == nil {
+		return false
+	}
+	expiration := tw.expireAfter(d)
+
+	// The slot can't be released once unlinked until it's submitted again,
+	// since it's neither fired nor cancelled meanwhile.
+	a.mu.Lock()
+	ok := s.gen == h.gen() && s.timer.unlink()
+	a.mu.Unlock()
+	if ok {
+		s.timer.reschedule(expiration)
+	}
+	return ok
+}
+
+// Armed reports whether the timer of h is not finished yet, i.e. it's pending
+// or its task is running.
+func (tw *TimeWheel) Armed(h Handle) bool {
+	a := tw.root.arena
+	if a == nil {
+		return false
+	}
+	s := a.lookup(h)
+	return s != nil && atomic.LoadUint32(&s.gen) == h.gen()
+}
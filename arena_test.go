@@ -0,0 +1,193 @@
+package timewheel
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithCapacity(t *testing.T) {
+	require.Panics(t, func() { WithCapacity(0) })
+	require.Panics(t, func() { New(time.Millisecond, 8).Arm(time.Millisecond, func() {}) })
+
+	tw := New(time.Millisecond, 8, WithCapacity(2))
+	tw.Start()
+	defer tw.Stop()
+
+	var fired int32
+	h1, err := tw.Arm(time.Millisecond, func() { atomic.AddInt32(&fired, 1) })
+	require.NoError(t, err)
+	h2, err := tw.Arm(time.Hour, func() { atomic.AddInt32(&fired, 1) })
+	require.NoError(t, err)
+	require.NotEqual(t, Handle(0), h1)
+	require.NotEqual(t, h1, h2)
+
+	// The arena is exhausted.
+	h3, err := tw.Arm(time.Millisecond, func() {})
+	require.Equal(t, ErrFull, err)
+	require.Equal(t, Handle(0), h3)
+	require.Equal(t, uint64(1), tw.Stats().Rejected)
+
+	require.Eventually(t, func() bool { return !tw.Armed(h1) }, time.Second, time.Millisecond)
+	require.Equal(t, int32(1), atomic.LoadInt32(&fired))
+	require.True(t, tw.Armed(h2))
+
+	// The finished slot is reused, with a new generation.
+	h4, err := tw.Arm(time.Hour, func() {})
+	require.NoError(t, err)
+	require.Equal(t, h1.index(), h4.index())
+	require.NotEqual(t, h1, h4)
+	require.False(t, tw.Disarm(h1))
+	require.True(t, tw.Armed(h4))
+
+	require.True(t, tw.Disarm(h2))
+	require.False(t, tw.Disarm(h2))
+	require.False(t, tw.Armed(h2))
+	require.True(t, tw.Disarm(h4))
+	require.Equal(t, int64(0), tw.Pending())
+	require.Equal(t, int32(1), atomic.LoadInt32(&fired))
+
+	// The handle out of range is invalid.
+	require.False(t, tw.Armed(newHandle(5, 1)))
+	require.False(t, tw.Disarm(newHandle(5, 1)))
+}
+
+func TestWithCapacity_NoAlloc(t *testing.T) {
+	clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	tw := New(time.Millisecond, 64, WithCapacity(16), WithClock(clock), WithDispatchPolicy(DispatchInline))
+	tw.Start()
+	defer tw.Stop()
+
+	f := func() {}
+	// The buckets are allocated on the first insert into their slots.
+	for i := int64(0); i < tw.size; i++ {
+		tw.ensureBucket(i)
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		h, err := tw.Arm(time.Millisecond*5, f)
+		if err != nil {
+			panic(err)
+		}
+		tw.Disarm(h)
+	})
+	require.Equal(t, float64(0), allocs)
+
+	allocs = testing.AllocsPerRun(100, func() {
+		if _, err := tw.Arm(time.Millisecond*5, f); err != nil {
+			panic(err)
+		}
+		clock.add(time.Millisecond * 5)
+		tw.Poll()
+	})
+	require.Equal(t, float64(0), allocs)
+	require.Equal(t, int64(0), tw.Pending())
+}
+
+func TestWithCapacity_Stale(t *testing.T) {
+	tw := New(time.Millisecond, 8, WithCapacity(4))
+	tw.Start()
+	defer tw.Stop()
+
+	// The stale handles never disarm the timers that reuse their slots.
+	var wg sync.WaitGroup
+	var fired int64
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				h, err := tw.Arm(time.Millisecond, func() { atomic.AddInt64(&fired, 1) })
+				if err != nil {
+					continue
+				}
+				if j%2 == 0 {
+					tw.Disarm(h)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	require.Eventually(t, func() bool { return tw.Pending() == 0 }, time.Second, time.Millisecond)
+	a := tw.root.arena
+	require.Eventually(t, func() bool {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		return len(a.free) == 4
+	}, time.Second, time.Millisecond)
+	s := tw.Stats()
+	require.Equal(t, s.Scheduled, uint64(atomic.LoadInt64(&fired))+s.Cancelled)
+}
+
+func TestWithCapacity_Recycled(t *testing.T) {
+	clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	tw := New(time.Millisecond, 8, WithCapacity(1), WithClock(clock), WithDispatchPolicy(DispatchInline))
+	tw.Start()
+	defer tw.Stop()
+
+	var fired []string
+	stale, err := tw.Arm(time.Millisecond, func() { fired = append(fired, "x") })
+	require.NoError(t, err)
+	require.True(t, tw.Rearm(stale, time.Millisecond*2))
+	clock.add(time.Millisecond * 2)
+	tw.Poll()
+	require.Equal(t, []string{"x"}, fired)
+
+	// The slot is recycled between obtaining the handle and using it.
+	h, err := tw.Arm(time.Millisecond, func() { fired = append(fired, "y") })
+	require.NoError(t, err)
+	require.Equal(t, stale.index(), h.index())
+	require.False(t, tw.Disarm(stale))
+	require.False(t, tw.Rearm(stale, time.Hour))
+	require.True(t, tw.Armed(h))
+	require.False(t, tw.Armed(stale))
+
+	clock.add(time.Millisecond)
+	tw.Poll()
+	require.Equal(t, []string{"x", "y"}, fired)
+	require.False(t, tw.Rearm(h, time.Hour))
+	require.False(t, tw.Rearm(newHandle(5, 1), time.Hour))
+	require.False(t, New(time.Millisecond, 8).Rearm(h, time.Hour))
+}
+
+func TestWithCapacity_RecycledConcurrently(t *testing.T) {
+	tw := New(time.Millisecond, 8, WithCapacity(2))
+	tw.Start()
+	defer tw.Stop()
+
+	// The stale handles are used while their slots are being recycled by the
+	// others, each armed timer fires exactly once or is disarmed by its own
+	// handle.
+	handles := make(chan Handle, 1024)
+	var armed, fired, disarmed int64
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer close(handles)
+		for i := 0; i < 1000; i++ {
+			h, err := tw.Arm(time.Millisecond, func() { atomic.AddInt64(&fired, 1) })
+			if err == nil {
+				armed++
+				handles <- h
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for h := range handles {
+			time.Sleep(time.Duration(h.index()) * time.Microsecond * 100)
+			tw.Rearm(h, time.Millisecond)
+			if tw.Disarm(h) {
+				atomic.AddInt64(&disarmed, 1)
+			}
+		}
+	}()
+	wg.Wait()
+	require.Eventually(t, func() bool { return tw.Pending() == 0 }, time.Second, time.Millisecond)
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt64(&fired)+atomic.LoadInt64(&disarmed) == armed
+	}, time.Second, time.Millisecond)
+}
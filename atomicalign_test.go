@@ -0,0 +1,160 @@
+package timewheel
+
+import (
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestAtomicAlign checks that the fields accessed by the 64-bit atomic
+// functions are 8-byte aligned on the 32-bit platforms, where the misaligned
+// ones panic. The package is type-checked with the sizes of each platform,
+// thus it's checked on any platform the test runs; placing such a field after
+// a pointer, or a struct holding such fields in a slice of the odd size, breaks
+// the test.
+func TestAtomicAlign(t *testing.T) {
+	if testing.Short() {
+		t.Skip("type-checks the package from the source")
+	}
+	fset := token.NewFileSet()
+	names, err := filepath.Glob("*.go")
+	require.NoError(t, err)
+	var files []*ast.File
+	for _, name := range names {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, name, nil, 0)
+		require.NoError(t, err)
+		files = append(files, f)
+	}
+
+	// The dependencies are type-checked from the source as well, without the
+	// cgo for any platform.
+	build.Default.CgoEnabled = false
+	imp := importer.ForCompiler(fset, "source", nil)
+	for _, arch := range []string{"386", "arm", "mips"} {
+		conf := types.Config{Importer: imp, Sizes: types.SizesFor("gc", arch)}
+		info := &types.Info{Uses: map[*ast.Ident]types.Object{}, Selections: map[*ast.SelectorExpr]*types.Selection{}}
+		pkg, err := conf.Check("github.com/yu31/timewheel", fset, files, info)
+		require.NoError(t, err)
+
+		c := &alignChecker{sizes: conf.Sizes, atomics: atomicFields(files, info), seen: map[types.Type]bool{}}
+		require.NotEmpty(t, c.atomics)
+		for _, name := range pkg.Scope().Names() {
+			tn, ok := pkg.Scope().Lookup(name).(*types.TypeName)
+			if !ok {
+				continue
+			}
+			if n, ok := tn.Type().(*types.Named); ok && n.TypeParams().Len() != 0 {
+				// The generic types are checked by their instances.
+				continue
+			}
+			c.check(tn.Type(), 0, name)
+		}
+		require.Empty(t, c.errors, "GOARCH=%s", arch)
+	}
+}
+
+// atomicFields returns the struct fields whose address is passed to the 64-bit
+// atomic functions.
+func atomicFields(files []*ast.File, info *types.Info) map[*types.Var]bool {
+	fields := map[*types.Var]bool{}
+	for _, f := range files {
+		ast.Inspect(f, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) == 0 {
+				return true
+			}
+			fun, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok || !strings.HasSuffix(fun.Sel.Name, "64") {
+				return true
+			}
+			if x, ok := fun.X.(*ast.Ident); !ok {
+				return true
+			} else if p, ok := info.Uses[x].(*types.PkgName); !ok || p.Imported().Path() != "sync/atomic" {
+				return true
+			}
+			addr, ok := call.Args[0].(*ast.UnaryExpr)
+			if !ok || addr.Op != token.AND {
+				return true
+			}
+			if sel, ok := addr.X.(*ast.SelectorExpr); ok {
+				if s := info.Selections[sel]; s != nil && s.Kind() == types.FieldVal {
+					fields[s.Obj().(*types.Var)] = true
+				}
+			}
+			return true
+		})
+	}
+	return fields
+}
+
+type alignChecker struct {
+	sizes   types.Sizes
+	atomics map[*types.Var]bool
+	seen    map[types.Type]bool
+	errors  []string
+}
+
+// check checks the type T placed at the offset of an allocation.
+func (c *alignChecker) check(T types.Type, offset int64, path string) {
+	if _, ok := T.(*types.TypeParam); ok {
+		return
+	}
+	switch u := T.Underlying().(type) {
+	case *types.Struct:
+		fields := make([]*types.Var, u.NumFields())
+		for i := range fields {
+			fields[i] = u.Field(i)
+		}
+		offsets := c.sizes.Offsetsof(fields)
+		for i, f := range fields {
+			p := path + "." + f.Name()
+			if c.atomics[f] && (offset+offsets[i])%8 != 0 {
+				c.errors = append(c.errors, p+" is not 8-byte aligned")
+			}
+			c.check(f.Type(), offset+offsets[i], p)
+		}
+	case *types.Array:
+		c.element(u.Elem(), path)
+		c.check(u.Elem(), offset, path+"[0]")
+	case *types.Slice:
+		// The backing array is allocated apart.
+		if !c.seen[u.Elem()] {
+			c.seen[u.Elem()] = true
+			c.element(u.Elem(), path)
+			c.check(u.Elem(), 0, path+"[0]")
+		}
+	}
+}
+
+// element checks the element type T of an array, each of them is aligned if
+// the size of T is a multiple of 8 bytes.
+func (c *alignChecker) element(T types.Type, path string) {
+	if c.holdsAtomic(T) && c.sizes.Sizeof(T)%8 != 0 {
+		c.errors = append(c.errors, path+" has the elements of the size not a multiple of 8 bytes")
+	}
+}
+
+func (c *alignChecker) holdsAtomic(T types.Type) bool {
+	switch u := T.Underlying().(type) {
+	case *types.Struct:
+		for i := 0; i < u.NumFields(); i++ {
+			if c.atomics[u.Field(i)] || c.holdsAtomic(u.Field(i).Type()) {
+				return true
+			}
+		}
+	case *types.Array:
+		return c.holdsAtomic(u.Elem())
+	}
+	return false
+}
@@ -2,6 +2,9 @@ package timewheel
 
 import (
 	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 	"unsafe"
@@ -29,7 +32,7 @@ func Test_bucket_insert(t *testing.T) {
 	require.Equal(t, timers.Len(), n)
 
 	for e := timers.Front(); e != nil; e = e.Next() {
-		timer := e.Value.(*Timer)
+		timer := e.Value
 
 		require.Equal(t, timer.element, e)
 		require.Equal(t, timer.getBucket(), b)
@@ -39,13 +42,13 @@ func Test_bucket_insert(t *testing.T) {
 func Test_bucket_flush_switch(t *testing.T) {
 	b := newBucket()
 
-	b.insert(&Timer{})
-	b.insert(&Timer{})
+	b.push(&Timer{}, 1)
+	b.push(&Timer{}, 1)
 	p1 := unsafe.Pointer(b.timers)
 
 	require.Equal(t, b.timers.Len(), 2)
 
-	b.flush(func(*Timer) {})
+	require.True(t, b.flush(1, func(*Timer) {}))
 	p2 := unsafe.Pointer(b.timers)
 
 	require.Equal(t, b.timers.Len(), 0)
@@ -57,16 +60,44 @@ func Test_bucket_flush_reinsert(t *testing.T) {
 
 	n := 17
 	for i := 0; i < n; i++ {
-		b.insert(&Timer{})
+		b.push(&Timer{}, 1)
 	}
 
 	require.Equal(t, b.timers.Len(), n)
 
-	b.flush(b.insert)
+	b.flush(1, b.insert)
 
 	require.Equal(t, b.timers.Len(), n)
 }
 
+func Test_bucket_push(t *testing.T) {
+	b := newBucket()
+
+	// Only the first push enqueues b, the later rotations of the slot join it.
+	require.Equal(t, pushEnqueue, b.push(&Timer{}, 8))
+	require.Equal(t, pushCoalesced, b.push(&Timer{}, 8))
+	require.Equal(t, pushCoalesced, b.push(&Timer{}, 16))
+	require.Equal(t, int64(8), b.getExpiration())
+	require.Equal(t, int32(1), b.enqueued)
+
+	// An earlier rotation is routed against a stale current, it's not added.
+	b2 := newBucket()
+	require.Equal(t, pushEnqueue, b2.push(&Timer{}, 16))
+	require.Equal(t, pushStale, b2.push(&Timer{}, 8))
+	require.Equal(t, 1, b2.timers.Len())
+	result, locked := b2.tryPush(&Timer{}, 8, 0)
+	require.True(t, locked)
+	require.Equal(t, pushStale, result)
+
+	// The stale dequeues flush nothing.
+	require.False(t, b.flush(16, b.insert))
+	require.Equal(t, 3, b.timers.Len())
+	require.True(t, b.flush(8, func(*Timer) {}))
+	require.Equal(t, int32(0), b.enqueued)
+	require.False(t, b.flush(8, func(*Timer) { t.Fatal("flushed twice") }))
+	require.Equal(t, pushEnqueue, b.push(&Timer{}, 16))
+}
+
 // Test for display flush use time.
 func Test_bucket_flush_elapse(t *testing.T) {
 	b := newBucket()
@@ -83,10 +114,10 @@ func Test_bucket_flush_elapse(t *testing.T) {
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
 			for i := 0; i < c.N; i++ {
-				b.insert(&Timer{})
+				b.push(&Timer{}, 1)
 			}
 			start := time.Now()
-			b.flush(func(timer *Timer) {
+			b.flush(1, func(timer *Timer) {
 				_ = timer
 			})
 			elapse := time.Since(start)
@@ -95,3 +126,41 @@ func Test_bucket_flush_elapse(t *testing.T) {
 		})
 	}
 }
+
+func Test_bucket_padded(t *testing.T) {
+	require.Equal(t, uintptr(0), unsafe.Sizeof(paddedBucket{})%cacheLineSize)
+}
+
+// newPackedBucket creates a bucket without padding, as a baseline of BenchmarkBucket_Parallel.
+func newPackedBucket() *bucket {
+	return &bucket{
+		expiration: -1,
+		timers:     newTimerList(),
+		mu:         new(sync.Mutex),
+		flushMu:    new(sync.Mutex),
+	}
+}
+
+// BenchmarkBucket_Parallel runs GOMAXPROCS goroutines, each inserts into and
+// deletes from a distinct bucket. Any difference between the sub-benchmarks is
+// caused by the false sharing, it only shows with multiple cores.
+func BenchmarkBucket_Parallel(b *testing.B) {
+	run := func(b *testing.B, create func() *bucket) {
+		buckets := make([]*bucket, runtime.GOMAXPROCS(0))
+		for i := range buckets {
+			buckets[i] = create()
+		}
+		var next int32 = -1
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			bk := buckets[int(atomic.AddInt32(&next, 1))%len(buckets)]
+			timer := &Timer{}
+			for pb.Next() {
+				bk.insert(timer)
+				bk.delete(timer)
+			}
+		})
+	}
+	b.Run("packed", func(b *testing.B) { run(b, newPackedBucket) })
+	b.Run("padded", func(b *testing.B) { run(b, newBucket) })
+}
@@ -6,17 +6,36 @@
 package timewheel
 
 import (
-	"container/list"
+	"runtime"
 	"sync"
 	"sync/atomic"
+	"unsafe"
 )
 
 // Each tick(time interval) have a bucket to store all timers(tasks) that belonging to this tick.
 type bucket struct {
 	expiration int64
-	timers     *list.List
+	timers     *timerList
 	mu         *sync.Mutex
 	flushMu    *sync.Mutex // represents whether the bucket is performing flush.
+
+	// The list swapped with the timers by flush and drain, so that no list
+	// is allocated per flush. It's only used while holding the flushMu.
+	spare *timerList
+
+	// The number of times that the bucket is in the queue, it's set to 1 by
+	// the push that enqueues it, and cleared by the flush of its dequeue; a
+	// bucket is never enqueued twice, see push. Protected by mu.
+	enqueued int32
+
+	// The state of the SaveTo of the TimeWheel, and the epoch of the SaveTo
+	// that visited b. The saved is protected by mu, see saveState.
+	save  *saveState
+	saved uint64
+	// The element that the SaveTo in progress resumes from, it's moved to
+	// the next one if removed, and cleared once the timers are swapped out.
+	// Protected by mu.
+	cursor *timerElement
 }
 
 func (b *bucket) getExpiration() int64 {
@@ -27,26 +46,91 @@ func (b *bucket) setExpiration(expiration int64) bool {
 	return atomic.SwapInt64(&b.expiration, expiration) != expiration
 }
 
-// insert add t to the b.timers, it only called by tw.add.
+// insert add t to the b.timers.
 func (b *bucket) insert(t *Timer) {
 	b.mu.Lock()
+	b.insertLocked(t)
+	b.mu.Unlock()
+}
 
-	e := b.timers.PushBack(t)
-	t.setBucket(b)
-	t.element = e
+// The results of pushing a timer into a bucket, see push.
+const (
+	pushCoalesced = iota // b is enqueued already, it's flushed no later than t.
+	pushEnqueue          // b must be enqueued with the expiration.
+	pushStale            // t is not added, it's routed against a stale current.
+)
+
+// push add t to the b.timers and set the expiration of b atomically, it only called by tw.add.
+//
+// Only the push into a bucket that is not in the queue sets the expiration and
+// returns pushEnqueue, the bucket must be enqueued with it then. Otherwise the
+// bucket keeps the expiration it's enqueued with: the timer of a later rotation
+// of the same slot waits in it, and is inserted again once the bucket expires
+// and flushes it. An earlier expiration than the enqueued one is computed from
+// a current that has fallen behind the one of the enqueued push, the timer is
+// not added and it returns pushStale, the caller should route t again.
+func (b *bucket) push(t *Timer, expiration int64) int {
+	b.mu.Lock()
+	result := b.pushLocked(t, expiration)
+	b.mu.Unlock()
+	return result
+}
 
+// tryPush is like push, but it gives up if the lock of b is still contended
+// after spins retries. The locked is false if it gave up, and t is not added.
+func (b *bucket) tryPush(t *Timer, expiration int64, spins int) (result int, locked bool) {
+	for i := 0; !b.mu.TryLock(); i++ {
+		if i >= spins {
+			return 0, false
+		}
+		runtime.Gosched()
+	}
+	result = b.pushLocked(t, expiration)
 	b.mu.Unlock()
+	return result, true
+}
+
+func (b *bucket) pushLocked(t *Timer, expiration int64) int {
+	if b.enqueued == 0 {
+		b.insertLocked(t)
+		b.setExpiration(expiration)
+		b.enqueued = 1
+		return pushEnqueue
+	}
+	if expiration < b.getExpiration() {
+		return pushStale
+	}
+	b.insertLocked(t)
+	return pushCoalesced
+}
+
+func (b *bucket) insertLocked(t *Timer) {
+	var e *timerElement
+	if pe := t.getAttrs().element; pe != nil {
+		// The element is preallocated by the arena.
+		e = b.timers.pushBackElement(pe, t)
+	} else {
+		e = b.timers.PushBack(t)
+	}
+	t.setBucket(b)
+	t.element = e
+	if b.save != nil && b.saved != 0 && b.saved == atomic.LoadUint64(&b.save.active) {
+		// Inserted after visited by the SaveTo in progress.
+		b.save.record(t)
+	}
 }
 
 // delete remove t from the TimeWheel, it only called by t.Close().
 //
-// return true indicates t has been removed from TimeWheel.
-// return false indicates the bucket has changed and can't perform delete operations.
-func (b *bucket) delete(t *Timer) bool {
+// return ok true indicates t has been removed from TimeWheel.
+// return ok false indicates the bucket has changed and can't perform delete operations.
+// return removed true indicates t is actually removed from b, rather than it has
+// been expired before.
+func (b *bucket) delete(t *Timer) (ok bool, removed bool) {
 	b.flushMu.Lock()
 	b.mu.Lock()
 
-	ok := true
+	ok = true
 	if t.getBucket() != b {
 		// If delete is called just after the TimeWheel's goroutine has under cases:
 		//   - moved t from the b to another non-nil bucket "ab" (through: tw.process -> b.flush -> tw.submit -> tw.add -> ab.insert)
@@ -62,24 +146,51 @@ func (b *bucket) delete(t *Timer) bool {
 		// In either cases, the timer t not in TimeWheel and is nil (set by b.flush),
 		// and it can be considered as a successful deletion.
 	} else {
+		if b.cursor == t.element {
+			b.cursor = t.element.Next()
+		}
 		b.timers.Remove(t.element)
 		t.setBucket(nil)
 		t.element = nil
+		removed = true
 	}
 
 	b.mu.Unlock()
 	b.flushMu.Unlock()
-	return ok
+	return ok, removed
 }
 
-func (b *bucket) flush(submit func(*Timer)) {
+// swapTimers replaces the timers of b with an empty list and returns the old
+// one. The caller must hold both the flushMu and mu, and must empty the old
+// list before releasing the flushMu, since it's reused by the next swap.
+func (b *bucket) swapTimers() *timerList {
+	timers := b.timers
+	if b.spare == nil {
+		b.spare = newTimerList()
+	}
+	b.timers = b.spare
+	b.spare = timers
+	b.cursor = nil
+	return timers
+}
+
+// flush moves all the timers out of b and passes them to submit, once b is
+// dequeued with the expiration. It returns false and does nothing if the
+// dequeue is stale, i.e. b is not enqueued with the expiration any more.
+func (b *bucket) flush(expiration int64, submit func(*Timer)) bool {
 	b.flushMu.Lock()
 	b.mu.Lock()
 
-	timers := b.timers
+	if b.enqueued == 0 || b.getExpiration() != expiration {
+		b.mu.Unlock()
+		b.flushMu.Unlock()
+		return false
+	}
+
 	// Reset the times in bucket.
-	b.timers = list.New()
+	timers := b.swapTimers()
 	b.setExpiration(-1)
+	b.enqueued = 0
 
 	b.mu.Unlock()
 
@@ -87,8 +198,7 @@ func (b *bucket) flush(submit func(*Timer)) {
 	for e := timers.Front(); e != nil; {
 		next := e.Next()
 
-		v := timers.Remove(e)
-		t := v.(*Timer)
+		t := timers.Remove(e)
 
 		// The timer t may not re-enqueue in the following cases:
 		//   1. the timer add by tw.AfterFunc.
@@ -101,21 +211,62 @@ func (b *bucket) flush(submit func(*Timer)) {
 	}
 
 	b.flushMu.Unlock()
+	return true
+}
+
+// cacheLineSize is the size that the hot state of each bucket is padded to.
+// It's two cache lines of 64 bytes, since the adjacent-line prefetcher of the
+// x86 processors pulls the lines in pairs.
+const cacheLineSize = 128
+
+// paddedBucket holds a bucket with its mutexes in a single allocation that
+// occupies whole cache lines.
+//
+// The buckets are allocated individually, but the allocations of the same size
+// class are adjacent in memory, and two small mutexes allocated on their own
+// share a cache line. Thus, without the padding, the inserts into neighboring
+// buckets from different cores bounce the same cache lines, see BenchmarkBucket_Parallel.
+type paddedBucket struct {
+	bucket
+	mu      sync.Mutex
+	flushMu sync.Mutex
+	_       [cacheLineSize - (unsafe.Sizeof(bucket{})+2*unsafe.Sizeof(sync.Mutex{}))%cacheLineSize]byte
 }
 
 func newBucket() *bucket {
-	return &bucket{
+	pb := &paddedBucket{}
+	pb.bucket = bucket{
 		expiration: -1,
-		timers:     list.New(),
-		mu:         new(sync.Mutex),
-		flushMu:    new(sync.Mutex),
+		timers:     newTimerList(),
+		mu:         &pb.mu,
+		flushMu:    &pb.flushMu,
 	}
+	return &pb.bucket
+}
+
+// loadBucket returns the bucket of the slot i, or nil if it's not allocated
+// yet, see ensureBucket.
+func (tw *TimeWheel) loadBucket(i int64) *bucket {
+	return (*bucket)(atomic.LoadPointer(&tw.buckets[i]))
 }
 
-func createBuckets(n int) []*bucket {
-	buckets := make([]*bucket, n)
-	for i := 0; i < n; i++ {
-		buckets[i] = newBucket()
+// ensureBucket returns the bucket of the slot i, it's allocated on the first
+// insert into the slot. The goroutines racing for the same slot may allocate
+// a bucket each, but only one of them is kept.
+func (tw *TimeWheel) ensureBucket(i int64) *bucket {
+	if b := tw.loadBucket(i); b != nil {
+		return b
+	}
+	b := newBucket()
+	if s := tw.root.save; s != nil {
+		// A bucket created during the SaveTo counts as visited, since the
+		// timers moved into it may have been moved out of a visited one.
+		b.save = s
+		b.saved = atomic.LoadUint64(&s.active)
+	}
+	if !atomic.CompareAndSwapPointer(&tw.buckets[i], nil, unsafe.Pointer(b)) {
+		return tw.loadBucket(i)
 	}
-	return buckets
+	atomic.AddInt64(&tw.root.allocatedBuckets, 1)
+	return b
 }
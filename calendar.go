@@ -0,0 +1,207 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// calendarPeriods is the number of the periods that Next looks ahead for a
+// date, e.g. the holidays may cover all the business days of a period.
+const calendarPeriods = 120
+
+// HolidayCalendar tells the holidays of a CalendarSchedule, it's supplied by
+// the caller, e.g. from the calendar of an exchange.
+type HolidayCalendar interface {
+	// IsHoliday reports whether the date is a holiday, the date is at the
+	// midnight in the location of the schedule.
+	IsHoliday(date time.Time) bool
+}
+
+// HolidayFunc adapts a func to the HolidayCalendar.
+type HolidayFunc func(date time.Time) bool
+
+// IsHoliday calls f(date).
+func (f HolidayFunc) IsHoliday(date time.Time) bool {
+	return f(date)
+}
+
+// CalendarSchedule is an execution plan on a day of each month or quarter at
+// some times of day, it's created by MonthEnd, QuarterEnd or NthBusinessDay,
+// e.g. the last business day of each month at 17:00:
+//
+//	timer, err := timewheel.MonthEnd().OnBusinessDay().At("17:00").In(loc).Holidays(cal).Do(tw, f)
+//
+// The business days are the days from Monday to Friday that are not holidays.
+// The errors of the parameters are reported by Do.
+type CalendarSchedule struct {
+	op string
+	// The months of the period, 1 or 3.
+	months int
+	// The n-th business day of the period, counted from the end if negative,
+	// or the last day of the period if it's 0.
+	nth      int
+	times    []time.Duration // the offsets of the times of day, sorted.
+	loc      *time.Location
+	holidays HolidayCalendar
+	err      error
+}
+
+// MonthEnd starts building a CalendarSchedule on the last day of each month.
+func MonthEnd() *CalendarSchedule {
+	return &CalendarSchedule{op: "MonthEnd", months: 1, loc: time.Local}
+}
+
+// QuarterEnd starts building a CalendarSchedule on the last day of each
+// quarter, i.e. the last day of March, June, September and December.
+func QuarterEnd() *CalendarSchedule {
+	return &CalendarSchedule{op: "QuarterEnd", months: 3, loc: time.Local}
+}
+
+// NthBusinessDay starts building a CalendarSchedule on the n-th business day
+// of each month, e.g. 1 is the first one, and -1 is the last one if n is
+// negative. A month that has less than |n| business days is skipped.
+func NthBusinessDay(n int) *CalendarSchedule {
+	cs := &CalendarSchedule{op: "NthBusinessDay", months: 1, nth: n, loc: time.Local}
+	if n == 0 {
+		cs.err = fmt.Errorf("%w: the n-th business day must not be 0", ErrInvalidSchedule)
+	}
+	return cs
+}
+
+// Quarterly makes the schedule on the day of each quarter rather than month,
+// e.g. NthBusinessDay(1).Quarterly() is the first business day of each quarter.
+func (cs *CalendarSchedule) Quarterly() *CalendarSchedule {
+	cs.months = 3
+	return cs
+}
+
+// OnBusinessDay makes the last day of the period rolled back to the last
+// business day on or before it, it does nothing for NthBusinessDay.
+func (cs *CalendarSchedule) OnBusinessDay() *CalendarSchedule {
+	if cs.nth == 0 {
+		cs.nth = -1
+	}
+	return cs
+}
+
+// At adds a time of day in the form of "HH:MM" or "HH:MM:SS" in 24-hour clock,
+// it can be called more than once for multiple times a day. Default is "00:00".
+func (cs *CalendarSchedule) At(clock string) *CalendarSchedule {
+	d, err := parseClock(clock)
+	if err != nil {
+		if cs.err == nil {
+			cs.err = err
+		}
+		return cs
+	}
+	i := sort.Search(len(cs.times), func(i int) bool { return cs.times[i] >= d })
+	if i == len(cs.times) || cs.times[i] != d {
+		cs.times = append(cs.times, 0)
+		copy(cs.times[i+1:], cs.times[i:])
+		cs.times[i] = d
+	}
+	return cs
+}
+
+// In sets the location that the days and times are in, default is time.Local.
+func (cs *CalendarSchedule) In(loc *time.Location) *CalendarSchedule {
+	if loc == nil {
+		if cs.err == nil {
+			cs.err = fmt.Errorf("%w: nil location", ErrInvalidSchedule)
+		}
+		return cs
+	}
+	cs.loc = loc
+	return cs
+}
+
+// Holidays sets the calendar of the holidays, default is none. The c must be
+// safe for concurrent use if the schedule is shared.
+func (cs *CalendarSchedule) Holidays(c HolidayCalendar) *CalendarSchedule {
+	cs.holidays = c
+	return cs
+}
+
+// Next returns the first execution time after the given time, or a zero time
+// if the schedule is invalid or no day is found in the next 120 periods, e.g.
+// all the business days are holidays.
+//
+// The days and times are evaluated in the location of the schedule, the times
+// skipped or repeated by a daylight saving transition are handled like the
+// WeekdaySchedule.
+func (cs *CalendarSchedule) Next(after time.Time) time.Time {
+	if cs.err != nil {
+		return time.Time{}
+	}
+	times := cs.times
+	if len(times) == 0 {
+		times = []time.Duration{0}
+	}
+
+	year, month, _ := after.In(cs.loc).Date()
+	// The index of the first month of the period that after is in.
+	period := year*12 + int(month-1)
+	period -= period % cs.months
+	for i := 0; i < calendarPeriods; i, period = i+1, period+cs.months {
+		year, month := period/12, time.Month(period%12+1)
+		day, ok := cs.dayOf(year, month)
+		if !ok {
+			continue
+		}
+		for _, d := range times {
+			t := wallTime(year, month, day, d, cs.loc)
+			if t.After(after) {
+				return t
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// dayOf returns the date of the schedule in the period starting at the month
+// of the year, the day may be beyond the days of the month, which is moved to
+// the following months by the time.Date. The ok is false if the period has
+// not enough business days.
+func (cs *CalendarSchedule) dayOf(year int, month time.Month) (day int, ok bool) {
+	// The days of the period, counted from the first day of the month.
+	days := 0
+	for i := 0; i < cs.months; i++ {
+		days += daysIn(year, month+time.Month(i))
+	}
+	if cs.nth == 0 {
+		return days, true
+	}
+
+	step, first, n := 1, 1, cs.nth
+	if n < 0 {
+		step, first, n = -1, days, -n
+	}
+	for day = first; day >= 1 && day <= days; day += step {
+		if cs.isBusinessDay(year, month, day) {
+			if n--; n == 0 {
+				return day, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func (cs *CalendarSchedule) isBusinessDay(year int, month time.Month, day int) bool {
+	date := time.Date(year, month, day, 0, 0, 0, 0, cs.loc)
+	if wd := date.Weekday(); wd == time.Saturday || wd == time.Sunday {
+		return false
+	}
+	return cs.holidays == nil || !cs.holidays.IsHoliday(date)
+}
+
+// Do validates the schedule and schedules f to execute according to it.
+// It returns the same errors as WeekdaySchedule.Do.
+func (cs *CalendarSchedule) Do(tw *TimeWheel, f func(), opts ...TimerOption) (*Timer, error) {
+	return tw.doPlan(cs.op, cs.Next, cs.err, f, opts)
+}
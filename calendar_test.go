@@ -0,0 +1,133 @@
+package timewheel
+
+import (
+	"errors"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCalendarSchedule_Next(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	at := func(s string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04:05", s, ny)
+		require.NoError(t, err)
+		return v
+	}
+	// 2021-07-05 is the observed Independence Day, and 2021-12-31 is the
+	// observed New Year's Day of 2022.
+	holidays := HolidayFunc(func(date time.Time) bool {
+		switch date.Format("2006-01-02") {
+		case "2021-07-05", "2021-12-31", "2022-01-03":
+			return true
+		}
+		return false
+	})
+
+	cases := []struct {
+		cs    *CalendarSchedule
+		after time.Time
+		want  time.Time
+	}{
+		// The last day of each month, across the year boundary.
+		{MonthEnd().At("17:00").In(ny), at("2021-01-15 00:00:00"), at("2021-01-31 17:00:00")},
+		{MonthEnd().At("17:00").In(ny), at("2021-01-31 17:00:00"), at("2021-02-28 17:00:00")},
+		{MonthEnd().At("17:00").In(ny), at("2021-04-30 16:59:59"), at("2021-04-30 17:00:00")},
+		{MonthEnd().At("17:00").In(ny), at("2021-12-31 17:00:00"), at("2022-01-31 17:00:00")},
+		// Leap years, 2000 is a leap year but 2100 is not.
+		{MonthEnd().In(ny), at("2024-02-01 00:00:00"), at("2024-02-29 00:00:00")},
+		{MonthEnd().In(ny), at("2023-02-01 00:00:00"), at("2023-02-28 00:00:00")},
+		{MonthEnd().In(ny), at("2000-02-01 00:00:00"), at("2000-02-29 00:00:00")},
+		{MonthEnd().In(ny), at("2100-02-01 00:00:00"), at("2100-02-28 00:00:00")},
+		// The last business day of each month, 2021-07-31 is a Saturday and
+		// 2022-01-31 is a Monday.
+		{MonthEnd().OnBusinessDay().In(ny), at("2021-07-01 00:00:00"), at("2021-07-30 00:00:00")},
+		{MonthEnd().OnBusinessDay().In(ny), at("2022-01-01 00:00:00"), at("2022-01-31 00:00:00")},
+		// Rolled back over the holiday, across the year boundary.
+		{MonthEnd().OnBusinessDay().At("17:00").In(ny).Holidays(holidays), at("2021-12-01 00:00:00"), at("2021-12-30 17:00:00")},
+		{MonthEnd().OnBusinessDay().At("17:00").In(ny).Holidays(holidays), at("2021-12-30 17:00:00"), at("2022-01-31 17:00:00")},
+		// The last day of each quarter.
+		{QuarterEnd().In(ny), at("2021-01-15 00:00:00"), at("2021-03-31 00:00:00")},
+		{QuarterEnd().In(ny), at("2021-03-31 00:00:00"), at("2021-06-30 00:00:00")},
+		{QuarterEnd().In(ny), at("2021-11-01 00:00:00"), at("2021-12-31 00:00:00")},
+		{QuarterEnd().In(ny), at("2021-12-31 00:00:00"), at("2022-03-31 00:00:00")},
+		// 2022-12-31 is a Saturday.
+		{QuarterEnd().OnBusinessDay().In(ny), at("2022-10-01 00:00:00"), at("2022-12-30 00:00:00")},
+		// The n-th business day of each month, 2022-01-01 is a Saturday.
+		{NthBusinessDay(1).At("09:00").In(ny), at("2021-12-15 00:00:00"), at("2022-01-03 09:00:00")},
+		{NthBusinessDay(1).At("09:00").In(ny).Holidays(holidays), at("2021-12-15 00:00:00"), at("2022-01-04 09:00:00")},
+		{NthBusinessDay(3).In(ny).Holidays(holidays), at("2021-07-01 00:00:00"), at("2021-07-06 00:00:00")},
+		{NthBusinessDay(-1).In(ny), at("2024-02-01 00:00:00"), at("2024-02-29 00:00:00")},
+		{NthBusinessDay(-2).In(ny), at("2021-07-29 00:00:00"), at("2021-08-30 00:00:00")},
+		// 2021-02 has 20 business days, it's skipped.
+		{NthBusinessDay(21).In(ny), at("2021-02-01 00:00:00"), at("2021-03-29 00:00:00")},
+		// The first business day of each quarter.
+		{NthBusinessDay(1).Quarterly().In(ny), at("2021-04-01 00:00:00"), at("2021-07-01 00:00:00")},
+		{NthBusinessDay(1).Quarterly().In(ny).Holidays(holidays), at("2021-10-01 00:00:00"), at("2022-01-04 00:00:00")},
+		{NthBusinessDay(-1).Quarterly().In(ny), at("2021-01-01 00:00:00"), at("2021-03-31 00:00:00")},
+		// Multiple times a day.
+		{MonthEnd().At("17:00").At("09:00").In(ny), at("2021-03-31 10:00:00"), at("2021-03-31 17:00:00")},
+		// In UTC, the day is not the one of the argument in New York.
+		{MonthEnd().In(time.UTC), at("2021-03-30 19:00:00"), time.Date(2021, 3, 31, 0, 0, 0, 0, time.UTC)},
+	}
+	for i, c := range cases {
+		got := c.cs.Next(c.after)
+		require.True(t, c.want.Equal(got), "case %d: want %s, got %s", i, c.want, got)
+	}
+
+	// No business day at all.
+	all := HolidayFunc(func(time.Time) bool { return true })
+	require.True(t, MonthEnd().OnBusinessDay().Holidays(all).Next(at("2021-01-01 00:00:00")).IsZero())
+}
+
+func TestCalendarSchedule_Invalid(t *testing.T) {
+	cases := []*CalendarSchedule{
+		NthBusinessDay(0),
+		MonthEnd().In(nil),
+		QuarterEnd().At("24:00"),
+		// No execution in the future.
+		MonthEnd().OnBusinessDay().Holidays(HolidayFunc(func(time.Time) bool { return true })),
+	}
+
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+	for i, cs := range cases {
+		require.True(t, cs.Next(time.Now()).IsZero(), "case %d", i)
+
+		timer, err := cs.Do(tw, func() {}, WithTag("monthly"))
+		require.Nil(t, timer)
+		require.True(t, errors.Is(err, ErrInvalidSchedule), "case %d: %v", i, err)
+		var se *ScheduleError
+		require.True(t, errors.As(err, &se))
+		require.Equal(t, cs.op, se.Op)
+		require.Equal(t, "monthly", se.Tag)
+	}
+	require.Equal(t, int64(0), tw.Pending())
+}
+
+func TestCalendarSchedule_Do(t *testing.T) {
+	clock := &manualClock{now: time.Date(2021, 12, 30, 12, 0, 0, 0, time.UTC)}
+	tw := New(time.Millisecond*100, 64, WithClock(clock), WithDispatchPolicy(DispatchInline))
+	tw.Start()
+	defer tw.Stop()
+
+	var fired []time.Time
+	timer, err := MonthEnd().OnBusinessDay().At("17:00").In(time.UTC).Do(tw, func() { fired = append(fired, clock.Now()) })
+	require.NoError(t, err)
+	defer timer.Close()
+	require.Equal(t, time.Date(2021, 12, 31, 17, 0, 0, 0, time.UTC).UnixNano(), timer.Expiration().UnixNano())
+
+	clock.add(time.Hour * 29)
+	for n, _ := tw.Poll(); n != 0; n, _ = tw.Poll() {
+	}
+	require.Len(t, fired, 1)
+	require.Equal(t, time.Date(2022, 1, 31, 17, 0, 0, 0, time.UTC).UnixNano(), timer.Expiration().UnixNano())
+
+	tw.Stop()
+	_, err = MonthEnd().Do(tw, func() {})
+	require.True(t, errors.Is(err, ErrStopped))
+}
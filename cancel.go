@@ -0,0 +1,244 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// CancelWhere cancels the pending timers that pred returns true for, e.g. all
+// the timers that belong to a shard by their tags or payloads, and returns the
+// number of timers cancelled. The cancelled timers are finished like closed by
+// Timer.Close, except that a ReusableTimer can be started again.
+//
+// It locks one bucket at a time only while copying its timers, and pred is
+// called without holding any lock, one timer at a time in the calling
+// goroutine. Thus, the TimeWheel keeps running during the sweep: a timer that
+// fires before it's cancelled is not counted, and a timer scheduled during the
+// sweep may or may not be visited. The levels are visited from the highest,
+// since the timers only move to the lower levels, so that no timer pending
+// through the sweep is missed; pred may be called twice for a timer that has
+// moved in the meantime. The timers that have expired but not dispatched are
+// not visited.
+func (tw *TimeWheel) CancelWhere(pred func(TimerInfo) bool) int {
+	var levels []*TimeWheel
+	for l := tw.root; l != nil; l = l.getOverflow() {
+		levels = append(levels, l)
+	}
+
+	n := 0
+	var timers []*Timer
+	for level := len(levels) - 1; level >= 0; level-- {
+		l := levels[level]
+		for i := range l.buckets {
+			b := l.loadBucket(int64(i))
+			if b == nil {
+				continue
+			}
+			timers = b.snapshot(timers[:0])
+			for i, t := range timers {
+				if pred(t.info(level)) && t.sweep() {
+					n++
+				}
+				timers[i] = nil
+			}
+		}
+	}
+	if c := tw.root.critical; c != nil {
+		n += c.CancelWhere(pred)
+	}
+	return n
+}
+
+// CancelBetween cancels the pending timers whose expiration is within [from,
+// to), e.g. all the timers due in the next 30 seconds, and returns the number
+// of timers cancelled. They're finished like closed by Timer.Close, counted in
+// Stats.Cancelled and reported to the OnCancel of the observers, except that a
+// ReusableTimer can be started again.
+//
+// Only the buckets that may hold such expirations are visited, i.e. at most
+// the size of buckets per level, and fewer if the window is shorter than the
+// interval of the level. Like CancelWhere, the TimeWheel keeps running during
+// the sweep: a timer within the window may fire before it's visited, and it's
+// not counted then; a timer is counted at most once even if it has moved to a
+// lower level in the meantime.
+func (tw *TimeWheel) CancelBetween(from, to time.Time) int {
+	start, end := from.UnixNano(), to.UnixNano()
+	if end <= start {
+		return 0
+	}
+	var levels []*TimeWheel
+	for l := tw.root; l != nil; l = l.getOverflow() {
+		levels = append(levels, l)
+	}
+
+	n := 0
+	var timers []*Timer
+	for level := len(levels) - 1; level >= 0; level-- {
+		levels[level].bucketsBetween(start, end, func(b *bucket, _ int64) {
+			timers = b.snapshot(timers[:0])
+			for i, t := range timers {
+				if e := t.getExpiration(); e >= start && e < end && t.sweep() {
+					n++
+				}
+				timers[i] = nil
+			}
+		})
+	}
+	if c := tw.root.critical; c != nil {
+		n += c.CancelBetween(from, to)
+	}
+	return n
+}
+
+// bucketsBetween calls f with each bucket of the level that may hold the
+// timers whose expiration is within [start, end), once per bucket. The whole
+// is the expiration of b if all its timers are within the range for sure as
+// long as b is still of the expiration, i.e. the range of b is within [start,
+// end) and b holds no timer beyond the range of the level; it's -1 otherwise.
+func (tw *TimeWheel) bucketsBetween(start, end int64, f func(b *bucket, whole int64)) {
+	first, last := start/tw.tick, (end-1)/tw.tick
+	if last-first >= tw.size {
+		last = first + tw.size - 1
+	}
+	// The timers beyond the range of the top level are put into the buckets
+	// of their later rounds, or parked in the last bucket, see insert.
+	mixed := tw.level+1 >= tw.root.opts.maxLevels
+	for v := first; v <= last; v++ {
+		whole := int64(-1)
+		if !mixed && v*tw.tick >= start && (v+1)*tw.tick <= end {
+			whole = v * tw.tick
+		}
+		if b := tw.loadBucket(v & tw.mask); b != nil {
+			f(b, whole)
+		}
+	}
+	if mixed {
+		parked := (atomic.LoadInt64(&tw.current)+tw.interval)/tw.tick - 1
+		if (parked-first)&tw.mask > last-first {
+			if b := tw.loadBucket(parked & tw.mask); b != nil {
+				f(b, -1)
+			}
+		}
+	}
+}
+
+// CancelAll cancels all the pending timers in all the levels without stopping
+// the TimeWheel, and returns the number of timers cancelled. Each of them is
+// counted in Stats.Cancelled and reported to the OnCancel of the observers,
+// like closed by Timer.Close, except that a ReusableTimer can be started again.
+//
+// The buckets are emptied one at a time, and the TimeWheel keeps accepting the
+// new timers: a timer scheduled during the sweep may or may not be cancelled,
+// but it's either cancelled entirely or left pending. The timers that have
+// expired but not dispatched are not cancelled, they're still dispatched.
+func (tw *TimeWheel) CancelAll() int {
+	n := 0
+	for l := tw.root; l != nil; l = l.getOverflow() {
+		for i := range l.buckets {
+			b := l.loadBucket(int64(i))
+			if b == nil {
+				continue
+			}
+			b.drain(func(t *Timer) {
+				if t.cancelDrained() {
+					n++
+				}
+			})
+		}
+	}
+	if c := tw.root.critical; c != nil {
+		n += c.CancelAll()
+	}
+	return n
+}
+
+// drain removes all the timers from b and passes them to f. Like flush, it
+// holds the flushMu while calling f, so that a concurrent Close waits for the
+// timer to be settled. The expiration of b is kept, since b may be still in
+// the queue; it expires with nothing or with the timers added later.
+func (b *bucket) drain(f func(t *Timer)) {
+	b.flushMu.Lock()
+	b.mu.Lock()
+	timers := b.timers
+	if timers.Len() == 0 {
+		b.mu.Unlock()
+		b.flushMu.Unlock()
+		return
+	}
+	b.swapTimers()
+	b.mu.Unlock()
+
+	for e := timers.Front(); e != nil; {
+		next := e.Next()
+		t := timers.Remove(e)
+		t.element = nil
+		f(t)
+		e = next
+	}
+	b.flushMu.Unlock()
+}
+
+// cancelDrained cancels the timer t that has been drained from its bucket,
+// it returns false if t has been closed in the meantime.
+func (t *Timer) cancelDrained() bool {
+	if !t.transit(StateScheduled, StateCancelled) {
+		return false
+	}
+	reusable := t.getAttrs().reusable
+	if !reusable {
+		t.setEndReason(EndCancelled)
+	}
+	atomic.AddUint64(&t.tw.root.cancelled, 1)
+	t.tw.observeCancel(t)
+	t.tw.decPending()
+	if !reusable {
+		t.finish()
+	}
+	return true
+}
+
+// count returns the number of the timers in b whose expiration is within
+// [start, end), it's the length of b if b is still of the whole expiration,
+// see bucketsBetween.
+func (b *bucket) count(start, end, whole int64) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if whole >= 0 && b.getExpiration() == whole {
+		return int64(b.timers.Len())
+	}
+	var n int64
+	for e := b.timers.Front(); e != nil; e = e.Next() {
+		if te := e.Value.getExpiration(); te >= start && te < end {
+			n++
+		}
+	}
+	return n
+}
+
+// snapshot appends the timers in b to timers.
+func (b *bucket) snapshot(timers []*Timer) []*Timer {
+	b.mu.Lock()
+	for e := b.timers.Front(); e != nil; e = e.Next() {
+		timers = append(timers, e.Value)
+	}
+	b.mu.Unlock()
+	return timers
+}
+
+// sweep cancels the timer t like Close, but a ReusableTimer is left reusable.
+// It returns whether t is cancelled by the call.
+func (t *Timer) sweep() bool {
+	if t.getAttrs().reusable {
+		return t.cancel(false)
+	}
+	if !t.cancel(true) {
+		return false
+	}
+	t.finish()
+	return true
+}
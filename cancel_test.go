@@ -0,0 +1,218 @@
+package timewheel
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeWheel_CancelWhere(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	var timers []*Timer
+	for i := 0; i < 20; i++ {
+		tag := "shard-7"
+		if i%2 == 0 {
+			tag = "shard-1"
+		}
+		// Spread over the levels.
+		d := time.Millisecond * time.Duration(10<<i%100000+100)
+		timers = append(timers, tw.AfterFunc(d, func() {}, WithTag(tag)))
+	}
+	rt := tw.NewStoppedTimer(func() {}, WithTag("shard-7"))
+	rt.Start(time.Hour)
+	require.Equal(t, int64(21), tw.Pending())
+
+	var visited []TimerInfo
+	n := tw.CancelWhere(func(info TimerInfo) bool {
+		visited = append(visited, info)
+		return strings.HasSuffix(info.Tag, "-7")
+	})
+	require.Equal(t, 11, n)
+	require.Len(t, visited, 21)
+	require.Equal(t, int64(10), tw.Pending())
+	require.Equal(t, uint64(11), tw.Stats().Cancelled)
+
+	for i, timer := range timers {
+		if i%2 == 0 {
+			require.Equal(t, StateScheduled, timer.State())
+		} else {
+			<-timer.Done()
+			require.Equal(t, EndCancelled, timer.EndReason())
+		}
+	}
+	// The ReusableTimer can be started again.
+	require.Equal(t, StateCancelled, rt.State())
+	require.False(t, rt.Start(time.Hour))
+	require.Equal(t, int64(11), tw.Pending())
+
+	// Nothing matched.
+	require.Equal(t, 0, tw.CancelWhere(func(TimerInfo) bool { return false }))
+}
+
+func TestTimeWheel_CancelWhere_Payload(t *testing.T) {
+	tw := New(time.Millisecond, 8, WithExpiredChannel(1, DeliveryDrop))
+	defer tw.Stop()
+
+	a := tw.After(time.Hour, 1)
+	b := tw.After(time.Hour, 2)
+	n := tw.CancelWhere(func(info TimerInfo) bool {
+		require.NotZero(t, info.ID)
+		return info.Payload == 2
+	})
+	require.Equal(t, 1, n)
+	require.Equal(t, StateScheduled, a.State())
+	require.Equal(t, StateCancelled, b.State())
+}
+
+func TestTimeWheel_CancelWhere_Concurrent(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	// The timers firing and scheduling during the sweep.
+	var fired int64
+	stopC := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stopC:
+				return
+			default:
+			}
+			tw.AfterFunc(time.Millisecond*time.Duration(i%10), func() { atomic.AddInt64(&fired, 1) })
+			time.Sleep(time.Microsecond * 50)
+		}
+	}()
+
+	var cancelled int
+	for i := 0; i < 50; i++ {
+		cancelled += tw.CancelWhere(func(TimerInfo) bool { return true })
+		time.Sleep(time.Millisecond)
+	}
+	close(stopC)
+	wg.Wait()
+
+	require.Eventually(t, func() bool { return tw.Pending() == 0 }, time.Second, time.Millisecond)
+	stats := tw.Stats()
+	require.Equal(t, uint64(cancelled), stats.Cancelled)
+	require.Equal(t, stats.Scheduled, stats.Fired+stats.Cancelled)
+}
+
+func TestTimeWheel_CancelAll(t *testing.T) {
+	o := new(eventObserver)
+	tw := New(time.Millisecond, 8, WithObserver(o))
+	tw.Start()
+	defer tw.Stop()
+
+	var timers []*Timer
+	for i := 0; i < 10; i++ {
+		timers = append(timers, tw.AfterFunc(time.Millisecond*time.Duration(50<<i), func() {}, WithTag("a")))
+	}
+	recurring, err := tw.Every(time.Minute).Do(func() {}, WithTag("a"))
+	require.NoError(t, err)
+	rt := tw.NewStoppedTimer(func() {}, WithTag("a"))
+	rt.Start(time.Hour)
+	closed := tw.AfterFunc(time.Hour, func() {})
+	closed.Close()
+
+	require.Equal(t, 12, tw.CancelAll())
+	require.Equal(t, int64(0), tw.Pending())
+	for _, timer := range append(timers, recurring) {
+		<-timer.Done()
+		require.Equal(t, EndCancelled, timer.EndReason())
+	}
+	require.Equal(t, 12, strings.Count(strings.Join(o.get(), ","), "cancel:a"))
+	require.Equal(t, 0, tw.CancelAll())
+
+	// It keeps accepting new timers.
+	require.False(t, rt.Start(time.Millisecond))
+	doneC := make(chan struct{})
+	tw.AfterFunc(time.Millisecond, func() { close(doneC) })
+	<-doneC
+}
+
+func TestTimeWheel_CancelAll_Concurrent(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	var wg sync.WaitGroup
+	stopC := make(chan struct{})
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stopC:
+					return
+				default:
+				}
+				timer := tw.AfterFunc(time.Millisecond*time.Duration(i%50), func() {})
+				if i%3 == g%3 {
+					timer.Close()
+				}
+			}
+		}(g)
+	}
+
+	var cancelled int
+	for i := 0; i < 100; i++ {
+		cancelled += tw.CancelAll()
+	}
+	close(stopC)
+	wg.Wait()
+	cancelled += tw.CancelAll()
+
+	require.Eventually(t, func() bool { return tw.Pending() == 0 }, time.Second, time.Millisecond)
+	stats := tw.Stats()
+	require.Equal(t, stats.Scheduled, stats.Fired+stats.Cancelled)
+	require.LessOrEqual(t, uint64(cancelled), stats.Cancelled)
+}
+
+func TestTimeWheel_CancelBetween(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithHashedMode()}, {WithMaxLevels(2)}} {
+		clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+		tw := New(time.Millisecond, 8, append(opts, WithClock(clock), WithDispatchPolicy(DispatchInline))...)
+		tw.Start()
+
+		start := clock.Now()
+		var timers []*Timer
+		for i := 1; i <= 60; i++ {
+			timers = append(timers, tw.AfterFunc(time.Duration(i)*time.Millisecond, func() {}))
+		}
+		require.Equal(t, 0, tw.CancelBetween(start.Add(time.Second), start))
+
+		// The window [10ms, 40ms) spans the levels.
+		n := tw.CancelBetween(start.Add(time.Millisecond*10), start.Add(time.Millisecond*40))
+		require.Equal(t, 30, n)
+		require.Equal(t, int64(30), tw.Pending())
+		require.Equal(t, uint64(30), tw.Stats().Cancelled)
+		require.Equal(t, 0, tw.CancelBetween(start.Add(time.Millisecond*10), start.Add(time.Millisecond*40)))
+		// The window shorter than a level only visits its own buckets.
+		require.Equal(t, 3, tw.CancelBetween(start.Add(time.Millisecond*50), start.Add(time.Millisecond*53)))
+
+		for i := 0; i < 60; i++ {
+			clock.add(time.Millisecond)
+			tw.Poll()
+		}
+		for i, timer := range timers {
+			if d := i + 1; d >= 10 && d < 40 || d >= 50 && d < 53 {
+				require.Equal(t, EndCancelled, timer.EndReason(), d)
+			} else {
+				require.Equal(t, EndCompleted, timer.EndReason(), d)
+			}
+		}
+		tw.Stop()
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"math"
+	"time"
+)
+
+// Clock is the source of the current time of a TimeWheel, see WithClock.
+type Clock interface {
+	// Now returns the current time, it must never move backwards.
+	Now() time.Time
+}
+
+// WithClock makes the TimeWheel read the current time from c instead of the
+// system clock, i.e. the expiration of the new timers, the start of the
+// recurrences, and the time measured by Stats and the MetricsSink.
+//
+// The TimeWheel with a Clock is driven by Poll rather than a consumer
+// goroutine, since the buckets expire at the time of c rather than the
+// wall time. Thus, the WithQueue is ignored. It's meant to control the
+// time in tests, see the package timewheeltest. NOTICE: the timeout set by
+// WithTaskTimeout is still measured by the system clock.
+func WithClock(c Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+// timeNow returns the current time of the clock of the TimeWheel.
+func (tw *TimeWheel) timeNow() time.Time {
+	return time.Unix(0, tw.root.now())
+}
+
+// expireAfter returns the expiration after the duration d from the current
+// time of the clock, it's saturated at the maximum int64 rather than wrapping
+// around to the past, thus a far-future delay is rejected by the admission or
+// clamped, see MaxDelay.
+func (tw *TimeWheel) expireAfter(d time.Duration) int64 {
+	now := tw.root.now()
+	if d > 0 && now > math.MaxInt64-int64(d) {
+		return math.MaxInt64
+	}
+	return now + int64(d)
+}
+
+// Poll processes the buckets that have expired at the current time of the
+// Clock set by WithClock, and returns the number of the buckets processed and
+// the earliest expiration of the remaining buckets, or the zero time if there
+// is no bucket left. The tasks are dispatched by the DispatchPolicy, thus
+// they have been executed when Poll returns if the DispatchInline is set.
+//
+// The buckets expired by the tasks (e.g. a task schedules a timer that has
+// expired) are processed by the same Poll. It's safe to call Poll from
+// multiple goroutines, but they're serialized. Poll does nothing if the
+// TimeWheel is stopped or created without WithClock.
+func (tw *TimeWheel) Poll() (processed int, next time.Time) {
+	root := tw.root
+	q := root.queue
+	if q.manual == nil || root.stoppedNow() {
+		return 0, time.Time{}
+	}
+
+	q.pollMu.Lock()
+	defer q.pollMu.Unlock()
+	for {
+		b, expiration, ok := q.manual.pop(root.now())
+		if !ok {
+			break
+		}
+		root.process(b, expiration)
+		processed++
+	}
+	if expiration, ok := q.manual.peek(); ok {
+		next = time.Unix(0, expiration)
+	}
+	if c := root.critical; c != nil {
+		n, cnext := c.Poll()
+		processed += n
+		if !cnext.IsZero() && (next.IsZero() || cnext.Before(next)) {
+			next = cnext
+		}
+	}
+	return processed, next
+}
@@ -0,0 +1,100 @@
+package timewheel
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// manualClock is a Clock that only moves by set.
+type manualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *manualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *manualClock) add(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+func TestWithClock(t *testing.T) {
+	clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	tw := New(time.Millisecond, 64, WithClock(clock), WithDispatchPolicy(DispatchInline))
+	tw.Start()
+	defer tw.Stop()
+
+	var fired []int
+	tw.AfterFunc(time.Millisecond*5, func() { fired = append(fired, 5) })
+	tw.AfterFunc(time.Millisecond*20, func() { fired = append(fired, 20) })
+	timer := tw.AfterFunc(time.Hour, func() { fired = append(fired, 3600) })
+	require.Equal(t, clock.Now().Add(time.Hour).UnixNano(), timer.getExpiration())
+
+	// Nothing expires before the clock moves, even in the wall time.
+	time.Sleep(time.Millisecond * 10)
+	processed, next := tw.Poll()
+	require.Equal(t, 0, processed)
+	require.Equal(t, clock.Now().Add(time.Millisecond*5).UnixNano(), next.UnixNano())
+	require.Len(t, fired, 0)
+
+	clock.add(time.Millisecond * 5)
+	processed, next = tw.Poll()
+	require.Equal(t, 1, processed)
+	require.Equal(t, []int{5}, fired)
+	require.Equal(t, clock.Now().Add(time.Millisecond*15).UnixNano(), next.UnixNano())
+
+	clock.add(time.Hour)
+	tw.Poll()
+	require.Equal(t, []int{5, 20, 3600}, fired)
+	require.Equal(t, int64(0), tw.Pending())
+
+	processed, next = tw.Poll()
+	require.Equal(t, 0, processed)
+	require.True(t, next.IsZero())
+}
+
+func TestWithClock_Recurrence(t *testing.T) {
+	clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	tw := New(time.Millisecond, 8, WithClock(clock), WithDispatchPolicy(DispatchInline))
+	tw.Start()
+	defer tw.Stop()
+
+	var ran int
+	timer, err := tw.Every(time.Second).Times(3).Do(func() { ran++ })
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		clock.add(time.Second)
+		tw.Poll()
+		require.Equal(t, i+1, ran)
+	}
+	<-timer.Done()
+	require.Equal(t, EndTimes, timer.EndReason())
+}
+
+func TestTimeWheel_Poll(t *testing.T) {
+	// It does nothing without a Clock.
+	tw := New(time.Millisecond, 8)
+	tw.AfterFunc(0, func() {})
+	processed, next := tw.Poll()
+	require.Equal(t, 0, processed)
+	require.True(t, next.IsZero())
+	tw.Stop()
+
+	// Or after stopped.
+	clock := &manualClock{now: time.Now()}
+	tw = New(time.Millisecond, 8, WithClock(clock))
+	tw.AfterFunc(time.Millisecond, func() { t.Fatal("unexpected execution") })
+	tw.Stop()
+	clock.add(time.Millisecond)
+	processed, _ = tw.Poll()
+	require.Equal(t, 0, processed)
+}
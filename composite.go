@@ -0,0 +1,163 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"context"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Composite fronts a TimeWheel per class of timers, e.g. the few critical
+// timers on a TimeWheel of 1ms tick, and millions of bulk ones on another of
+// 100ms tick, and routes each scheduling by its class. Each TimeWheel has its
+// own queue and consumer goroutine, thus the backlog of a class never delays
+// the expirations of the others. It generalizes the WithCriticalPlane to the
+// classes of any configuration.
+//
+// The TimeWheels are owned by the Composite, they're started and stopped
+// together. Each of them reports its own metrics, set WithName and
+// WithMetricsSink per class for the metrics by class, and see Stats.
+type Composite struct {
+	wheels   map[string]*TimeWheel
+	classes  []string
+	fallback *TimeWheel
+	// The shortest tick of the TimeWheels, it's the interval to check the
+	// pending during Drain.
+	tick time.Duration
+	// Whether Drain has been called, it's accessed atomically.
+	draining int32
+}
+
+// NewComposite creates a Composite of the TimeWheels by their classes, the
+// timers of the classes not in the classes are routed to the TimeWheel of the
+// fallback class. The TimeWheels must not be started, and must be different.
+//
+// It panics if the fallback is not one of the classes, or any TimeWheel is nil
+// or shared by two classes.
+func NewComposite(fallback string, classes map[string]*TimeWheel) *Composite {
+	c := &Composite{wheels: make(map[string]*TimeWheel, len(classes))}
+	for class, tw := range classes {
+		if tw == nil {
+			panic("timewheel: TimeWheel of class " + class + " is nil")
+		}
+		for other, w := range c.wheels {
+			if w == tw {
+				panic("timewheel: classes " + class + " and " + other + " share a TimeWheel")
+			}
+		}
+		c.wheels[class] = tw
+		c.classes = append(c.classes, class)
+		if tick := time.Duration(tw.tick); c.tick == 0 || tick < c.tick {
+			c.tick = tick
+		}
+	}
+	c.fallback = c.wheels[fallback]
+	if c.fallback == nil {
+		panic("timewheel: fallback class " + fallback + " is not one of the classes")
+	}
+	sort.Strings(c.classes)
+	return c
+}
+
+// Classes returns the classes of the Composite in sorted order.
+func (c *Composite) Classes() []string {
+	return append([]string(nil), c.classes...)
+}
+
+// Wheel returns the TimeWheel of the class, or the one of the fallback class
+// if the class is unknown. The whole API of the TimeWheel is available through
+// it, e.g. Every or AfterTask.
+func (c *Composite) Wheel(class string) *TimeWheel {
+	if tw := c.wheels[class]; tw != nil {
+		return tw
+	}
+	return c.fallback
+}
+
+// AfterFunc calls the AfterFunc of the TimeWheel of the class.
+func (c *Composite) AfterFunc(class string, d time.Duration, f func(), opts ...TimerOption) *Timer {
+	return c.Wheel(class).AfterFunc(d, f, opts...)
+}
+
+// TryAfterFunc calls the TryAfterFunc of the TimeWheel of the class. It
+// returns ErrDraining once Drain is called.
+func (c *Composite) TryAfterFunc(class string, d time.Duration, f func(), opts ...TimerOption) (*Timer, error) {
+	if atomic.LoadInt32(&c.draining) == 1 {
+		return nil, ErrDraining
+	}
+	return c.Wheel(class).TryAfterFunc(d, f, opts...)
+}
+
+// Schedule calls the Schedule of the TimeWheel of the class.
+func (c *Composite) Schedule(class string, sh Scheduler, opts ...TimerOption) *Timer {
+	return c.Wheel(class).Schedule(sh, opts...)
+}
+
+// Start starts all the TimeWheels.
+func (c *Composite) Start() {
+	for _, class := range c.classes {
+		c.wheels[class].Start()
+	}
+}
+
+// Stop stops all the TimeWheels like TimeWheel.Stop.
+func (c *Composite) Stop() {
+	for _, class := range c.classes {
+		c.wheels[class].Stop()
+	}
+}
+
+// Wait blocks until all the TimeWheels are fully stopped, see TimeWheel.Wait.
+func (c *Composite) Wait() {
+	for _, class := range c.classes {
+		c.wheels[class].Wait()
+	}
+}
+
+// Drain waits for all the pending timers of all the classes to expire or be
+// closed, then stops all the TimeWheels. The TryAfterFunc is rejected with
+// ErrDraining since Drain is called, while the recurring timers and the other
+// scheduling funcs are still accepted and waited for, thus close the recurring
+// timers before Drain. The pending is checked every the shortest tick of the
+// TimeWheels.
+//
+// If the ctx is done before the pending drops to 0, the TimeWheels are
+// stopped with the pending timers, and the error of the ctx is returned.
+func (c *Composite) Drain(ctx context.Context) error {
+	atomic.StoreInt32(&c.draining, 1)
+	defer c.Stop()
+
+	ticker := time.NewTicker(c.tick)
+	defer ticker.Stop()
+	for c.Pending() != 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// Pending returns the number of the pending timers of all the classes.
+func (c *Composite) Pending() int64 {
+	var n int64
+	for _, tw := range c.wheels {
+		n += tw.Pending()
+	}
+	return n
+}
+
+// Stats returns the Stats of the TimeWheel of each class.
+func (c *Composite) Stats() map[string]Stats {
+	stats := make(map[string]Stats, len(c.wheels))
+	for class, tw := range c.wheels {
+		stats[class] = tw.Stats()
+	}
+	return stats
+}
@@ -0,0 +1,92 @@
+package timewheel
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestComposite() *Composite {
+	return NewComposite("bulk", map[string]*TimeWheel{
+		"critical": New(time.Millisecond, 8),
+		"bulk":     New(time.Millisecond*10, 64, WithDispatchPolicy(DispatchInline)),
+	})
+}
+
+func TestComposite(t *testing.T) {
+	c := newTestComposite()
+	c.Start()
+	defer c.Stop()
+
+	require.Equal(t, []string{"bulk", "critical"}, c.Classes())
+	require.Equal(t, time.Millisecond, time.Duration(c.Wheel("critical").tick))
+	require.Equal(t, c.Wheel("bulk"), c.Wheel("unknown"))
+
+	// The bulk backlog never delays the critical timers.
+	releaseC := make(chan struct{})
+	defer close(releaseC)
+	c.AfterFunc("bulk", time.Millisecond*20, func() { <-releaseC })
+	for i := 0; i < 10; i++ {
+		c.AfterFunc("unknown", time.Millisecond*40, func() {})
+	}
+	firedC := make(chan time.Time, 1)
+	start := time.Now()
+	c.AfterFunc("critical", time.Millisecond*100, func() { firedC <- time.Now() })
+	select {
+	case fired := <-firedC:
+		require.True(t, fired.Sub(start) < time.Second)
+	case <-time.After(time.Second * 5):
+		t.Fatal("the critical timer is delayed")
+	}
+
+	require.Equal(t, int64(11), c.Pending())
+	stats := c.Stats()
+	require.Equal(t, uint64(1), stats["critical"].Fired)
+	require.Equal(t, uint64(11), stats["bulk"].Scheduled)
+}
+
+func TestComposite_Drain(t *testing.T) {
+	c := newTestComposite()
+	c.Start()
+
+	for _, class := range c.Classes() {
+		_, err := c.TryAfterFunc(class, time.Millisecond*20, func() {})
+		require.NoError(t, err)
+	}
+	sh := &countdown{interval: time.Millisecond, n: 3}
+	c.Schedule("critical", sh)
+
+	require.NoError(t, c.Drain(context.Background()))
+	require.Equal(t, int64(0), c.Pending())
+	require.Equal(t, int32(3), atomic.LoadInt32(&sh.runs))
+	c.Wait()
+	for _, class := range c.Classes() {
+		require.True(t, c.Wheel(class).stoppedNow())
+	}
+
+	_, err := c.TryAfterFunc("bulk", time.Millisecond, func() {})
+	require.True(t, errors.Is(err, ErrDraining))
+}
+
+func TestComposite_DrainTimeout(t *testing.T) {
+	c := newTestComposite()
+	c.Start()
+
+	c.AfterFunc("bulk", time.Hour, func() {})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	require.True(t, errors.Is(c.Drain(ctx), context.DeadlineExceeded))
+	require.Equal(t, int64(1), c.Pending())
+	require.True(t, c.Wheel("bulk").stoppedNow())
+}
+
+func TestNewComposite_Invalid(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	require.Panics(t, func() { NewComposite("foo", map[string]*TimeWheel{"bar": tw}) })
+	require.Panics(t, func() { NewComposite("foo", map[string]*TimeWheel{"foo": nil}) })
+	require.Panics(t, func() { NewComposite("foo", map[string]*TimeWheel{"foo": tw, "bar": tw}) })
+}
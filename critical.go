@@ -0,0 +1,98 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"github.com/yu31/dqueue"
+)
+
+// WithCriticalPlane makes the TimeWheel run a second wheel internally, with
+// its own delay queue and consumer goroutine, for the timers of the
+// PriorityCritical. Thus, the critical timers never wait behind the buckets
+// of the other timers, however large the backlog of them is.
+//
+// The timers are routed by the priority when scheduled, and they're operated
+// by the same API as the others. The two planes are started, stopped and
+// polled together, and the Stats reports the critical plane in its Critical.
+// The tags, quotas, rate limits, watchers and task durations are shared by
+// both planes, while the WithMaxPending and WithLoadShedding apply to each
+// plane apart, and the OnIdle, OnActive, WithIdleShutdown and watermarks only
+// consider the other timers. The Dump and SaveTo only covers the other timers.
+func WithCriticalPlane() Option {
+	return func(o *options) {
+		o.criticalPlane = true
+	}
+}
+
+// newCriticalPlane creates the critical plane of the root TimeWheel main.
+func newCriticalPlane(main *TimeWheel) *TimeWheel {
+	o := main.opts
+	o.criticalPlane = false
+	// The main plane reports the metrics, and covers the idleness.
+	o.metricsSink = nil
+	o.onIdle, o.onActive, o.idleShutdown = nil, nil, 0
+	o.onHighWatermark, o.onLowWatermark = nil, nil
+	o.capacity = 0
+
+	var dq *dqueue.DQueue
+	if o.clock == nil {
+		dq = dqueue.Default()
+	}
+	queue := newBucketQueue(dq, nil)
+	queue.logger = o.logger
+
+	c := newTimeWheel(main.tick, main.size, main.now(), queue, nil)
+	c.opts = o
+	c.now = main.now
+	c.maxSpan = main.maxSpan
+	c.stopC = make(chan struct{})
+	c.doneC = make(chan struct{})
+	c.expiredC = main.expiredC
+	c.baseCtx = main.baseCtx
+	if o.fair {
+		c.fair = newFairer(o.fairWeights)
+	}
+
+	// Shared with the main plane.
+	c.inflight = main.inflight
+	c.quotas = main.quotas
+	c.rates = main.rates
+	c.rateLimit = main.rateLimit
+	c.sampler = main.sampler
+	c.durations = main.durations
+	c.deadLetters = main.deadLetters
+	c.watch = main.watch
+	c.leaks = main.leaks
+	c.save = main.save
+	// The main plane replays the store.
+	c.replayed = 1
+	return c
+}
+
+// route returns the plane that the new timer t belongs to, and moves t to it.
+func (tw *TimeWheel) route(t *Timer) *TimeWheel {
+	if c := tw.root.critical; c != nil && t.Priority() == PriorityCritical {
+		t.tw = c
+		return c
+	}
+	return tw
+}
+
+// closePlane shuts the stopped TimeWheel down like Close, or defers it if a
+// task is dispatched inline meanwhile.
+func (tw *TimeWheel) closePlane() {
+	root := tw.root
+	root.deferMu.Lock()
+	if root.dispatching && root.opts.dispatchPolicy == DispatchInline {
+		// Maybe called by a task in the consumer goroutine, see drainDeferred.
+		root.stopDeferred = true
+		root.deferMu.Unlock()
+		return
+	}
+	root.deferMu.Unlock()
+
+	root.shutdown()
+}
@@ -0,0 +1,110 @@
+package timewheel
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithCriticalPlane(t *testing.T) {
+	tw := New(time.Millisecond, 8, WithCriticalPlane(), WithDispatchPolicy(DispatchInline))
+	tw.Start()
+
+	// The consumer goroutine of the other timers is blocked.
+	release := make(chan struct{})
+	bulk := tw.AfterFunc(time.Millisecond, func() { <-release })
+	late := tw.AfterFunc(time.Millisecond*5, func() {})
+
+	critical := tw.AfterFunc(time.Millisecond*10, func() {}, WithPriority(PriorityCritical))
+	select {
+	case <-critical.Done():
+	case <-time.After(time.Second):
+		t.Fatal("the critical timer is blocked by the others")
+	}
+	require.Equal(t, StateRunning, bulk.State())
+	require.Equal(t, StateScheduled, late.State())
+
+	// The recurring timer stays on the critical plane.
+	var fired int64
+	recurring, err := tw.Every(time.Millisecond).Times(3).Do(func() { atomic.AddInt64(&fired, 1) }, WithPriority(PriorityCritical))
+	require.NoError(t, err)
+	<-recurring.Done()
+	require.Equal(t, int64(3), atomic.LoadInt64(&fired))
+
+	pending := tw.AfterFunc(time.Hour, func() {}, WithPriority(PriorityCritical))
+	stats := tw.Stats()
+	require.NotNil(t, stats.Critical)
+	require.Nil(t, stats.Critical.Critical)
+	require.Equal(t, uint64(4), stats.Critical.Fired)
+	require.Equal(t, uint64(5), stats.Critical.Scheduled)
+	require.Equal(t, int64(1), stats.Critical.Pending)
+	require.Equal(t, uint64(2), stats.Scheduled)
+	require.Equal(t, int64(2), stats.Pending)
+	require.Equal(t, int64(3), tw.Pending())
+
+	next, ok := tw.NextExpiration()
+	require.True(t, ok)
+	require.False(t, next.After(time.Now()))
+
+	close(release)
+	<-late.Done()
+	require.Equal(t, 1, tw.CancelAll())
+	require.Equal(t, EndCancelled, pending.EndReason())
+	require.Equal(t, int64(0), tw.Pending())
+
+	// Both planes are stopped together.
+	tw.Stop()
+	<-tw.root.critical.doneC
+	require.True(t, tw.root.critical.stoppedNow())
+}
+
+func TestWithCriticalPlane_Clock(t *testing.T) {
+	clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	tw := New(time.Millisecond, 8, WithCriticalPlane(), WithClock(clock), WithDispatchPolicy(DispatchInline))
+	tw.Start()
+	defer tw.Stop()
+
+	var fired []string
+	tw.AfterFunc(time.Millisecond*20, func() { fired = append(fired, "bulk") })
+	critical := tw.AfterFunc(time.Millisecond*5, func() { fired = append(fired, "critical") }, WithPriority(PriorityCritical))
+	rt := tw.NewStoppedTimer(func() { fired = append(fired, "reusable") }, WithPriority(PriorityCritical))
+	rt.Start(time.Millisecond * 30)
+
+	require.Equal(t, int64(3), tw.CountDue(time.Hour))
+	require.Len(t, tw.DueWithin(time.Hour, 2), 2)
+	next, ok := tw.NextExpiration()
+	require.True(t, ok)
+	require.Equal(t, clock.Now().Add(time.Millisecond*5).UnixNano(), next.UnixNano())
+
+	clock.add(time.Millisecond * 15)
+	n, _ := tw.Poll()
+	require.Equal(t, 1, n)
+	require.Equal(t, []string{"critical"}, fired)
+	require.Equal(t, StateCompleted, critical.State())
+
+	clock.add(time.Millisecond * 30)
+	tw.Poll()
+	require.Equal(t, []string{"critical", "bulk", "reusable"}, fired)
+	require.Equal(t, uint64(2), tw.Stats().Critical.Fired)
+}
+
+func TestWithCriticalPlane_StopByTask(t *testing.T) {
+	tw := New(time.Millisecond, 8, WithCriticalPlane(), WithDispatchPolicy(DispatchInline))
+	tw.Start()
+
+	// Stopped by a task in the consumer goroutine of the critical plane.
+	tw.AfterFunc(time.Millisecond, tw.Stop, WithPriority(PriorityCritical))
+	doneC := make(chan struct{})
+	go func() {
+		tw.Wait()
+		<-tw.root.critical.doneC
+		close(doneC)
+	}()
+	select {
+	case <-doneC:
+	case <-time.After(time.Second):
+		t.Fatal("the shutdown is blocked")
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"context"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// WithDeadLetter registers f to be called with the timer whose task failed
+// permanently, and the reason of the failure, rather than having it silently
+// dropped. A timer is dead-lettered:
+//   - once its task of AfterFuncErr finally failed, the reason is the error
+//     handed to the OnError, e.g. a *RetryError once the retries exhausted;
+//   - once its task is dropped by the Executor, the reason is the error of it,
+//     or dropped by the DeliveryDrop, the reason is the ErrChannelFull;
+//   - once its task panicked if the DeadLetterPanics is set, the reason is a
+//     *PanicError;
+//   - if it's still pending when the TimeWheel shut down, the reason is the
+//     ErrStopped.
+//
+// The f is called synchronously in the goroutine that caused the failure, so
+// it must return quickly. See WithDeadLetterBuffer to keep the dead letters
+// on the TimeWheel.
+func WithDeadLetter(f func(t TimerInfo, reason error)) Option {
+	return func(o *options) {
+		o.onDeadLetter = f
+	}
+}
+
+// WithDeadLetterBuffer keeps the latest n dead letters (see WithDeadLetter) in
+// memory, they're listed by DeadLetters and scheduled again by Redrive. Once
+// the buffer is full, the oldest dead letter is evicted to make room for the
+// new one, it's still passed to the f of WithDeadLetter if any.
+//
+// It panics if n is less than 1.
+func WithDeadLetterBuffer(n int) Option {
+	if n < 1 {
+		panic("timewheel: size of dead letter buffer must be greater than 0")
+	}
+	return func(o *options) {
+		o.deadLetterCap = n
+	}
+}
+
+// DeadLetterPanics makes the timers whose task panicked dead-lettered, each
+// panicked execution of a recurring timer is dead-lettered apart.
+func DeadLetterPanics() Option {
+	return func(o *options) {
+		o.deadLetterPanics = true
+	}
+}
+
+// deadLetter is an entry of the deadLetters.
+type deadLetter struct {
+	info TimerInfo
+	// It creates the timer scheduled by Redrive, it's nil if the timer can't
+	// be driven again, e.g. a recurring timer.
+	redrive func(expiration int64) *Timer
+}
+
+// deadLetters is the bounded buffer of the WithDeadLetterBuffer, the entries
+// are kept in order of arrival.
+type deadLetters struct {
+	cap int
+
+	mu      sync.Mutex
+	entries []deadLetter
+}
+
+func (dl *deadLetters) add(e deadLetter) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	if len(dl.entries) >= dl.cap {
+		// Evict the oldest.
+		copy(dl.entries, dl.entries[1:])
+		dl.entries[len(dl.entries)-1] = e
+		return
+	}
+	dl.entries = append(dl.entries, e)
+}
+
+// take returns the entry of the timer id, it's removed if it can be driven
+// again.
+func (dl *deadLetters) take(id uint64) (deadLetter, bool) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	for i, e := range dl.entries {
+		if e.info.ID == id {
+			if e.redrive != nil {
+				dl.entries = append(dl.entries[:i], dl.entries[i+1:]...)
+			}
+			return e, true
+		}
+	}
+	return deadLetter{}, false
+}
+
+// DeadLetters returns the dead letters in the buffer from the oldest, with the
+// reason of each in its Reason. It's nil unless WithDeadLetterBuffer is set.
+func (tw *TimeWheel) DeadLetters() []TimerInfo {
+	dl := tw.root.deadLetters
+	if dl == nil {
+		return nil
+	}
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	infos := make([]TimerInfo, len(dl.entries))
+	for i, e := range dl.entries {
+		infos[i] = e.info
+	}
+	return infos
+}
+
+// Redrive removes the dead letter of the timer id from the buffer, and
+// schedules a new timer with the same task and options to run it as soon as
+// possible. The new timer is dead-lettered again if it fails again.
+//
+// It returns ErrNoDeadLetter if there is no such dead letter, ErrNotRedrivable
+// if the timer can't be driven again, such as a recurring timer, a ReusableTimer
+// or a Deadline, or a *ScheduleError if the new timer is rejected.
+func (tw *TimeWheel) Redrive(id uint64) error {
+	dl := tw.root.deadLetters
+	if dl == nil {
+		return ErrNoDeadLetter
+	}
+	e, ok := dl.take(id)
+	if !ok {
+		return ErrNoDeadLetter
+	}
+	if e.redrive == nil {
+		return ErrNotRedrivable
+	}
+	expiration := tw.root.now()
+	t := e.redrive(expiration)
+	if !tw.scheduleNew(t, false) {
+		return &ScheduleError{Op: "Redrive", Expiration: time.Unix(0, expiration), Tag: t.Tag(), Err: t.rejected()}
+	}
+	return nil
+}
+
+// deadLetterEnabled reports whether the dead letters are handled.
+func (tw *TimeWheel) deadLetterEnabled() bool {
+	root := tw.root
+	return root.opts.onDeadLetter != nil || root.deadLetters != nil
+}
+
+// setRedrive makes the run-once timer t created by newFuncTimer with the ctx, f
+// and opts redrivable, if the dead letters are handled.
+func (tw *TimeWheel) setRedrive(ctx context.Context, t *Timer, f func(ctx context.Context, t *Timer), opts []TimerOption) {
+	if !tw.deadLetterEnabled() {
+		return
+	}
+	t.setAttrs().redrive = func(expiration int64) *Timer {
+		nt := tw.newFuncTimer(ctx, expiration, f, opts)
+		tw.setRedrive(ctx, nt, f, opts)
+		return nt
+	}
+}
+
+// deadLetter dead-letters the timer t for the reason.
+func (tw *TimeWheel) deadLetter(t *Timer, reason error) {
+	root := tw.root
+	if !tw.deadLetterEnabled() {
+		return
+	}
+	info := t.info(0)
+	info.Reason = reason
+	if dl := root.deadLetters; dl != nil {
+		dl.add(deadLetter{info: info, redrive: t.getAttrs().redrive})
+	}
+	if f := root.opts.onDeadLetter; f != nil {
+		f(info, reason)
+	}
+}
+
+// deadLetterPanic dead-letters the timer t whose task panicked with r, if the
+// DeadLetterPanics is set.
+func (tw *TimeWheel) deadLetterPanic(t *Timer, r interface{}) {
+	if tw.root.opts.deadLetterPanics {
+		tw.deadLetter(t, &PanicError{Value: r, Stack: debug.Stack()})
+	}
+}
+
+// deadLetterPending dead-letters all the pending timers, it's called once the
+// TimeWheel is shut down.
+func (tw *TimeWheel) deadLetterPending() {
+	if !tw.deadLetterEnabled() {
+		return
+	}
+	var timers []*Timer
+	for l := tw.root; l != nil; l = l.getOverflow() {
+		for i := range l.buckets {
+			b := l.loadBucket(int64(i))
+			if b == nil {
+				continue
+			}
+			timers = b.snapshot(timers[:0])
+			for i, t := range timers {
+				tw.deadLetter(t, ErrStopped)
+				timers[i] = nil
+			}
+		}
+	}
+}
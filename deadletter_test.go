@@ -0,0 +1,151 @@
+package timewheel
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithDeadLetterBuffer(t *testing.T) {
+	require.Panics(t, func() { WithDeadLetterBuffer(0) })
+	require.NotPanics(t, func() { WithDeadLetterBuffer(1) })
+}
+
+func TestTimeWheel_DeadLetter_Retry(t *testing.T) {
+	errFoo := errors.New("foo")
+	var reasons []error
+	clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	tw := New(time.Millisecond, 8, WithClock(clock), WithDispatchPolicy(DispatchInline), WithDeadLetterBuffer(4),
+		WithDeadLetter(func(_ TimerInfo, reason error) { reasons = append(reasons, reason) }))
+	tw.Start()
+	defer tw.Stop()
+
+	n := 0
+	timer := tw.AfterFuncErr(time.Millisecond, func(ctx context.Context) error {
+		n++
+		return errFoo
+	}, WithTag("retried"), WithRetry(RetryPolicy{MaxAttempts: 2, Backoff: ConstantBackoff(time.Millisecond)}))
+	for timer.State() != StateCompleted {
+		clock.add(time.Millisecond)
+		tw.Poll()
+	}
+	require.Equal(t, 2, n)
+	require.Len(t, reasons, 1)
+	var re *RetryError
+	require.True(t, errors.As(reasons[0], &re))
+	require.Len(t, re.Attempts, 2)
+
+	dls := tw.DeadLetters()
+	require.Len(t, dls, 1)
+	require.Equal(t, timer.ID(), dls[0].ID)
+	require.Equal(t, "retried", dls[0].Tag)
+	require.Equal(t, reasons[0], dls[0].Reason)
+
+	// The redriven timer runs the task again with a fresh retry policy, and it's
+	// dead-lettered again once it fails.
+	require.NoError(t, tw.Redrive(timer.ID()))
+	require.Empty(t, tw.DeadLetters())
+	for len(reasons) < 2 {
+		clock.add(time.Millisecond)
+		tw.Poll()
+	}
+	require.Equal(t, 4, n)
+	dls = tw.DeadLetters()
+	require.Len(t, dls, 1)
+	require.NotEqual(t, timer.ID(), dls[0].ID)
+	require.Equal(t, "retried", dls[0].Tag)
+
+	// The entry is removed once driven again.
+	require.True(t, errors.Is(tw.Redrive(timer.ID()), ErrNoDeadLetter))
+	require.True(t, errors.Is(tw.Redrive(12345), ErrNoDeadLetter))
+}
+
+func TestTimeWheel_DeadLetter_Panic(t *testing.T) {
+	var got []TimerInfo
+	tw := New(time.Millisecond, 8, WithDispatchPolicy(DispatchInline), DeadLetterPanics(),
+		WithDeadLetter(func(info TimerInfo, _ error) { got = append(got, info) }))
+	tw.Start()
+	defer tw.Stop()
+
+	<-tw.AfterFunc(time.Millisecond, func() { panic("boom") }).Done()
+	require.Len(t, got, 1)
+	var pe *PanicError
+	require.True(t, errors.As(got[0].Reason, &pe))
+	require.Equal(t, "boom", pe.Value)
+	require.NotEmpty(t, pe.Stack)
+
+	// The panics are not dead-lettered by default.
+	tw2 := New(time.Millisecond, 8, WithDispatchPolicy(DispatchInline), WithDeadLetterBuffer(1))
+	tw2.Start()
+	defer tw2.Stop()
+	<-tw2.AfterFunc(time.Millisecond, func() { panic("boom") }).Done()
+	require.Empty(t, tw2.DeadLetters())
+}
+
+func TestTimeWheel_DeadLetter_Dropped(t *testing.T) {
+	tw := New(time.Millisecond, 8, WithExecutor(refuseExecutor{}), WithDeadLetterBuffer(2))
+	tw.Start()
+	defer tw.Stop()
+
+	timer := tw.AfterFunc(time.Millisecond, func() {})
+	<-timer.Done()
+	dls := tw.DeadLetters()
+	require.Len(t, dls, 1)
+	require.Equal(t, timer.ID(), dls[0].ID)
+	require.EqualError(t, dls[0].Reason, "refused")
+}
+
+func TestTimeWheel_DeadLetter_Stopped(t *testing.T) {
+	tw := New(time.Millisecond, 8, WithDeadLetterBuffer(8))
+	tw.Start()
+	t1 := tw.AfterFunc(time.Hour, func() {}, WithTag("a"))
+	t2 := tw.AfterFunc(time.Millisecond*5, func() {}, WithTag("b"))
+	tw.Stop()
+
+	dls := tw.DeadLetters()
+	require.Len(t, dls, 2)
+	ids := map[uint64]bool{dls[0].ID: true, dls[1].ID: true}
+	require.True(t, ids[t1.ID()])
+	require.True(t, ids[t2.ID()])
+	for _, info := range dls {
+		require.True(t, errors.Is(info.Reason, ErrStopped))
+	}
+}
+
+func TestTimeWheel_DeadLetter_Evict(t *testing.T) {
+	var n int
+	tw := New(time.Millisecond, 8, WithExecutor(refuseExecutor{}), WithDeadLetterBuffer(2),
+		WithDeadLetter(func(TimerInfo, error) { n++ }))
+	tw.Start()
+	defer tw.Stop()
+
+	var ids []uint64
+	for i := 0; i < 3; i++ {
+		timer := tw.AfterFunc(time.Millisecond, func() {})
+		<-timer.Done()
+		ids = append(ids, timer.ID())
+	}
+	require.Equal(t, 3, n)
+	dls := tw.DeadLetters()
+	require.Len(t, dls, 2)
+	// The oldest is evicted.
+	require.Equal(t, ids[1], dls[0].ID)
+	require.Equal(t, ids[2], dls[1].ID)
+	require.True(t, errors.Is(tw.Redrive(ids[0]), ErrNoDeadLetter))
+}
+
+func TestTimeWheel_DeadLetter_NotRedrivable(t *testing.T) {
+	tw := New(time.Millisecond, 8, WithExecutor(refuseExecutor{}), WithDeadLetterBuffer(2))
+	tw.Start()
+	defer tw.Stop()
+
+	timer, err := tw.Every(time.Millisecond).Times(1).Do(func() {})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return len(tw.DeadLetters()) == 1 }, time.Second, time.Millisecond)
+	require.True(t, errors.Is(tw.Redrive(timer.ID()), ErrNotRedrivable))
+	// It's kept in the buffer.
+	require.Len(t, tw.DeadLetters(), 1)
+}
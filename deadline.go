@@ -0,0 +1,136 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// DeadlineManager tracks the idle deadlines of many entries, such as the
+// connections of a proxy that are considered dead if no packet in a timeout.
+// It's created by TimeWheel.NewDeadlineManager.
+//
+// The activity of an entry is far more frequent than its expiration, thus
+// Deadline.Touch only stores the time of the activity, rather than removing
+// and re-inserting a timer like Timer.Reset. The timer of each entry fires
+// at the earliest possible deadline to check the idle time, and re-arms
+// itself for the remainder if the entry is touched in the meantime. Thus, a
+// busy entry costs about one fire per timeout, however often it's touched.
+type DeadlineManager struct {
+	// The number of entries that are neither expired nor closed. It's placed
+	// first to be 64-bit aligned on 32-bit platforms.
+	live int64
+
+	tw      *TimeWheel
+	timeout int64 // in nanoseconds.
+}
+
+// NewDeadlineManager creates a DeadlineManager whose entries expire once idle
+// for the timeout. It panics if the timeout is not positive.
+func (tw *TimeWheel) NewDeadlineManager(timeout time.Duration) *DeadlineManager {
+	if timeout <= 0 {
+		panic("timewheel: timeout of deadline must be greater than 0")
+	}
+	return &DeadlineManager{tw: tw, timeout: int64(timeout)}
+}
+
+// Timeout returns the idle timeout of the entries.
+func (m *DeadlineManager) Timeout() time.Duration {
+	return time.Duration(m.timeout)
+}
+
+// Len returns the number of entries that are neither expired nor closed.
+func (m *DeadlineManager) Len() int {
+	return int(atomic.LoadInt64(&m.live))
+}
+
+// Deadline is an entry of the DeadlineManager, it's touched on each activity.
+type Deadline struct {
+	// The time of the latest activity, in nanoseconds. It's placed first to
+	// be 64-bit aligned on 32-bit platforms.
+	last int64
+
+	m *DeadlineManager
+	t *Timer
+	f func()
+
+	// Set once the entry is expired or closed, accessed atomically.
+	ended int32
+}
+
+// Add adds an entry that calls f in its own goroutine by default once it's
+// idle for the timeout, i.e. not touched within the timeout. The entry is
+// considered active at the time it's added. The opts apply to its timer.
+func (m *DeadlineManager) Add(f func(), opts ...TimerOption) *Deadline {
+	tw := m.tw
+	now := tw.root.now()
+	d := &Deadline{last: now, m: m, f: f}
+	d.t = tw.newFuncTimer(context.Background(), now+m.timeout, func(_ context.Context, t *Timer) { d.check(t) }, opts)
+
+	atomic.AddInt64(&m.live, 1)
+	if !tw.scheduleNew(d.t, false) {
+		d.end()
+	}
+	return d
+}
+
+// check is the task of the timer, it expires the entry or re-arms the timer
+// for the remainder of the timeout.
+func (d *Deadline) check(t *Timer) {
+	if atomic.LoadInt32(&d.ended) != 0 {
+		return
+	}
+	idle := d.m.tw.root.now() - atomic.LoadInt64(&d.last)
+	if remain := d.m.timeout - idle; remain > 0 {
+		// Re-armed once this task returns, see Timer.Reset.
+		t.Reset(time.Duration(remain))
+		return
+	}
+	if d.end() {
+		d.f()
+	}
+}
+
+// end marks the entry as ended, it returns false if it's already ended.
+func (d *Deadline) end() bool {
+	if !atomic.CompareAndSwapInt32(&d.ended, 0, 1) {
+		return false
+	}
+	atomic.AddInt64(&d.m.live, -1)
+	return true
+}
+
+// Touch records an activity of the entry, it postpones the expiration to the
+// timeout after now. It's a single atomic store, and has no effect once the
+// entry is expired or closed.
+func (d *Deadline) Touch() {
+	atomic.StoreInt64(&d.last, d.m.tw.root.now())
+}
+
+// Remaining returns the time until the entry expires if no more activity, it's
+// zero or negative if the entry is due.
+func (d *Deadline) Remaining() time.Duration {
+	return time.Duration(d.m.timeout - (d.m.tw.root.now() - atomic.LoadInt64(&d.last)))
+}
+
+// Close removes the entry, its f is never called after Close returned unless
+// it's already running. It returns false if the entry is already expired or
+// closed.
+func (d *Deadline) Close() bool {
+	if !d.end() {
+		return false
+	}
+	d.t.Close()
+	return true
+}
+
+// Done returns a channel that's closed once the timer of the entry is finished,
+// i.e. the entry is expired and its f returned, or it's closed.
+func (d *Deadline) Done() <-chan struct{} {
+	return d.t.Done()
+}
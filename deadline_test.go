@@ -0,0 +1,60 @@
+package timewheel
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadlineManager(t *testing.T) {
+	require.Panics(t, func() { New(time.Millisecond, 8).NewDeadlineManager(0) })
+
+	clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	tw := New(time.Millisecond, 8, WithClock(clock), WithDispatchPolicy(DispatchInline))
+	tw.Start()
+	defer tw.Stop()
+
+	m := tw.NewDeadlineManager(time.Millisecond * 10)
+	require.Equal(t, int64(time.Millisecond*10), int64(m.Timeout()))
+
+	var expired int32
+	d := m.Add(func() { atomic.AddInt32(&expired, 1) })
+	closed := m.Add(func() { t.Fatal("the closed entry expired") })
+	require.Equal(t, 2, m.Len())
+	require.True(t, closed.Close())
+	require.False(t, closed.Close())
+	require.Equal(t, 1, m.Len())
+
+	advance := func(n int) {
+		for i := 0; i < n; i++ {
+			clock.add(time.Millisecond)
+			tw.Poll()
+		}
+	}
+
+	// Each touch postpones the expiration, without any fire in between.
+	for i := 0; i < 5; i++ {
+		advance(6)
+		d.Touch()
+	}
+	require.Equal(t, int32(0), atomic.LoadInt32(&expired))
+	require.Equal(t, int64(time.Millisecond*10), int64(d.Remaining()))
+	// Fired at 10ms, 16ms, 22ms and 28ms to re-arm for the remainder.
+	require.Equal(t, uint64(4), tw.Stats().Fired)
+
+	advance(9)
+	require.Equal(t, int32(0), atomic.LoadInt32(&expired))
+	advance(1)
+	require.Equal(t, int32(1), atomic.LoadInt32(&expired))
+	<-d.Done()
+	require.Equal(t, 0, m.Len())
+
+	// The expired entry is never touched again.
+	d.Touch()
+	advance(20)
+	require.Equal(t, int32(1), atomic.LoadInt32(&expired))
+	require.False(t, d.Close())
+	require.Equal(t, int64(0), tw.Pending())
+}
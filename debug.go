@@ -0,0 +1,184 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// maxDebugTimers is the upper limit of timers listed by the DebugHandler.
+const maxDebugTimers = 1000
+
+type debugState struct {
+	Tick     string        `json:"tick"`
+	Size     int64         `json:"size"`
+	Stopped  bool          `json:"stopped"`
+	Counters debugCounters `json:"counters"`
+	Levels   []debugLevel  `json:"levels"`
+	Timers   []debugTimer  `json:"timers,omitempty"`
+}
+
+type debugCounters struct {
+	Pending   int64  `json:"pending"`
+	Scheduled uint64 `json:"scheduled"`
+	Fired     uint64 `json:"fired"`
+	Cancelled uint64 `json:"cancelled"`
+	Skipped   uint64 `json:"skipped"`
+	Queued    uint64 `json:"queued"`
+
+	GuardDenied    uint64 `json:"guard_denied"`
+	Rejected       uint64 `json:"rejected"`
+	Shed           uint64 `json:"shed"`
+	Dropped        uint64 `json:"dropped"`
+	QueueDepth     int    `json:"queue_depth"`
+	ConsumerLag    string `json:"consumer_lag"`
+	ConsumerLagMax string `json:"consumer_lag_max"`
+
+	ExecutorQueue          int `json:"executor_queue"`
+	ExecutorQueueHighWater int `json:"executor_queue_high_water"`
+}
+
+type debugLevel struct {
+	Level    int       `json:"level"`
+	Tick     string    `json:"tick"`
+	Interval string    `json:"interval"`
+	Current  time.Time `json:"current"`
+	Occupied int       `json:"occupied_buckets"`
+	Timers   int       `json:"timers"`
+}
+
+type debugTimer struct {
+	Tag        string    `json:"tag,omitempty"`
+	Expiration time.Time `json:"expiration"`
+	Level      int       `json:"level"`
+}
+
+// DebugHandler returns a http.Handler that serves the state of the TimeWheel
+// as JSON, includes the configuration, counters and per-level occupancy.
+//
+// The upcoming timers are listed only if the query parameter "timers" is
+// given, e.g. "?timers=20" lists about the first 20 upcoming timers, at most
+// 1000. Without it, the handler only locks each bucket briefly to read its
+// length, so that it's safe to serve on a busy TimeWheel.
+func (tw *TimeWheel) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := 0
+		if v := r.URL.Query().Get("timers"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				http.Error(w, "invalid parameter timers: "+v, http.StatusBadRequest)
+				return
+			}
+			if n > maxDebugTimers {
+				n = maxDebugTimers
+			}
+			limit = n
+		}
+
+		state := tw.root.debugState(limit)
+
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(state)
+	})
+}
+
+func (tw *TimeWheel) debugState(limit int) *debugState {
+	stats := tw.Stats()
+	state := &debugState{
+		Tick:    time.Duration(tw.tick).String(),
+		Size:    tw.size,
+		Stopped: atomic.LoadInt32(&tw.stopped) == 1,
+		Counters: debugCounters{
+			Pending:   stats.Pending,
+			Scheduled: stats.Scheduled,
+			Fired:     stats.Fired,
+			Cancelled: stats.Cancelled,
+			Skipped:   stats.Skipped,
+			Queued:    stats.Queued,
+
+			GuardDenied:    stats.GuardDenied,
+			Rejected:       stats.Rejected,
+			Shed:           stats.Shed,
+			Dropped:        stats.Dropped,
+			QueueDepth:     stats.QueueDepth,
+			ConsumerLag:    stats.ConsumerLag.String(),
+			ConsumerLagMax: stats.ConsumerLagMax.String(),
+
+			ExecutorQueue:          stats.ExecutorQueue,
+			ExecutorQueueHighWater: stats.ExecutorQueueHighWater,
+		},
+	}
+
+	for level, l := 0, tw; l != nil; level, l = level+1, l.getOverflow() {
+		dl := debugLevel{
+			Level:    level,
+			Tick:     time.Duration(l.tick).String(),
+			Interval: time.Duration(l.interval).String(),
+			Current:  time.Unix(0, atomic.LoadInt64(&l.current)),
+		}
+		for i := range l.buckets {
+			b := l.loadBucket(int64(i))
+			if b == nil {
+				continue
+			}
+			b.mu.Lock()
+			n := b.timers.Len()
+			b.mu.Unlock()
+			if n != 0 {
+				dl.Occupied++
+				dl.Timers += n
+			}
+		}
+		state.Levels = append(state.Levels, dl)
+	}
+
+	if limit > 0 {
+		for _, info := range tw.upcoming(limit) {
+			state.Timers = append(state.Timers, debugTimer{Tag: info.Tag, Expiration: info.Expiration, Level: info.Level})
+		}
+	}
+	return state
+}
+
+// upcoming returns about the first n upcoming timers sorted by expiration.
+// It visits the buckets from the lowest level, and in the order of expiration
+// within a level, stops once n timers are collected. Thus, it's approximate
+// if the timers at different levels are interleaved, but it never walks more
+// buckets than necessary.
+func (tw *TimeWheel) upcoming(n int) []TimerInfo {
+	var infos []TimerInfo
+	for level, l := 0, tw.root; l != nil && len(infos) < n; level, l = level+1, l.getOverflow() {
+		current := atomic.LoadInt64(&l.current)
+		start := current / l.tick
+		for i := int64(0); i < l.size && len(infos) < n; i++ {
+			b := l.loadBucket((start + i) & l.mask)
+			if b == nil {
+				continue
+			}
+			b.mu.Lock()
+			for e := b.timers.Front(); e != nil; e = e.Next() {
+				t := e.Value
+				infos = append(infos, t.info(level))
+			}
+			b.mu.Unlock()
+		}
+	}
+
+	sort.SliceStable(infos, func(i, j int) bool {
+		return infos[i].Expiration.Before(infos[j].Expiration)
+	})
+	if len(infos) > n {
+		infos = infos[:n]
+	}
+	return infos
+}
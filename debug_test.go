@@ -0,0 +1,80 @@
+package timewheel
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func serveDebug(t *testing.T, tw *TimeWheel, target string) (int, *debugState) {
+	rec := httptest.NewRecorder()
+	tw.DebugHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if rec.Code != http.StatusOK {
+		return rec.Code, nil
+	}
+	require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	state := new(debugState)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), state))
+	return rec.Code, state
+}
+
+func TestTimeWheel_DebugHandler(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	tw.AfterFunc(time.Second*2, func() {}, WithTag("b"))
+	tw.AfterFunc(time.Second, func() {}, WithTag("a"))
+	tw.AfterFunc(time.Hour, func() {}, WithTag("c"))
+
+	code, state := serveDebug(t, tw, "/debug/timewheel")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "1ms", state.Tick)
+	require.Equal(t, int64(8), state.Size)
+	require.False(t, state.Stopped)
+	require.Equal(t, int64(3), state.Counters.Pending)
+	require.Equal(t, uint64(3), state.Counters.Scheduled)
+	require.Equal(t, tw.Stats().Levels, len(state.Levels))
+	require.Nil(t, state.Timers)
+
+	timers := 0
+	for i, l := range state.Levels {
+		require.Equal(t, i, l.Level)
+		require.LessOrEqual(t, l.Occupied, l.Timers)
+		timers += l.Timers
+	}
+	require.Equal(t, 3, timers)
+
+	code, state = serveDebug(t, tw, "/debug/timewheel?timers=2")
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, state.Timers, 2)
+	require.Equal(t, "a", state.Timers[0].Tag)
+	require.Equal(t, "b", state.Timers[1].Tag)
+	require.True(t, state.Timers[0].Expiration.Before(state.Timers[1].Expiration))
+
+	code, state = serveDebug(t, tw, "/debug/timewheel?timers=100")
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, state.Timers, 3)
+
+	code, _ = serveDebug(t, tw, "/debug/timewheel?timers=-1")
+	require.Equal(t, http.StatusBadRequest, code)
+	code, _ = serveDebug(t, tw, "/debug/timewheel?timers=x")
+	require.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestTimeWheel_DebugHandler_Stopped(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	tw.AfterFunc(time.Hour, func() {})
+	tw.Stop()
+
+	code, state := serveDebug(t, tw, "/debug/timewheel?timers=10")
+	require.Equal(t, http.StatusOK, code)
+	require.True(t, state.Stopped)
+	require.Len(t, state.Timers, 1)
+}
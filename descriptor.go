@@ -0,0 +1,108 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The anchors of the descriptors, i.e. the first execution is at the next top of it.
+const (
+	anchorNone = iota
+	anchorHour
+	anchorDay
+	anchorWeek
+)
+
+// Descriptor starts building a recurrence by the cron-style descriptor s,
+// which is one of:
+//
+//	@every <duration>  // every duration in Go syntax such as "90s" or "1h30m", like Every.
+//	@hourly            // every hour, at the top of each hour.
+//	@daily, @midnight  // every day, at each midnight.
+//	@weekly            // every week, at the midnight between Saturday and Sunday.
+//
+// The tops of hour and day are in the location set by In, default is
+// time.Local. The @daily and @weekly follow the wall clock like OnDays, i.e.
+// they stay at the midnight across the daylight saving transitions, and a
+// midnight skipped by a transition is shifted forward by its length.
+//
+// The duration of @every must be greater than or equal to the tick of the
+// TimeWheel. The errors of s wrap ErrInvalidSchedule and are reported by Do.
+func (tw *TimeWheel) Descriptor(s string) *Recurrence {
+	r := &Recurrence{tw: tw, op: "Descriptor"}
+	fail := func(format string, args ...interface{}) *Recurrence {
+		r.err = fmt.Errorf("%w: descriptor %q: %s", ErrInvalidSchedule, s, fmt.Sprintf(format, args...))
+		return r
+	}
+
+	switch s {
+	case "@hourly":
+		r.interval, r.anchor = time.Hour, anchorHour
+	case "@daily", "@midnight":
+		r.interval, r.anchor = time.Hour*24, anchorDay
+	case "@weekly":
+		r.interval, r.anchor = time.Hour*24*7, anchorWeek
+	default:
+		if !strings.HasPrefix(s, "@every ") {
+			return fail("must be one of @every <duration>, @hourly, @daily, @midnight and @weekly")
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(s[len("@every "):]))
+		if err != nil {
+			return fail("%v", err)
+		}
+		if tick := time.Duration(tw.tick); d < tick {
+			return fail("duration %s must be greater than or equal to the tick %s", d, tick)
+		}
+		r.interval = d
+	}
+	return r
+}
+
+// In sets the location of the tops of hour and day for Descriptor, default is time.Local.
+func (r *Recurrence) In(loc *time.Location) *Recurrence {
+	r.loc = loc
+	return r
+}
+
+// location returns the location of the tops of hour and day.
+func (r *Recurrence) location() *time.Location {
+	if r.loc == nil {
+		return time.Local
+	}
+	return r.loc
+}
+
+// anchorDays returns the number of days between two occurrences of the
+// anchor that follows the wall clock, or 0 if it's a fixed interval.
+func (r *Recurrence) anchorDays() int {
+	switch r.anchor {
+	case anchorDay:
+		return 1
+	case anchorWeek:
+		return 7
+	default:
+		return 0
+	}
+}
+
+// nextAnchor returns the first top of the anchor after now.
+func (r *Recurrence) nextAnchor(now time.Time) time.Time {
+	loc := r.location()
+	now = now.In(loc)
+	y, m, d := now.Date()
+
+	switch r.anchor {
+	case anchorHour:
+		return time.Date(y, m, d, now.Hour(), 0, 0, 0, loc).Add(time.Hour)
+	case anchorDay:
+		return wallTime(y, m, d+1, 0, loc)
+	default:
+		return wallTime(y, m, d+7-int(now.Weekday()), 0, loc)
+	}
+}
@@ -0,0 +1,146 @@
+package timewheel
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeWheel_Descriptor(t *testing.T) {
+	tw := New(time.Millisecond*10, 8)
+
+	cases := []struct {
+		s        string
+		interval time.Duration
+		anchor   int
+	}{
+		{"@every 90s", time.Second * 90, anchorNone},
+		{"@every 1h30m", time.Minute * 90, anchorNone},
+		{"@every 10ms", time.Millisecond * 10, anchorNone},
+		{"@hourly", time.Hour, anchorHour},
+		{"@daily", time.Hour * 24, anchorDay},
+		{"@midnight", time.Hour * 24, anchorDay},
+		{"@weekly", time.Hour * 24 * 7, anchorWeek},
+	}
+	for _, c := range cases {
+		r := tw.Descriptor(c.s)
+		require.NoError(t, r.validate(), c.s)
+		require.Equal(t, int64(c.interval), int64(r.interval), c.s)
+		require.Equal(t, c.anchor, r.anchor, c.s)
+	}
+}
+
+func TestTimeWheel_Descriptor_Invalid(t *testing.T) {
+	tw := New(time.Millisecond*10, 8)
+
+	cases := []struct {
+		s   string
+		msg string
+	}{
+		{"@yearly", "must be one of"},
+		{"@every", "must be one of"},
+		{"hourly", "must be one of"},
+		{"@every 90", "missing unit"},
+		{"@every 5ms", "must be greater than or equal to the tick 10ms"},
+		{"@every -1s", "must be greater than or equal to the tick"},
+	}
+	for _, c := range cases {
+		_, err := tw.Descriptor(c.s).Do(func() {})
+		require.True(t, errors.Is(err, ErrInvalidSchedule), c.s)
+		require.Contains(t, err.Error(), c.msg, c.s)
+	}
+}
+
+func TestRecurrence_nextAnchor(t *testing.T) {
+	loc := time.FixedZone("UTC+5:30", 5*3600+1800)
+	tw := New(time.Millisecond, 8)
+	// Saturday.
+	now := time.Date(2024, 1, 6, 10, 45, 30, 0, loc)
+
+	require.Equal(t, time.Date(2024, 1, 6, 11, 0, 0, 0, loc), tw.Descriptor("@hourly").In(loc).nextAnchor(now))
+	require.Equal(t, time.Date(2024, 1, 7, 0, 0, 0, 0, loc), tw.Descriptor("@daily").In(loc).nextAnchor(now))
+	require.Equal(t, time.Date(2024, 1, 7, 0, 0, 0, 0, loc), tw.Descriptor("@weekly").In(loc).nextAnchor(now))
+
+	// Sunday, the next week.
+	now = time.Date(2024, 1, 7, 0, 0, 0, 0, loc)
+	require.Equal(t, time.Date(2024, 1, 14, 0, 0, 0, 0, loc), tw.Descriptor("@weekly").In(loc).nextAnchor(now))
+	require.Equal(t, time.Date(2024, 1, 8, 0, 0, 0, 0, loc), tw.Descriptor("@midnight").In(loc).nextAnchor(now))
+
+	// The top of hour in the location that offsets half an hour.
+	require.Equal(t, 30, tw.Descriptor("@hourly").In(loc).nextAnchor(now.Add(time.Minute)).UTC().Minute())
+
+	// The default location.
+	require.Equal(t, time.Local, tw.Descriptor("@daily").nextAnchor(time.Now()).Location())
+}
+
+func TestTimeWheel_Descriptor_Do(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	doneC := make(chan struct{})
+	timer, err := tw.Descriptor("@every 5ms").Times(2).Do(func() {})
+	require.NoError(t, err)
+	go func() {
+		<-timer.Done()
+		close(doneC)
+	}()
+	waitC(t, doneC)
+	require.Equal(t, EndTimes, timer.EndReason())
+
+	// The first execution is at the top of the next hour.
+	timer, err = tw.Descriptor("@hourly").In(time.UTC).Do(func() {})
+	require.NoError(t, err)
+	defer timer.Close()
+	next := time.Now().UTC().Truncate(time.Hour).Add(time.Hour)
+	require.Equal(t, next.UnixNano(), timer.getExpiration())
+}
+
+func TestTimeWheel_Descriptor_DST(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	cases := []struct {
+		s     string
+		now   time.Time
+		fires []time.Time
+	}{
+		// The clock springs forward on 2024-03-10.
+		{"@daily", time.Date(2024, 3, 9, 12, 0, 0, 0, ny), []time.Time{
+			time.Date(2024, 3, 10, 0, 0, 0, 0, ny),
+			time.Date(2024, 3, 11, 0, 0, 0, 0, ny),
+			time.Date(2024, 3, 12, 0, 0, 0, 0, ny),
+		}},
+		// The clock falls back on 2024-11-03, a Sunday.
+		{"@weekly", time.Date(2024, 10, 30, 12, 0, 0, 0, ny), []time.Time{
+			time.Date(2024, 11, 3, 0, 0, 0, 0, ny),
+			time.Date(2024, 11, 10, 0, 0, 0, 0, ny),
+			time.Date(2024, 11, 17, 0, 0, 0, 0, ny),
+		}},
+	}
+	for _, c := range cases {
+		clock := &manualClock{now: c.now}
+		tw := New(time.Second, 60, WithClock(clock), WithDispatchPolicy(DispatchInline))
+		tw.Start()
+
+		var fired []time.Time
+		timer, err := tw.Descriptor(c.s).In(ny).Times(len(c.fires)).Do(func() { fired = append(fired, tw.timeNow()) })
+		require.NoError(t, err, c.s)
+		for _, fire := range c.fires {
+			require.Equal(t, fire.UnixNano(), timer.Expiration().UnixNano(), c.s)
+			clock.add(fire.Sub(tw.timeNow()))
+			for n, _ := tw.Poll(); n != 0; n, _ = tw.Poll() {
+			}
+		}
+		require.Equal(t, StateCompleted, timer.State(), c.s)
+		require.Len(t, fired, len(c.fires), c.s)
+		for i, fire := range c.fires {
+			// At the midnight of the wall clock, not 23:00 or 01:00.
+			require.Equal(t, 0, fired[i].In(ny).Hour(), c.s)
+			require.Equal(t, fire.UnixNano(), fired[i].UnixNano(), c.s)
+		}
+		tw.Stop()
+	}
+}
@@ -0,0 +1,310 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"context"
+	"log/slog"
+	"runtime/debug"
+	"runtime/trace"
+	"sync/atomic"
+	"time"
+)
+
+// dispatch executes the task func f of the timer t according to the DispatchPolicy.
+// The ctx is the parent of the context passed to f.
+func (tw *TimeWheel) dispatch(ctx context.Context, t *Timer, f func(ctx context.Context)) {
+	if t.tw.root != tw.root {
+		// Routed to the critical plane, see WithCriticalPlane.
+		t.tw.dispatch(ctx, t, f)
+		return
+	}
+	root := tw.root
+	if root.opts.onExpireBatch != nil {
+		tw.handOver(t, true)
+		return
+	}
+	inline := root.opts.dispatchPolicy == DispatchInline
+
+	if root.durations != nil {
+		f = tw.timed(t, f)
+	}
+	if root.opts.watchdog > 0 && !inline {
+		f = tw.watched(t, f)
+	}
+	if root.baseCtx != nil {
+		f = tw.withBaseContext(f)
+	}
+	if trace.IsEnabled() {
+		f = traceRegion(t, f)
+	}
+	if e := root.opts.executor; e != nil {
+		tw.executeBy(e, ctx, t, f)
+		return
+	}
+	if root.opts.dispatchPolicy == DispatchBatch && tw.collect(ctx, t, f) {
+		return
+	}
+
+	if root.opts.taskTimeout <= 0 {
+		if inline {
+			tw.runInline(ctx, t, f)
+			return
+		}
+		// Like the standard time.AfterFunc (https://golang.org/pkg/time/#AfterFunc),
+		// execute the timer's task in its own goroutine.
+		go f(ctx)
+		return
+	}
+
+	if inline {
+		tw.runInlineWithTimeout(ctx, t, f)
+		return
+	}
+	go tw.runWithTimeout(ctx, t, f)
+}
+
+// executeBy hands the task func f to the Executor e, the task is dropped if
+// e refused it.
+func (tw *TimeWheel) executeBy(e Executor, ctx context.Context, t *Timer, f func(ctx context.Context)) {
+	var err error
+	if tw.root.opts.taskTimeout > 0 {
+		err = e.Execute(func() { tw.runWithTimeout(ctx, t, f) })
+	} else {
+		err = e.Execute(func() { f(ctx) })
+	}
+	if err != nil {
+		tw.drop(t, err)
+	}
+}
+
+// drop handles the task of timer t that is dropped for err, the run-once
+// timer is finished with EndDropped.
+func (tw *TimeWheel) drop(t *Timer, err error) {
+	root := tw.root
+	atomic.AddUint64(&root.dropped, 1)
+	if l := root.opts.logger; l != nil {
+		l.Warn("timewheel: task dropped", timerAttr(t), slog.Any("error", err))
+	}
+	if f := root.opts.onDrop; f != nil {
+		f(t, err)
+	}
+	tw.notifyWatchers(EventDropped, t)
+	tw.deadLetter(t, err)
+	if o := t.getAttrs().overlap; o != nil {
+		// The refused execution never returns, see runRecurring.
+		o.release()
+	}
+	if t.State() == StateRunning {
+		t.setEndReason(EndDropped)
+		t.transit(StateRunning, StateCompleted)
+		t.finish()
+	}
+}
+
+// handOver adds the expired timer t to the batch of OnExpireBatch if the
+// consumer goroutine is dispatching, or hands it over alone otherwise. If
+// it's the last execution of t, t is completed as its task returned.
+func (tw *TimeWheel) handOver(t *Timer, last bool) {
+	root := tw.root
+	if last && t.State() == StateRunning {
+		if t.getAttrs().reusable {
+			// The ReusableTimer is never finished.
+			t.transit(StateRunning, StateCompleted)
+		} else {
+			t.complete()
+		}
+	}
+	root.deferMu.Lock()
+	if root.dispatching {
+		root.expireBatch = append(root.expireBatch, t)
+		root.deferMu.Unlock()
+		return
+	}
+	root.deferMu.Unlock()
+	root.opts.onExpireBatch([]*Timer{t})
+}
+
+// withBaseContext wraps the task func f to receive a context that is also
+// cancelled once the base context is done, see WithBaseContext.
+func (tw *TimeWheel) withBaseContext(f func(ctx context.Context)) func(ctx context.Context) {
+	base := tw.root.baseCtx
+	return func(ctx context.Context) {
+		if ctx == context.Background() {
+			// No context of the timer's own.
+			f(base)
+			return
+		}
+		ctx, cancel := context.WithCancelCause(ctx)
+		stop := context.AfterFunc(base, func() { cancel(context.Cause(base)) })
+		defer func() {
+			stop()
+			cancel(nil)
+		}()
+		f(ctx)
+	}
+}
+
+// runInline executes f in the consumer goroutine, and recovers it from panic
+// so that the rest of the expired timers are not affected.
+func (tw *TimeWheel) runInline(ctx context.Context, t *Timer, f func(ctx context.Context)) {
+	defer func() {
+		if r := recover(); r != nil {
+			tw.recovered(t, r)
+		}
+	}()
+	f(ctx)
+}
+
+// recovered handles the panic r recovered from the task of timer t, the run-once
+// timer is finished as its task returned.
+//
+// The panic of a timer with NoRecover is propagated after the bookkeeping: it's
+// re-panicked by the consumer goroutine once the current bucket is dispatched
+// if it's dispatching, or on a fresh goroutine otherwise.
+func (tw *TimeWheel) recovered(t *Timer, r interface{}) {
+	root := tw.root
+	noRecover := t.getAttrs().noRecover
+	if noRecover {
+		if l := root.opts.logger; l != nil {
+			l.Error("timewheel: task panic propagated", timerAttr(t), slog.Any("panic", r), slog.String("stack", string(debug.Stack())))
+		}
+	} else {
+		tw.logPanic(t, r)
+		if f := root.opts.onPanic; f != nil {
+			f(t, r)
+		}
+		tw.deadLetterPanic(t, r)
+	}
+	if t.State() == StateRunning {
+		t.complete()
+	}
+	if !noRecover {
+		return
+	}
+
+	root.deferMu.Lock()
+	if root.dispatching {
+		if root.propagated == nil {
+			root.propagated = r
+		}
+		root.deferMu.Unlock()
+		return
+	}
+	root.deferMu.Unlock()
+	go panic(r)
+}
+
+// runWithTimeout executes f in the current goroutine, and reports the overrun
+// if it exceeded the task timeout.
+func (tw *TimeWheel) runWithTimeout(ctx context.Context, t *Timer, f func(ctx context.Context)) {
+	timeout := tw.root.opts.taskTimeout
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	watch := time.AfterFunc(timeout, func() {
+		tw.overrun(t)
+	})
+	defer watch.Stop()
+
+	f(ctx)
+}
+
+// runInlineWithTimeout executes f in a new goroutine and waits for it returns
+// or exceeded the task timeout, so that the consumer goroutine is never blocked
+// by a task longer than the timeout.
+func (tw *TimeWheel) runInlineWithTimeout(ctx context.Context, t *Timer, f func(ctx context.Context)) {
+	timeout := tw.root.opts.taskTimeout
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	doneC := make(chan struct{})
+	go func() {
+		defer close(doneC)
+		tw.runInline(ctx, t, f)
+	}()
+
+	watch := time.NewTimer(timeout)
+	defer watch.Stop()
+
+	select {
+	case <-doneC:
+	case <-watch.C:
+		// Leave the task behind.
+		tw.overrun(t)
+	}
+}
+
+// overrun reports the task of timer t exceeded the task timeout.
+func (tw *TimeWheel) overrun(t *Timer) {
+	root := tw.root
+	if l := root.opts.logger; l != nil {
+		l.Warn("timewheel: task exceeded timeout", timerAttr(t), slog.Duration("timeout", root.opts.taskTimeout))
+	}
+	if f := root.opts.onOverrun; f != nil {
+		f(t)
+	}
+}
+
+// batchTask is a task collected into the chunk of the DispatchBatch.
+type batchTask struct {
+	ctx context.Context
+	t   *Timer
+	f   func(ctx context.Context)
+}
+
+// collect adds the task f to the current chunk if the consumer goroutine is
+// dispatching, the chunk is executed once it's full or the dispatching ended.
+// It returns false if the task is not collected.
+func (tw *TimeWheel) collect(ctx context.Context, t *Timer, f func(ctx context.Context)) bool {
+	root := tw.root
+	root.deferMu.Lock()
+	if !root.dispatching {
+		root.deferMu.Unlock()
+		return false
+	}
+	if root.batch == nil {
+		root.batch = make([]batchTask, 0, root.opts.batchSize)
+	}
+	root.batch = append(root.batch, batchTask{ctx: ctx, t: t, f: f})
+	var full []batchTask
+	if len(root.batch) >= root.opts.batchSize {
+		full = root.batch
+		root.batch = nil
+	}
+	root.deferMu.Unlock()
+
+	if full != nil {
+		go tw.runBatch(full)
+	}
+	return true
+}
+
+// runBatch executes the tasks of a chunk one by one.
+func (tw *TimeWheel) runBatch(batch []batchTask) {
+	for i := range batch {
+		tw.runBatchTask(&batch[i])
+		batch[i] = batchTask{}
+	}
+}
+
+// runBatchTask executes a task of the chunk, and recovers it from panic so
+// that the rest of the chunk is not affected.
+func (tw *TimeWheel) runBatchTask(bt *batchTask) {
+	t := bt.t
+	defer func() {
+		if r := recover(); r != nil {
+			tw.recovered(t, r)
+		}
+	}()
+	if tw.root.opts.taskTimeout > 0 {
+		tw.runWithTimeout(bt.ctx, t, bt.f)
+		return
+	}
+	bt.f(bt.ctx)
+}
@@ -0,0 +1,387 @@
+package timewheel
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithTaskTimeout(t *testing.T) {
+	overrunC := make(chan *Timer, 1)
+	tw := New(time.Millisecond, 8, WithTaskTimeout(time.Millisecond*10), OnOverrun(func(t *Timer) {
+		overrunC <- t
+	}))
+	tw.Start()
+	defer tw.Stop()
+
+	t.Run("context", func(t *testing.T) {
+		errC := make(chan error, 1)
+		timer := tw.AfterFuncContext(context.Background(), time.Millisecond, func(ctx context.Context) {
+			<-ctx.Done()
+			errC <- ctx.Err()
+		})
+		require.Equal(t, <-errC, context.DeadlineExceeded)
+		require.Equal(t, <-overrunC, timer)
+	})
+
+	t.Run("legacy", func(t *testing.T) {
+		releaseC := make(chan struct{})
+		timer := tw.AfterFunc(time.Millisecond, func() {
+			<-releaseC
+		})
+		// Reported while the task is still running.
+		require.Equal(t, <-overrunC, timer)
+		close(releaseC)
+		<-timer.Done()
+	})
+
+	t.Run("in-time", func(t *testing.T) {
+		timer := tw.AfterFunc(time.Millisecond, func() {})
+		<-timer.Done()
+		select {
+		case <-overrunC:
+			t.Fatal("unexpected overrun")
+		case <-time.After(time.Millisecond * 20):
+		}
+	})
+}
+
+func TestWithTaskTimeout_Inline(t *testing.T) {
+	var overruns int32
+	tw := New(time.Millisecond, 8,
+		WithDispatchPolicy(DispatchInline),
+		WithTaskTimeout(time.Millisecond*10),
+		OnOverrun(func(t *Timer) { atomic.AddInt32(&overruns, 1) }),
+	)
+	tw.Start()
+	defer tw.Stop()
+
+	releaseC := make(chan struct{})
+	defer close(releaseC)
+
+	// The blocked task does not starve the consumer goroutine.
+	tw.AfterFunc(time.Millisecond, func() { <-releaseC })
+	timer := tw.AfterFunc(time.Millisecond*2, func() {})
+
+	waitC(t, timer.Done())
+	require.Equal(t, atomic.LoadInt32(&overruns), int32(1))
+}
+
+func TestWithBatchDispatch(t *testing.T) {
+	rec := new(logRecorder)
+	tw := New(time.Millisecond*10, 8, WithBatchDispatch(10), WithLogger(rec.logger()))
+
+	var mu sync.Mutex
+	var started []int
+	releaseC := make(chan struct{})
+
+	at := time.Now().Add(time.Millisecond * 30)
+	timers := make([]*Timer, 0, 35)
+	for i := 0; i < 35; i++ {
+		i := i
+		timers = append(timers, tw.TimeFunc(at, func() {
+			mu.Lock()
+			started = append(started, i)
+			mu.Unlock()
+			if i%10 == 0 {
+				<-releaseC
+			}
+			if i == 13 {
+				panic("boom")
+			}
+		}))
+	}
+	tw.Start()
+	defer tw.Stop()
+
+	// The first task of each chunk blocks the rest of its chunk.
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(started) == 4
+	}, time.Second, time.Millisecond)
+	mu.Lock()
+	require.ElementsMatch(t, []int{0, 10, 20, 30}, started)
+	mu.Unlock()
+
+	close(releaseC)
+	for _, timer := range timers {
+		<-timer.Done()
+	}
+
+	// The order within a chunk is preserved, and the panic is isolated.
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, started, 35)
+	last := []int{-1, -1, -1, -1}
+	for _, i := range started {
+		require.Greater(t, i, last[i/10])
+		last[i/10] = i
+	}
+	require.Equal(t, "boom", rec.find(t, "timewheel: task panic recovered")["panic"])
+	require.Equal(t, EndCompleted, timers[13].EndReason())
+}
+
+func TestWithBatchDispatch_Outside(t *testing.T) {
+	tw := New(time.Millisecond, 8, WithDispatchPolicy(DispatchBatch))
+	tw.Start()
+	defer tw.Stop()
+
+	// The expired timer scheduled by the caller runs in its own goroutine.
+	doneC := make(chan struct{})
+	timer := tw.AfterFunc(0, func() { <-doneC })
+	close(doneC)
+	<-timer.Done()
+
+	require.Equal(t, defaultBatchSize, tw.opts.batchSize)
+	require.Panics(t, func() { WithBatchDispatch(0) })
+}
+
+func TestDispatchInline_Panic(t *testing.T) {
+	clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	var panicked []*Timer
+	tw := New(time.Millisecond, 8, WithClock(clock), WithDispatchPolicy(DispatchInline),
+		OnPanic(func(timer *Timer, value interface{}) {
+			require.Equal(t, timer.Tag(), value)
+			panicked = append(panicked, timer)
+		}))
+	tw.Start()
+	defer tw.Stop()
+
+	// A bucket with interleaved panicking and counting tasks.
+	n := 1000
+	counters := make([]int, n)
+	var bad []*Timer
+	for i := 0; i < n; i++ {
+		i := i
+		if i%3 == 2 {
+			bad = append(bad, tw.AfterFunc(time.Millisecond*5, func() { panic("bad") }, WithTag("bad")))
+			continue
+		}
+		tw.AfterFunc(time.Millisecond*5, func() { counters[i]++ })
+	}
+	require.Equal(t, 1, tw.queue.len())
+
+	clock.add(time.Millisecond * 5)
+	tw.Poll()
+	for i, c := range counters {
+		if i%3 != 2 {
+			require.Equal(t, 1, c, "timer #%d", i)
+		}
+	}
+	require.Equal(t, bad, panicked)
+	for _, timer := range bad {
+		require.Equal(t, StateCompleted, timer.State())
+	}
+	require.Equal(t, uint64(n), tw.Stats().Fired)
+	require.Equal(t, int64(0), tw.Pending())
+}
+
+func TestNoRecover(t *testing.T) {
+	clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	var recovered int
+	tw := New(time.Millisecond, 8, WithClock(clock), WithDispatchPolicy(DispatchInline),
+		OnPanic(func(*Timer, interface{}) { recovered++ }))
+	tw.Start()
+	defer tw.Stop()
+
+	var fired int
+	tw.AfterFunc(time.Millisecond*5, func() { fired++ })
+	fatal := tw.AfterFunc(time.Millisecond*5, func() { panic("corrupted") }, NoRecover())
+	tw.AfterFunc(time.Millisecond*5, func() { panic("recoverable") })
+	tw.AfterFunc(time.Millisecond*5, func() { fired++ })
+
+	// The panic is propagated after all the timers of the bucket dispatched.
+	clock.add(time.Millisecond * 5)
+	require.PanicsWithValue(t, "corrupted", func() { tw.Poll() })
+	require.Equal(t, 2, fired)
+	require.Equal(t, 1, recovered)
+	require.Equal(t, StateCompleted, fatal.State())
+	require.Equal(t, int64(0), tw.Pending())
+
+	// The TimeWheel keeps working if the program survived.
+	timer := tw.AfterFunc(time.Millisecond*5, func() { fired++ })
+	clock.add(time.Millisecond * 5)
+	tw.Poll()
+	require.Equal(t, StateCompleted, timer.State())
+	require.Equal(t, 3, fired)
+}
+
+func TestNoRecover_Future(t *testing.T) {
+	clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	tw := New(time.Millisecond, 8, WithClock(clock), WithDispatchPolicy(DispatchInline))
+	tw.Start()
+	defer tw.Stop()
+
+	fu := ScheduleResult(tw, time.Millisecond, func() (int, error) { panic("corrupted") }, NoRecover())
+	clock.add(time.Millisecond)
+	require.PanicsWithValue(t, "corrupted", func() { tw.Poll() })
+
+	_, err := fu.Get(context.Background())
+	var pe *PanicError
+	require.True(t, errors.As(err, &pe))
+	require.Equal(t, "corrupted", pe.Value)
+}
+
+type baseKey struct{}
+
+func TestWithBaseContext(t *testing.T) {
+	base := context.WithValue(context.Background(), baseKey{}, "base")
+	tw := New(time.Millisecond, 8, WithBaseContext(base))
+	tw.Start()
+
+	causes := make(chan error, 2)
+	// Without a context of its own, the task receives the base.
+	tw.AfterFuncContext(context.Background(), time.Millisecond, func(ctx context.Context) {
+		require.Equal(t, "base", ctx.Value(baseKey{}))
+		<-ctx.Done()
+		causes <- context.Cause(ctx)
+	})
+	// With a context of its own, the task is cancelled by either of them.
+	own, cancel := context.WithCancel(context.WithValue(context.Background(), baseKey{}, "own"))
+	defer cancel()
+	tw.TimeFuncContext(own, time.Now().Add(time.Millisecond), func(ctx context.Context) {
+		require.Equal(t, "own", ctx.Value(baseKey{}))
+		<-ctx.Done()
+		causes <- context.Cause(ctx)
+	})
+	var plain int32
+	tw.AfterFunc(time.Millisecond, func() { atomic.AddInt32(&plain, 1) })
+
+	require.Eventually(t, func() bool { return tw.Stats().Fired == 3 }, time.Second, time.Millisecond)
+	select {
+	case err := <-causes:
+		t.Fatalf("cancelled before stopped: %v", err)
+	case <-time.After(time.Millisecond * 10):
+	}
+
+	tw.Stop()
+	require.Equal(t, ErrStopped, <-causes)
+	require.Equal(t, ErrStopped, <-causes)
+	require.Equal(t, int32(1), atomic.LoadInt32(&plain))
+	require.NoError(t, own.Err())
+}
+
+// queueExecutor is an Executor of a bounded run queue that drained by run.
+type queueExecutor struct {
+	mu    sync.Mutex
+	queue []func()
+	max   int
+}
+
+var errQueueFull = errors.New("queue full")
+
+func (e *queueExecutor) Execute(f func()) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.queue) >= e.max {
+		return errQueueFull
+	}
+	e.queue = append(e.queue, f)
+	return nil
+}
+
+func (e *queueExecutor) run() int {
+	e.mu.Lock()
+	queue := e.queue
+	e.queue = nil
+	e.mu.Unlock()
+	for _, f := range queue {
+		f()
+	}
+	return len(queue)
+}
+
+func TestWithExecutor(t *testing.T) {
+	clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	e := &queueExecutor{max: 2}
+	var dropped []*Timer
+	tw := New(time.Millisecond, 8, WithClock(clock), WithExecutor(e),
+		OnDrop(func(timer *Timer, err error) {
+			require.Equal(t, errQueueFull, err)
+			dropped = append(dropped, timer)
+		}))
+	tw.Start()
+	defer tw.Stop()
+
+	var fired []int
+	t1 := tw.AfterFunc(time.Millisecond, func() { fired = append(fired, 1) })
+	t2 := tw.AfterFunc(time.Millisecond, func() { fired = append(fired, 2) })
+	t3 := tw.AfterFunc(time.Millisecond, func() { fired = append(fired, 3) })
+
+	// The tasks are posted to the executor rather than executed.
+	clock.add(time.Millisecond)
+	tw.Poll()
+	require.Len(t, fired, 0)
+	require.Equal(t, StateRunning, t1.State())
+	require.Equal(t, 2, e.run())
+	require.Equal(t, []int{1, 2}, fired)
+	require.Equal(t, StateCompleted, t2.State())
+	require.Equal(t, EndCompleted, t2.EndReason())
+
+	// The refused task is dropped.
+	require.Equal(t, []*Timer{t3}, dropped)
+	require.Equal(t, StateCompleted, t3.State())
+	require.Equal(t, EndDropped, t3.EndReason())
+	require.Equal(t, uint64(1), tw.Stats().Dropped)
+	<-t3.Done()
+
+	// The timer that is already expired goes through the executor too.
+	t4 := tw.AfterFunc(0, func() { fired = append(fired, 4) })
+	require.Equal(t, StateRunning, t4.State())
+	require.Equal(t, 1, e.run())
+	require.Equal(t, []int{1, 2, 4}, fired)
+	require.Equal(t, int64(0), tw.Pending())
+}
+
+func TestOnExpireBatch(t *testing.T) {
+	clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	var batches [][]*Timer
+	tw := New(time.Millisecond, 8, WithClock(clock), OnExpireBatch(func(timers []*Timer) {
+		batches = append(batches, timers)
+	}))
+	tw.Start()
+	defer tw.Stop()
+
+	a := tw.After(time.Millisecond*5, "a")
+	b := tw.AfterFunc(time.Millisecond*5, func() { t.Error("executed") })
+	c := tw.After(time.Millisecond*5, "c")
+	sh := &countdown{interval: time.Millisecond * 5, n: 2}
+	r := tw.Schedule(sh)
+	rt := tw.NewStoppedTimer(func() { t.Error("executed") })
+	rt.Start(time.Millisecond * 10)
+
+	// The whole bucket in the FIFO order, the recurring timer is re-armed.
+	clock.add(time.Millisecond * 5)
+	tw.Poll()
+	require.Len(t, batches, 1)
+	require.Equal(t, []*Timer{a, b, c, r}, batches[0])
+	require.Equal(t, "c", batches[0][2].Payload())
+	for _, timer := range []*Timer{a, b, c} {
+		<-timer.Done()
+		require.Equal(t, StateCompleted, timer.State())
+	}
+	require.Equal(t, StateScheduled, r.State())
+	require.Equal(t, int64(2), tw.Pending())
+
+	// The last execution of the recurring timer is finished.
+	clock.add(time.Millisecond * 5)
+	tw.Poll()
+	require.Len(t, batches, 2)
+	require.Equal(t, []*Timer{r, &rt.timer}, batches[1])
+	<-r.Done()
+	require.Equal(t, StateCompleted, rt.State())
+	require.Equal(t, int32(0), atomic.LoadInt32(&sh.runs))
+	require.Equal(t, int64(0), tw.Pending())
+	require.Equal(t, uint64(6), tw.Stats().Fired)
+
+	// Expired when scheduled, it's handed over alone.
+	d := tw.After(-time.Millisecond, "d")
+	require.Len(t, batches, 3)
+	require.Equal(t, []*Timer{d}, batches[2])
+}
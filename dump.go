@@ -0,0 +1,115 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// dumpTimeLayout is the layout of times in the output of Dump.
+const dumpTimeLayout = time.RFC3339Nano
+
+type dumpBucket struct {
+	index      int
+	expiration int64
+	length     int
+	timers     []TimerInfo
+}
+
+// Dump writes a human-readable description of the whole hierarchy to w,
+// one record per line in the form of "key=value" pairs:
+//
+//	timewheel tick=1ms size=8 levels=2 pending=3 stopped=false
+//	level=0 tick=1ms size=8 interval=8ms current=2020-01-01T00:00:00Z
+//	level=0 bucket=3 expiration=2020-01-01T00:00:00.003Z timers=2
+//	level=0 bucket=3 timer tag="foo" expiration=2020-01-01T00:00:00.003Z
+//	level=0 bucket=3 omitted=1
+//
+// Only non-empty buckets are listed. The timer records are written only if
+// verbose is true, at most the limit set by WithDumpLimit per bucket.
+// Each bucket is locked only while it's being copied, and never while writing
+// to w, thus it's safe to call on a busy or stopped TimeWheel.
+// It returns the first error that writing to w.
+func (tw *TimeWheel) Dump(w io.Writer, verbose bool) error {
+	root := tw.root
+	stats := root.Stats()
+
+	if _, err := fmt.Fprintf(w, "timewheel tick=%s size=%d levels=%d pending=%d stopped=%t\n",
+		time.Duration(root.tick), root.size, stats.Levels, stats.Pending, atomic.LoadInt32(&root.stopped) == 1); err != nil {
+		return err
+	}
+
+	for level, l := 0, root; l != nil; level, l = level+1, l.getOverflow() {
+		current := time.Unix(0, atomic.LoadInt64(&l.current)).UTC()
+		if _, err := fmt.Fprintf(w, "level=%d tick=%s size=%d interval=%s current=%s\n",
+			level, time.Duration(l.tick), l.size, time.Duration(l.interval), current.Format(dumpTimeLayout)); err != nil {
+			return err
+		}
+
+		for i := range l.buckets {
+			b := l.loadBucket(int64(i))
+			if b == nil {
+				continue
+			}
+			db := b.dump(i, level, verbose, root.opts.dumpLimit)
+			if db.length == 0 {
+				continue
+			}
+			if err := db.write(w, level); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// dump copies the state of the bucket, includes the first limit timers if verbose.
+func (b *bucket) dump(index int, level int, verbose bool, limit int) *dumpBucket {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	db := &dumpBucket{index: index, expiration: b.getExpiration(), length: b.timers.Len()}
+	if !verbose {
+		return db
+	}
+	for e := b.timers.Front(); e != nil; e = e.Next() {
+		if limit > 0 && len(db.timers) >= limit {
+			break
+		}
+		t := e.Value
+		db.timers = append(db.timers, t.info(level))
+	}
+	return db
+}
+
+func (db *dumpBucket) write(w io.Writer, level int) error {
+	expiration := "none"
+	if db.expiration != -1 {
+		expiration = time.Unix(0, db.expiration).UTC().Format(dumpTimeLayout)
+	}
+	if _, err := fmt.Fprintf(w, "level=%d bucket=%d expiration=%s timers=%d\n", level, db.index, expiration, db.length); err != nil {
+		return err
+	}
+	if db.timers == nil {
+		return nil
+	}
+
+	for _, info := range db.timers {
+		if _, err := fmt.Fprintf(w, "level=%d bucket=%d timer tag=%q expiration=%s\n",
+			level, db.index, info.Tag, info.Expiration.UTC().Format(dumpTimeLayout)); err != nil {
+			return err
+		}
+	}
+	if omitted := db.length - len(db.timers); omitted > 0 {
+		if _, err := fmt.Fprintf(w, "level=%d bucket=%d omitted=%d\n", level, db.index, omitted); err != nil {
+			return err
+		}
+	}
+	return nil
+}
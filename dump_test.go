@@ -0,0 +1,58 @@
+package timewheel
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeWheel_Dump(t *testing.T) {
+	tw := New(time.Millisecond, 8, WithDumpLimit(2))
+	tw.Start()
+	defer tw.Stop()
+
+	// Three timers in the same bucket of level 1.
+	for i := 0; i < 3; i++ {
+		tw.AfterFunc(time.Second, func() {}, WithTag("foo"))
+	}
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, tw.Dump(buf, false))
+	out := buf.String()
+	require.True(t, strings.HasPrefix(out, "timewheel tick=1ms size=8 "), out)
+	require.Contains(t, out, "pending=3 stopped=false\n")
+	require.Contains(t, out, "level=0 tick=1ms size=8 interval=8ms current=")
+	require.Contains(t, out, " timers=3\n")
+	require.NotContains(t, out, " timer tag=")
+
+	buf.Reset()
+	require.NoError(t, tw.Dump(buf, true))
+	out = buf.String()
+	require.Equal(t, 2, strings.Count(out, ` timer tag="foo" expiration=`), out)
+	require.Contains(t, out, " omitted=1\n")
+}
+
+func TestTimeWheel_Dump_Stopped(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	tw.AfterFunc(time.Hour, func() {})
+	tw.Stop()
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, tw.Dump(buf, true))
+	require.Contains(t, buf.String(), "stopped=true")
+	require.Equal(t, 1, strings.Count(buf.String(), " timer tag="))
+}
+
+type errWriter struct{}
+
+func (errWriter) Write(p []byte) (int, error) { return 0, errors.New("write error") }
+
+func TestTimeWheel_Dump_Error(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	require.EqualError(t, tw.Dump(errWriter{}, false), "write error")
+}
@@ -0,0 +1,216 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultDurationBounds are the default upper bounds of the buckets of the
+// task duration histograms, see WithTaskDurations.
+var DefaultDurationBounds = []time.Duration{
+	100 * time.Microsecond, time.Millisecond, 10 * time.Millisecond,
+	100 * time.Millisecond, time.Second, 10 * time.Second,
+}
+
+// WithTaskDurations makes the TimeWheel time the execution of each task into
+// the fixed-bucket histograms with the upper bounds given, or the
+// DefaultDurationBounds if no bound given. It's separated from the fire lag,
+// the duration is measured from the start of the task func to its return or
+// panic, in real time even if the TimeWheel is driven by a Clock.
+//
+// Besides the histogram of all tasks, the tasks with a tag (see WithTag) are
+// broken down by the tag, for at most maxTags distinct tags in the order that
+// they're first seen; the tasks of the other tags are folded into a single
+// histogram, thus the cardinality is bounded. The maxTags of 0 disables the
+// breakdown. The histograms are read by TaskDurations, and reported to the
+// MetricsSink as MetricTaskDuration, see TagSink for the breakdown.
+//
+// It panics if the maxTags is negative or a bound is not positive.
+func WithTaskDurations(maxTags int, bounds ...time.Duration) Option {
+	if maxTags < 0 {
+		panic("timewheel: max tags of task durations must be greater than or equal to 0")
+	}
+	if len(bounds) == 0 {
+		bounds = DefaultDurationBounds
+	}
+	bs := append([]time.Duration(nil), bounds...)
+	sort.Slice(bs, func(i, j int) bool { return bs[i] < bs[j] })
+	if bs[0] <= 0 {
+		panic("timewheel: bound of task durations must be greater than 0")
+	}
+	return func(o *options) {
+		o.durationTags = maxTags
+		o.durationBounds = bs
+	}
+}
+
+// DurationHistogram is a fixed-bucket histogram of the task durations.
+type DurationHistogram struct {
+	// Bounds are the inclusive upper bounds of the buckets in ascending order.
+	Bounds []time.Duration
+	// Counts are the number of tasks in each bucket, it has one more bucket
+	// than the Bounds for the durations above the last bound.
+	Counts []uint64
+	// Count is the total number of tasks, and Sum is their total duration.
+	Count uint64
+	Sum   time.Duration
+	// Max is the longest duration observed.
+	Max time.Duration
+}
+
+// TaskDurations is a point-in-time view of the task duration histograms.
+type TaskDurations struct {
+	// All is the histogram of all tasks.
+	All DurationHistogram
+	// ByTag is the histograms of the tasks with a tag, by the tag.
+	ByTag map[string]DurationHistogram
+	// Other is the histogram of the tasks with a tag beyond the maximum number
+	// of tags, they're not in the ByTag.
+	Other DurationHistogram
+}
+
+// TaskDurations returns the task duration histograms, it's empty unless the
+// WithTaskDurations is set.
+func (tw *TimeWheel) TaskDurations() TaskDurations {
+	td := tw.root.durations
+	if td == nil {
+		return TaskDurations{}
+	}
+	s := TaskDurations{All: td.all.snapshot(td.bounds), Other: td.other.snapshot(td.bounds)}
+	td.mu.RLock()
+	defer td.mu.RUnlock()
+	if len(td.tags) != 0 {
+		s.ByTag = make(map[string]DurationHistogram, len(td.tags))
+		for tag, h := range td.tags {
+			s.ByTag[tag] = h.snapshot(td.bounds)
+		}
+	}
+	return s
+}
+
+// durationHistogram is a lock-free fixed-bucket histogram, its fields are
+// accessed atomically. The 64-bit fields are placed first, to be 8-byte
+// aligned on the 32-bit platforms.
+type durationHistogram struct {
+	sum    int64
+	max    int64
+	counts []uint64
+}
+
+func newDurationHistogram(buckets int) *durationHistogram {
+	return &durationHistogram{counts: make([]uint64, buckets)}
+}
+
+func (h *durationHistogram) observe(bounds []time.Duration, d time.Duration) {
+	i := sort.Search(len(bounds), func(i int) bool { return bounds[i] >= d })
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddInt64(&h.sum, int64(d))
+	for {
+		max := atomic.LoadInt64(&h.max)
+		if int64(d) <= max || atomic.CompareAndSwapInt64(&h.max, max, int64(d)) {
+			return
+		}
+	}
+}
+
+func (h *durationHistogram) snapshot(bounds []time.Duration) DurationHistogram {
+	s := DurationHistogram{
+		Bounds: bounds,
+		Counts: make([]uint64, len(h.counts)),
+		Sum:    time.Duration(atomic.LoadInt64(&h.sum)),
+		Max:    time.Duration(atomic.LoadInt64(&h.max)),
+	}
+	for i := range h.counts {
+		s.Counts[i] = atomic.LoadUint64(&h.counts[i])
+		s.Count += s.Counts[i]
+	}
+	return s
+}
+
+// taskDurations is the state of the WithTaskDurations.
+type taskDurations struct {
+	bounds  []time.Duration
+	maxTags int
+	all     *durationHistogram
+	other   *durationHistogram
+
+	// The mu protects the tags, it's only locked exclusively when a new tag
+	// is admitted.
+	mu   sync.RWMutex
+	tags map[string]*durationHistogram
+}
+
+func newTaskDurations(o *options) *taskDurations {
+	n := len(o.durationBounds) + 1
+	return &taskDurations{
+		bounds:  o.durationBounds,
+		maxTags: o.durationTags,
+		all:     newDurationHistogram(n),
+		other:   newDurationHistogram(n),
+		tags:    make(map[string]*durationHistogram),
+	}
+}
+
+// lookup returns the histogram of the tag, and whether the tag is broken down.
+func (td *taskDurations) lookup(tag string) (*durationHistogram, bool) {
+	td.mu.RLock()
+	h, full := td.tags[tag], len(td.tags) >= td.maxTags
+	td.mu.RUnlock()
+	if h != nil {
+		return h, true
+	} else if full {
+		return td.other, false
+	}
+
+	td.mu.Lock()
+	defer td.mu.Unlock()
+	if h = td.tags[tag]; h != nil {
+		return h, true
+	}
+	if len(td.tags) >= td.maxTags {
+		return td.other, false
+	}
+	h = newDurationHistogram(len(td.bounds) + 1)
+	td.tags[tag] = h
+	return h, true
+}
+
+// observeDuration records the duration d of a task of the timer t.
+func (tw *TimeWheel) observeDuration(t *Timer, d time.Duration) {
+	root := tw.root
+	td := root.durations
+	td.all.observe(td.bounds, d)
+
+	var tag string
+	broken := false
+	if tag = t.Tag(); tag != "" {
+		var h *durationHistogram
+		h, broken = td.lookup(tag)
+		h.observe(td.bounds, d)
+	}
+	if m := root.metrics; m != nil {
+		if !broken {
+			tag = ""
+		}
+		m.observeDuration(tag, d)
+	}
+}
+
+// timed wraps the task func f of the timer t to observe its duration.
+func (tw *TimeWheel) timed(t *Timer, f func(ctx context.Context)) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		start := time.Now()
+		defer func() {
+			tw.observeDuration(t, time.Since(start))
+		}()
+		f(ctx)
+	}
+}
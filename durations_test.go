@@ -0,0 +1,88 @@
+package timewheel
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// tagSinkRecorder is a sinkRecorder that also records the tagged observations.
+type tagSinkRecorder struct {
+	*sinkRecorder
+	tagged map[string][]float64
+}
+
+func (r *tagSinkRecorder) ObserveTag(name string, tag string, values []float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tagged[name+"/"+tag] = append(r.tagged[name+"/"+tag], values...)
+}
+
+func TestWithTaskDurations(t *testing.T) {
+	require.Panics(t, func() { WithTaskDurations(-1) })
+	require.Panics(t, func() { WithTaskDurations(1, 0) })
+
+	sink := &tagSinkRecorder{sinkRecorder: newSinkRecorder(), tagged: make(map[string][]float64)}
+	tw := New(time.Millisecond, 8,
+		WithTaskDurations(1, time.Millisecond*20, time.Millisecond),
+		WithMetricsSink(sink, time.Hour),
+		WithDispatchPolicy(DispatchInline),
+	)
+	require.Equal(t, TaskDurations{}, New(time.Millisecond, 8).TaskDurations())
+	tw.Start()
+
+	<-tw.AfterFunc(time.Millisecond, func() {}).Done()
+	<-tw.AfterFunc(time.Millisecond, func() { time.Sleep(time.Millisecond * 5) }, WithTag("a")).Done()
+	<-tw.AfterFunc(time.Millisecond, func() { time.Sleep(time.Millisecond * 30) }, WithTag("a")).Done()
+	// Beyond the maximum number of tags.
+	<-tw.AfterFunc(time.Millisecond, func() {}, WithTag("b")).Done()
+	<-tw.AfterFunc(time.Millisecond, func() { panic("boom") }, WithTag("b")).Done()
+
+	// The duration is observed once the task returned, which races with Done.
+	require.Eventually(t, func() bool { return tw.TaskDurations().All.Count == 5 }, time.Second, time.Millisecond)
+	td := tw.TaskDurations()
+	require.Equal(t, []time.Duration{time.Millisecond, time.Millisecond * 20}, td.All.Bounds)
+	require.Len(t, td.All.Counts, 3)
+	require.Equal(t, uint64(1), td.All.Counts[2])
+	require.GreaterOrEqual(t, int64(td.All.Max), int64(time.Millisecond*30))
+	require.GreaterOrEqual(t, int64(td.All.Sum), int64(time.Millisecond*35))
+
+	require.Len(t, td.ByTag, 1)
+	a := td.ByTag["a"]
+	require.Equal(t, uint64(2), a.Count)
+	require.Equal(t, []uint64{0, 1, 1}, a.Counts)
+	require.Equal(t, uint64(2), td.Other.Count)
+
+	tw.Stop()
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	require.Len(t, sink.observed[MetricTaskDuration], 5)
+	require.Len(t, sink.tagged[MetricTaskDuration+"/a"], 2)
+	require.Len(t, sink.tagged, 1)
+}
+
+func TestWithTaskDurations_Concurrent(t *testing.T) {
+	tw := New(time.Millisecond, 8, WithTaskDurations(4), WithDispatchPolicy(DispatchInline))
+	tw.Start()
+	defer tw.Stop()
+
+	tags := []string{"", "a", "b", "c", "d", "e", "f"}
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		tw.AfterFunc(time.Millisecond, wg.Done, WithTag(tags[i%len(tags)]))
+	}
+	wg.Wait()
+
+	require.Eventually(t, func() bool { return tw.TaskDurations().All.Count == 100 }, time.Second, time.Millisecond)
+	td := tw.TaskDurations()
+	require.Len(t, td.ByTag, 4)
+	n := td.Other.Count
+	for _, h := range td.ByTag {
+		n += h.Count
+	}
+	// The untagged tasks are only in the All.
+	require.Equal(t, uint64(100-15), n)
+}
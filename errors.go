@@ -0,0 +1,142 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// The errors that indicate the operation may succeed later or elsewhere.
+var (
+	// ErrStopped is returned when the TimeWheel has been stopped.
+	ErrStopped = errors.New("timewheel: time wheel is stopped")
+	// ErrIdleShutdown is returned when the TimeWheel has stopped itself since
+	// it's been idle, see WithIdleShutdown. It wraps the ErrStopped, a new one
+	// should be created to retry, see Manager.
+	ErrIdleShutdown = fmt.Errorf("%w for idleness", ErrStopped)
+	// ErrDraining is returned when the TimeWheel is draining and does not
+	// accept new timers, the pending timers are still being expired.
+	ErrDraining = errors.New("timewheel: time wheel is draining")
+	// ErrFull is returned when the TimeWheel has reached its capacity limit.
+	ErrFull = errors.New("timewheel: time wheel is full")
+	// ErrQuotaExceeded is returned when the quota of the timer's tag has been
+	// reached, see SetQuota.
+	ErrQuotaExceeded = errors.New("timewheel: quota exceeded")
+	// ErrShed is returned when the timer of low priority is shed since the
+	// TimeWheel is under pressure, see WithLoadShedding.
+	ErrShed = errors.New("timewheel: timer is shed under load")
+	// ErrRateLimited is returned when the rate of the new timers exceeds the
+	// limit, see WithScheduleRateLimit and SetRateLimit.
+	ErrRateLimited = errors.New("timewheel: schedule rate limited")
+	// ErrBusy is returned by the non-blocking scheduling funcs when an internal
+	// lock is contended, see TryAfterFunc.
+	ErrBusy = errors.New("timewheel: time wheel is busy")
+	// ErrChannelFull is the reason of the timer dropped since the channel of
+	// WithExpiredChannel is full, see DeliveryDrop.
+	ErrChannelFull = errors.New("timewheel: expired channel is full")
+)
+
+// ErrCancelled is returned when the timer has been cancelled before its task started.
+var ErrCancelled = errors.New("timewheel: timer is cancelled")
+
+// ErrScopeClosed is returned when the timer is scheduled through a Scope that
+// has been closed.
+var ErrScopeClosed = errors.New("timewheel: scope is closed")
+
+// The errors returned by Redrive.
+var (
+	// ErrNoDeadLetter is returned when there is no dead letter of the timer
+	// in the buffer, e.g. it's been evicted or driven again.
+	ErrNoDeadLetter = errors.New("timewheel: no such dead letter")
+	// ErrNotRedrivable is returned when the dead-lettered timer can't be driven
+	// again, such as a recurring timer.
+	ErrNotRedrivable = errors.New("timewheel: dead letter is not redrivable")
+)
+
+// ErrInvalidTrace is returned by Replay when the stream is not a schedule trace
+// written by a TraceRecorder, or it's corrupted.
+var ErrInvalidTrace = errors.New("timewheel: invalid schedule trace")
+
+// The errors returned by the WorkerPool.
+var (
+	// ErrPoolFull is returned when the run queue of the WorkerPool is full.
+	ErrPoolFull = errors.New("timewheel: worker pool is full")
+	// ErrPoolClosed is returned when the WorkerPool has been closed.
+	ErrPoolClosed = errors.New("timewheel: worker pool is closed")
+)
+
+// The errors that indicate a programming bug, retrying with the same
+// parameters always fails.
+var (
+	// ErrExpired is returned when the expiration is in the past while the
+	// operation requires a future time.
+	ErrExpired = errors.New("timewheel: expiration is in the past")
+	// ErrDelayTooLarge is returned when the expiration of a new timer is
+	// beyond the MaxDelay of the TimeWheel.
+	ErrDelayTooLarge = errors.New("timewheel: delay is too large")
+	// ErrInvalidTick is returned when the tick is less than 1ms.
+	ErrInvalidTick = errors.New("timewheel: tick must be greater than or equal to 1ms")
+	// ErrInvalidSize is returned when the size is less than 1.
+	ErrInvalidSize = errors.New("timewheel: size must be greater than 0")
+	// ErrInvalidAlign is returned when the alignment set by AlignTo is not a
+	// multiple of the tick.
+	ErrInvalidAlign = errors.New("timewheel: alignment must be a multiple of the tick")
+	// ErrInvalidSchedule is returned when the parameters of a schedule are
+	// invalid or conflict with each other.
+	ErrInvalidSchedule = errors.New("timewheel: invalid schedule")
+	// ErrUnknownTask is returned when the task name is not registered in the
+	// TaskRegistry, or no TaskRegistry is set.
+	ErrUnknownTask = errors.New("timewheel: unknown task")
+)
+
+// ScheduleError records a failed scheduling and the parameters that caused it.
+// The Err is one of the sentinel errors above, test it by errors.Is.
+type ScheduleError struct {
+	// Op is the name of the scheduling func, such as "AfterFunc".
+	Op string
+	// Expiration is the requested expiration time.
+	Expiration time.Time
+	// Tag is the tag that set by WithTag.
+	Tag string
+	// Err is the cause of the failure.
+	Err error
+}
+
+func (e *ScheduleError) Error() string {
+	return fmt.Sprintf("%s tag=%q expiration=%s: %v",
+		e.Op, e.Tag, e.Expiration.UTC().Format(time.RFC3339Nano), e.Err)
+}
+
+// Unwrap returns the cause of the failure.
+func (e *ScheduleError) Unwrap() error {
+	return e.Err
+}
+
+// scheduleError returns the *ScheduleError of the timer with the opts that op
+// failed to schedule for err.
+func scheduleError(op string, expiration time.Time, opts []TimerOption, err error) *ScheduleError {
+	return &ScheduleError{Op: op, Expiration: expiration, Tag: probeOptions(opts).Tag(), Err: err}
+}
+
+// PanicError wraps the value recovered from a panicking task.
+type PanicError struct {
+	// Value is the value passed to panic.
+	Value interface{}
+	// Stack is the stack trace of the goroutine that panicked.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("timewheel: task panicked: %v", e.Value)
+}
+
+// Unwrap returns the panic value if it's an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
@@ -0,0 +1,28 @@
+package timewheel
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduleError(t *testing.T) {
+	var err error = &ScheduleError{
+		Op:         "AfterFunc",
+		Expiration: time.Unix(1, 0),
+		Tag:        "foo",
+		Err:        ErrStopped,
+	}
+	require.EqualError(t, err, `AfterFunc tag="foo" expiration=1970-01-01T00:00:01Z: timewheel: time wheel is stopped`)
+
+	err = fmt.Errorf("wrapped: %w", err)
+	require.True(t, errors.Is(err, ErrStopped))
+	require.False(t, errors.Is(err, ErrFull))
+
+	var se *ScheduleError
+	require.True(t, errors.As(err, &se))
+	require.Equal(t, "foo", se.Tag)
+}
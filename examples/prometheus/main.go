@@ -0,0 +1,105 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+// Command prometheus runs a TimeWheel under a synthetic load and serves its
+// metrics for Prometheus on /metrics, e.g. to build the dashboards against the
+// names listed by timewheel.MetricNames:
+//
+//	go run ./examples/prometheus -addr :2112
+//
+// The load keeps a fixed number of sessions, each of them is a timeout that is
+// rescheduled by Reset on activity, cancelled by Close on logout, or fired on
+// idle, thus all of timewheel_scheduled_total, timewheel_cancelled_total and
+// timewheel_fired_total move.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/yu31/timewheel"
+	"github.com/yu31/timewheel/metrics/prometheus"
+)
+
+func main() {
+	addr := flag.String("addr", ":2112", "the address to serve the metrics on")
+	sessions := flag.Int("sessions", 1000, "the number of concurrent sessions of the load")
+	idle := flag.Duration("idle", time.Second*5, "the idle timeout of a session")
+	flag.Parse()
+
+	sink := prometheus.New()
+	pool := timewheel.NewWorkerPool(4, 1024)
+	defer pool.Close()
+	tw := timewheel.New(time.Millisecond, 512,
+		timewheel.WithMetricsSink(sink, time.Second*5),
+		timewheel.WithExecutor(pool),
+		timewheel.WithTaskDurations(8),
+	)
+	tw.Start()
+	defer tw.Stop()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go load(ctx, tw, *sessions, *idle)
+
+	srv := &http.Server{Addr: *addr, Handler: sink}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	log.Printf("serving the metrics on %s/metrics", *addr)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
+
+// load keeps n sessions until the ctx is done. Each step picks a session at
+// random, it's active most of the time, which reschedules its timeout.
+func load(ctx context.Context, tw *timewheel.TimeWheel, n int, idle time.Duration) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	timeout := func() *timewheel.Timer {
+		// The jitter spreads the timeouts over the buckets.
+		d := idle/2 + time.Duration(rnd.Int63n(int64(idle)))
+		return tw.AfterFunc(d, func() {}, timewheel.WithTag("session"))
+	}
+	timers := make([]*timewheel.Timer, n)
+	for i := range timers {
+		timers[i] = timeout()
+	}
+
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			for _, t := range timers {
+				t.Close()
+			}
+			return
+		case <-ticker.C:
+		}
+		for i := 0; i < 10; i++ {
+			k := rnd.Intn(n)
+			switch t := timers[k]; {
+			case t.State() != timewheel.StateScheduled:
+				// Expired on idle, a new session takes its place.
+				timers[k] = timeout()
+			case rnd.Intn(20) == 0:
+				// Logout.
+				t.Close()
+				timers[k] = timeout()
+			default:
+				// Activity.
+				t.Reset(idle/2 + time.Duration(rnd.Int63n(int64(idle))))
+			}
+		}
+	}
+}
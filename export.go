@@ -0,0 +1,99 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+)
+
+// exportedTimer is a line of ExportJSON, the Payload is encoded in base64.
+type exportedTimer struct {
+	ID         uint64    `json:"id"`
+	Tag        string    `json:"tag,omitempty"`
+	Expiration time.Time `json:"expiration"`
+	Task       string    `json:"task"`
+	Payload    []byte    `json:"payload,omitempty"`
+}
+
+// ExportJSON writes the pending timers of the named tasks (see AfterTask) that
+// the filter accepts to w, one JSON object per line, e.g.:
+//
+//	{"id":42,"tag":"order","expiration":"2020-01-01T00:00:00Z","task":"expire","payload":"b3JkZXItMQ=="}
+//
+// It's meant for the operational tooling, e.g. to pull the pending timers out
+// of a sick instance, filter them with jq, and push them into a healthy one by
+// ImportJSON. The filter receives the TimerInfo of each timer, its Level is not
+// set; all the timers are written if it's nil. The timers are visited like
+// SaveTo, they're streamed out chunk by chunk rather than collected first, and
+// the filter is never called while holding any lock of the TimeWheel.
+func (tw *TimeWheel) ExportJSON(w io.Writer, filter func(TimerInfo) bool) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	err := tw.scanNamed(func(timers []*Timer) error {
+		for _, t := range timers {
+			info := t.info(0)
+			info.Redeliveries = t.getAttrs().redeliveries
+			if filter != nil && !filter(info) {
+				continue
+			}
+			payload, _ := info.Payload.([]byte)
+			line := exportedTimer{ID: info.ID, Tag: info.Tag, Expiration: info.Expiration.UTC(), Task: t.TaskName(), Payload: payload}
+			if err := enc.Encode(&line); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// ImportJSON reads the timers written by ExportJSON from r and schedules them
+// by the registry, or the one set by WithTaskRegistry if it's nil, and returns
+// the number of timers scheduled. The timers are assigned new IDs, and those
+// already due are handled by the PastPolicy, see WithPastPolicy. The lines are
+// decoded one by one, the unknown fields are ignored.
+//
+// It stops at the first error, such as a malformed line or a task not
+// registered, the timers scheduled before it are kept.
+func (tw *TimeWheel) ImportJSON(r io.Reader, registry *TaskRegistry) (int, error) {
+	if registry == nil {
+		registry = tw.root.opts.registry
+	}
+	dec := json.NewDecoder(r)
+	var n int
+	for {
+		var line exportedTimer
+		if err := dec.Decode(&line); err != nil {
+			if errors.Is(err, io.EOF) {
+				return n, nil
+			}
+			return n, err
+		}
+		expiration := line.Expiration.UnixNano()
+		if ok, err := tw.admitPast("ImportJSON", expiration, line.Tag); !ok {
+			if err != nil {
+				return n, err
+			}
+			continue
+		}
+
+		var opts []TimerOption
+		if line.Tag != "" {
+			opts = append(opts, WithTag(line.Tag))
+		}
+		if _, err := tw.expireTaskOf(registry, "ImportJSON", expiration, line.Task, line.Payload, nil, opts); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
@@ -0,0 +1,98 @@
+package timewheel
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeWheel_ExportJSON_ImportJSON(t *testing.T) {
+	r := NewTaskRegistry()
+	r.Register("noop", func(context.Context, []byte) {})
+	src := New(time.Millisecond, 8, WithTaskRegistry(r))
+	for i := 0; i < 3; i++ {
+		_, err := src.AfterTask(time.Hour*time.Duration(i+1), "noop", []byte("p"), WithTag("keep"))
+		require.NoError(t, err)
+	}
+	_, err := src.AfterTask(time.Hour, "noop", nil, WithTag("drop"))
+	require.NoError(t, err)
+	src.AfterFunc(time.Hour, func() {})
+
+	var buf bytes.Buffer
+	require.NoError(t, src.ExportJSON(&buf, func(info TimerInfo) bool { return info.Tag == "keep" }))
+
+	// One object per line, the payload is in base64.
+	sc := bufio.NewScanner(bytes.NewReader(buf.Bytes()))
+	var lines int
+	for sc.Scan() {
+		var m map[string]interface{}
+		require.NoError(t, json.Unmarshal(sc.Bytes(), &m))
+		require.Equal(t, "keep", m["tag"])
+		require.Equal(t, "noop", m["task"])
+		require.Equal(t, "cA==", m["payload"])
+		require.Contains(t, m, "id")
+		require.Contains(t, m, "expiration")
+		lines++
+	}
+	require.Equal(t, 3, lines)
+
+	// Imported by the given registry.
+	dst := New(time.Millisecond, 8)
+	n, err := dst.ImportJSON(bytes.NewReader(buf.Bytes()), r)
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+	require.Equal(t, int64(3), dst.Pending())
+
+	var again bytes.Buffer
+	require.NoError(t, dst.ExportJSON(&again, nil))
+	require.Equal(t, 3, strings.Count(again.String(), "\n"))
+	require.NotContains(t, again.String(), `"drop"`)
+}
+
+func TestTimeWheel_ImportJSON_PastPolicy(t *testing.T) {
+	ranC := make(chan []byte, 1)
+	r := NewTaskRegistry()
+	r.Register("record", func(_ context.Context, payload []byte) { ranC <- payload })
+	past := `{"expiration":"2000-01-01T00:00:00Z","task":"record","payload":"cA=="}` + "\n"
+
+	tw := New(time.Millisecond, 8, WithTaskRegistry(r))
+	tw.Start()
+	defer tw.Stop()
+	n, err := tw.ImportJSON(strings.NewReader(past), nil)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	require.Equal(t, []byte("p"), <-ranC)
+
+	tw = New(time.Millisecond, 8, WithTaskRegistry(r), WithPastPolicy(PastSkip))
+	n, err = tw.ImportJSON(strings.NewReader(past), nil)
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+	require.Equal(t, int64(0), tw.Pending())
+
+	tw = New(time.Millisecond, 8, WithTaskRegistry(r), WithPastPolicy(PastReject))
+	_, err = tw.ImportJSON(strings.NewReader(past), nil)
+	require.True(t, errors.Is(err, ErrExpired))
+}
+
+func TestTimeWheel_ImportJSON_Error(t *testing.T) {
+	r := NewTaskRegistry()
+	r.Register("noop", func(context.Context, []byte) {})
+	tw := New(time.Millisecond, 8, WithTaskRegistry(r))
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339Nano)
+
+	// Stops at the first error, the ones before it are kept.
+	n, err := tw.ImportJSON(strings.NewReader(`{"expiration":"`+future+`","task":"noop"}`+"\n"+`{"task":`), nil)
+	require.Error(t, err)
+	require.Equal(t, 1, n)
+
+	_, err = tw.ImportJSON(strings.NewReader(`{"expiration":"`+future+`","task":"unknown"}`), nil)
+	require.True(t, errors.Is(err, ErrUnknownTask))
+	require.Equal(t, int64(1), tw.Pending())
+}
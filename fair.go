@@ -0,0 +1,111 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+// WithFairDispatch makes the timers that expire in the same bucket dispatched
+// in the round-robin order across their tags, instead of the order they were
+// inserted. Thus, the timers of a tag with few timers never wait behind the
+// entire batch of another tag with many. In each round, a tag dispatches as
+// many timers as its weight in weights, and the tags not in weights (including
+// the empty tag) weigh 1.
+//
+// The order within a tag is preserved, but it breaks the FIFO order across
+// tags. It matters the most with DispatchInline or a blocking delivery, since
+// the cost of dispatching is paid by the consumer goroutine one after another.
+// The reordering is O(n) over the timers of the bucket.
+func WithFairDispatch(weights map[string]int) Option {
+	w := make(map[string]int, len(weights))
+	for tag, n := range weights {
+		w[tag] = n
+	}
+	return func(o *options) {
+		o.fair = true
+		o.fairWeights = w
+	}
+}
+
+// fairGroup is the timers of a tag in the bucket being reordered.
+type fairGroup struct {
+	timers []*Timer
+	weight int
+	next   int
+}
+
+// fairer reorders the expired timers in the round-robin order across their
+// tags. Only accessed by the consumer goroutine, the buffers are reused.
+type fairer struct {
+	weights map[string]int
+	index   map[string]int
+	groups  []fairGroup
+	out     []*Timer
+}
+
+func newFairer(weights map[string]int) *fairer {
+	return &fairer{weights: weights, index: make(map[string]int)}
+}
+
+// reorder reorders the timers in place.
+func (f *fairer) reorder(timers []*Timer) {
+	if len(timers) < 2 {
+		return
+	}
+
+	// Group the timers by tag in the order of their first appearances.
+	for _, t := range timers {
+		tag := t.Tag()
+		i, ok := f.index[tag]
+		if !ok {
+			i = len(f.groups)
+			f.index[tag] = i
+			weight := 1
+			if n, ok := f.weights[tag]; ok && n > 1 {
+				weight = n
+			}
+			if i < cap(f.groups) {
+				f.groups = f.groups[:i+1]
+				f.groups[i].timers = f.groups[i].timers[:0]
+				f.groups[i].weight, f.groups[i].next = weight, 0
+			} else {
+				f.groups = append(f.groups, fairGroup{weight: weight})
+			}
+		}
+		f.groups[i].timers = append(f.groups[i].timers, t)
+	}
+
+	if len(f.groups) > 1 {
+		// Each round takes at least one timer from every group that left, and
+		// the exhausted groups are removed, thus it's O(n) over the timers.
+		out := f.out[:0]
+		active := f.groups
+		for len(active) != 0 {
+			n := 0
+			for i := range active {
+				g := &active[i]
+				end := g.next + g.weight
+				if end > len(g.timers) {
+					end = len(g.timers)
+				}
+				out = append(out, g.timers[g.next:end]...)
+				g.next = end
+				if g.next < len(g.timers) {
+					active[n], active[i] = active[i], active[n]
+					n++
+				}
+			}
+			active = active[:n]
+		}
+		copy(timers, out)
+		clear(out)
+		f.out = out[:0]
+	}
+
+	// Release the references for the GC while keeping the buffers.
+	for i := range f.groups {
+		clear(f.groups[i].timers)
+	}
+	f.groups = f.groups[:0]
+	clear(f.index)
+}
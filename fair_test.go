@@ -0,0 +1,88 @@
+package timewheel
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func fairTimers(tags ...string) []*Timer {
+	timers := make([]*Timer, 0, len(tags))
+	for i, tag := range tags {
+		timers = append(timers, &Timer{meta: newMeta(uint64(i+1), StateQueued, EndNone), attrs: &attrs{tag: tag}})
+	}
+	return timers
+}
+
+func fairOrder(timers []*Timer) []uint64 {
+	ids := make([]uint64, 0, len(timers))
+	for _, t := range timers {
+		ids = append(ids, t.ID())
+	}
+	return ids
+}
+
+func Test_fairer_reorder(t *testing.T) {
+	f := newFairer(nil)
+
+	timers := fairTimers("a", "a", "a", "a", "b", "", "b", "c")
+	f.reorder(timers)
+	require.Equal(t, []uint64{1, 5, 6, 8, 2, 7, 3, 4}, fairOrder(timers))
+
+	// The buffers are reused and reset.
+	timers = fairTimers("x", "y", "x")
+	f.reorder(timers)
+	require.Equal(t, []uint64{1, 2, 3}, fairOrder(timers))
+	require.Len(t, f.index, 0)
+	require.Len(t, f.groups, 0)
+
+	// A single tag keeps the order.
+	timers = fairTimers("a", "a", "a")
+	f.reorder(timers)
+	require.Equal(t, []uint64{1, 2, 3}, fairOrder(timers))
+}
+
+func Test_fairer_weighted(t *testing.T) {
+	f := newFairer(map[string]int{"a": 3, "b": 0})
+
+	timers := fairTimers("a", "a", "a", "a", "a", "b", "b", "c")
+	f.reorder(timers)
+	require.Equal(t, []uint64{1, 2, 3, 6, 8, 4, 5, 7}, fairOrder(timers))
+}
+
+func TestWithFairDispatch(t *testing.T) {
+	tw := New(time.Millisecond*10, 8, WithDispatchPolicy(DispatchInline), WithFairDispatch(nil))
+
+	var mu sync.Mutex
+	var fired []string
+	record := func(s string) func() {
+		return func() {
+			mu.Lock()
+			fired = append(fired, s)
+			mu.Unlock()
+		}
+	}
+
+	at := time.Now().Add(time.Millisecond * 50)
+	var last *Timer
+	for i := 0; i < 100; i++ {
+		tw.TimeFunc(at, record("big"+strconv.Itoa(i)), WithTag("big"))
+	}
+	for i := 0; i < 2; i++ {
+		last = tw.TimeFunc(at, record("small"+strconv.Itoa(i)), WithTag("small"))
+	}
+
+	tw.Start()
+	defer tw.Stop()
+	<-last.Done()
+	require.Eventually(t, func() bool { return tw.Pending() == 0 }, time.Second, time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, fired, 102)
+	require.Equal(t, []string{"big0", "small0", "big1", "small1", "big2"}, fired[:5])
+	require.Equal(t, "big99", fired[101])
+}
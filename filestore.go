@@ -0,0 +1,222 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileStore is a Store of an append-only file: each Save and Delete appends a
+// line of JSON to the file, and the LoadAll replays the file. A truncated last
+// line, e.g. written partly by a crash, is ignored and truncated once opened.
+//
+// The lines are written without fsync, call Sync to flush them to the disk,
+// e.g. periodically. The file grows with every operation, call Compact to
+// rewrite it with the live records only.
+type FileStore struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	w    *bufio.Writer
+	// The live records, it mirrors the file.
+	records map[uint64]TimerRecord
+}
+
+// fileEntry is a line of the FileStore.
+type fileEntry struct {
+	Op     string       `json:"op"`
+	Record *TimerRecord `json:"record,omitempty"`
+	ID     uint64       `json:"id,omitempty"`
+}
+
+const (
+	fileOpSave   = "save"
+	fileOpDelete = "delete"
+)
+
+// NewFileStore opens the FileStore of the file path, it's created if not
+// exists.
+func NewFileStore(path string) (*FileStore, error) {
+	records, size, err := readFileStore(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(size); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &FileStore{path: path, f: f, w: bufio.NewWriter(f), records: records}, nil
+}
+
+// readFileStore replays the file path into the live records, it returns the
+// size of the complete lines as well.
+func readFileStore(path string) (map[uint64]TimerRecord, int64, error) {
+	records := make(map[uint64]TimerRecord)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return records, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	var size int64
+	for n := 1; len(data) > 0; n++ {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			// The last line is truncated.
+			break
+		}
+		line := data[:i]
+		data = data[i+1:]
+		size += int64(i + 1)
+		var e fileEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, 0, fmt.Errorf("timewheel: line %d of %s: %w", n, path, err)
+		}
+		switch {
+		case e.Op == fileOpSave && e.Record != nil:
+			records[e.Record.ID] = *e.Record
+		case e.Op == fileOpDelete:
+			delete(records, e.ID)
+		default:
+			return nil, 0, fmt.Errorf("timewheel: line %d of %s: invalid entry", n, path)
+		}
+	}
+	return records, size, nil
+}
+
+// Save implements the Store.
+func (s *FileStore) Save(r TimerRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.append(fileEntry{Op: fileOpSave, Record: &r}); err != nil {
+		return err
+	}
+	s.records[r.ID] = r
+	return nil
+}
+
+// Delete implements the Store.
+func (s *FileStore) Delete(id uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.records[id]; !ok {
+		return nil
+	}
+	if err := s.append(fileEntry{Op: fileOpDelete, ID: id}); err != nil {
+		return err
+	}
+	delete(s.records, id)
+	return nil
+}
+
+// LoadAll implements the Store, the records are in order of the ID.
+func (s *FileStore) LoadAll() (RecordIterator, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil, os.ErrClosed
+	}
+	return &sliceIterator{records: sortedRecords(s.records)}, nil
+}
+
+func (s *FileStore) append(e fileEntry) error {
+	if s.f == nil {
+		return os.ErrClosed
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := s.w.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
+
+// Sync commits the file to the disk.
+func (s *FileStore) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return os.ErrClosed
+	}
+	return s.f.Sync()
+}
+
+// Compact rewrites the file with the live records only, it's replaced
+// atomically by renaming a temporary file.
+func (s *FileStore) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return os.ErrClosed
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	w := bufio.NewWriter(tmp)
+	for _, r := range sortedRecords(s.records) {
+		r := r
+		line, err := json.Marshal(fileEntry{Op: fileOpSave, Record: &r})
+		if err != nil {
+			_ = tmp.Close()
+			return err
+		}
+		_, _ = w.Write(append(line, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	_ = s.f.Close()
+	s.f, s.w = f, bufio.NewWriter(f)
+	return nil
+}
+
+// Close syncs and closes the file, the FileStore can't be used after closed.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Sync()
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	s.f, s.w = nil, nil
+	return err
+}
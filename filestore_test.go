@@ -0,0 +1,121 @@
+package timewheel
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func loadAll(t *testing.T, s Store) []TimerRecord {
+	it, err := s.LoadAll()
+	require.NoError(t, err)
+	defer it.Close()
+	var records []TimerRecord
+	for {
+		r, err := it.Next()
+		if err != nil {
+			return records
+		}
+		records = append(records, r)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "timers.log")
+	exp := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	s, err := NewFileStore(path)
+	require.NoError(t, err)
+	require.Empty(t, loadAll(t, s))
+	require.NoError(t, s.Save(TimerRecord{ID: 1, Expiration: exp, Task: "a", Payload: []byte{0, 1}}))
+	require.NoError(t, s.Save(TimerRecord{ID: 2, Expiration: exp, Task: "b", Tag: "x"}))
+	require.NoError(t, s.Save(TimerRecord{ID: 1, Expiration: exp, Task: "a", Payload: []byte{0, 1}, InFlight: true, Redeliveries: 3}))
+	require.NoError(t, s.Delete(2))
+	require.NoError(t, s.Delete(5))
+	require.NoError(t, s.Sync())
+	require.NoError(t, s.Close())
+	require.NoError(t, s.Close())
+	require.True(t, errors.Is(s.Save(TimerRecord{ID: 3}), os.ErrClosed))
+
+	// A truncated last line is ignored.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"op":"save","rec`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	s, err = NewFileStore(path)
+	require.NoError(t, err)
+	records := loadAll(t, s)
+	require.Len(t, records, 1)
+	require.Equal(t, uint64(1), records[0].ID)
+	require.Equal(t, "a", records[0].Task)
+	require.Equal(t, []byte{0, 1}, records[0].Payload)
+	require.True(t, records[0].InFlight)
+	require.Equal(t, uint32(3), records[0].Redeliveries)
+	require.True(t, records[0].Expiration.Equal(exp))
+
+	require.NoError(t, s.Save(TimerRecord{ID: 4, Expiration: exp, Task: "c"}))
+	require.NoError(t, s.Compact())
+	require.NoError(t, s.Save(TimerRecord{ID: 5, Expiration: exp, Task: "d"}))
+	require.NoError(t, s.Close())
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, 3, bytes.Count(data, []byte("\n")))
+
+	s, err = NewFileStore(path)
+	require.NoError(t, err)
+	defer s.Close()
+	records = loadAll(t, s)
+	require.Len(t, records, 3)
+	require.Equal(t, []uint64{1, 4, 5}, []uint64{records[0].ID, records[1].ID, records[2].ID})
+}
+
+func TestFileStore_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "timers.log")
+	require.NoError(t, os.WriteFile(path, []byte("{\"op\":\"save\"}\n"), 0o644))
+	_, err := NewFileStore(path)
+	require.EqualError(t, err, "timewheel: line 1 of "+path+": invalid entry")
+
+	require.NoError(t, os.WriteFile(path, []byte("garbage\n"), 0o644))
+	_, err = NewFileStore(path)
+	require.Error(t, err)
+}
+
+func TestTimeWheel_WithStore_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "timers.log")
+	runC := make(chan string, 1)
+	r := NewTaskRegistry()
+	r.Register("job", func(_ context.Context, payload []byte) { runC <- string(payload) })
+
+	// The pending timer survives the restart.
+	s, err := NewFileStore(path)
+	require.NoError(t, err)
+	tw := New(time.Millisecond, 8, WithTaskRegistry(r), WithStore(s))
+	tw.Start()
+	timer, err := tw.AfterTask(time.Millisecond*50, "job", []byte("hello"))
+	require.NoError(t, err)
+	tw.Stop()
+	require.NoError(t, s.Close())
+	require.Equal(t, StateScheduled, timer.State())
+
+	s, err = NewFileStore(path)
+	require.NoError(t, err)
+	defer s.Close()
+	tw = New(time.Millisecond, 8, WithTaskRegistry(r), WithStore(s))
+	tw.Start()
+	defer tw.Stop()
+	select {
+	case payload := <-runC:
+		require.Equal(t, "hello", payload)
+	case <-time.After(time.Second):
+		t.Fatal("the timer is not replayed")
+	}
+	require.Eventually(t, func() bool { return len(loadAll(t, s)) == 0 }, time.Second, time.Millisecond)
+}
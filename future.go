@@ -0,0 +1,153 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"context"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+)
+
+// The states of Future.
+const (
+	futurePending int32 = iota
+	futureRunning
+	futureDone
+)
+
+// Future represents the result of a task that will be executed in the future.
+type Future[T any] struct {
+	timer *Timer
+	stopC <-chan struct{}
+
+	state int32 // futurePending, futureRunning or futureDone.
+	doneC chan struct{}
+
+	// The value and err are written before the doneC closed.
+	value T
+	err   error
+}
+
+// ScheduleResult waits for the duration to elapse and then calls f, the result
+// of f can be retrieved from the returned Future.
+//
+// The Future is resolved with:
+//   - the value and error returned by f;
+//   - a *PanicError if f panics;
+//   - ErrCancelled if the Future is cancelled before f started;
+//   - ErrStopped if the TimeWheel is stopped before f started;
+//   - a *ScheduleError if the timer is rejected when scheduled, e.g. by the
+//     quota of its tag (see SetQuota), it's resolved at once.
+func ScheduleResult[T any](tw *TimeWheel, d time.Duration, f func() (T, error), opts ...TimerOption) *Future[T] {
+	fu := &Future[T]{
+		stopC: tw.root.stopC,
+		state: futurePending,
+		doneC: make(chan struct{}),
+	}
+	expiration := tw.expireAfter(d)
+	fu.timer = tw.expireFunc(context.Background(), expiration, func(_ context.Context, t *Timer) {
+		fu.run(t, f)
+	}, opts)
+	if err := fu.timer.rejected(); err == ErrStopped {
+		fu.abort(err)
+	} else if err != nil {
+		fu.abort(&ScheduleError{Op: "ScheduleResult", Expiration: time.Unix(0, expiration), Tag: fu.timer.Tag(), Err: err})
+	}
+	return fu
+}
+
+// run executes the f and resolves the Future by its result.
+func (fu *Future[T]) run(t *Timer, f func() (T, error)) {
+	if !atomic.CompareAndSwapInt32(&fu.state, futurePending, futureRunning) {
+		// Cancelled or stopped.
+		return
+	}
+
+	var value T
+	var err error
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+			if t.getAttrs().noRecover {
+				fu.resolve(value, err)
+				panic(r)
+			}
+			t.tw.logPanic(t, r)
+		}
+		fu.resolve(value, err)
+	}()
+	value, err = f()
+}
+
+// resolve sets the result and closes the doneC, it must be called only by
+// the goroutine that moved the state away from futurePending.
+func (fu *Future[T]) resolve(value T, err error) {
+	fu.value = value
+	fu.err = err
+	atomic.StoreInt32(&fu.state, futureDone)
+	close(fu.doneC)
+}
+
+// abort resolves the Future with err if the task has not started.
+func (fu *Future[T]) abort(err error) bool {
+	if !atomic.CompareAndSwapInt32(&fu.state, futurePending, futureRunning) {
+		return false
+	}
+	var zero T
+	fu.resolve(zero, err)
+	return true
+}
+
+// Get blocks until the Future is resolved or the ctx is done, and returns the result.
+// It's safe to call Get concurrently from multiple goroutines.
+func (fu *Future[T]) Get(ctx context.Context) (T, error) {
+	select {
+	case <-fu.doneC:
+		return fu.value, fu.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	case <-fu.stopC:
+		// The task will never start if it has not started.
+		fu.abort(ErrStopped)
+	}
+
+	select {
+	case <-fu.doneC:
+		return fu.value, fu.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// TryGet returns the result without blocking, the ok is false if the
+// Future is not resolved yet.
+func (fu *Future[T]) TryGet() (value T, ok bool, err error) {
+	select {
+	case <-fu.stopC:
+		fu.abort(ErrStopped)
+	default:
+	}
+
+	select {
+	case <-fu.doneC:
+		return fu.value, true, fu.err
+	default:
+		return value, false, nil
+	}
+}
+
+// Cancel cancels the underlying timer and resolves the Future with
+// ErrCancelled. It returns false if the task has already started.
+func (fu *Future[T]) Cancel() bool {
+	if !fu.abort(ErrCancelled) {
+		return false
+	}
+	fu.timer.Close()
+	return true
+}
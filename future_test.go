@@ -0,0 +1,138 @@
+package timewheel
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduleResult(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	fu := ScheduleResult(tw, time.Millisecond*5, func() (int, error) {
+		return 1024, nil
+	})
+
+	_, ok, _ := fu.TryGet()
+	require.False(t, ok)
+
+	// Multiple concurrent callers.
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := fu.Get(context.Background())
+			require.Nil(t, err)
+			require.Equal(t, v, 1024)
+		}()
+	}
+	wg.Wait()
+
+	v, ok, err := fu.TryGet()
+	require.True(t, ok)
+	require.Nil(t, err)
+	require.Equal(t, v, 1024)
+	require.False(t, fu.Cancel())
+}
+
+func TestScheduleResult_Error(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	errTask := errors.New("task error")
+	fu := ScheduleResult(tw, time.Millisecond, func() (string, error) {
+		return "", errTask
+	})
+	_, err := fu.Get(context.Background())
+	require.Equal(t, err, errTask)
+}
+
+func TestScheduleResult_Panic(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	errPanic := errors.New("panic error")
+	fu := ScheduleResult(tw, time.Millisecond, func() (string, error) {
+		panic(errPanic)
+	})
+	_, err := fu.Get(context.Background())
+
+	var pe *PanicError
+	require.True(t, errors.As(err, &pe))
+	require.Equal(t, pe.Value, errPanic)
+	require.NotEmpty(t, pe.Stack)
+	require.True(t, errors.Is(err, errPanic))
+}
+
+func TestScheduleResult_Cancel(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	fu := ScheduleResult(tw, time.Hour, func() (int, error) {
+		return 1, nil
+	})
+	require.True(t, fu.Cancel())
+	require.False(t, fu.Cancel())
+	require.Equal(t, tw.Pending(), int64(0))
+
+	_, err := fu.Get(context.Background())
+	require.Equal(t, err, ErrCancelled)
+}
+
+func TestScheduleResult_Stopped(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+
+	fu := ScheduleResult(tw, time.Hour, func() (int, error) {
+		return 1, nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	_, err := fu.Get(ctx)
+	require.Equal(t, err, context.DeadlineExceeded)
+
+	tw.Stop()
+	_, err = fu.Get(context.Background())
+	require.Equal(t, err, ErrStopped)
+
+	_, ok, err := fu.TryGet()
+	require.True(t, ok)
+	require.Equal(t, err, ErrStopped)
+}
+
+func TestScheduleResult_Rejected(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+	tw.SetQuota("report", 1)
+
+	first := ScheduleResult(tw, time.Hour, func() (int, error) { return 1, nil }, WithTag("report"))
+	defer first.Cancel()
+	fu := ScheduleResult(tw, time.Hour, func() (int, error) { return 2, nil }, WithTag("report"))
+
+	// Resolved at once rather than waiting for the ctx.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := fu.Get(ctx)
+	require.True(t, errors.Is(err, ErrQuotaExceeded), err)
+	var se *ScheduleError
+	require.True(t, errors.As(err, &se))
+	require.Equal(t, "ScheduleResult", se.Op)
+	require.Equal(t, "report", se.Tag)
+	require.Equal(t, EndRejected, fu.timer.EndReason())
+
+	tw.Stop()
+	_, err = ScheduleResult(tw, time.Hour, func() (int, error) { return 3, nil }).Get(ctx)
+	require.Equal(t, ErrStopped, err)
+}
@@ -1,8 +1,15 @@
 module github.com/yu31/timewheel
 
-go 1.15
+go 1.21
 
 require (
 	github.com/stretchr/testify v1.6.1
 	github.com/yu31/dqueue v0.0.0-20201222193016-dd941aa76798
 )
+
+require (
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/yu31/gostructs v0.0.0-20201217022118-6e9ecbe366b6 // indirect
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
+)
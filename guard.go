@@ -0,0 +1,123 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FireGuard decides whether an occurrence of a guarded timer (see WithGuardID)
+// is executed. It's used to run the same schedule on several replicas for the
+// availability, while only one of them executes each occurrence.
+//
+// The Acquire is called right before the task is executed, in the goroutine
+// of the task (or the consumer goroutine if the DispatchInline is set without
+// a task timeout). It returns true if the caller wins the occurrence of the
+// timerID scheduled at the time. The occurrence is skipped if it returns false
+// or an error, and counted by Stats.GuardDenied.
+//
+// The implementation is outside this package, it must make the decision for
+// each pair of the timerID and scheduled atomically across the replicas, e.g.:
+//
+//   - SQL: INSERT INTO fires(timer_id, scheduled) a row with the primary key on
+//     both columns, and returns true if the row is inserted, false on the
+//     duplicate key error.
+//   - Redis: SET "fire:<timerID>:<scheduled>" NX PX <ttl>, and returns true if
+//     the key is set. The ttl must outlive the clock skew between the replicas.
+//
+// The scheduled is the nominal time of the occurrence rather than the time it
+// actually fires, thus it's the same on all replicas that share a schedule.
+type FireGuard interface {
+	Acquire(ctx context.Context, timerID string, scheduled time.Time) (bool, error)
+}
+
+// WithFireGuard sets the FireGuard consulted by the guarded timers.
+func WithFireGuard(g FireGuard) Option {
+	return func(o *options) {
+		o.fireGuard = g
+	}
+}
+
+// WithGuardID makes the timer guarded by the FireGuard set by WithFireGuard,
+// the id identifies the timer across the replicas, thus it must be the same
+// for the same schedule on each replica. It has no effect if no FireGuard is set.
+func WithGuardID(id string) TimerOption {
+	return func(t *Timer) {
+		t.setAttrs().guardID = id
+	}
+}
+
+// guard returns whether the occurrence of t scheduled at the time is executed,
+// it's always true unless t is guarded.
+func (tw *TimeWheel) guard(ctx context.Context, t *Timer, scheduled int64) bool {
+	root := tw.root
+	g := root.opts.fireGuard
+	if g == nil || t.attrs == nil || t.attrs.guardID == "" {
+		return true
+	}
+
+	ok, err := g.Acquire(ctx, t.attrs.guardID, time.Unix(0, scheduled))
+	if err != nil {
+		if l := root.opts.logger; l != nil {
+			l.Warn("timewheel: fire guard failed", timerAttr(t), slog.String("guard_id", t.attrs.guardID), slog.Any("error", err))
+		}
+		ok = false
+	}
+	if !ok {
+		atomic.AddUint64(&root.guardDenied, 1)
+		// The occurrence belongs to another replica.
+		if t.attrs.task != "" {
+			root.inflight.remove(t)
+		}
+	}
+	return ok
+}
+
+// MemoryFireGuard is an in-memory FireGuard that shared by the TimeWheels in
+// a process, it's intended for tests. Each occurrence is acquired once, the
+// records are kept until Forget.
+type MemoryFireGuard struct {
+	mu       sync.Mutex
+	acquired map[string]struct{}
+}
+
+// NewMemoryFireGuard creates an empty MemoryFireGuard.
+func NewMemoryFireGuard() *MemoryFireGuard {
+	return &MemoryFireGuard{acquired: make(map[string]struct{})}
+}
+
+// Acquire implements the FireGuard.
+func (g *MemoryFireGuard) Acquire(_ context.Context, timerID string, scheduled time.Time) (bool, error) {
+	key := timerID + "@" + strconv.FormatInt(scheduled.UnixNano(), 10)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.acquired[key]; ok {
+		return false, nil
+	}
+	g.acquired[key] = struct{}{}
+	return true, nil
+}
+
+// Forget removes the records of the occurrences scheduled before the time.
+func (g *MemoryFireGuard) Forget(before time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for key := range g.acquired {
+		i := len(key) - 1
+		for key[i] != '@' {
+			i--
+		}
+		if ns, _ := strconv.ParseInt(key[i+1:], 10, 64); ns < before.UnixNano() {
+			delete(g.acquired, key)
+		}
+	}
+}
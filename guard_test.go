@@ -0,0 +1,112 @@
+package timewheel
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryFireGuard(t *testing.T) {
+	g := NewMemoryFireGuard()
+	at := time.Unix(100, 0)
+
+	ok, err := g.Acquire(context.Background(), "a", at)
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, _ = g.Acquire(context.Background(), "a", at)
+	require.False(t, ok)
+	ok, _ = g.Acquire(context.Background(), "a", at.Add(time.Second))
+	require.True(t, ok)
+	ok, _ = g.Acquire(context.Background(), "a@b", at)
+	require.True(t, ok)
+
+	g.Forget(at.Add(time.Second))
+	require.Len(t, g.acquired, 1)
+	ok, _ = g.Acquire(context.Background(), "a", at)
+	require.True(t, ok)
+}
+
+func TestWithFireGuard_Replicas(t *testing.T) {
+	g := NewMemoryFireGuard()
+	start := time.Now().Add(time.Millisecond * 5)
+
+	var mu sync.Mutex
+	runs := make(map[int]int)
+	var timers []*Timer
+	var wheels []*TimeWheel
+	for i := 0; i < 3; i++ {
+		replica := i
+		tw := New(time.Millisecond, 8, WithFireGuard(g))
+		tw.Start()
+		defer tw.Stop()
+
+		timer, err := tw.Every(time.Millisecond*10).StartingAt(start).Times(5).Do(func() {
+			mu.Lock()
+			runs[replica]++
+			mu.Unlock()
+		}, WithGuardID("report"))
+		require.NoError(t, err)
+		timers = append(timers, timer)
+		wheels = append(wheels, tw)
+	}
+	for _, timer := range timers {
+		<-timer.Done()
+	}
+
+	var total int
+	var denied uint64
+	for i, tw := range wheels {
+		total += runs[i]
+		denied += tw.Stats().GuardDenied
+	}
+	require.Equal(t, 5, total)
+	require.Equal(t, uint64(10), denied)
+}
+
+type failingGuard struct{}
+
+func (failingGuard) Acquire(context.Context, string, time.Time) (bool, error) {
+	return false, errors.New("unavailable")
+}
+
+func TestWithFireGuard_Error(t *testing.T) {
+	rec := new(logRecorder)
+	tw := New(time.Millisecond, 8, WithFireGuard(failingGuard{}), WithLogger(rec.logger()))
+	tw.Start()
+	defer tw.Stop()
+
+	var ran int32
+	timer := tw.AfterFunc(time.Millisecond, func() { atomic.StoreInt32(&ran, 1) }, WithGuardID("x"))
+	<-timer.Done()
+	require.Equal(t, int32(0), atomic.LoadInt32(&ran))
+	require.Equal(t, StateCompleted, timer.State())
+	require.Equal(t, uint64(1), tw.Stats().GuardDenied)
+
+	record := rec.find(t, "timewheel: fire guard failed")
+	require.NotNil(t, record)
+	require.Equal(t, "x", record["guard_id"])
+	require.Equal(t, "unavailable", record["error"])
+
+	// Not guarded without the ID.
+	<-tw.AfterFunc(time.Millisecond, func() { atomic.StoreInt32(&ran, 1) }).Done()
+	require.Equal(t, int32(1), atomic.LoadInt32(&ran))
+}
+
+func TestWithFireGuard_Named(t *testing.T) {
+	r := NewTaskRegistry()
+	r.Register("noop", func(context.Context, []byte) {})
+	tw := New(time.Millisecond, 8, WithFireGuard(failingGuard{}), WithTaskRegistry(r))
+	tw.Start()
+	defer tw.Stop()
+
+	timer, err := tw.AfterTask(time.Millisecond, "noop", nil, WithGuardID("x"))
+	require.NoError(t, err)
+	<-timer.Done()
+	// The denied occurrence is not left in flight.
+	require.Len(t, tw.root.inflight.snapshot(), 0)
+}
@@ -0,0 +1,265 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WithQuietPeriod debounces the OnIdle, the f of it is called only after the
+// number of pending timers has stayed 0 for the duration d, thus the pending
+// that flaps between 0 and 1 rapidly doesn't thrash the resources released by
+// OnIdle and acquired by OnActive. The d is measured in real time, even if the
+// TimeWheel is driven by a Clock. Default is 0, i.e. OnIdle is called as soon
+// as the pending drops to 0.
+//
+// The quiet period is skipped once the TimeWheel is stopped.
+func WithQuietPeriod(d time.Duration) Option {
+	if d < 0 {
+		panic("timewheel: quiet period must be greater than or equal to 0")
+	}
+	return func(o *options) {
+		o.quietPeriod = d
+	}
+}
+
+// idleNotifier delivers the OnActive and OnIdle in turn, by the transitions of
+// the pending between 0 and 1.
+//
+// A transition only signals the notifier, the notification is decided by the
+// pending at the delivery, thus the transitions that race with each other are
+// never delivered out of order: the notifications strictly alternate, starting
+// with OnActive. The ones that are reverted before being delivered, e.g. a
+// timer is closed right after scheduled, are dropped together.
+type idleNotifier struct {
+	pending  *int64
+	onIdle   func()
+	onActive func()
+	quiet    time.Duration
+
+	mu sync.Mutex
+	// Whether the OnActive is the latest notification delivered.
+	active bool
+	// Whether a goroutine is delivering, and whether it should check again.
+	delivering bool
+	dirty      bool
+	// The time that the pending dropped to 0 at, and the timer that signals
+	// once the quiet period elapsed since then.
+	idleSince time.Time
+	timer     *time.Timer
+	stopped   bool
+}
+
+func newIdleNotifier(pending *int64, o *options) *idleNotifier {
+	return &idleNotifier{pending: pending, onIdle: o.onIdle, onActive: o.onActive, quiet: o.quietPeriod}
+}
+
+// rise is called when the pending rises from 0 to 1.
+func (n *idleNotifier) rise() {
+	n.signal(false)
+}
+
+// fall is called when the pending drops from 1 to 0.
+func (n *idleNotifier) fall() {
+	n.signal(true)
+}
+
+// stop skips the quiet period from now on, it's called once the TimeWheel is
+// stopped.
+func (n *idleNotifier) stop() {
+	n.mu.Lock()
+	n.stopped = true
+	if n.timer != nil {
+		n.timer.Stop()
+	}
+	n.mu.Unlock()
+	n.signal(false)
+}
+
+// signal delivers the notifications until they agree with the pending. Only
+// one goroutine delivers at a time, the others (including the notifications
+// that schedule or close timers) only mark it to check again.
+func (n *idleNotifier) signal(fell bool) {
+	n.mu.Lock()
+	if fell {
+		n.idleSince = time.Now()
+	}
+	n.dirty = true
+	if n.delivering {
+		n.mu.Unlock()
+		return
+	}
+	n.delivering = true
+	for n.dirty {
+		n.dirty = false
+		active := atomic.LoadInt64(n.pending) > 0
+		if active == n.active {
+			continue
+		}
+		var f func()
+		if active {
+			f = n.onActive
+		} else {
+			if wait := n.quiet - time.Since(n.idleSince); wait > 0 && !n.stopped {
+				n.wait(wait)
+				continue
+			}
+			f = n.onIdle
+		}
+		n.active = active
+		if f != nil {
+			n.mu.Unlock()
+			f()
+			n.mu.Lock()
+		}
+		// The pending may have changed during f.
+		n.dirty = true
+	}
+	n.delivering = false
+	n.mu.Unlock()
+}
+
+// wait arms the timer to check again after the duration d, the caller must hold
+// the mu.
+func (n *idleNotifier) wait(d time.Duration) {
+	if n.timer == nil {
+		n.timer = time.AfterFunc(d, func() { n.signal(false) })
+		return
+	}
+	n.timer.Reset(d)
+}
+
+// WithIdleShutdown makes the TimeWheel stop itself once it's been idle for the
+// duration d, i.e. no timer is pending, releasing its consumer goroutine and
+// buckets. It's meant for the TimeWheels created on demand, e.g. by a Manager
+// per tenant, which creates a new one on the next scheduling.
+//
+// The idleness is checked by the delay queue of the TimeWheel, the same as
+// the buckets, thus no goroutine is spent on it. Once shut down, it's stopped
+// like by Stop, and the new timers are rejected with ErrIdleShutdown; it's
+// never shut down while a timer is being scheduled, thus a timer scheduled
+// concurrently with the shutdown is either scheduled before it, or rejected.
+// NOTICE: a timer that is re-armed by its own running task, e.g. by Reset or
+// a recurring timer with overlapping executions, is not covered.
+func WithIdleShutdown(d time.Duration) Option {
+	if d <= 0 {
+		panic("timewheel: duration of idle shutdown must be greater than 0")
+	}
+	return func(o *options) {
+		o.idleShutdown = d
+	}
+}
+
+// The states of the idleShutdown.
+const (
+	idleLive int32 = iota
+	idleDeciding
+	idleDown
+)
+
+// idleShutdown is the state of the WithIdleShutdown.
+type idleShutdown struct {
+	// The time that the pending dropped to 0 at, and the number of the timers
+	// being scheduled (see enter), both are accessed atomically. They're
+	// placed first to be 64-bit aligned on 32-bit platforms.
+	since     int64
+	admitting int64
+
+	d int64 // in nanoseconds.
+	// The sentinel bucket that offered to the queue to check the idleness,
+	// it never holds any timer.
+	b *bucket
+	// Whether the b has been offered and not processed yet, and the state
+	// (see enter), both are accessed atomically.
+	armed int32
+	state int32
+}
+
+func newIdleShutdown(d time.Duration) *idleShutdown {
+	return &idleShutdown{d: int64(d), b: newBucket()}
+}
+
+// armIdleShutdown offers the sentinel bucket to check the idleness after the
+// duration, it's called when the pending drops to 0 or the TimeWheel starts.
+func (tw *TimeWheel) armIdleShutdown() {
+	root := tw.root
+	s := root.idleDown
+	now := root.now()
+	atomic.StoreInt64(&s.since, now)
+	if atomic.CompareAndSwapInt32(&s.armed, 0, 1) {
+		root.queue.offer(s.b, now+s.d)
+	}
+}
+
+// checkIdleShutdown is called when the sentinel bucket expires, it shuts the
+// TimeWheel down if it's still idle, or offers the sentinel bucket again for
+// the latest idleness.
+func (tw *TimeWheel) checkIdleShutdown() {
+	root := tw.root
+	s := root.idleDown
+	atomic.StoreInt32(&s.armed, 0)
+	if atomic.LoadInt64(&root.pending) != 0 {
+		// It's offered again once the pending drops to 0.
+		return
+	}
+	if since := atomic.LoadInt64(&s.since); root.now()-since < s.d {
+		if atomic.CompareAndSwapInt32(&s.armed, 0, 1) {
+			root.queue.offer(s.b, since+s.d)
+		}
+		return
+	}
+
+	// Close the door, then check whether anyone has entered, see enter.
+	atomic.StoreInt32(&s.state, idleDeciding)
+	if atomic.LoadInt64(&s.admitting) != 0 || atomic.LoadInt64(&root.pending) != 0 {
+		atomic.StoreInt32(&s.state, idleLive)
+		return
+	}
+	atomic.StoreInt32(&s.state, idleDown)
+	if root.stop() {
+		if l := root.opts.logger; l != nil {
+			l.Info("timewheel: shut down for idleness")
+		}
+		// The shutdown waits for the consumer goroutine to exit, thus it must
+		// be done in another goroutine.
+		go root.shutdown()
+	}
+}
+
+// enter is called before a new timer is scheduled, and leave is called after
+// it's counted as pending, the TimeWheel is never shut down for idleness in
+// between. It returns ErrIdleShutdown if the TimeWheel has been shut down.
+//
+// The admitting is increased before the state is read, and the shutdown sets
+// the state before the admitting is read, thus at least one of them sees the
+// other: either the new timer waits for the decision of the shutdown, or the
+// shutdown sees the new timer and gives up.
+func (tw *TimeWheel) enter() error {
+	s := tw.root.idleDown
+	if s == nil {
+		return nil
+	}
+	atomic.AddInt64(&s.admitting, 1)
+	for {
+		switch atomic.LoadInt32(&s.state) {
+		case idleLive:
+			return nil
+		case idleDown:
+			atomic.AddInt64(&s.admitting, -1)
+			return ErrIdleShutdown
+		}
+		runtime.Gosched()
+	}
+}
+
+func (tw *TimeWheel) leave() {
+	if s := tw.root.idleDown; s != nil {
+		atomic.AddInt64(&s.admitting, -1)
+	}
+}
@@ -0,0 +1,134 @@
+package timewheel
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithQuietPeriod(t *testing.T) {
+	require.Panics(t, func() { WithQuietPeriod(-1) })
+
+	var idle, active int32
+	tw := New(time.Millisecond, 8,
+		OnIdle(func() { atomic.AddInt32(&idle, 1) }),
+		OnActive(func() { atomic.AddInt32(&active, 1) }),
+		WithQuietPeriod(time.Millisecond*50),
+	)
+	tw.Start()
+	defer tw.Stop()
+
+	// The flapping within the quiet period is not notified.
+	for i := 0; i < 100; i++ {
+		tw.AfterFunc(time.Hour, func() {}).Close()
+	}
+	require.Equal(t, int32(1), atomic.LoadInt32(&active))
+	require.Equal(t, int32(0), atomic.LoadInt32(&idle))
+
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&idle) == 1 }, time.Second, time.Millisecond)
+	tw.AfterFunc(time.Hour, func() {}).Close()
+	require.Equal(t, int32(2), atomic.LoadInt32(&active))
+	require.Equal(t, int32(1), atomic.LoadInt32(&idle))
+
+	// The quiet period is skipped once stopped.
+	tw.Stop()
+	require.Equal(t, int32(2), atomic.LoadInt32(&idle))
+}
+
+func TestOnIdle_OnActive_Concurrent(t *testing.T) {
+	var mu sync.Mutex
+	var events []bool
+	record := func(active bool) func() {
+		return func() {
+			mu.Lock()
+			events = append(events, active)
+			mu.Unlock()
+		}
+	}
+	tw := New(time.Millisecond, 8, OnIdle(record(false)), OnActive(record(true)))
+	tw.Start()
+	defer tw.Stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				timer := tw.AfterFunc(time.Duration(j%3)*time.Millisecond, func() {})
+				if i%2 == 0 {
+					timer.Close()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	require.Eventually(t, func() bool { return tw.Pending() == 0 }, time.Second, time.Millisecond)
+
+	// The notifications alternate, starting with OnActive and ending with
+	// OnIdle since nothing is pending.
+	mu.Lock()
+	defer mu.Unlock()
+	require.NotEmpty(t, events)
+	for i, active := range events {
+		require.Equal(t, i%2 == 0, active, i)
+	}
+	require.False(t, events[len(events)-1])
+}
+
+func TestOnIdle_Reentrant(t *testing.T) {
+	var tw *TimeWheel
+	var idle, active int32
+	tw = New(time.Millisecond, 8,
+		OnActive(func() { atomic.AddInt32(&active, 1) }),
+		OnIdle(func() {
+			// Scheduling from the OnIdle notifies OnActive after it returned.
+			if atomic.AddInt32(&idle, 1) == 1 {
+				tw.AfterFunc(time.Hour, func() {})
+			}
+		}),
+	)
+	tw.Start()
+	defer tw.Stop()
+
+	tw.AfterFunc(time.Hour, func() {}).Close()
+	require.Equal(t, int32(1), atomic.LoadInt32(&idle))
+	require.Equal(t, int32(2), atomic.LoadInt32(&active))
+	require.Equal(t, int64(1), tw.Pending())
+}
+
+func TestWithIdleShutdown(t *testing.T) {
+	require.Panics(t, func() { WithIdleShutdown(0) })
+
+	clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	tw := New(time.Millisecond, 8, WithIdleShutdown(time.Millisecond*10), WithClock(clock), WithDispatchPolicy(DispatchInline))
+	tw.Start()
+	defer tw.Stop()
+
+	// The idleness restarts once the pending drops to 0.
+	clock.add(time.Millisecond * 8)
+	tw.Poll()
+	var fired int
+	tw.AfterFunc(time.Millisecond*5, func() { fired++ })
+	for i := 0; i < 14; i++ {
+		clock.add(time.Millisecond)
+		tw.Poll()
+	}
+	require.Equal(t, 1, fired)
+	require.False(t, tw.stoppedNow())
+
+	clock.add(time.Millisecond)
+	tw.Poll()
+	require.True(t, tw.stoppedNow())
+
+	_, err := tw.TryAfterFunc(time.Millisecond, func() {})
+	require.True(t, errors.Is(err, ErrIdleShutdown))
+	require.True(t, errors.Is(err, ErrStopped))
+	timer := tw.AfterFunc(time.Millisecond, func() {})
+	require.Equal(t, EndRejected, timer.EndReason())
+	tw.Wait()
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// InvariantError describes all the violations found by CheckInvariants.
+type InvariantError struct {
+	Violations []string
+}
+
+func (e *InvariantError) Error() string {
+	return fmt.Sprintf("timewheel: %d invariant violation(s): %s", len(e.Violations), strings.Join(e.Violations, "; "))
+}
+
+// CheckInvariants verifies the consistency of the internal state, it returns
+// an *InvariantError describing all violations found, or nil if no violation.
+//
+// It's intended for tests and debugging. It's safe to call on a running TimeWheel,
+// each bucket is locked only while it's being checked. The check of the pending
+// counter is exact only if no timer is being scheduled, closed or expired during
+// the call, otherwise it may report a transient mismatch.
+func (tw *TimeWheel) CheckInvariants() error {
+	var violations []string
+	var walked int64
+
+	stopped := atomic.LoadInt32(&tw.root.stopped) == 1
+	pending := tw.Pending()
+
+	for level, l := 0, tw.root; l != nil; level, l = level+1, l.getOverflow() {
+		violations = l.checkLevel(level, stopped, &walked, violations)
+	}
+
+	if walked != pending {
+		violations = append(violations, fmt.Sprintf("pending counter is %d but %d timer(s) found in buckets", pending, walked))
+	}
+
+	if len(violations) != 0 {
+		return &InvariantError{Violations: violations}
+	}
+	return nil
+}
+
+// checkLevel checks the invariants of the current level, and appends the
+// violations found to vs.
+func (tw *TimeWheel) checkLevel(level int, stopped bool, walked *int64, vs []string) []string {
+	current := atomic.LoadInt64(&tw.current)
+	if current%tw.tick != 0 {
+		vs = append(vs, fmt.Sprintf("level %d: current %d is not a multiple of tick %d", level, current, tw.tick))
+	}
+
+	for i := range tw.buckets {
+		b := tw.loadBucket(int64(i))
+		if b == nil {
+			continue
+		}
+		b.flushMu.Lock()
+		b.mu.Lock()
+
+		expiration := b.getExpiration()
+		n := b.timers.Len()
+		*walked += int64(n)
+
+		if expiration != -1 {
+			if expiration%tw.tick != 0 {
+				vs = append(vs, fmt.Sprintf("level %d bucket %d: expiration %d is not a multiple of tick %d", level, i, expiration, tw.tick))
+			}
+			if idx := (expiration / tw.tick) & tw.mask; idx != int64(i) {
+				vs = append(vs, fmt.Sprintf("level %d bucket %d: expiration %d belongs to bucket %d", level, i, expiration, idx))
+			}
+			if expiration >= current+tw.interval {
+				vs = append(vs, fmt.Sprintf("level %d bucket %d: expiration %d is out of the interval of current %d", level, i, expiration, current))
+			}
+		} else if n != 0 {
+			vs = append(vs, fmt.Sprintf("level %d bucket %d: %d timer(s) in a bucket without expiration", level, i, n))
+		}
+
+		if b.enqueued > 1 {
+			vs = append(vs, fmt.Sprintf("level %d bucket %d: enqueued %d times", level, i, b.enqueued))
+		}
+		if n != 0 && b.enqueued == 0 && !stopped {
+			vs = append(vs, fmt.Sprintf("level %d bucket %d: %d timer(s) in a bucket that is not enqueued", level, i, n))
+		}
+
+		for e := b.timers.Front(); e != nil; e = e.Next() {
+			t := e.Value
+			te := t.getExpiration()
+			// A timer of a later rotation of the slot joins the expiration
+			// that the bucket is enqueued with, see bucket.push.
+			later := te >= expiration+tw.tick && (te/tw.tick)&tw.mask == int64(i)
+			if expiration != -1 && (te < expiration || te >= expiration+tw.tick && !later) {
+				vs = append(vs, fmt.Sprintf("level %d bucket %d: timer expiration %d is out of the bucket range [%d, %d)",
+					level, i, te, expiration, expiration+tw.tick))
+			}
+			if st := t.State(); st != StateScheduled {
+				vs = append(vs, fmt.Sprintf("level %d bucket %d: timer in state %s", level, i, st))
+			}
+			if t.element != e || t.getBucket() != b {
+				vs = append(vs, fmt.Sprintf("level %d bucket %d: timer is not linked to its element or bucket", level, i))
+			}
+		}
+
+		b.mu.Unlock()
+		b.flushMu.Unlock()
+	}
+	return vs
+}
@@ -0,0 +1,71 @@
+package timewheel
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeWheel_CheckInvariants(t *testing.T) {
+	tw := New(time.Millisecond, 4)
+	require.Nil(t, tw.CheckInvariants())
+
+	// Timers at multiple levels.
+	for i := 0; i < 100; i++ {
+		tw.AfterFunc(genInterval(i*97), func() {})
+	}
+	require.NotNil(t, tw.getOverflow())
+	require.Nil(t, tw.CheckInvariants())
+}
+
+func TestTimeWheel_CheckInvariants_Running(t *testing.T) {
+	tw := New(time.Millisecond, 4)
+	tw.Start()
+	defer tw.Stop()
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				timer := tw.AfterFunc(genInterval(i*200+j)%(time.Millisecond*50), func() {})
+				if j%3 == 0 {
+					timer.Close()
+				}
+			}
+		}(i)
+	}
+
+	// Safe to call on a running wheel, but may report transient mismatch.
+	for i := 0; i < 10; i++ {
+		_ = tw.CheckInvariants()
+	}
+	wg.Wait()
+
+	require.Eventually(t, func() bool { return tw.Pending() == 0 }, time.Second, time.Millisecond)
+	require.Nil(t, tw.CheckInvariants())
+}
+
+func TestTimeWheel_CheckInvariants_Violations(t *testing.T) {
+	tw := New(time.Millisecond, 4)
+
+	// Misaligned current.
+	atomic.AddInt64(&tw.current, 1)
+	// A bucket enqueued twice.
+	tw.ensureBucket(0).enqueued = 2
+	// A timer in the wrong bucket, and not counted by the pending counter.
+	b := tw.ensureBucket(1)
+	b.push(&Timer{expiration: 2 * tw.tick, meta: newMeta(0, StateScheduled, EndNone)}, 5*tw.tick)
+
+	err := tw.CheckInvariants()
+	require.NotNil(t, err)
+
+	var ie *InvariantError
+	require.True(t, errors.As(err, &ie))
+	require.Len(t, ie.Violations, 4, err.Error())
+}
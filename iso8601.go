@@ -0,0 +1,224 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RepeatingInterval is a repeating interval of ISO 8601, such as
+// "R5/2024-01-01T00:00:00Z/PT1H" for five repetitions every hour from the
+// start of 2024, it's parsed by ParseRepeatingInterval.
+type RepeatingInterval struct {
+	// Repetitions is the number of executions, 0 means unbounded.
+	Repetitions int
+	// Start is the time of the first execution, it's zero if the interval is
+	// given by the duration only, i.e. it starts when scheduled.
+	Start time.Time
+	// Interval is the duration between two executions.
+	Interval time.Duration
+}
+
+// ParseRepeatingInterval parses the repeating interval of ISO 8601 in one of
+// the forms:
+//
+//	R5/2024-01-01T00:00:00Z/PT1H                  // start and duration.
+//	R5/2024-01-01T00:00:00Z/2024-01-01T01:00:00Z  // start and end.
+//	R5/PT1H/2024-01-01T01:00:00Z                  // duration and end.
+//	R5/PT1H                                       // duration only.
+//
+// The "R/" without the number of repetitions repeats unbounded. The times are
+// in RFC 3339, and the duration is in the form of "PnWnDTnHnMnS" where the
+// last component may be fractional, a day is always 24 hours. The years and
+// months are not supported since their lengths vary with the calendar.
+//
+// The errors wrap ErrInvalidSchedule and tell which part is invalid.
+func ParseRepeatingInterval(s string) (RepeatingInterval, error) {
+	var ri RepeatingInterval
+	fail := func(format string, args ...interface{}) (RepeatingInterval, error) {
+		return RepeatingInterval{}, fmt.Errorf("%w: repeating interval %q: %s", ErrInvalidSchedule, s, fmt.Sprintf(format, args...))
+	}
+
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return fail("must be in the form of R[n]/start/duration, R[n]/start/end, R[n]/duration/end or R[n]/duration")
+	}
+	if !strings.HasPrefix(parts[0], "R") {
+		return fail("must start with R")
+	}
+	if n := parts[0][1:]; n != "" {
+		reps, err := strconv.Atoi(n)
+		if err != nil || reps < 1 {
+			return fail("number of repetitions %q must be a positive integer", n)
+		}
+		ri.Repetitions = reps
+	}
+
+	if len(parts) == 2 {
+		d, err := parseISODuration(parts[1])
+		if err != nil {
+			return fail("%v", err)
+		}
+		ri.Interval = d
+		return ri, nil
+	}
+
+	first, second := parts[1], parts[2]
+	if strings.HasPrefix(first, "P") {
+		// The duration and the end of the first interval.
+		d, err := parseISODuration(first)
+		if err != nil {
+			return fail("%v", err)
+		}
+		end, err := time.Parse(time.RFC3339Nano, second)
+		if err != nil {
+			return fail("end %q must be in RFC 3339", second)
+		}
+		ri.Start, ri.Interval = end.Add(-d), d
+		return ri, nil
+	}
+
+	start, err := time.Parse(time.RFC3339Nano, first)
+	if err != nil {
+		return fail("start %q must be in RFC 3339", first)
+	}
+	ri.Start = start
+	if strings.HasPrefix(second, "P") {
+		if ri.Interval, err = parseISODuration(second); err != nil {
+			return fail("%v", err)
+		}
+		return ri, nil
+	}
+	end, err := time.Parse(time.RFC3339Nano, second)
+	if err != nil {
+		return fail("end %q must be in RFC 3339 or a duration", second)
+	}
+	if !end.After(start) {
+		return fail("end %s must be after start %s", second, first)
+	}
+	ri.Interval = end.Sub(start)
+	return ri, nil
+}
+
+// isoUnits are the units of the duration of ISO 8601, in the order they
+// must appear in each part.
+var isoUnits = [2][]struct {
+	symbol byte
+	unit   time.Duration
+}{
+	{{'W', time.Hour * 24 * 7}, {'D', time.Hour * 24}},
+	{{'H', time.Hour}, {'M', time.Minute}, {'S', time.Second}},
+}
+
+// parseISODuration parses the duration of ISO 8601 such as "PT1H30M", see
+// ParseRepeatingInterval.
+func parseISODuration(s string) (time.Duration, error) {
+	if len(s) < 2 || s[0] != 'P' {
+		return 0, fmt.Errorf("duration %q must start with P", s)
+	}
+	datePart, timePart := s[1:], ""
+	if i := strings.IndexByte(datePart, 'T'); i >= 0 {
+		datePart, timePart = datePart[:i], datePart[i+1:]
+		if timePart == "" {
+			return 0, fmt.Errorf("duration %q has no component after T", s)
+		}
+	}
+
+	var total float64
+	fraction := false
+	for i, part := range [2]string{datePart, timePart} {
+		j := 0
+		for part != "" {
+			if fraction {
+				return 0, fmt.Errorf("duration %q has a fraction in a component other than the last", s)
+			}
+			k := strings.IndexFunc(part, func(r rune) bool { return r < '0' || r > '9' })
+			if k > 0 && (part[k] == '.' || part[k] == ',') {
+				n := strings.IndexFunc(part[k+1:], func(r rune) bool { return r < '0' || r > '9' })
+				if n <= 0 {
+					return 0, fmt.Errorf("duration %q has an invalid fraction", s)
+				}
+				k += 1 + n
+				fraction = true
+			}
+			if k <= 0 {
+				return 0, fmt.Errorf("duration %q has a component without number", s)
+			}
+			symbol := part[k]
+			if i == 0 && (symbol == 'Y' || symbol == 'M') {
+				return 0, fmt.Errorf("duration %q has years or months, whose lengths vary with the calendar", s)
+			}
+			for j < len(isoUnits[i]) && isoUnits[i][j].symbol != symbol {
+				j++
+			}
+			if j == len(isoUnits[i]) {
+				return 0, fmt.Errorf("duration %q has an unknown or out-of-order unit %q", s, symbol)
+			}
+			v, err := strconv.ParseFloat(strings.Replace(part[:k], ",", ".", 1), 64)
+			if err != nil {
+				return 0, fmt.Errorf("duration %q has an invalid number %q", s, part[:k])
+			}
+			total += v * float64(isoUnits[i][j].unit)
+			part = part[k+1:]
+			j++
+		}
+	}
+
+	if total >= math.MaxInt64 {
+		return 0, fmt.Errorf("duration %q is too large", s)
+	}
+	d := time.Duration(total)
+	if d <= 0 {
+		return 0, fmt.Errorf("duration %q must be greater than 0", s)
+	}
+	return d, nil
+}
+
+// Repeating starts building a recurrence by the repeating interval of ISO 8601
+// s, see ParseRepeatingInterval. The error of s is reported by Do.
+//
+// If the start is in the past, the first execution is at the next occurrence
+// that has not passed, and the passed occurrences count toward the number of
+// repetitions, e.g. "R5/2024-01-01T00:00:00Z/PT1H" scheduled at 02:30 executes
+// at 03:00 and 04:00 only. Do reports ErrInvalidSchedule if all the repetitions
+// have passed. The other parameters can be refined by the Recurrence, e.g. the
+// Times overrides the number of repetitions.
+func (tw *TimeWheel) Repeating(s string) *Recurrence {
+	r := &Recurrence{tw: tw, op: "Repeating"}
+	ri, err := ParseRepeatingInterval(s)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	r.interval = ri.Interval
+	r.times = ri.Repetitions
+	r.start = ri.Start
+	r.anchored = true
+	return r
+}
+
+// fastForward moves the start of the anchored recurrence to the first
+// occurrence at or after now, the passed occurrences count toward the times.
+// It returns false if all the occurrences have passed.
+func (r *recurrence) fastForward(now time.Time) bool {
+	if !r.first.Before(now) {
+		return true
+	}
+	// It's O(1) regardless of how many occurrences have passed.
+	missed := (int64(now.Sub(r.first)) + int64(r.interval) - 1) / int64(r.interval)
+	if r.times > 0 {
+		if missed >= int64(r.times) {
+			return false
+		}
+		r.times -= int(missed)
+	}
+	r.first = r.first.Add(time.Duration(missed) * r.interval)
+	return true
+}
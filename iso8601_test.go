@@ -0,0 +1,127 @@
+package timewheel
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRepeatingInterval(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		s  string
+		ri RepeatingInterval
+	}{
+		{"R5/2024-01-01T00:00:00Z/PT1H", RepeatingInterval{Repetitions: 5, Start: start, Interval: time.Hour}},
+		{"R/2024-01-01T00:00:00Z/P1DT12H", RepeatingInterval{Start: start, Interval: time.Hour * 36}},
+		{"R2/2024-01-01T00:00:00Z/2024-01-01T00:30:00Z", RepeatingInterval{Repetitions: 2, Start: start, Interval: time.Minute * 30}},
+		{"R3/PT1M/2024-01-01T00:01:00Z", RepeatingInterval{Repetitions: 3, Start: start, Interval: time.Minute}},
+		{"R/PT0.5S", RepeatingInterval{Interval: time.Millisecond * 500}},
+		{"R10/P2W", RepeatingInterval{Repetitions: 10, Interval: time.Hour * 24 * 14}},
+		{"R/PT1H30M15,25S", RepeatingInterval{Interval: time.Hour + time.Minute*30 + time.Millisecond*15250}},
+	}
+	for _, c := range cases {
+		ri, err := ParseRepeatingInterval(c.s)
+		require.NoError(t, err, c.s)
+		require.Equal(t, c.ri.Repetitions, ri.Repetitions, c.s)
+		require.Equal(t, int64(c.ri.Interval), int64(ri.Interval), c.s)
+		require.True(t, c.ri.Start.Equal(ri.Start), c.s)
+	}
+}
+
+func TestParseRepeatingInterval_Invalid(t *testing.T) {
+	cases := []struct {
+		s   string
+		msg string
+	}{
+		{"PT1H", "must be in the form"},
+		{"R5/PT1H/2024-01-01T00:00:00Z/PT1H", "must be in the form"},
+		{"5/PT1H", "must start with R"},
+		{"R0/PT1H", `number of repetitions "0"`},
+		{"Rx/PT1H", `number of repetitions "x"`},
+		{"R/1H", "must start with P"},
+		{"R/P1M", "years or months"},
+		{"R/P1Y", "years or months"},
+		{"R/PT", "no component after T"},
+		{"R/PT1H2X", "unknown or out-of-order unit"},
+		{"R/PT1S2M", "unknown or out-of-order unit"},
+		{"R/PT1.5H2M", "fraction in a component other than the last"},
+		{"R/PTH", "component without number"},
+		{"R/PT1.H", "invalid fraction"},
+		{"R/PT0S", "must be greater than 0"},
+		{"R/P99999999999999D", "too large"},
+		{"R/2024-01-01/PT1H", "start \"2024-01-01\" must be in RFC 3339"},
+		{"R/2024-01-01T00:00:00Z/tomorrow", "must be in RFC 3339 or a duration"},
+		{"R/2024-01-01T00:00:00Z/2023-01-01T00:00:00Z", "must be after start"},
+		{"R/PT1H/yesterday", "end \"yesterday\" must be in RFC 3339"},
+	}
+	for _, c := range cases {
+		_, err := ParseRepeatingInterval(c.s)
+		require.Error(t, err, c.s)
+		require.True(t, errors.Is(err, ErrInvalidSchedule), c.s)
+		require.Contains(t, err.Error(), c.msg, c.s)
+	}
+}
+
+func Test_recurrence_fastForward(t *testing.T) {
+	now := time.Date(2024, 1, 1, 2, 30, 0, 0, time.UTC)
+	r := &recurrence{interval: time.Hour, times: 5, first: now.Add(-time.Hour * 150 / 60)}
+	require.True(t, r.fastForward(now))
+	require.Equal(t, now.Add(time.Minute*30), r.first)
+	require.Equal(t, 2, r.times)
+
+	// All passed.
+	r = &recurrence{interval: time.Hour, times: 2, first: now.Add(-time.Hour * 2)}
+	require.False(t, r.fastForward(now))
+
+	// At an occurrence exactly, and in the future.
+	r = &recurrence{interval: time.Hour, times: 2, first: now.Add(-time.Hour)}
+	require.True(t, r.fastForward(now))
+	require.Equal(t, now, r.first)
+	require.Equal(t, 1, r.times)
+	r = &recurrence{interval: time.Hour, first: now.Add(time.Hour)}
+	require.True(t, r.fastForward(now))
+	require.Equal(t, now.Add(time.Hour), r.first)
+
+	// Millions of passed occurrences, unbounded.
+	r = &recurrence{interval: time.Millisecond, first: now.AddDate(-50, 0, 0)}
+	require.True(t, r.fastForward(now))
+	require.Equal(t, now, r.first)
+	require.Equal(t, 0, r.times)
+}
+
+func TestTimeWheel_Repeating(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	// Three of five occurrences have passed.
+	anchor := time.Now().Add(-time.Millisecond * 125).UTC().Format(time.RFC3339Nano)
+	ranC := make(chan int, 1)
+	timer, err := tw.Repeating("R5/" + anchor + "/PT0.05S").OnComplete(func(ran int) { ranC <- ran }).Do(func() {})
+	require.NoError(t, err)
+	<-timer.Done()
+	require.Equal(t, 2, <-ranC)
+	require.Equal(t, EndTimes, timer.EndReason())
+
+	// The duration only.
+	timer, err = tw.Repeating("R2/PT0.005S").OnComplete(func(ran int) { ranC <- ran }).Do(func() {})
+	require.NoError(t, err)
+	<-timer.Done()
+	require.Equal(t, 2, <-ranC)
+
+	// All passed.
+	anchor = time.Now().Add(-time.Hour).UTC().Format(time.RFC3339Nano)
+	_, err = tw.Repeating("R3/" + anchor + "/PT1M").Do(func() {})
+	require.True(t, errors.Is(err, ErrInvalidSchedule))
+	require.Contains(t, err.Error(), "all the 3 repetitions have passed")
+
+	// The error of parsing.
+	_, err = tw.Repeating("R3/PT1Y").Do(func() {})
+	var se *ScheduleError
+	require.True(t, errors.As(err, &se))
+	require.Equal(t, "Repeating", se.Op)
+	require.True(t, errors.Is(err, ErrInvalidSchedule))
+}
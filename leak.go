@@ -0,0 +1,257 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// WithLeakTracking enables the accounting of the timers held by the TimeWheel
+// per scheduling site and tag, reported by LeakReport. It's meant for the soak
+// tests, to tell whether a slowly growing heap is held by the TimeWheel or by
+// the caller; it's not for production, since each new timer resolves the call
+// stack of its scheduling and is tracked in a map under a mutex.
+//
+// A timer is live from its scheduling until it's finished, i.e. its task of a
+// run-once timer returned, it's closed, or its recurrence ended. The scheduling
+// site is the first caller outside of this package, e.g. the line that calls
+// AfterFunc; the tags should be of a bounded cardinality, since the counters
+// of each site and tag are kept for the lifetime of the TimeWheel. The
+// ReusableTimer isn't tracked, since it's owned by the caller and never
+// finished.
+func WithLeakTracking() Option {
+	return func(o *options) {
+		o.leakTracking = true
+	}
+}
+
+// LeakReport is the accounting of the timers made by WithLeakTracking.
+type LeakReport struct {
+	// Live is the number of the timers held by the TimeWheel, i.e. scheduled
+	// but not finished yet, including the ones expired or running.
+	Live int64
+	// Sites are the counters per scheduling site and tag, sorted by Live in
+	// descending order.
+	Sites []LeakSite
+	// LevelTimers is the number of the timers referenced by the buckets of
+	// each level, the overflow levels hold none once the TimeWheel is drained.
+	LevelTimers []int
+	// Lingering are the timers that are still referenced by a bucket after
+	// they're cancelled, expired or finished. It's always empty unless the
+	// TimeWheel leaks them.
+	Lingering []LingeringTimer
+}
+
+// LeakSite is the counters of the timers scheduled at a site with a tag, see
+// LeakReport. The Scheduled, Fired and Cancelled are counted like the ones of
+// the Stats, e.g. a Reset counts as cancelled and scheduled again.
+type LeakSite struct {
+	// Site is the "file:line" that scheduled the timers, it's empty if no
+	// caller outside of this package is found.
+	Site string
+	// Tag is the tag set by WithTag.
+	Tag       string
+	Scheduled uint64
+	Fired     uint64
+	Cancelled uint64
+	// Live is the number of the timers scheduled at the site that are not
+	// finished yet.
+	Live int64
+}
+
+// LingeringTimer is a timer that is referenced by a bucket, but not pending.
+type LingeringTimer struct {
+	TimerInfo
+	// State is the state of the timer when found.
+	State State
+	// Finished is whether the timer has been finished, see Timer.Done.
+	Finished bool
+}
+
+// String returns the report in the form of "key=value" pairs like Dump, one
+// record per line:
+//
+//	leaks live=2 lingering=0 levels=[2 0]
+//	site="main.go:42" tag="conn" scheduled=10 fired=7 cancelled=1 live=2
+func (r *LeakReport) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "leaks live=%d lingering=%d levels=%v\n", r.Live, len(r.Lingering), r.LevelTimers)
+	for _, s := range r.Sites {
+		fmt.Fprintf(&sb, "site=%q tag=%q scheduled=%d fired=%d cancelled=%d live=%d\n",
+			s.Site, s.Tag, s.Scheduled, s.Fired, s.Cancelled, s.Live)
+	}
+	for _, t := range r.Lingering {
+		fmt.Fprintf(&sb, "lingering id=%d tag=%q level=%d state=%s finished=%t\n",
+			t.ID, t.Tag, t.Level, t.State, t.Finished)
+	}
+	return sb.String()
+}
+
+type leakKey struct {
+	site, tag string
+}
+
+type leakTracker struct {
+	mu    sync.Mutex
+	live  map[*Timer]*LeakSite
+	sites map[leakKey]*LeakSite
+}
+
+func newLeakTracker() *leakTracker {
+	return &leakTracker{live: make(map[*Timer]*LeakSite), sites: make(map[leakKey]*LeakSite)}
+}
+
+// tracked reports whether t is accounted, the watchdogs are internal and the
+// ReusableTimers are owned by the caller.
+func (l *leakTracker) tracked(t *Timer) bool {
+	a := t.getAttrs()
+	return !a.watchdog && !a.reusable
+}
+
+// schedule counts the scheduling of t, t is live since its first scheduling.
+func (l *leakTracker) schedule(t *Timer) {
+	if !l.tracked(t) {
+		return
+	}
+	l.mu.Lock()
+	s := l.live[t]
+	if s == nil {
+		l.mu.Unlock()
+		// Resolve the site out of the lock.
+		key := leakKey{site: leakSite(), tag: t.Tag()}
+		l.mu.Lock()
+		if s = l.live[t]; s == nil {
+			if s = l.sites[key]; s == nil {
+				s = &LeakSite{Site: key.site, Tag: key.tag}
+				l.sites[key] = s
+			}
+			l.live[t] = s
+			s.Live++
+		}
+	}
+	s.Scheduled++
+	l.mu.Unlock()
+}
+
+func (l *leakTracker) fire(t *Timer) {
+	l.mu.Lock()
+	if s := l.live[t]; s != nil {
+		s.Fired++
+	}
+	l.mu.Unlock()
+}
+
+func (l *leakTracker) cancel(t *Timer) {
+	l.mu.Lock()
+	if s := l.live[t]; s != nil {
+		s.Cancelled++
+	}
+	l.mu.Unlock()
+}
+
+// release is called once t is finished, it's no longer live since then.
+func (l *leakTracker) release(t *Timer) {
+	l.mu.Lock()
+	if s := l.live[t]; s != nil {
+		delete(l.live, t)
+		s.Live--
+	}
+	l.mu.Unlock()
+}
+
+// leakPackageDir is the directory of the source files of this package.
+var leakPackageDir = func() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Dir(file)
+}()
+
+// leakSite returns the "file:line" of the first caller outside of this
+// package, the tests of the package are regarded as outside.
+func leakSite() string {
+	var pcs [32]uintptr
+	n := runtime.Callers(3, pcs[:])
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		f, more := frames.Next()
+		if filepath.Dir(f.File) != leakPackageDir || strings.HasSuffix(f.File, "_test.go") {
+			return fmt.Sprintf("%s:%d", filepath.Base(f.File), f.Line)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+// LeakReport returns the accounting of the timers made by WithLeakTracking,
+// including the critical plane. The buckets of all the levels are walked to
+// find the lingering timers, each bucket is locked only while it's walked.
+//
+// It panics if the TimeWheel is created without the WithLeakTracking option.
+func (tw *TimeWheel) LeakReport() *LeakReport {
+	l := tw.root.leaks
+	if l == nil {
+		panic("timewheel: LeakReport requires the WithLeakTracking option")
+	}
+	r := &LeakReport{}
+	l.mu.Lock()
+	for _, s := range l.sites {
+		r.Sites = append(r.Sites, *s)
+		r.Live += s.Live
+	}
+	l.mu.Unlock()
+	sort.Slice(r.Sites, func(i, j int) bool {
+		if a, b := r.Sites[i], r.Sites[j]; a.Live != b.Live {
+			return a.Live > b.Live
+		} else if a.Site != b.Site {
+			return a.Site < b.Site
+		} else {
+			return a.Tag < b.Tag
+		}
+	})
+
+	tw.root.walkLeaks(r)
+	if c := tw.root.critical; c != nil {
+		c.walkLeaks(r)
+	}
+	return r
+}
+
+// walkLeaks counts the timers referenced by the buckets of each level of the
+// plane, and collects the ones not pending.
+func (tw *TimeWheel) walkLeaks(r *LeakReport) {
+	for level, l := 0, tw; l != nil; level, l = level+1, l.getOverflow() {
+		if level == len(r.LevelTimers) {
+			r.LevelTimers = append(r.LevelTimers, 0)
+		}
+		for i := range l.buckets {
+			b := l.loadBucket(int64(i))
+			if b == nil {
+				continue
+			}
+			// A timer is moved out of its bucket before it's cancelled or
+			// expired, both under the lock, thus it's in StateScheduled
+			// as long as it's in the bucket.
+			b.mu.Lock()
+			r.LevelTimers[level] += b.timers.Len()
+			for e := b.timers.Front(); e != nil; e = e.Next() {
+				t := e.Value
+				state := t.State()
+				finished := atomic.LoadPointer(&t.done) == unsafe.Pointer(&closedC)
+				if state != StateScheduled || finished {
+					r.Lingering = append(r.Lingering, LingeringTimer{TimerInfo: t.info(level), State: state, Finished: finished})
+				}
+			}
+			b.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package timewheel
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithLeakTracking(t *testing.T) {
+	clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	tw := New(time.Millisecond, 8, WithClock(clock), WithDispatchPolicy(DispatchInline), WithLeakTracking())
+	tw.Start()
+	defer tw.Stop()
+
+	var timers []*Timer
+	for i := 0; i < 3; i++ {
+		timers = append(timers, tw.AfterFunc(time.Millisecond*5, func() {}, WithTag("short")))
+	}
+	long := tw.AfterFunc(time.Hour, func() {}, WithTag("long"))
+	timers[0].Close()
+	timers[1].Reset(time.Millisecond * 2)
+
+	r := tw.LeakReport()
+	require.Equal(t, int64(3), r.Live)
+	require.Len(t, r.Sites, 2)
+	require.Equal(t, "short", r.Sites[0].Tag)
+	require.True(t, strings.HasPrefix(r.Sites[0].Site, "leak_test.go:"), r.Sites[0].Site)
+	require.Equal(t, LeakSite{Site: r.Sites[0].Site, Tag: "short", Scheduled: 4, Cancelled: 2, Live: 2}, r.Sites[0])
+	require.Equal(t, int64(1), r.Sites[1].Live)
+	require.NotEqual(t, r.Sites[0].Site, r.Sites[1].Site)
+	require.Equal(t, 2, r.LevelTimers[0])
+	require.Equal(t, 1, r.LevelTimers[len(r.LevelTimers)-1])
+	require.Empty(t, r.Lingering)
+
+	clock.add(time.Millisecond * 5)
+	tw.Poll()
+	long.Close()
+
+	// Drained, the overflow levels hold no reference.
+	r = tw.LeakReport()
+	require.Equal(t, int64(0), r.Live)
+	require.Equal(t, LeakSite{Site: r.Sites[0].Site, Tag: "short", Scheduled: 4, Fired: 2, Cancelled: 2}, r.Sites[0])
+	require.Equal(t, LeakSite{Site: r.Sites[1].Site, Tag: "long", Scheduled: 1, Cancelled: 1}, r.Sites[1])
+	for _, n := range r.LevelTimers {
+		require.Equal(t, 0, n)
+	}
+	require.Empty(t, r.Lingering)
+}
+
+func TestWithLeakTracking_Arena(t *testing.T) {
+	tw := New(time.Millisecond, 8, WithCapacity(1), WithLeakTracking())
+	tw.Start()
+	defer tw.Stop()
+
+	// The slot is reused by the next Arm once disarmed.
+	for i := 0; i < 3; i++ {
+		h, err := tw.Arm(time.Hour, func() {})
+		require.NoError(t, err)
+		require.Equal(t, int64(1), tw.LeakReport().Live)
+		require.True(t, tw.Disarm(h))
+	}
+	r := tw.LeakReport()
+	require.Equal(t, int64(0), r.Live)
+	require.Equal(t, uint64(3), r.Sites[0].Scheduled)
+}
+
+func TestLeakReport_Lingering(t *testing.T) {
+	tw := New(time.Millisecond, 8, WithLeakTracking())
+	tw.Start()
+	defer tw.Stop()
+
+	timer := tw.AfterFunc(time.Hour, func() {})
+	// Mimic a cancellation that leaves the timer in its bucket.
+	require.True(t, timer.transit(StateScheduled, StateCancelled))
+
+	r := tw.LeakReport()
+	require.Len(t, r.Lingering, 1)
+	require.Equal(t, timer.ID(), r.Lingering[0].ID)
+	require.Equal(t, StateCancelled, r.Lingering[0].State)
+	require.False(t, r.Lingering[0].Finished)
+	require.Contains(t, r.String(), "leaks live=1 lingering=1")
+	require.Contains(t, r.String(), "lingering id=1 tag=\"\"")
+}
+
+func TestLeakReport_NotTracked(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	require.Panics(t, func() { tw.LeakReport() })
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+// timerList is a doubly linked list of timers. It's the same as container/list
+// but typed to *Timer, and its elements can be preallocated by the caller so
+// that no allocation happens on insertion, see WithCapacity.
+type timerList struct {
+	root timerElement // sentinel list element, only &root, root.prev, and root.next are used.
+	len  int
+}
+
+// timerElement is an element of the timerList.
+type timerElement struct {
+	next, prev *timerElement
+	list       *timerList
+
+	Value *Timer
+}
+
+// Next returns the next list element or nil.
+func (e *timerElement) Next() *timerElement {
+	if p := e.next; e.list != nil && p != &e.list.root {
+		return p
+	}
+	return nil
+}
+
+// newTimerList returns an initialized list.
+func newTimerList() *timerList {
+	l := new(timerList)
+	l.root.next = &l.root
+	l.root.prev = &l.root
+	return l
+}
+
+// Len returns the number of elements of list l.
+func (l *timerList) Len() int { return l.len }
+
+// Front returns the first element of list l or nil if the list is empty.
+func (l *timerList) Front() *timerElement {
+	if l.len == 0 {
+		return nil
+	}
+	return l.root.next
+}
+
+// PushBack inserts a new element with the timer t at the back of list l and returns it.
+func (l *timerList) PushBack(t *Timer) *timerElement {
+	return l.pushBackElement(new(timerElement), t)
+}
+
+// pushBackElement inserts the unused element e with the timer t at the back of
+// list l and returns it.
+func (l *timerList) pushBackElement(e *timerElement, t *Timer) *timerElement {
+	at := l.root.prev
+	e.Value = t
+	e.prev = at
+	e.next = at.next
+	e.prev.next = e
+	e.next.prev = e
+	e.list = l
+	l.len++
+	return e
+}
+
+// Remove removes e from l if e is an element of list l, and returns its timer.
+// The e is unused after removed and can be pushed again.
+func (l *timerList) Remove(e *timerElement) *Timer {
+	t := e.Value
+	if e.list == l {
+		e.prev.next = e.next
+		e.next.prev = e.prev
+		e.next = nil
+		e.prev = nil
+		e.list = nil
+		e.Value = nil
+		l.len--
+	}
+	return t
+}
@@ -0,0 +1,40 @@
+package timewheel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_timerList(t *testing.T) {
+	l := newTimerList()
+	require.Equal(t, 0, l.Len())
+	require.Nil(t, l.Front())
+
+	t1, t2, t3 := &Timer{}, &Timer{}, &Timer{}
+	e1 := l.PushBack(t1)
+	e2 := l.PushBack(t2)
+	pe := new(timerElement)
+	require.Equal(t, pe, l.pushBackElement(pe, t3))
+	require.Equal(t, 3, l.Len())
+
+	var timers []*Timer
+	for e := l.Front(); e != nil; e = e.Next() {
+		timers = append(timers, e.Value)
+	}
+	require.Equal(t, []*Timer{t1, t2, t3}, timers)
+
+	require.Equal(t, t2, l.Remove(e2))
+	require.Equal(t, 2, l.Len())
+	require.Equal(t, e1.Next(), pe)
+	// Removing an element that not in the list does nothing.
+	require.Nil(t, l.Remove(e2))
+	require.Equal(t, 2, l.Len())
+
+	// The removed element can be pushed again.
+	require.Equal(t, t3, l.Remove(pe))
+	l.pushBackElement(pe, t2)
+	require.Equal(t, e1.Next(), pe)
+	require.Nil(t, pe.Next())
+	require.Equal(t, t2, pe.Value)
+}
@@ -0,0 +1,22 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"log/slog"
+)
+
+// timerAttr returns the attribute that identifies the timer t in the records.
+func timerAttr(t *Timer) slog.Attr {
+	return slog.Group("timer", slog.Uint64("id", t.ID()), slog.String("tag", t.Tag()))
+}
+
+// logPanic records the value recovered from the panicking task of timer t.
+func (tw *TimeWheel) logPanic(t *Timer, value interface{}) {
+	if l := tw.root.opts.logger; l != nil {
+		l.Error("timewheel: task panic recovered", timerAttr(t), slog.Any("panic", value))
+	}
+}
@@ -0,0 +1,148 @@
+package timewheel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/yu31/dqueue"
+)
+
+// logRecorder collects the JSON records written by a slog.Logger.
+type logRecorder struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (r *logRecorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.buf.Write(p)
+}
+
+func (r *logRecorder) logger() *slog.Logger {
+	return slog.New(slog.NewJSONHandler(r, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+func (r *logRecorder) records(t *testing.T) []map[string]interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(r.buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		record := make(map[string]interface{})
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	return records
+}
+
+// find returns the first record with the message msg.
+func (r *logRecorder) find(t *testing.T, msg string) map[string]interface{} {
+	for _, record := range r.records(t) {
+		if record["msg"] == msg {
+			return record
+		}
+	}
+	return nil
+}
+
+func TestWithLogger_Overrun(t *testing.T) {
+	rec := new(logRecorder)
+	tw := New(time.Millisecond, 8, WithName("foo"), WithLogger(rec.logger()), WithTaskTimeout(time.Millisecond*5))
+	tw.Start()
+	defer tw.Stop()
+
+	timer := tw.AfterFunc(time.Millisecond, func() { time.Sleep(time.Millisecond * 20) }, WithTag("slow"))
+	<-timer.Done()
+
+	require.Eventually(t, func() bool { return rec.find(t, "timewheel: task exceeded timeout") != nil }, time.Second, time.Millisecond)
+	record := rec.find(t, "timewheel: task exceeded timeout")
+	require.Equal(t, "WARN", record["level"])
+	require.Equal(t, "foo", record["wheel"])
+	require.Equal(t, "5ms", time.Duration(record["timeout"].(float64)).String())
+	require.Equal(t, map[string]interface{}{"id": float64(timer.ID()), "tag": "slow"}, record["timer"])
+}
+
+func TestWithLogger_Panic(t *testing.T) {
+	rec := new(logRecorder)
+	tw := New(time.Millisecond, 8, WithLogger(rec.logger()))
+	tw.Start()
+	defer tw.Stop()
+
+	fu := ScheduleResult(tw, time.Millisecond, func() (int, error) { panic(errors.New("boom")) }, WithTag("bad"))
+	_, err := fu.Get(context.Background())
+	require.Error(t, err)
+
+	record := rec.find(t, "timewheel: task panic recovered")
+	require.NotNil(t, record)
+	require.Equal(t, "ERROR", record["level"])
+	require.Equal(t, "", record["wheel"])
+	require.Equal(t, "bad", record["timer"].(map[string]interface{})["tag"])
+	require.Equal(t, "boom", record["panic"])
+}
+
+func TestWithLogger_Shutdown(t *testing.T) {
+	rec := new(logRecorder)
+	tw := New(time.Millisecond, 8, WithLogger(rec.logger()), WithExpiredChannel(0, DeliveryBlock))
+	tw.Start()
+
+	// Nobody receives, the consumer goroutine blocks until stopped.
+	timer := tw.After(time.Millisecond*5, "payload", WithTag("undelivered"))
+	time.Sleep(time.Millisecond * 20)
+	tw.AfterFunc(time.Hour, func() {})
+	tw.Stop()
+	<-timer.Done()
+
+	record := rec.find(t, "timewheel: timer dropped at shutdown")
+	require.NotNil(t, record)
+	require.Equal(t, "undelivered", record["timer"].(map[string]interface{})["tag"])
+
+	record = rec.find(t, "timewheel: stopped with pending timers")
+	require.NotNil(t, record)
+	require.Equal(t, "INFO", record["level"])
+
+	// The timer scheduled after stopped can't be enqueued.
+	tw.AfterFunc(time.Hour*2, func() {})
+	require.NotNil(t, rec.find(t, "timewheel: bucket enqueued after close"))
+}
+
+func TestWithLogger_ForeignMessage(t *testing.T) {
+	rec := new(logRecorder)
+	dq := dqueue.Default()
+	tw := New(time.Millisecond, 8, WithQueue(dq), WithLogger(rec.logger()))
+	tw.Start()
+	defer tw.Stop()
+
+	dq.After(time.Millisecond, "foreign")
+	require.Eventually(t, func() bool { return rec.find(t, "timewheel: foreign message dropped") != nil }, time.Second, time.Millisecond)
+	require.Equal(t, "foreign", rec.find(t, "timewheel: foreign message dropped")["value"])
+}
+
+func TestWithLogger_Quiet(t *testing.T) {
+	rec := new(logRecorder)
+	tw := New(time.Millisecond, 8, WithLogger(rec.logger()), WithExpiredChannel(10, DeliveryDrop))
+	tw.Start()
+
+	for i := 0; i < 10; i++ {
+		<-tw.AfterFunc(time.Millisecond, func() {}).Done()
+		<-tw.After(time.Millisecond, i).Done()
+	}
+	timer, err := tw.Every(time.Millisecond).Times(3).Do(func() {})
+	require.NoError(t, err)
+	<-timer.Done()
+	tw.Stop()
+
+	// No record for the regular expiration, even at the debug level.
+	require.Len(t, rec.records(t), 0)
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Manager owns a TimeWheel per key, e.g. per tenant, that is created on demand
+// by the func given to NewManager and started by the Manager.
+//
+// It's meant for the TimeWheels created WithIdleShutdown: a TimeWheel stops
+// itself once idle, and the Manager creates a new one for the key on the next
+// scheduling. The scheduling that races with the shutdown is retried on the
+// new TimeWheel, thus it's never lost.
+type Manager struct {
+	newWheel func(key string) *TimeWheel
+
+	mu     sync.Mutex
+	wheels map[string]*TimeWheel
+	closed bool
+}
+
+// NewManager creates a Manager that creates the TimeWheel of a key by newWheel.
+// The newWheel must return a new TimeWheel that is not started.
+func NewManager(newWheel func(key string) *TimeWheel) *Manager {
+	return &Manager{newWheel: newWheel, wheels: make(map[string]*TimeWheel)}
+}
+
+// Wheel returns the running TimeWheel of the key, a new one is created if
+// there is none, or the previous one has been stopped. It returns ErrStopped
+// if the Manager has been closed.
+func (m *Manager) Wheel(key string) (*TimeWheel, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, ErrStopped
+	}
+	if tw := m.wheels[key]; tw != nil && !tw.stoppedNow() {
+		return tw, nil
+	}
+	tw := m.newWheel(key)
+	tw.Start()
+	m.wheels[key] = tw
+	return tw, nil
+}
+
+// Do calls f with the running TimeWheel of the key, and calls it again with a
+// new one if f returns an error that wraps ErrIdleShutdown, i.e. the TimeWheel
+// has shut down itself meanwhile. It returns the error of f otherwise.
+func (m *Manager) Do(key string, f func(tw *TimeWheel) error) error {
+	for {
+		tw, err := m.Wheel(key)
+		if err != nil {
+			return err
+		}
+		if err = f(tw); !errors.Is(err, ErrIdleShutdown) {
+			return err
+		}
+		m.evict(key, tw)
+	}
+}
+
+// TryAfterFunc calls the TryAfterFunc of the TimeWheel of the key by Do.
+func (m *Manager) TryAfterFunc(key string, d time.Duration, f func(), opts ...TimerOption) (*Timer, error) {
+	var t *Timer
+	err := m.Do(key, func(tw *TimeWheel) (err error) {
+		t, err = tw.TryAfterFunc(d, f, opts...)
+		return err
+	})
+	return t, err
+}
+
+// evict removes the stopped tw of the key if it's still the current one.
+func (m *Manager) evict(key string, tw *TimeWheel) {
+	m.mu.Lock()
+	if m.wheels[key] == tw {
+		delete(m.wheels, key)
+	}
+	m.mu.Unlock()
+}
+
+// Len returns the number of the TimeWheels that are running.
+func (m *Manager) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for key, tw := range m.wheels {
+		if tw.stoppedNow() {
+			delete(m.wheels, key)
+			continue
+		}
+		n++
+	}
+	return n
+}
+
+// Close stops all the TimeWheels, and no more is created since then.
+func (m *Manager) Close() {
+	m.mu.Lock()
+	wheels := m.wheels
+	m.wheels = nil
+	m.closed = true
+	m.mu.Unlock()
+
+	for _, tw := range wheels {
+		tw.Stop()
+	}
+}
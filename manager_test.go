@@ -0,0 +1,66 @@
+package timewheel
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestManager(t *testing.T) {
+	var created int32
+	m := NewManager(func(key string) *TimeWheel {
+		atomic.AddInt32(&created, 1)
+		return New(time.Millisecond, 8, WithName(key), WithIdleShutdown(time.Millisecond*20))
+	})
+	defer m.Close()
+
+	retC := make(chan struct{}, 1)
+	_, err := m.TryAfterFunc("a", time.Millisecond, func() { retC <- struct{}{} })
+	require.NoError(t, err)
+	<-retC
+	require.Equal(t, 1, m.Len())
+
+	// The idle TimeWheel stops itself, a new one is created on demand.
+	require.Eventually(t, func() bool { return m.Len() == 0 }, time.Second, time.Millisecond)
+	_, err = m.TryAfterFunc("a", time.Millisecond, func() { retC <- struct{}{} })
+	require.NoError(t, err)
+	<-retC
+	require.Equal(t, int32(2), atomic.LoadInt32(&created))
+
+	m.Close()
+	_, err = m.Wheel("a")
+	require.True(t, errors.Is(err, ErrStopped))
+}
+
+func TestManager_RaceShutdown(t *testing.T) {
+	m := NewManager(func(key string) *TimeWheel {
+		return New(time.Millisecond, 8, WithIdleShutdown(time.Millisecond))
+	})
+	defer m.Close()
+
+	// The schedulings racing with the shutdown are never lost.
+	var fired, scheduled int32
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				timer, err := m.TryAfterFunc("a", 0, func() { atomic.AddInt32(&fired, 1) })
+				if errors.Is(err, ErrBusy) {
+					continue
+				}
+				require.NoError(t, err)
+				atomic.AddInt32(&scheduled, 1)
+				<-timer.Done()
+				time.Sleep(time.Duration(j%3) * time.Millisecond)
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, atomic.LoadInt32(&scheduled), atomic.LoadInt32(&fired))
+}
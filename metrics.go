@@ -0,0 +1,296 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"sync"
+	"time"
+)
+
+// The names of the metrics reported to the MetricsSink. They're a stable
+// contract for the dashboards and alerts built on them, renaming or removing
+// one is a breaking change; see MetricNames.
+const (
+	// MetricPending is the gauge of the number of pending timers.
+	MetricPending = "timewheel_pending"
+	// MetricScheduled is the counter of the number of times that timers armed.
+	MetricScheduled = "timewheel_scheduled_total"
+	// MetricFired is the counter of the number of times that timers expired.
+	MetricFired = "timewheel_fired_total"
+	// MetricCancelled is the counter of the number of timers closed before expired.
+	MetricCancelled = "timewheel_cancelled_total"
+	// MetricSkipped is the counter of the number of skipped recurring executions.
+	MetricSkipped = "timewheel_skipped_total"
+	// MetricQueued is the counter of the number of queued recurring executions.
+	MetricQueued = "timewheel_queued_total"
+	// MetricGuardDenied is the counter of the number of executions denied by the FireGuard.
+	MetricGuardDenied = "timewheel_guard_denied_total"
+	// MetricRejected is the counter of the number of timers rejected when scheduled.
+	MetricRejected = "timewheel_rejected_total"
+	// MetricShed is the counter of the number of timers shed under load.
+	MetricShed = "timewheel_shed_total"
+	// MetricRateLimited is the counter of the number of timers rejected by the rate limits.
+	MetricRateLimited = "timewheel_rate_limited_total"
+	// MetricDropped is the counter of the number of tasks refused by the Executor.
+	MetricDropped = "timewheel_dropped_total"
+	// MetricQueueDepth is the gauge of the number of buckets that have expired
+	// but not yet processed, see Stats.QueueDepth.
+	MetricQueueDepth = "timewheel_queue_depth"
+	// MetricExecutorQueue is the gauge of the number of tasks in the run queue
+	// of the Executor, see Stats.ExecutorQueue.
+	MetricExecutorQueue = "timewheel_executor_queue"
+	// MetricExecutorQueueHighWater is the gauge of the maximum number of tasks
+	// in the run queue of the Executor, see Stats.ExecutorQueueHighWater.
+	MetricExecutorQueueHighWater = "timewheel_executor_queue_high_water"
+	// MetricFireLag is the histogram of the seconds between the expiration
+	// of a timer and the dispatch of its task, it's observed on every fire.
+	MetricFireLag = "timewheel_fire_lag_seconds"
+	// MetricConsumerLag is the histogram of the seconds between the expiration
+	// of a bucket and the start of its processing, see Stats.ConsumerLag.
+	MetricConsumerLag = "timewheel_consumer_lag_seconds"
+	// MetricConsumerLagMax is the gauge of the maximum seconds of the consumer
+	// lag in the last minute, see Stats.ConsumerLagMax.
+	MetricConsumerLagMax = "timewheel_consumer_lag_max_seconds"
+	// MetricTaskDuration is the histogram of the seconds that the tasks take
+	// to run, see WithTaskDurations.
+	MetricTaskDuration = "timewheel_task_duration_seconds"
+)
+
+// MetricNames returns the names of all the metrics that a TimeWheel may
+// report, in the order of their declarations. The gauges of the executor are
+// reported only with a QueuedExecutor, the MetricTaskDuration only with the
+// WithTaskDurations, and the histograms once they have values.
+func MetricNames() []string {
+	return []string{
+		MetricPending,
+		MetricScheduled,
+		MetricFired,
+		MetricCancelled,
+		MetricSkipped,
+		MetricQueued,
+		MetricGuardDenied,
+		MetricRejected,
+		MetricShed,
+		MetricRateLimited,
+		MetricDropped,
+		MetricQueueDepth,
+		MetricExecutorQueue,
+		MetricExecutorQueueHighWater,
+		MetricFireLag,
+		MetricConsumerLag,
+		MetricConsumerLagMax,
+		MetricTaskDuration,
+	}
+}
+
+// maxLagBuffer is the maximum number of the lags of each histogram buffered
+// between two flushes, the excess ones are dropped.
+const maxLagBuffer = 4096
+
+// MetricsSink receives the metrics of a TimeWheel, it's implemented by the
+// adapters of the monitoring systems, such as the subpackages of metrics.
+//
+// The methods are called by a dedicated goroutine of the TimeWheel in every
+// flush interval, never on the path of the expiration.
+type MetricsSink interface {
+	// Count adds the delta to the counter of name.
+	Count(name string, delta uint64)
+	// Gauge sets the gauge of name to the value.
+	Gauge(name string, value float64)
+	// Observe records the values observed since the last flush to the
+	// histogram of name. The values must not be retained after returns.
+	Observe(name string, values []float64)
+}
+
+// TagSink is an optional interface of the MetricsSink, the histograms that
+// broken down by the tag of the timers, such as the MetricTaskDuration, are
+// reported to it in addition to the Observe of all the values. The number of
+// tags is bounded, see WithTaskDurations.
+type TagSink interface {
+	// ObserveTag records the values of the timers with the tag observed since
+	// the last flush to the histogram of name. The values must not be retained
+	// after returns.
+	ObserveTag(name string, tag string, values []float64)
+}
+
+// metrics drives the MetricsSink of a TimeWheel.
+type metrics struct {
+	sink     MetricsSink
+	interval time.Duration
+
+	// The mu protects the buffers of lags and durations.
+	mu           sync.Mutex
+	fireLags     lagBuffer
+	consumerLags lagBuffer
+	durations    lagBuffer
+	// The durations by the tag, it's nil unless the sink is a TagSink.
+	tagDurations map[string]*lagBuffer
+
+	// The last stats that reported, only accessed by the flush goroutine.
+	last Stats
+
+	startOnce sync.Once
+	stopOnce  sync.Once
+	started   bool
+	stopC     chan struct{}
+	doneC     chan struct{}
+}
+
+func newMetrics(sink MetricsSink, interval time.Duration) *metrics {
+	m := &metrics{
+		sink:     sink,
+		interval: interval,
+		stopC:    make(chan struct{}),
+		doneC:    make(chan struct{}),
+	}
+	if _, ok := sink.(TagSink); ok {
+		m.tagDurations = make(map[string]*lagBuffer)
+	}
+	return m
+}
+
+// lagBuffer is a double buffer of the lags in seconds, thus no allocation in
+// the steady state.
+type lagBuffer struct {
+	values []float64
+	spare  []float64
+}
+
+func (lb *lagBuffer) add(lag time.Duration) {
+	if len(lb.values) < maxLagBuffer {
+		lb.values = append(lb.values, lag.Seconds())
+	}
+}
+
+// swap returns the buffered values, they're valid until the next swap.
+func (lb *lagBuffer) swap() []float64 {
+	values := lb.values
+	lb.values = lb.spare[:0]
+	lb.spare = values
+	return values
+}
+
+// observeLag buffers the lag of a fire until the next flush.
+func (m *metrics) observeLag(lag time.Duration) {
+	m.mu.Lock()
+	m.fireLags.add(lag)
+	m.mu.Unlock()
+}
+
+// observeConsumerLag buffers the lag of processing a bucket until the next flush.
+func (m *metrics) observeConsumerLag(lag time.Duration) {
+	m.mu.Lock()
+	m.consumerLags.add(lag)
+	m.mu.Unlock()
+}
+
+// observeDuration buffers the duration of a task until the next flush, the tag
+// is empty unless it's broken down.
+func (m *metrics) observeDuration(tag string, d time.Duration) {
+	m.mu.Lock()
+	m.durations.add(d)
+	if tag != "" && m.tagDurations != nil {
+		lb := m.tagDurations[tag]
+		if lb == nil {
+			lb = new(lagBuffer)
+			m.tagDurations[tag] = lb
+		}
+		lb.add(d)
+	}
+	m.mu.Unlock()
+}
+
+// start starts the flush goroutine, only the first call takes effect.
+func (m *metrics) start(tw *TimeWheel) {
+	m.startOnce.Do(func() {
+		m.started = true
+		go m.run(tw)
+	})
+}
+
+// stop stops the flush goroutine after a final flush, and waits for it exits.
+func (m *metrics) stop() {
+	m.stopOnce.Do(func() {
+		close(m.stopC)
+	})
+	// Prevents the start after stop, and waits if started.
+	m.startOnce.Do(func() {})
+	if m.started {
+		<-m.doneC
+	}
+}
+
+func (m *metrics) run(tw *TimeWheel) {
+	defer close(m.doneC)
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.flush(tw)
+		case <-m.stopC:
+			m.flush(tw)
+			return
+		}
+	}
+}
+
+// flush reports the metrics to the sink.
+func (m *metrics) flush(tw *TimeWheel) {
+	stats := tw.Stats()
+	last := m.last
+	m.last = stats
+
+	m.sink.Gauge(MetricPending, float64(stats.Pending))
+	m.sink.Count(MetricScheduled, stats.Scheduled-last.Scheduled)
+	m.sink.Count(MetricFired, stats.Fired-last.Fired)
+	m.sink.Count(MetricCancelled, stats.Cancelled-last.Cancelled)
+	m.sink.Count(MetricSkipped, stats.Skipped-last.Skipped)
+	m.sink.Count(MetricQueued, stats.Queued-last.Queued)
+	m.sink.Count(MetricGuardDenied, stats.GuardDenied-last.GuardDenied)
+	m.sink.Count(MetricRejected, stats.Rejected-last.Rejected)
+	m.sink.Count(MetricShed, stats.Shed-last.Shed)
+	m.sink.Count(MetricRateLimited, stats.RateLimited-last.RateLimited)
+	m.sink.Count(MetricDropped, stats.Dropped-last.Dropped)
+	m.sink.Gauge(MetricQueueDepth, float64(stats.QueueDepth))
+	m.sink.Gauge(MetricConsumerLagMax, stats.ConsumerLagMax.Seconds())
+	if _, ok := tw.root.opts.executor.(QueuedExecutor); ok {
+		m.sink.Gauge(MetricExecutorQueue, float64(stats.ExecutorQueue))
+		m.sink.Gauge(MetricExecutorQueueHighWater, float64(stats.ExecutorQueueHighWater))
+	}
+
+	// The values swapped out are only accessed by the flush goroutine until
+	// the next swap.
+	m.mu.Lock()
+	fireLags := m.fireLags.swap()
+	consumerLags := m.consumerLags.swap()
+	durations := m.durations.swap()
+	var tagDurations map[string][]float64
+	for tag, lb := range m.tagDurations {
+		if values := lb.swap(); len(values) != 0 {
+			if tagDurations == nil {
+				tagDurations = make(map[string][]float64)
+			}
+			tagDurations[tag] = values
+		}
+	}
+	m.mu.Unlock()
+
+	if len(fireLags) != 0 {
+		m.sink.Observe(MetricFireLag, fireLags)
+	}
+	if len(consumerLags) != 0 {
+		m.sink.Observe(MetricConsumerLag, consumerLags)
+	}
+	if len(durations) != 0 {
+		m.sink.Observe(MetricTaskDuration, durations)
+	}
+	for tag, values := range tagDurations {
+		m.sink.(TagSink).ObserveTag(MetricTaskDuration, tag, values)
+	}
+}
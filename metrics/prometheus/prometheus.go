@@ -0,0 +1,174 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+// Package prometheus implements a timewheel.MetricsSink that aggregates the
+// metrics and serves them in the Prometheus text exposition format, so that
+// it can be scraped without depending on the Prometheus client library.
+package prometheus
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the default upper bounds of the histogram buckets, in
+// seconds. They suit the fire lag of a TimeWheel with the tick of 1ms.
+var DefaultBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5}
+
+// Sink is a timewheel.MetricsSink and an http.Handler. The metrics reported
+// by the TimeWheel are aggregated in memory, and served on each request.
+//
+// It's also a timewheel.TagSink, the histograms broken down by the tag are
+// served with the label "tag" besides the one of all the values.
+type Sink struct {
+	buckets []float64
+
+	// The mu protects all the following fields.
+	mu         sync.Mutex
+	counters   map[string]uint64
+	gauges     map[string]float64
+	histograms map[string]*histogram
+	// The histograms by the name and then the tag.
+	tagged map[string]map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64 // The cumulative count is computed when serving.
+	count  uint64
+	sum    float64
+}
+
+// New creates a Sink with the given upper bounds of the histogram buckets,
+// the DefaultBuckets is used if no bound given.
+func New(buckets ...float64) *Sink {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	bs := append([]float64(nil), buckets...)
+	sort.Float64s(bs)
+	return &Sink{
+		buckets:    bs,
+		counters:   make(map[string]uint64),
+		gauges:     make(map[string]float64),
+		histograms: make(map[string]*histogram),
+		tagged:     make(map[string]map[string]*histogram),
+	}
+}
+
+// Count implements the timewheel.MetricsSink.
+func (s *Sink) Count(name string, delta uint64) {
+	s.mu.Lock()
+	s.counters[name] += delta
+	s.mu.Unlock()
+}
+
+// Gauge implements the timewheel.MetricsSink.
+func (s *Sink) Gauge(name string, value float64) {
+	s.mu.Lock()
+	s.gauges[name] = value
+	s.mu.Unlock()
+}
+
+// Observe implements the timewheel.MetricsSink.
+func (s *Sink) Observe(name string, values []float64) {
+	s.mu.Lock()
+	h, ok := s.histograms[name]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(s.buckets))}
+		s.histograms[name] = h
+	}
+	s.observe(h, values)
+	s.mu.Unlock()
+}
+
+// ObserveTag implements the timewheel.TagSink.
+func (s *Sink) ObserveTag(name string, tag string, values []float64) {
+	s.mu.Lock()
+	tags, ok := s.tagged[name]
+	if !ok {
+		tags = make(map[string]*histogram)
+		s.tagged[name] = tags
+	}
+	h, ok := tags[tag]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(s.buckets))}
+		tags[tag] = h
+	}
+	s.observe(h, values)
+	s.mu.Unlock()
+}
+
+// observe adds the values to h, the caller must hold the mu.
+func (s *Sink) observe(h *histogram, values []float64) {
+	for _, v := range values {
+		if i := sort.SearchFloat64s(s.buckets, v); i < len(s.buckets) {
+			h.counts[i]++
+		}
+		h.count++
+		h.sum += v
+	}
+}
+
+// ServeHTTP implements the http.Handler, it writes all the metrics in the
+// Prometheus text exposition format, sorted by name.
+func (s *Sink) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, name := range sortedKeys(s.counters) {
+		fmt.Fprintf(w, "# TYPE %s counter\n%s %d\n", name, name, s.counters[name])
+	}
+	for _, name := range sortedKeys(s.gauges) {
+		fmt.Fprintf(w, "# TYPE %s gauge\n%s %s\n", name, name, formatFloat(s.gauges[name]))
+	}
+	for _, name := range sortedKeys(s.histograms) {
+		fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+		s.writeHistogram(w, name, "", s.histograms[name])
+		tags := s.tagged[name]
+		for _, tag := range sortedKeys(tags) {
+			s.writeHistogram(w, name, "tag=\""+labelEscaper.Replace(tag)+"\"", tags[tag])
+		}
+	}
+}
+
+// writeHistogram writes the series of the histogram h with the labels, such as
+// `tag="a"`, the caller must hold the mu.
+func (s *Sink) writeHistogram(w io.Writer, name string, labels string, h *histogram) {
+	group := ""
+	if labels != "" {
+		group = "{" + labels + "}"
+		labels += ","
+	}
+	var cumulative uint64
+	for i, le := range s.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", name, labels, formatFloat(le), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, labels, h.count)
+	fmt.Fprintf(w, "%s_sum%s %s\n%s_count%s %d\n", name, group, formatFloat(h.sum), name, group, h.count)
+}
+
+// labelEscaper escapes a label value in the text exposition format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,120 @@
+package prometheus
+
+import (
+	"bufio"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/yu31/timewheel"
+)
+
+func TestSink_ServeHTTP(t *testing.T) {
+	s := New(0.1, 0.01)
+	s.Count("b_total", 1)
+	s.Count("b_total", 2)
+	s.Count("a_total", 0)
+	s.Gauge("pending", 5)
+	s.Observe("lag_seconds", []float64{0.005, 0.05, 0.01, 1})
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	require.Equal(t, "text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"))
+	require.Equal(t, `# TYPE a_total counter
+a_total 0
+# TYPE b_total counter
+b_total 3
+# TYPE pending gauge
+pending 5
+# TYPE lag_seconds histogram
+lag_seconds_bucket{le="0.01"} 2
+lag_seconds_bucket{le="0.1"} 3
+lag_seconds_bucket{le="+Inf"} 4
+lag_seconds_sum 1.065
+lag_seconds_count 4
+`, rec.Body.String())
+}
+
+func TestSink_TimeWheel(t *testing.T) {
+	s := New()
+	tw := timewheel.New(time.Millisecond, 8, timewheel.WithMetricsSink(s, time.Hour))
+	tw.Start()
+	for i := 0; i < 3; i++ {
+		<-tw.AfterFunc(time.Millisecond, func() {}).Done()
+	}
+	tw.Stop()
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	require.Contains(t, rec.Body.String(), timewheel.MetricFired+" 3\n")
+	require.Contains(t, rec.Body.String(), timewheel.MetricFireLag+"_count 3\n")
+}
+
+func TestSink_MetricNames(t *testing.T) {
+	// The metric names are a contract of the dashboards, the scrape of a
+	// TimeWheel with all of them enabled serves exactly the declared ones.
+	s := New()
+	pool := timewheel.NewWorkerPool(2, 8)
+	defer pool.Close()
+	tw := timewheel.New(time.Millisecond, 8, timewheel.WithMetricsSink(s, time.Hour),
+		timewheel.WithExecutor(pool), timewheel.WithTaskDurations(4))
+	tw.Start()
+	for i := 0; i < 3; i++ {
+		tw.AfterFunc(time.Millisecond, func() {}, timewheel.WithTag("synthetic"))
+	}
+	require.Eventually(t, func() bool { return tw.Stats().Fired == 3 }, time.Second, time.Millisecond)
+	tw.Stop()
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	types := make(map[string]string)
+	for sc := bufio.NewScanner(rec.Body); sc.Scan(); {
+		if fields := strings.Fields(sc.Text()); len(fields) == 4 && fields[1] == "TYPE" {
+			types[fields[2]] = fields[3]
+		}
+	}
+
+	want := timewheel.MetricNames()
+	got := sortedKeys(types)
+	sort.Strings(want)
+	require.Equal(t, want, got)
+	for name, typ := range types {
+		switch {
+		case strings.HasSuffix(name, "_total"):
+			require.Equal(t, "counter", typ, name)
+		case name == timewheel.MetricFireLag || name == timewheel.MetricConsumerLag || name == timewheel.MetricTaskDuration:
+			require.Equal(t, "histogram", typ, name)
+		default:
+			require.Equal(t, "gauge", typ, name)
+		}
+	}
+}
+
+func TestSink_ObserveTag(t *testing.T) {
+	s := New(0.1)
+	s.Observe("duration_seconds", []float64{0.05, 1})
+	s.ObserveTag("duration_seconds", "b", []float64{1})
+	s.ObserveTag("duration_seconds", `a"`, []float64{0.05})
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, `# TYPE duration_seconds histogram
+duration_seconds_bucket{le="0.1"} 1
+duration_seconds_bucket{le="+Inf"} 2
+duration_seconds_sum 1.05
+duration_seconds_count 2
+duration_seconds_bucket{tag="a\"",le="0.1"} 1
+duration_seconds_bucket{tag="a\"",le="+Inf"} 1
+duration_seconds_sum{tag="a\""} 0.05
+duration_seconds_count{tag="a\""} 1
+duration_seconds_bucket{tag="b",le="0.1"} 0
+duration_seconds_bucket{tag="b",le="+Inf"} 1
+duration_seconds_sum{tag="b"} 1
+duration_seconds_count{tag="b"} 1
+`, rec.Body.String())
+}
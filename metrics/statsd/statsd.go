@@ -0,0 +1,137 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+// Package statsd implements a timewheel.MetricsSink that writes the metrics
+// in the statsd line protocol, with the optional dogstatsd tags.
+package statsd
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxPacketSize is the maximum size of a packet, it fits into the MTU of
+// the Ethernet without fragmentation.
+const maxPacketSize = 1432
+
+// Sink is a timewheel.MetricsSink that writes the metrics to a statsd server.
+//
+// The lines of a flush are batched into packets of at most 1432 bytes. The
+// errors of writes are ignored since the statsd is a lossy protocol.
+type Sink struct {
+	w      io.Writer
+	prefix string
+	tags   string
+
+	// The mu protects the buf.
+	mu  sync.Mutex
+	buf []byte
+}
+
+// Option is used to customize the Sink.
+type Option func(s *Sink)
+
+// WithPrefix sets the prefix prepended to the name of each metric, such as "myapp.".
+func WithPrefix(prefix string) Option {
+	return func(s *Sink) {
+		s.prefix = prefix
+	}
+}
+
+// WithTags appends the dogstatsd tags to each line, such as "env:prod".
+func WithTags(tags ...string) Option {
+	return func(s *Sink) {
+		if len(tags) != 0 {
+			s.tags = "|#" + strings.Join(tags, ",")
+		}
+	}
+}
+
+// New creates a Sink that writes each packet by a call of w.Write.
+func New(w io.Writer, opts ...Option) *Sink {
+	s := &Sink{w: w, buf: make([]byte, 0, maxPacketSize)}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Dial creates a Sink that sends the packets to the UDP address addr,
+// such as "127.0.0.1:8125".
+func Dial(addr string, opts ...Option) (*Sink, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return New(conn, opts...), nil
+}
+
+// Count implements the timewheel.MetricsSink.
+func (s *Sink) Count(name string, delta uint64) {
+	if delta == 0 {
+		return
+	}
+	s.mu.Lock()
+	s.line(name, strconv.FormatUint(delta, 10), "c")
+	s.flush()
+	s.mu.Unlock()
+}
+
+// Gauge implements the timewheel.MetricsSink.
+func (s *Sink) Gauge(name string, value float64) {
+	s.mu.Lock()
+	s.line(name, strconv.FormatFloat(value, 'f', -1, 64), "g")
+	s.flush()
+	s.mu.Unlock()
+}
+
+// Observe implements the timewheel.MetricsSink, each value is sent as a
+// histogram line, batched with the others.
+func (s *Sink) Observe(name string, values []float64) {
+	s.mu.Lock()
+	for _, v := range values {
+		s.line(name, strconv.FormatFloat(v, 'f', -1, 64), "h")
+	}
+	s.flush()
+	s.mu.Unlock()
+}
+
+// line appends a line to the buf, it writes the buf first if it has no room.
+func (s *Sink) line(name, value, typ string) {
+	n := len(s.prefix) + len(name) + 1 + len(value) + 1 + len(typ) + len(s.tags)
+	if len(s.buf) != 0 && len(s.buf)+1+n > maxPacketSize {
+		s.flush()
+	}
+	if len(s.buf) != 0 {
+		s.buf = append(s.buf, '\n')
+	}
+	s.buf = append(s.buf, s.prefix...)
+	s.buf = append(s.buf, name...)
+	s.buf = append(s.buf, ':')
+	s.buf = append(s.buf, value...)
+	s.buf = append(s.buf, '|')
+	s.buf = append(s.buf, typ...)
+	s.buf = append(s.buf, s.tags...)
+}
+
+// flush writes the buf as a packet.
+func (s *Sink) flush() {
+	if len(s.buf) == 0 {
+		return
+	}
+	_, _ = s.w.Write(s.buf)
+	s.buf = s.buf[:0]
+}
+
+// Close closes the underlying writer if it's an io.Closer.
+func (s *Sink) Close() error {
+	if c, ok := s.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package statsd
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/yu31/timewheel"
+)
+
+// packets records each write as a packet.
+type packets struct {
+	p []string
+}
+
+func (w *packets) Write(b []byte) (int, error) {
+	w.p = append(w.p, string(b))
+	return len(b), nil
+}
+
+func TestSink_Lines(t *testing.T) {
+	w := new(packets)
+	s := New(w, WithPrefix("app."), WithTags("env:test", "zone:a"))
+
+	s.Count("fired", 3)
+	s.Count("skipped", 0)
+	s.Gauge("pending", 2)
+	s.Observe("lag", []float64{0.5, 0.25})
+	s.Observe("empty", nil)
+
+	require.Equal(t, []string{
+		"app.fired:3|c|#env:test,zone:a",
+		"app.pending:2|g|#env:test,zone:a",
+		"app.lag:0.5|h|#env:test,zone:a\napp.lag:0.25|h|#env:test,zone:a",
+	}, w.p)
+}
+
+func TestSink_Packets(t *testing.T) {
+	w := new(packets)
+	s := New(w)
+
+	values := make([]float64, 1000)
+	for i := range values {
+		values[i] = 0.001
+	}
+	s.Observe("lag", values)
+
+	require.Greater(t, len(w.p), 1)
+	var lines int
+	for _, p := range w.p {
+		require.LessOrEqual(t, len(p), maxPacketSize)
+		lines += len(strings.Split(p, "\n"))
+	}
+	require.Equal(t, len(values), lines)
+}
+
+func TestSink_TimeWheel(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer pc.Close()
+
+	s, err := Dial(pc.LocalAddr().String())
+	require.NoError(t, err)
+	defer s.Close()
+
+	tw := timewheel.New(time.Millisecond, 8, timewheel.WithMetricsSink(s, time.Hour))
+	tw.Start()
+	<-tw.AfterFunc(time.Millisecond, func() {}).Done()
+	tw.Stop()
+
+	var received []string
+	buf := make([]byte, maxPacketSize)
+	require.NoError(t, pc.SetReadDeadline(time.Now().Add(time.Second)))
+	for {
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		received = append(received, strings.Split(string(buf[:n]), "\n")...)
+		require.NoError(t, pc.SetReadDeadline(time.Now().Add(time.Millisecond*100)))
+	}
+	require.Contains(t, received, timewheel.MetricFired+":1|c")
+	require.Contains(t, received, timewheel.MetricPending+":0|g")
+}
@@ -0,0 +1,161 @@
+package timewheel
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// sinkRecorder is a MetricsSink that records the reported metrics.
+type sinkRecorder struct {
+	mu       sync.Mutex
+	counters map[string]uint64
+	gauges   map[string]float64
+	observed map[string][]float64
+	flushes  int
+}
+
+func newSinkRecorder() *sinkRecorder {
+	return &sinkRecorder{
+		counters: make(map[string]uint64),
+		gauges:   make(map[string]float64),
+		observed: make(map[string][]float64),
+	}
+}
+
+func (r *sinkRecorder) Count(name string, delta uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counters[name] += delta
+}
+
+func (r *sinkRecorder) Gauge(name string, value float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.gauges[name] = value
+	if name == MetricPending {
+		r.flushes++
+	}
+}
+
+func (r *sinkRecorder) Observe(name string, values []float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.observed[name] = append(r.observed[name], values...)
+}
+
+func (r *sinkRecorder) counter(name string) uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.counters[name]
+}
+
+func TestWithMetricsSink(t *testing.T) {
+	sink := newSinkRecorder()
+	tw := New(time.Millisecond, 8, WithMetricsSink(sink, time.Millisecond*10))
+	tw.Start()
+
+	for i := 0; i < 5; i++ {
+		<-tw.AfterFunc(time.Millisecond, func() {}).Done()
+	}
+	timer := tw.AfterFunc(time.Hour, func() {})
+	timer.Close()
+	tw.AfterFunc(time.Hour, func() {})
+
+	// Reported periodically while running.
+	require.Eventually(t, func() bool { return sink.counter(MetricFired) == 5 }, time.Second, time.Millisecond)
+	tw.Stop()
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	require.Greater(t, sink.flushes, 1)
+	require.Equal(t, uint64(7), sink.counters[MetricScheduled])
+	require.Equal(t, uint64(5), sink.counters[MetricFired])
+	require.Equal(t, uint64(1), sink.counters[MetricCancelled])
+	require.Equal(t, float64(1), sink.gauges[MetricPending])
+	require.Len(t, sink.observed[MetricFireLag], 5)
+	for _, lag := range sink.observed[MetricFireLag] {
+		require.GreaterOrEqual(t, lag, float64(0))
+		require.Less(t, lag, float64(1))
+	}
+	require.GreaterOrEqual(t, len(sink.observed[MetricConsumerLag]), 5)
+	for _, lag := range sink.observed[MetricConsumerLag] {
+		require.GreaterOrEqual(t, lag, float64(0))
+		require.Less(t, lag, float64(1))
+	}
+	require.Equal(t, float64(0), sink.gauges[MetricQueueDepth])
+	require.Contains(t, sink.gauges, MetricConsumerLagMax)
+	require.Less(t, sink.gauges[MetricConsumerLagMax], float64(1))
+	// Reported only with a QueuedExecutor.
+	require.NotContains(t, sink.gauges, MetricExecutorQueue)
+}
+
+func TestWithMetricsSink_EarlyFire(t *testing.T) {
+	clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	sink := newSinkRecorder()
+	tw := New(time.Millisecond, 8, WithMetricsSink(sink, time.Hour), WithClock(clock), WithDispatchPolicy(DispatchInline))
+	tw.Start()
+
+	// Fired at the tick that its expiration is truncated to.
+	timer := tw.AfterFunc(time.Microsecond*1500, func() {})
+	clock.add(time.Millisecond)
+	for n, _ := tw.Poll(); n != 0; n, _ = tw.Poll() {
+	}
+	<-timer.Done()
+	tw.Stop()
+
+	require.Equal(t, []float64{0}, sink.observed[MetricFireLag])
+}
+
+func TestWithMetricsSink_FinalFlush(t *testing.T) {
+	sink := newSinkRecorder()
+	tw := New(time.Millisecond, 8, WithMetricsSink(sink, time.Hour))
+	tw.Start()
+	<-tw.AfterFunc(time.Millisecond, func() {}).Done()
+	require.Equal(t, uint64(0), sink.counter(MetricFired))
+
+	tw.Stop()
+	require.Equal(t, uint64(1), sink.counter(MetricFired))
+	require.Len(t, sink.observed[MetricFireLag], 1)
+}
+
+func TestWithMetricsSink_NotStarted(t *testing.T) {
+	sink := newSinkRecorder()
+	tw := New(time.Millisecond, 8, WithMetricsSink(sink, time.Millisecond))
+	time.Sleep(time.Millisecond * 5)
+	// Stop without started must not wait for the flush.
+	tw.Stop()
+	require.Equal(t, 0, sink.flushes)
+}
+
+func TestWithMetricsSink_Invalid(t *testing.T) {
+	require.Panics(t, func() { WithMetricsSink(newSinkRecorder(), 0) })
+}
+
+func TestMetrics_LagBuffer(t *testing.T) {
+	m := newMetrics(newSinkRecorder(), time.Hour)
+	for i := 0; i < maxLagBuffer+10; i++ {
+		m.observeLag(time.Millisecond)
+	}
+	require.Len(t, m.fireLags.values, maxLagBuffer)
+
+	// The buffers are swapped without allocation.
+	values := m.fireLags.swap()
+	require.Len(t, values, maxLagBuffer)
+	m.observeLag(time.Second)
+	require.Equal(t, []float64{1}, m.fireLags.swap())
+	require.Len(t, m.fireLags.swap(), 0)
+}
+
+func TestMetricNames(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, name := range MetricNames() {
+		require.True(t, strings.HasPrefix(name, "timewheel_"), name)
+		require.False(t, seen[name], name)
+		seen[name] = true
+	}
+	require.True(t, seen[MetricTaskDuration])
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// Observer is notified of the lifecycle events of the timers, see WithObserver.
+//
+// The methods are called synchronously in the goroutine that caused the event,
+// so they must return quickly and must not block. They may be called
+// concurrently for different timers.
+type Observer interface {
+	// OnSchedule is called each time a timer is armed, i.e. once for a run-once
+	// timer, and once for each execution planned of a recurring timer. It's not
+	// called for a timer that is rejected.
+	OnSchedule(t *Timer)
+	// OnFire is called each time the task of a timer is dispatched, before the
+	// task is executed.
+	OnFire(t *Timer)
+	// OnCancel is called when a timer is closed before its task dispatched.
+	OnCancel(t *Timer)
+}
+
+// WithObserver registers o to be notified of the lifecycle events of the
+// timers. It can be set more than once, the observers are notified in the
+// order they're registered.
+func WithObserver(obs Observer) Option {
+	return func(o *options) {
+		o.observers = append(o.observers, obs)
+	}
+}
+
+// WithObserverSampling makes the OnSchedule and OnFire of the observers sampled
+// at the rate in [0, 1], e.g. 0.01 notifies about 1% of the events. The metrics
+// are still exact, e.g. the Stats, the MetricsSink, the LeakReport and the
+// watchers see all of the events, and the OnCancel is never sampled since it's
+// rare.
+//
+// The decision is a hash of the ID and the expiration of the timer, with no
+// shared state to contend for; thus the OnSchedule and the OnFire of the same
+// execution are sampled together. The rate can be changed at runtime by
+// SetObserverSampling. Default is 1, it panics if the rate is out of range.
+func WithObserverSampling(rate float64) Option {
+	below := sampleBelow(rate)
+	return func(o *options) {
+		o.sampleBelow = below
+	}
+}
+
+// SetObserverSampling changes the rate of WithObserverSampling at runtime, it
+// takes effect on the events since then. It panics if the rate is not in [0, 1].
+func (tw *TimeWheel) SetObserverSampling(rate float64) {
+	atomic.StoreUint64(&tw.root.sampler.below, sampleBelow(rate))
+}
+
+// sampler decides the events sampled by WithObserverSampling.
+type sampler struct {
+	// The hashes below it are sampled, all the events are sampled if it's the
+	// maximum uint64. It's accessed atomically.
+	below uint64
+}
+
+// sampleBelow returns the threshold of the hashes sampled at the rate.
+func sampleBelow(rate float64) uint64 {
+	if !(rate >= 0 && rate <= 1) {
+		panic("timewheel: observer sampling rate must be in [0, 1]")
+	}
+	if rate == 1 {
+		return math.MaxUint64
+	}
+	return uint64(rate * (1 << 64))
+}
+
+// sampled reports whether the events of the current execution of t are sampled.
+func (s *sampler) sampled(t *Timer) bool {
+	below := atomic.LoadUint64(&s.below)
+	if below == math.MaxUint64 {
+		return true
+	}
+	// The finalizer of the splitmix64.
+	h := t.ID() ^ uint64(t.getExpiration())*0x9e3779b97f4a7c15
+	h = (h ^ h>>30) * 0xbf58476d1ce4e5b9
+	h = (h ^ h>>27) * 0x94d049bb133111eb
+	return h^h>>31 < below
+}
+
+// observeSchedule notifies the observers and the watchers that t has been armed.
+func (tw *TimeWheel) observeSchedule(t *Timer) {
+	if t.getAttrs().watchdog {
+		return
+	}
+	if l := tw.root.leaks; l != nil {
+		l.schedule(t)
+	}
+	if obs := tw.root.opts.observers; len(obs) != 0 && tw.root.sampler.sampled(t) {
+		for _, o := range obs {
+			o.OnSchedule(t)
+		}
+	}
+	tw.notifyWatchers(EventScheduled, t)
+}
+
+// observeFire notifies the observers and the watchers that the task of t is
+// dispatched.
+func (tw *TimeWheel) observeFire(t *Timer) {
+	if t.getAttrs().watchdog {
+		return
+	}
+	if l := tw.root.leaks; l != nil {
+		l.fire(t)
+	}
+	if obs := tw.root.opts.observers; len(obs) != 0 && tw.root.sampler.sampled(t) {
+		for _, o := range obs {
+			o.OnFire(t)
+		}
+	}
+	tw.notifyWatchers(EventFired, t)
+}
+
+// observeCancel notifies the observers and the watchers that t has been closed.
+func (tw *TimeWheel) observeCancel(t *Timer) {
+	if t.getAttrs().watchdog {
+		return
+	}
+	if l := tw.root.leaks; l != nil {
+		l.cancel(t)
+	}
+	for _, o := range tw.root.opts.observers {
+		o.OnCancel(t)
+	}
+	tw.notifyWatchers(EventCancelled, t)
+}
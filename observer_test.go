@@ -0,0 +1,133 @@
+package timewheel
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// eventObserver records the events as "<kind>:<tag>".
+type eventObserver struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (o *eventObserver) record(kind string, t *Timer) {
+	o.mu.Lock()
+	o.events = append(o.events, kind+":"+t.Tag())
+	o.mu.Unlock()
+}
+
+func (o *eventObserver) OnSchedule(t *Timer) { o.record("schedule", t) }
+func (o *eventObserver) OnFire(t *Timer)     { o.record("fire", t) }
+func (o *eventObserver) OnCancel(t *Timer)   { o.record("cancel", t) }
+
+func (o *eventObserver) get() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]string(nil), o.events...)
+}
+
+func TestWithObserver(t *testing.T) {
+	o1, o2 := new(eventObserver), new(eventObserver)
+	tw := New(time.Millisecond, 8, WithObserver(o1), WithObserver(o2), WithDispatchPolicy(DispatchInline))
+	tw.Start()
+	defer tw.Stop()
+
+	<-tw.AfterFunc(time.Millisecond, func() {}, WithTag("once")).Done()
+	timer := tw.AfterFunc(time.Hour, func() {}, WithTag("closed"))
+	timer.Close()
+	recurring, err := tw.Every(time.Millisecond).Times(2).Do(func() {}, WithTag("every"))
+	require.NoError(t, err)
+	<-recurring.Done()
+
+	expected := []string{
+		"schedule:once", "fire:once",
+		"schedule:closed", "cancel:closed",
+		"schedule:every", "fire:every", "schedule:every", "fire:every",
+	}
+	require.Equal(t, expected, o1.get())
+	require.Equal(t, expected, o2.get())
+}
+
+func TestWithObserver_Rejected(t *testing.T) {
+	o := new(eventObserver)
+	tw := New(time.Millisecond, 8, WithObserver(o), WithMaxPending(1))
+	defer tw.Stop()
+
+	tw.AfterFunc(time.Hour, func() {}, WithTag("a"))
+	tw.AfterFunc(time.Hour, func() {}, WithTag("b"))
+	require.Equal(t, []string{"schedule:a"}, o.get())
+}
+
+func TestWithObserverSampling(t *testing.T) {
+	require.Panics(t, func() { WithObserverSampling(-0.1) })
+	require.Panics(t, func() { WithObserverSampling(1.1) })
+	require.Panics(t, func() { WithObserverSampling(math.NaN()) })
+
+	clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	o := new(eventObserver)
+	tw := New(time.Millisecond, 8, WithObserver(o), WithObserverSampling(0.1), WithClock(clock), WithDispatchPolicy(DispatchInline))
+	tw.Start()
+	defer tw.Stop()
+
+	const n = 10000
+	for i := 0; i < n; i++ {
+		tw.AfterFunc(time.Millisecond*time.Duration(i%50+1), func() {})
+	}
+	clock.add(time.Millisecond * 50)
+	tw.Poll()
+
+	// The counters are exact, and the schedule and the fire of a timer are
+	// sampled together.
+	stats := tw.Stats()
+	require.Equal(t, uint64(n), stats.Scheduled)
+	require.Equal(t, uint64(n), stats.Fired)
+	var scheduled, fired int
+	for _, e := range o.get() {
+		if e == "schedule:" {
+			scheduled++
+		} else {
+			fired++
+		}
+	}
+	require.Equal(t, scheduled, fired)
+	require.True(t, fired > n/20 && fired < n/5, fired)
+
+	// Changed at runtime.
+	events := len(o.get())
+	tw.SetObserverSampling(0)
+	<-tw.AfterFunc(0, func() {}).Done()
+	require.Len(t, o.get(), events)
+	tw.SetObserverSampling(1)
+	timer := tw.AfterFunc(time.Hour, func() {})
+	timer.Close()
+	require.Equal(t, []string{"schedule:", "cancel:"}, o.get()[events:])
+	require.Panics(t, func() { tw.SetObserverSampling(2) })
+}
+
+func TestWithObserverSampling_Metrics(t *testing.T) {
+	clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	o := new(eventObserver)
+	sink := newSinkRecorder()
+	tw := New(time.Millisecond, 8, WithObserver(o), WithObserverSampling(0), WithMetricsSink(sink, time.Hour), WithClock(clock), WithDispatchPolicy(DispatchInline))
+	tw.Start()
+
+	const n = 100
+	for i := 0; i < n; i++ {
+		tw.AfterFunc(time.Millisecond*time.Duration(i%50+1), func() {})
+	}
+	clock.add(time.Millisecond * 50)
+	for n, _ := tw.Poll(); n != 0; n, _ = tw.Poll() {
+	}
+	tw.Stop()
+
+	// The observers see none of the events, but the fire lags are observed
+	// on every fire.
+	require.Empty(t, o.get())
+	require.Equal(t, uint64(n), sink.counter(MetricFired))
+	require.Len(t, sink.observed[MetricFireLag], n)
+}
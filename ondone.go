@@ -0,0 +1,105 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"context"
+	"sync"
+)
+
+// OnDone arranges to call f once the ctx is done, like the context.AfterFunc,
+// with the cause of ctx.Err(). If the ctx has a deadline, it's armed as a timer
+// of tw, thus f is called at the deadline by tw with its DispatchPolicy, even
+// if the ctx itself is slow to notice its deadline, e.g. a Context implemented
+// outside the context package; the cause is context.DeadlineExceeded then.
+//
+// The early cancellation is watched by the context.AfterFunc, which costs no
+// goroutine for the contexts of the context package: all of them are watched
+// by the goroutines that cancel them. The f is called exactly once, whichever
+// comes first, and the other one is released.
+//
+// The stop releases both, it returns true if it prevents f from being called,
+// and false if f has been called or the stop has been called before.
+func OnDone(ctx context.Context, tw *TimeWheel, f func(cause error)) (stop func() bool) {
+	w := &doneWatch{ctx: ctx, f: f}
+	var timer *Timer
+	if deadline, ok := ctx.Deadline(); ok && ctx.Err() == nil {
+		timer = tw.expireFunc(context.Background(), deadline.UnixNano(), w.expire, nil)
+	}
+	stopCtx := context.AfterFunc(ctx, w.cancel)
+
+	w.mu.Lock()
+	w.timer, w.stopCtx = timer, stopCtx
+	ended := w.ended
+	w.mu.Unlock()
+	if ended {
+		// Ended before both are armed, e.g. the timer expired inline.
+		if timer != nil {
+			timer.Close()
+		}
+		stopCtx()
+	}
+	return w.stop
+}
+
+// doneWatch is a registration of OnDone.
+type doneWatch struct {
+	ctx context.Context
+	f   func(cause error)
+
+	mu      sync.Mutex
+	ended   bool
+	timer   *Timer
+	stopCtx func() bool
+}
+
+// expire is the task of the timer of the deadline.
+func (w *doneWatch) expire(context.Context, *Timer) {
+	cause := w.ctx.Err()
+	if cause == nil {
+		// The timer fires up to a tick early, see TimeWheel.
+		cause = context.DeadlineExceeded
+	}
+	w.done(cause, false)
+}
+
+// cancel is called by the context.AfterFunc once the ctx is done.
+func (w *doneWatch) cancel() {
+	w.done(w.ctx.Err(), true)
+}
+
+// done calls f with the cause unless it's ended, byCtx is whether it's called
+// by the watch of the ctx rather than the timer.
+func (w *doneWatch) done(cause error, byCtx bool) {
+	if w.end(!byCtx, byCtx) {
+		w.f(cause)
+	}
+}
+
+func (w *doneWatch) stop() bool {
+	return w.end(true, true)
+}
+
+// end marks w as ended and releases the timer and the watch of the ctx if
+// told so, it returns false if w has already been ended.
+func (w *doneWatch) end(stopCtx, closeTimer bool) bool {
+	w.mu.Lock()
+	if w.ended {
+		w.mu.Unlock()
+		return false
+	}
+	w.ended = true
+	timer, stopFn := w.timer, w.stopCtx
+	w.mu.Unlock()
+
+	if closeTimer && timer != nil {
+		timer.Close()
+	}
+	if stopCtx && stopFn != nil {
+		stopFn()
+	}
+	return true
+}
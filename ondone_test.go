@@ -0,0 +1,188 @@
+package timewheel
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// slowDeadlineCtx is a Context whose deadline is never noticed by itself.
+type slowDeadlineCtx struct {
+	context.Context
+	deadline time.Time
+}
+
+func (c slowDeadlineCtx) Deadline() (time.Time, bool) {
+	return c.deadline, true
+}
+
+func TestOnDone_Deadline(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	causeC := make(chan error, 2)
+	OnDone(slowDeadlineCtx{Context: ctx, deadline: time.Now().Add(time.Millisecond * 20)}, tw, func(cause error) { causeC <- cause })
+
+	require.True(t, errors.Is(<-causeC, context.DeadlineExceeded))
+	// The watch of the ctx is released.
+	cancel()
+	time.Sleep(time.Millisecond * 10)
+	require.Len(t, causeC, 0)
+	require.Equal(t, int64(0), tw.Pending())
+}
+
+func TestOnDone_Cancel(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	causeC := make(chan error, 2)
+	OnDone(ctx, tw, func(cause error) { causeC <- cause })
+	require.Equal(t, int64(1), tw.Pending())
+
+	cancel()
+	require.True(t, errors.Is(<-causeC, context.Canceled))
+	// The timer of the deadline is released.
+	require.Eventually(t, func() bool { return tw.Pending() == 0 }, time.Second, time.Millisecond)
+	require.Len(t, causeC, 0)
+}
+
+func TestOnDone_Done(t *testing.T) {
+	tw := New(time.Millisecond, 8, WithDispatchPolicy(DispatchInline))
+	tw.Start()
+	defer tw.Stop()
+
+	// Both the expired deadline and the done ctx call f, only once.
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	var called int32
+	causeC := make(chan error, 2)
+	stop := OnDone(slowDeadlineCtx{Context: ctx, deadline: time.Now().Add(-time.Second)}, tw, func(cause error) {
+		atomic.AddInt32(&called, 1)
+		causeC <- cause
+	})
+	require.True(t, errors.Is(<-causeC, context.DeadlineExceeded))
+	require.False(t, stop())
+	time.Sleep(time.Millisecond * 10)
+	require.Equal(t, int32(1), atomic.LoadInt32(&called))
+}
+
+func TestOnDone_Stop(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	called := make(chan struct{}, 1)
+	stop := OnDone(ctx, tw, func(error) { called <- struct{}{} })
+	require.True(t, stop())
+	require.False(t, stop())
+	require.Equal(t, int64(0), tw.Pending())
+
+	<-ctx.Done()
+	time.Sleep(time.Millisecond * 10)
+	require.Len(t, called, 0)
+}
+
+// benchmarkOnDone registers b.N contexts that are all live until the end.
+func benchmarkOnDone(b *testing.B, register func(ctx context.Context) (stop func() bool)) {
+	parent, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stops := make([]func() bool, b.N)
+	cancels := make([]context.CancelFunc, b.N)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := range stops {
+		ctx, cancel := context.WithCancel(parent)
+		cancels[i] = cancel
+		stops[i] = register(slowDeadlineCtx{Context: ctx, deadline: time.Now().Add(time.Hour)})
+	}
+	b.StopTimer()
+	for i, stop := range stops {
+		stop()
+		cancels[i]()
+	}
+}
+
+// liveContexts is the number of contexts held by benchmarkOnDoneLive.
+const liveContexts = 1 << 20
+
+// benchmarkOnDoneLive holds liveContexts contexts at once, it reports the
+// memory retained by each of them, and measures the registration and the
+// stop of another one among them.
+func benchmarkOnDoneLive(b *testing.B, register func(ctx context.Context) (stop func() bool)) {
+	parent, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	deadline := time.Now().Add(time.Hour)
+	stops := make([]func() bool, liveContexts)
+	cancels := make([]context.CancelFunc, liveContexts)
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	for i := range stops {
+		ctx, cancel := context.WithCancel(parent)
+		cancels[i] = cancel
+		stops[i] = register(slowDeadlineCtx{Context: ctx, deadline: deadline})
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ctx, cancel := context.WithCancel(parent)
+		register(slowDeadlineCtx{Context: ctx, deadline: deadline})()
+		cancel()
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc)/liveContexts, "B/live")
+	for i, stop := range stops {
+		stop()
+		cancels[i]()
+	}
+}
+
+func BenchmarkOnDone(b *testing.B) {
+	tw := New(time.Millisecond, 512)
+	tw.Start()
+	defer tw.Stop()
+	benchmarkOnDone(b, func(ctx context.Context) func() bool {
+		return OnDone(ctx, tw, func(error) {})
+	})
+}
+
+func BenchmarkOnDone_Live(b *testing.B) {
+	tw := New(time.Millisecond, 512)
+	tw.Start()
+	defer tw.Stop()
+	benchmarkOnDoneLive(b, func(ctx context.Context) func() bool {
+		return OnDone(ctx, tw, func(error) {})
+	})
+}
+
+// stdlibOnDone arms a runtime timer per context for its deadline, like the
+// context.WithDeadline.
+func stdlibOnDone(ctx context.Context) func() bool {
+	deadline, _ := ctx.Deadline()
+	timer := time.AfterFunc(time.Until(deadline), func() {})
+	stop := context.AfterFunc(ctx, func() {})
+	return func() bool { return timer.Stop() && stop() }
+}
+
+func BenchmarkOnDone_Stdlib(b *testing.B) {
+	benchmarkOnDone(b, stdlibOnDone)
+}
+
+func BenchmarkOnDone_StdlibLive(b *testing.B) {
+	benchmarkOnDoneLive(b, stdlibOnDone)
+}
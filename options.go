@@ -0,0 +1,560 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/yu31/dqueue"
+)
+
+// Option is used to customize the TimeWheel created by New.
+type Option func(o *options)
+
+// options holds the optional configuration of a TimeWheel.
+// It is owned by the root TimeWheel and shared with all overflow wheels.
+type options struct {
+	name   string
+	logger *slog.Logger
+
+	onIdle      func()
+	onActive    func()
+	quietPeriod time.Duration
+
+	idleShutdown time.Duration
+
+	onError func(t *Timer, err error)
+
+	onDeadLetter     func(t TimerInfo, reason error)
+	deadLetterCap    int
+	deadLetterPanics bool
+
+	store Store
+
+	watchdog   time.Duration
+	onWatchdog func(t TimerInfo, stack []byte)
+
+	durationTags   int
+	durationBounds []time.Duration
+
+	clock            Clock
+	queue            *dqueue.DQueue
+	onForeignMessage func(msg *dqueue.Message)
+
+	expired       bool
+	expiredCap    int
+	expiredPolicy DeliveryPolicy
+
+	dispatchPolicy DispatchPolicy
+	batchSize      int
+	executor       Executor
+	onDrop         func(t *Timer, err error)
+	onExpireBatch  func(timers []*Timer)
+	pastPolicy     PastPolicy
+
+	baseCtx context.Context
+
+	taskTimeout time.Duration
+	onOverrun   func(t *Timer)
+	onPanic     func(t *Timer, value interface{})
+
+	dumpLimit    int
+	leakTracking bool
+
+	metricsSink     MetricsSink
+	metricsInterval time.Duration
+
+	registry *TaskRegistry
+
+	fireGuard FireGuard
+
+	observers   []Observer
+	sampleBelow uint64
+
+	fair        bool
+	fairWeights map[string]int
+
+	deadlineOrder bool
+
+	maxPending   int64
+	shedLimit    int64
+	shedPriority Priority
+	rateLimit    float64
+	rateBurst    int
+	onReject     func(t *Timer, err error)
+
+	align time.Duration
+
+	capacity int
+
+	maxLevels int
+	hashed    bool
+
+	delayClamp bool
+
+	criticalPlane bool
+
+	dedicatedThread bool
+	threadInit      func()
+
+	highWatermark   int64
+	onHighWatermark func(pending int64)
+	lowWatermark    int64
+	onLowWatermark  func(pending int64)
+}
+
+// defaultMaxLevels is the default maximum number of levels of a TimeWheel.
+// The 16 levels of 1ms tick span 50 days even with the size of 4.
+const defaultMaxLevels = 16
+
+// defaultDumpLimit is the default maximum number of timers listed per bucket by Dump.
+const defaultDumpLimit = 100
+
+// DispatchPolicy decides how the tasks of expired timers are executed.
+type DispatchPolicy int
+
+const (
+	// DispatchGoroutine executes each task in its own goroutine, like the
+	// standard time.AfterFunc. It's the default policy.
+	DispatchGoroutine DispatchPolicy = iota
+	// DispatchInline executes the tasks one by one in the consumer goroutine
+	// of the TimeWheel. It avoids the cost of goroutine creation for cheap
+	// tasks, but a slow task delays the expiration of subsequent timers.
+	//
+	// The task is never executed while holding any internal lock, thus it's
+	// safe to schedule or close timers in the task. A timer that is already
+	// expired when scheduled by a task is executed after the current task
+	// returns rather than recursively.
+	//
+	// A task that panics is recovered and logged (see OnPanic) individually,
+	// so that the rest of the expired timers are still executed.
+	DispatchInline
+	// DispatchBatch slices the tasks dispatched by the consumer goroutine into
+	// chunks, and executes each chunk in its own goroutine one by one in the
+	// order they expired. It cuts the cost of creating a goroutine per task for
+	// many cheap tasks that expire together, see WithBatchDispatch.
+	//
+	// A task that panics is recovered and logged (see OnPanic), so that the
+	// rest of its chunk is still executed. The tasks dispatched outside of the consumer goroutine
+	// (e.g. the executions queued by OverlapQueue) are executed like DispatchGoroutine.
+	DispatchBatch
+)
+
+// defaultBatchSize is the default number of tasks per chunk of the DispatchBatch.
+const defaultBatchSize = 64
+
+// DeliveryPolicy decides what to do when the channel returned by Expired is full.
+type DeliveryPolicy int
+
+const (
+	// DeliveryBlock blocks the delivery until the channel has room or the
+	// TimeWheel is stopped. It delays the expiration of all subsequent timers
+	// while blocking, but no timer is lost.
+	DeliveryBlock DeliveryPolicy = iota
+	// DeliveryDrop drops the expired timer if the channel is full, so that
+	// a slow receiver never delays the TimeWheel. The timer is dropped like
+	// a task refused by the Executor with the ErrChannelFull, see OnDrop.
+	DeliveryDrop
+)
+
+// PastPolicy decides what to do with the restored timers whose expiration has
+// passed, see WithPastPolicy.
+type PastPolicy int
+
+const (
+	// PastFire executes the timers immediately. It's the default policy.
+	PastFire PastPolicy = iota
+	// PastSkip skips the timers, they're neither scheduled nor counted.
+	PastSkip
+	// PastReject stops the restoring at the first of the timers, with a
+	// *ScheduleError that wraps the ErrExpired.
+	PastReject
+)
+
+// WithPastPolicy sets the PastPolicy of the timers restored by RestoreFrom
+// and ImportJSON whose expiration has passed, default is PastFire. The ones
+// saved in flight are always fired again, see SaveTo.
+func WithPastPolicy(p PastPolicy) Option {
+	return func(o *options) {
+		o.pastPolicy = p
+	}
+}
+
+// WithName sets the name of the TimeWheel, it's used to identify the
+// TimeWheel in diagnostics such as String.
+func WithName(name string) Option {
+	return func(o *options) {
+		o.name = name
+	}
+}
+
+// WithLogger makes the TimeWheel emit structured records of the notable
+// internal events to l, such as a task panicked or exceeded the timeout, a
+// timer dropped at shutdown. Each record carries the name of the TimeWheel,
+// and the ID and tag of the timer if any. No record is emitted for the
+// regular expiration of timers. Default is no logging at all.
+func WithLogger(l *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+// OnIdle registers f to be called each time the number of pending timers
+// drops from 1 to 0, e.g. to release the resources that only needed while
+// there is any pending timer. It may be debounced by WithQuietPeriod.
+//
+// The f is called synchronously in the goroutine that caused the transition
+// (the consumer goroutine if a timer expired, or the caller of Timer.Close),
+// so it must return quickly and must not block. The OnIdle and OnActive are
+// called in turn even if the transitions race with each other, a transition
+// that is reverted before notified is not notified at all.
+func OnIdle(f func()) Option {
+	return func(o *options) {
+		o.onIdle = f
+	}
+}
+
+// OnActive registers f to be called each time the number of pending timers
+// rises from 0 to 1.
+//
+// The f is called synchronously in the goroutine that caused the transition
+// (i.e. the caller of the scheduling func), so it must return quickly and
+// must not block. See OnIdle for the order of the calls.
+func OnActive(f func()) Option {
+	return func(o *options) {
+		o.onActive = f
+	}
+}
+
+// WithQueue makes the TimeWheel use dq as its delay queue instead of creating
+// a private one, so that the dq can be shared with other delayed messages.
+//
+// The TimeWheel takes over the consumer of dq: Start calls dq.Consume and Stop
+// calls dq.Close, thus the caller must not consume or close the dq by itself.
+// Messages that not enqueued by the TimeWheel are passed to the handler that
+// registered by WithForeignMessageHandler, or dropped if no handler registered.
+func WithQueue(dq *dqueue.DQueue) Option {
+	return func(o *options) {
+		o.queue = dq
+	}
+}
+
+// WithForeignMessageHandler registers f to handle the messages in a shared
+// queue (see WithQueue) that not enqueued by the TimeWheel.
+//
+// The f is called in the consumer goroutine of the TimeWheel, so it must
+// return quickly to avoid delaying the expiration of timers.
+func WithForeignMessageHandler(f func(msg *dqueue.Message)) Option {
+	return func(o *options) {
+		o.onForeignMessage = f
+	}
+}
+
+// WithExpiredChannel enables the channel-based delivery, the timers created
+// by After and At are sent to the channel returned by Expired when they expire,
+// instead of running a task.
+//
+// The capacity is the buffer size of the channel, and the policy decides what
+// to do when the channel is full.
+func WithExpiredChannel(capacity int, policy DeliveryPolicy) Option {
+	if capacity < 0 {
+		panic("timewheel: capacity of expired channel must be greater than or equal to 0")
+	}
+	return func(o *options) {
+		o.expired = true
+		o.expiredCap = capacity
+		o.expiredPolicy = policy
+	}
+}
+
+// WithDispatchPolicy sets the DispatchPolicy of the tasks, default is DispatchGoroutine.
+func WithDispatchPolicy(p DispatchPolicy) Option {
+	return func(o *options) {
+		o.dispatchPolicy = p
+	}
+}
+
+// Executor executes the tasks of the expired timers on behalf of the TimeWheel,
+// e.g. by posting them onto the run queue of an event loop, see WithExecutor.
+type Executor interface {
+	// Execute arranges f to be called, it returns an error if f is refused,
+	// e.g. the run queue is full. It must not block for long, since it's
+	// usually called by the consumer goroutine.
+	Execute(f func()) error
+}
+
+// QueuedExecutor is an Executor with a run queue, its occupancy is reported by
+// the Stats and the metrics, e.g. the WorkerPool.
+type QueuedExecutor interface {
+	Executor
+	// QueueLen returns the number of the tasks in the run queue.
+	QueueLen() int
+	// QueueHighWater returns the maximum QueueLen so far.
+	QueueHighWater() int
+}
+
+// WithExecutor makes the TimeWheel hand the tasks of the expired timers to e
+// instead of executing them by the DispatchPolicy, including the timers that
+// are already expired when scheduled. The task refused by e is dropped: it's
+// counted by Stats.Dropped and passed to the handler registered by OnDrop,
+// and the run-once timer is finished with EndDropped.
+//
+// The tasks are never recovered from panic by the TimeWheel, the e is in
+// charge of that. The WithTaskTimeout still applies to the context of tasks.
+func WithExecutor(e Executor) Option {
+	return func(o *options) {
+		o.executor = e
+	}
+}
+
+// OnDrop registers f to be called when the task of the expired timer t is
+// dropped, with the reason err, e.g. the error returned by the Executor, or the
+// ErrChannelFull of the DeliveryDrop.
+//
+// The f is called synchronously in the goroutine that dispatched the task,
+// so it must return quickly and must not block.
+func OnDrop(f func(t *Timer, err error)) Option {
+	return func(o *options) {
+		o.onDrop = f
+	}
+}
+
+// OnExpireBatch hands the expired timers over to f in batches instead of
+// executing their tasks, e.g. for a dispatcher that processes them with a
+// single lock or a vectorized call. The f is called once per bucket flush in
+// the consumer goroutine with all the timers expired by it, in the order they
+// would be executed, see WithFairness; or in the caller's goroutine with a
+// single timer if it has expired when scheduled.
+//
+// The timers are owned by f, the TimeWheel does no further dispatch: neither
+// their tasks nor the DispatchPolicy and the Executor apply. Each timer is
+// counted and observed as fired, and its next execution of a recurring timer
+// is planned before the handoff, see Schedule; the run-once timers and the
+// last executions are finished when handed over. The At and After are
+// handed over too, the WithExpiredChannel is not required for them then.
+//
+// The f must not block, since the expirations of the other buckets are
+// delayed by it.
+func OnExpireBatch(f func(timers []*Timer)) Option {
+	return func(o *options) {
+		o.onExpireBatch = f
+	}
+}
+
+// WithBatchDispatch sets the DispatchPolicy to DispatchBatch, with at most
+// size tasks per chunk. Default size is 64 if DispatchBatch is set by
+// WithDispatchPolicy. NOTICE: the tasks of a chunk are delayed by the tasks
+// before them, thus the size should be small if the task may be slow.
+func WithBatchDispatch(size int) Option {
+	if size < 1 {
+		panic("timewheel: size of batch must be greater than 0")
+	}
+	return func(o *options) {
+		o.dispatchPolicy = DispatchBatch
+		o.batchSize = size
+	}
+}
+
+// WithBaseContext sets the base of the contexts passed to the tasks, such as
+// the ones scheduled by AfterFuncContext. The base is cancelled with the cause
+// ErrStopped when the TimeWheel is stopped, so that the long-running tasks can
+// exit promptly. The context of a task scheduled with its own context is
+// cancelled once either of them is done, but its values are looked up from
+// its own context only. The tasks of func() are unaffected.
+func WithBaseContext(ctx context.Context) Option {
+	return func(o *options) {
+		o.baseCtx = ctx
+	}
+}
+
+// WithTaskTimeout sets the maximum execution time of each task.
+//
+// The task created by AfterFuncContext receives a context that is cancelled
+// after d elapsed. Since a goroutine can't be killed, the task that exceeded
+// the timeout is only reported to the handler registered by OnOverrun, and
+// the consumer goroutine stops waiting for it if the DispatchInline is set.
+// NOTICE: the DispatchInline executes each task in a new goroutine if the
+// task timeout is set, so that the consumer goroutine can leave it behind.
+func WithTaskTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.taskTimeout = d
+	}
+}
+
+// OnOverrun registers f to be called when a task exceeded the timeout that
+// set by WithTaskTimeout. The f is called once per overrun execution while
+// the task is still running, in a goroutine other than the task's.
+func OnOverrun(f func(t *Timer)) Option {
+	return func(o *options) {
+		o.onOverrun = f
+	}
+}
+
+// OnPanic registers f to be called when a task panicked and recovered, with
+// the timer of the task and the value passed to panic. Only the tasks
+// executed by DispatchInline and DispatchBatch are recovered unless created
+// with NoRecover, a panic of the task in its own goroutine (DispatchGoroutine)
+// crashes the program like the standard time.AfterFunc.
+//
+// The f is called in the goroutine that executed the task after it panicked,
+// the run-once timer is finished after f returns.
+func OnPanic(f func(t *Timer, value interface{})) Option {
+	return func(o *options) {
+		o.onPanic = f
+	}
+}
+
+// WithDumpLimit sets the maximum number of timers listed per bucket by the
+// verbose Dump, the rest are summarized as a count. Default is 100, and n <= 0
+// means no limit.
+func WithDumpLimit(n int) Option {
+	return func(o *options) {
+		o.dumpLimit = n
+	}
+}
+
+// WithMetricsSink makes the TimeWheel report its metrics (see the Metric
+// constants) to sink every interval. The observations of each fire are
+// buffered and reported in batch, so that the sink is never called on the
+// path of the expiration. The metrics are reported from Start, until a final
+// report when the TimeWheel is stopped.
+func WithMetricsSink(sink MetricsSink, interval time.Duration) Option {
+	if interval <= 0 {
+		panic("timewheel: interval of metrics must be greater than 0")
+	}
+	return func(o *options) {
+		o.metricsSink = sink
+		o.metricsInterval = interval
+	}
+}
+
+// OnReject registers f to be called when a new timer is rejected, such as the
+// quota of its tag is exceeded (see SetQuota) or it's shed under load (see
+// WithLoadShedding). The f receives the rejected timer and the reason.
+//
+// The f is called synchronously in the goroutine that scheduled the timer,
+// so it must return quickly and must not block.
+func OnReject(f func(t *Timer, err error)) Option {
+	return func(o *options) {
+		o.onReject = f
+	}
+}
+
+// WithTaskRegistry sets the TaskRegistry used by AfterTask, TimeTask and RestoreFrom.
+func WithTaskRegistry(r *TaskRegistry) Option {
+	return func(o *options) {
+		o.registry = r
+	}
+}
+
+// WithCapacity preallocates n timers into a fixed-capacity arena, that are
+// armed by Arm and referred by the Handle rather than the *Timer. Arm fails
+// with ErrFull once all of them are in use. It's meant for the deployments
+// that can't afford the allocation per timer, the other scheduling funcs are
+// not limited by n and still allocate as usual.
+func WithCapacity(n int) Option {
+	if n < 1 {
+		panic("timewheel: capacity must be greater than 0")
+	}
+	return func(o *options) {
+		o.capacity = n
+	}
+}
+
+// WithMaxLevels sets the maximum number of levels of the TimeWheel, it includes
+// the root and all the overflow wheels. Each level holds the slots of the
+// size, thus it bounds the memory that a far-future expiration costs; the
+// bucket of a slot is allocated on its first insert (see Stats.AllocatedBuckets).
+// A new timer beyond the span of n levels (see MaxDelay) is rejected with
+// ErrDelayTooLarge, or clamped by WithDelayClamp. Default is 16, the current
+// number of levels is reported by Stats.
+func WithMaxLevels(n int) Option {
+	if n < 1 {
+		panic("timewheel: maximum levels must be greater than 0")
+	}
+	return func(o *options) {
+		o.maxLevels = n
+	}
+}
+
+// WithHashedMode makes the TimeWheel a single-level hashed wheel, that never
+// creates an overflow wheel. A timer beyond the span of the wheel (i.e. tick *
+// size) is put into the bucket of its expiration modulo the span, and counts
+// the remaining rounds; it's examined again each time the bucket expires until
+// the last round. The public API is the same in both modes, and any delay is
+// accepted, the WithMaxLevels is ignored.
+//
+// Both modes fire a timer within a tick of its expiration, the difference is
+// the cost of the long timers. The hierarchical wheel moves a timer down once
+// per level, with the memory of the buckets of each level. The hashed wheel
+// has a fixed memory, but scans each long timer once per rotation, i.e. a
+// timer of the delay D is examined D / (tick * size) times. Thus it's suited
+// when most of the delays are within the span, e.g. the timeouts of requests,
+// with a size that covers the common delays (see BenchmarkHashedMode).
+func WithHashedMode() Option {
+	return func(o *options) {
+		o.hashed = true
+	}
+}
+
+// WithDelayClamp makes a new timer beyond MaxDelay expire at MaxDelay from now
+// rather than being rejected with ErrDelayTooLarge, e.g. for the delays passed
+// through from the callers that are meant as "never" in effect. A timer re-armed
+// beyond it by Reset is never rejected, but parked in the top level until it's
+// in the range.
+func WithDelayClamp() Option {
+	return func(o *options) {
+		o.delayClamp = true
+	}
+}
+
+// TimerOption is used to customize the Timer created by the scheduling funcs.
+type TimerOption func(t *Timer)
+
+// WithTag sets the tag of the timer, it's used to identify a group of timers
+// in diagnostics, such as the region name in runtime/trace.
+func WithTag(tag string) TimerOption {
+	return func(t *Timer) {
+		t.setAttrs().tag = tag
+	}
+}
+
+// Immediately makes the first execution of the recurring timer at the time
+// it's scheduled, through the same path as the following executions, i.e.
+// it's dispatched by the DispatchPolicy, follows the OverlapPolicy, and
+// counts toward the Times of Recurrence. It has no effect on the run-once timers.
+func Immediately() TimerOption {
+	return func(t *Timer) {
+		t.setAttrs().immediate = true
+	}
+}
+
+// NoRecover exempts the task of the timer from the panic recovery of the
+// DispatchInline and DispatchBatch (see OnPanic), so that its panic crashes
+// the program. The bookkeeping of the TimeWheel is finished before the panic
+// is propagated: the timer is finished, and the panic is re-panicked by the
+// consumer goroutine once the rest of the expired timers are dispatched, or
+// on a fresh goroutine if the consumer goroutine is not dispatching (e.g. the
+// chunk of DispatchBatch runs after). The original stack is logged by the
+// logger set by WithLogger, since the re-panic has its own stack.
+//
+// It has no effect with DispatchGoroutine, whose tasks are never recovered.
+// The panic of a task created by ScheduleResult is still reported by its
+// Future as a *PanicError before propagated.
+func NoRecover() TimerOption {
+	return func(t *Timer) {
+		t.setAttrs().noRecover = true
+	}
+}
+
+// withOnFinish sets the func called once the timer is finished, see Timer.Done.
+func withOnFinish(f func()) TimerOption {
+	return func(t *Timer) {
+		t.setAttrs().onFinish = f
+	}
+}
@@ -0,0 +1,250 @@
+package timewheel
+
+import (
+	"errors"
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/yu31/dqueue"
+)
+
+func TestOnIdle_OnActive(t *testing.T) {
+	var idle, active int32
+
+	tw := New(time.Millisecond, 3,
+		OnIdle(func() { atomic.AddInt32(&idle, 1) }),
+		OnActive(func() { atomic.AddInt32(&active, 1) }),
+	)
+	tw.Start()
+	defer tw.Stop()
+
+	retC := make(chan struct{}, 2)
+	tw.AfterFunc(time.Millisecond*5, func() { retC <- struct{}{} })
+	tw.AfterFunc(time.Millisecond*10, func() { retC <- struct{}{} })
+	require.Equal(t, atomic.LoadInt32(&active), int32(1))
+	require.Equal(t, atomic.LoadInt32(&idle), int32(0))
+
+	<-retC
+	<-retC
+	require.Equal(t, atomic.LoadInt32(&active), int32(1))
+	require.Equal(t, atomic.LoadInt32(&idle), int32(1))
+
+	timer := tw.AfterFunc(time.Hour, func() {})
+	require.Equal(t, atomic.LoadInt32(&active), int32(2))
+	timer.Close()
+	require.Equal(t, atomic.LoadInt32(&idle), int32(2))
+}
+
+// The queue holds no bucket while the TimeWheel is empty, thus an idle
+// TimeWheel performs no periodic work however long its clock advances.
+func TestTimeWheel_Idle_NoWakeup(t *testing.T) {
+	clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	tw := New(time.Millisecond, 3, WithClock(clock), WithDispatchPolicy(DispatchInline))
+	tw.Start()
+	defer tw.Stop()
+
+	// Lasts many rotations of the wheel.
+	clock.add(time.Millisecond * 100)
+	wakeups, next := tw.Poll()
+	require.Equal(t, 0, wakeups)
+	require.True(t, next.IsZero())
+
+	var fired int32
+	tw.AfterFunc(time.Millisecond*2, func() { atomic.AddInt32(&fired, 1) })
+	clock.add(time.Millisecond * 2)
+	// The timer may cascade from the overflow wheels, so it can cause more than one wakeup.
+	wakeups, next = tw.Poll()
+	require.Greater(t, wakeups, 0)
+	require.Equal(t, int32(1), atomic.LoadInt32(&fired))
+	require.True(t, next.IsZero())
+
+	clock.add(time.Millisecond * 100)
+	wakeups, _ = tw.Poll()
+	require.Equal(t, 0, wakeups)
+}
+
+func TestWithQueue(t *testing.T) {
+	dq := dqueue.Default()
+	msgC := make(chan *dqueue.Message, 1)
+
+	tw := New(time.Millisecond, 8, WithQueue(dq), WithForeignMessageHandler(func(msg *dqueue.Message) {
+		msgC <- msg
+	}))
+	require.Equal(t, tw.queue.dq, dq)
+
+	tw.Start()
+	defer tw.Stop()
+
+	retC := make(chan struct{}, 1)
+	tw.AfterFunc(time.Millisecond*5, func() { retC <- struct{}{} })
+	dq.After(time.Millisecond, "foreign")
+
+	require.Equal(t, (<-msgC).Value, "foreign")
+	<-retC
+}
+
+func TestImmediately(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	// The first execution is at scheduling time.
+	runC := make(chan time.Time, 10)
+	start := time.Now()
+	timer, err := tw.Every(time.Hour).Do(func() { runC <- time.Now() }, Immediately())
+	require.NoError(t, err)
+	require.Less(t, int64((<-runC).Sub(start)), int64(time.Millisecond*100))
+	require.Equal(t, StateScheduled, timer.State())
+	timer.Close()
+
+	// So does the Schedule.
+	sh := &countdown{interval: time.Hour, n: 1}
+	timer = tw.Schedule(sh, Immediately())
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&sh.runs) == 1 }, time.Second, time.Millisecond)
+	timer.Close()
+
+	// The immediate execution counts toward the Times.
+	for _, n := range []int{1, 3} {
+		var runs int32
+		ranC := make(chan int, 1)
+		timer, err = tw.Every(time.Millisecond*2).Times(n).OnComplete(func(ran int) { ranC <- ran }).
+			Do(func() { atomic.AddInt32(&runs, 1) }, Immediately())
+		require.NoError(t, err)
+		require.Equal(t, n, <-ranC)
+		<-timer.Done()
+		require.Equal(t, int32(n), atomic.LoadInt32(&runs))
+	}
+}
+
+func TestImmediately_SkipIfRunning(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	var runs int32
+	timer, err := tw.Every(time.Millisecond).Times(3).Do(func() {
+		atomic.AddInt32(&runs, 1)
+		time.Sleep(time.Millisecond * 20)
+	}, Immediately(), SkipIfRunning())
+	require.NoError(t, err)
+
+	<-timer.Done()
+	require.Equal(t, int32(1), atomic.LoadInt32(&runs))
+	require.Equal(t, uint64(2), timer.Skipped())
+}
+
+func TestWithMaxLevels(t *testing.T) {
+	require.Panics(t, func() { WithMaxLevels(0) })
+
+	clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	// The 2 levels span 16ms.
+	tw := New(time.Millisecond, 4, WithMaxLevels(2), WithClock(clock), WithDispatchPolicy(DispatchInline))
+	tw.Start()
+	defer tw.Stop()
+
+	var fired int
+	timer, err := tw.TryAfterFunc(time.Millisecond*15, func() { fired++ })
+	require.NoError(t, err)
+	_, err = tw.TryAfterFunc(time.Millisecond*16, func() {})
+	require.True(t, errors.Is(err, ErrDelayTooLarge))
+	rejected := tw.AfterFunc(time.Hour, func() {})
+	require.Equal(t, EndRejected, rejected.EndReason())
+
+	stats := tw.Stats()
+	require.Equal(t, 2, stats.Levels)
+	require.Equal(t, 2, stats.MaxLevels)
+	require.Equal(t, uint64(2), stats.Rejected)
+
+	// The re-armed timer beyond the span is parked in the top level until
+	// it's in the range.
+	start := clock.Now()
+	require.True(t, timer.Reset(time.Millisecond*200))
+	for fired == 0 {
+		clock.add(time.Millisecond)
+		tw.Poll()
+		require.Equal(t, 2, tw.Stats().Levels)
+	}
+	require.Equal(t, int64(time.Millisecond*200), int64(clock.Now().Sub(start)))
+	require.Equal(t, 1, fired)
+}
+
+func TestTimeWheel_MaxDelay(t *testing.T) {
+	clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	tw := New(time.Millisecond, 4, WithMaxLevels(3), WithClock(clock))
+	tw.Start()
+	defer tw.Stop()
+
+	// The 3 levels span 64ms, the value is stable once the overflow wheels
+	// are created.
+	require.Equal(t, int64(time.Millisecond*63), int64(tw.MaxDelay()))
+	_, err := tw.TryAfterFunc(tw.MaxDelay(), func() {})
+	require.NoError(t, err)
+	require.Equal(t, 3, tw.Stats().Levels)
+	require.Equal(t, int64(time.Millisecond*63), int64(tw.MaxDelay()))
+
+	// The far-future delays never wrap around to the past.
+	for _, d := range []time.Duration{time.Millisecond*63 + 1, time.Hour * 24 * 365 * 200, math.MaxInt64} {
+		_, err = tw.TryAfterFunc(d, func() {})
+		require.True(t, errors.Is(err, ErrDelayTooLarge), d)
+	}
+
+	// The default levels of the size saturate the span.
+	require.Equal(t, int64(math.MaxInt64), int64(New(time.Millisecond, 512).MaxDelay()))
+	require.Equal(t, int64(math.MaxInt64), int64(New(time.Millisecond, 4, WithHashedMode(), WithMaxLevels(2)).MaxDelay()))
+}
+
+func TestWithDelayClamp(t *testing.T) {
+	clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	tw := New(time.Millisecond, 4, WithMaxLevels(2), WithDelayClamp(), WithClock(clock), WithDispatchPolicy(DispatchInline))
+	tw.Start()
+	defer tw.Stop()
+
+	var fired int
+	for _, d := range []time.Duration{time.Hour, math.MaxInt64} {
+		timer, err := tw.TryAfterFunc(d, func() { fired++ })
+		require.NoError(t, err)
+		require.Equal(t, clock.Now().Add(time.Millisecond*15).UnixNano(), timer.Expiration().UnixNano())
+	}
+	clock.add(time.Millisecond * 15)
+	tw.Poll()
+	require.Equal(t, 2, fired)
+	require.Equal(t, uint64(0), tw.Stats().Rejected)
+}
+
+func TestWithHashedMode(t *testing.T) {
+	clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	// The single level spans 4ms, any delay is taken regardless of WithMaxLevels.
+	tw := New(time.Millisecond, 4, WithHashedMode(), WithMaxLevels(8), WithClock(clock), WithDispatchPolicy(DispatchInline))
+	tw.Start()
+	defer tw.Stop()
+
+	start := clock.Now()
+	fired := make(map[time.Duration]time.Duration)
+	delays := []time.Duration{1, 3, 4, 5, 8, 13, 64, 1000}
+	for _, d := range delays {
+		d := d * time.Millisecond
+		_, err := tw.TryAfterFunc(d, func() { fired[d] = clock.Now().Sub(start) })
+		require.NoError(t, err)
+	}
+	timer := tw.AfterFunc(time.Millisecond*2, func() { fired[0] = clock.Now().Sub(start) })
+	require.True(t, timer.Reset(time.Millisecond*30))
+
+	for len(fired) < len(delays)+1 {
+		clock.add(time.Millisecond)
+		tw.Poll()
+		require.Equal(t, 1, tw.Stats().Levels)
+	}
+	for d, at := range fired {
+		if d == 0 {
+			d = time.Millisecond * 30
+		}
+		require.Equal(t, int64(d), int64(at), d.String())
+	}
+
+	stats := tw.Stats()
+	require.Equal(t, 1, stats.MaxLevels)
+	require.Equal(t, uint64(0), stats.Rejected)
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"sort"
+)
+
+// overdueMerge is the maximum number of the overdue buckets whose expired
+// timers are merged before dispatching, see WithDeadlineOrder.
+const overdueMerge = 64
+
+// WithDeadlineOrder makes the consumer dispatch the overdue timers in the
+// order of their expirations once it falls behind, e.g. after a long stall of
+// the process or a jump of the Clock. Without it, the overdue buckets are
+// processed one after another in the order the queue yields them, and the
+// DQueue may have handed the next bucket over before the consumer moved the
+// timers of an earlier bucket of a higher level down to the lower levels, thus
+// a timer due at T+5s may be dispatched before the one due at T+1s.
+//
+// While the next bucket in the queue is overdue as well, the expired timers of
+// the processed buckets are held rather than dispatched, up to 64 buckets,
+// then they're dispatched together in the order of their expirations at the
+// resolution of the tick, except the ones at or after the next bucket which
+// are held further; the timers that expire in the same tick keep their FIFO
+// order. It's applied before WithFairDispatch, which preserves the order only
+// within each tag. While the consumer keeps up, it costs a pass over the
+// expired timers of each bucket to tell they're in order already. The held
+// timers are still dispatched if the TimeWheel is stopped meanwhile.
+func WithDeadlineOrder() Option {
+	return func(o *options) {
+		o.deadlineOrder = true
+	}
+}
+
+// holdOverdue reports whether the expired timers collected in ready should be
+// held until the next bucket is processed, since it has also expired at now.
+func (tw *TimeWheel) holdOverdue(now int64) bool {
+	root := tw.root
+	if !root.opts.deadlineOrder {
+		return false
+	}
+	next, ok := root.queue.nextOverdue(now)
+	if !ok {
+		return false
+	}
+	if root.held+1 >= overdueMerge {
+		root.cutoff = next
+		return false
+	}
+	root.held++
+	return true
+}
+
+// orderReady sorts the ready timers by their expirations at the tick, if they
+// are merged from multiple buckets or a bucket of the higher levels, and
+// returns the number of the ones to be dispatched now. Once the limit of the
+// merged buckets is reached, the ones at or after the expiration of the next
+// overdue bucket are kept held, since it may be a bucket of a higher level,
+// handed over before the lower-level buckets that are offered late.
+func (tw *TimeWheel) orderReady() int {
+	root := tw.root
+	cutoff := root.cutoff
+	root.held, root.cutoff = 0, 0
+	if !root.opts.deadlineOrder || len(root.ready) < 2 {
+		return len(root.ready)
+	}
+	ready, tick := root.ready, root.tick
+	less := func(i, j int) bool {
+		return truncate(ready[i].getExpiration(), tick) < truncate(ready[j].getExpiration(), tick)
+	}
+	if !sort.SliceIsSorted(ready, less) {
+		sort.SliceStable(ready, less)
+	}
+	if cutoff == 0 {
+		return len(ready)
+	}
+	n := sort.Search(len(ready), func(i int) bool { return truncate(ready[i].getExpiration(), tick) >= cutoff })
+	if n != len(ready) {
+		root.held = 1
+	}
+	return n
+}
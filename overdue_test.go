@@ -0,0 +1,172 @@
+package timewheel
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// stallAndPoll schedules timers of the delays in the given order on a stalled
+// clock, then jumps the clock past all of them and returns the fired delays.
+func stallAndPoll(t *testing.T, delays []int, opts ...Option) []int {
+	clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	opts = append(opts, WithClock(clock), WithDispatchPolicy(DispatchInline))
+	tw := New(time.Millisecond, 8, opts...)
+	tw.Start()
+	defer tw.Stop()
+
+	var fired []int
+	for _, d := range delays {
+		d := d
+		tw.AfterFunc(time.Duration(d)*time.Millisecond, func() { fired = append(fired, d) })
+	}
+	clock.add(time.Second)
+	for n, _ := tw.Poll(); n != 0; n, _ = tw.Poll() {
+	}
+	require.Len(t, fired, len(delays))
+	return fired
+}
+
+func TestWithDeadlineOrder(t *testing.T) {
+	// The delays span several levels, and several of them share a bucket of
+	// the higher levels.
+	delays := rand.New(rand.NewSource(1)).Perm(500)
+	for i := range delays {
+		delays[i]++
+	}
+
+	fired := stallAndPoll(t, delays, WithDeadlineOrder())
+	require.True(t, sort.IntsAreSorted(fired), fired)
+	fired = stallAndPoll(t, delays, WithDeadlineOrder(), WithHashedMode())
+	require.True(t, sort.IntsAreSorted(fired), fired)
+}
+
+func TestWithDeadlineOrder_Stall(t *testing.T) {
+	// The DQueue may have taken the next bucket while the consumer is offering
+	// the lower-level buckets of an earlier one, it's the order of the buckets
+	// rather than the timers without WithDeadlineOrder.
+	tw := New(time.Millisecond, 8, WithDispatchPolicy(DispatchInline), WithDeadlineOrder())
+	tw.Start()
+	defer tw.Stop()
+
+	var mu sync.Mutex
+	var fired []int
+	tw.AfterFunc(time.Millisecond, func() { time.Sleep(time.Millisecond * 300) })
+	base := time.Now()
+	delays := rand.New(rand.NewSource(1)).Perm(200)
+	for _, d := range delays {
+		d := d + 5
+		// Relative to the same base, thus the order of the delays is the one
+		// of the expirations.
+		tw.AfterFunc(time.Until(base.Add(time.Duration(d)*time.Millisecond)), func() {
+			mu.Lock()
+			fired = append(fired, d)
+			mu.Unlock()
+		})
+	}
+	require.Eventually(t, func() bool { return tw.Pending() == 0 }, time.Second*5, time.Millisecond*10)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, fired, len(delays))
+	require.True(t, sort.IntsAreSorted(fired), fired)
+}
+
+func TestWithDeadlineOrder_SameTick(t *testing.T) {
+	// The timers expire in the same tick keep their FIFO order.
+	clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	tw := New(time.Millisecond*10, 8, WithClock(clock), WithDispatchPolicy(DispatchInline))
+	tw.Start()
+	defer tw.Stop()
+
+	var mu sync.Mutex
+	var fired []int
+	for i, d := range []time.Duration{time.Millisecond * 205, time.Millisecond * 201, time.Millisecond * 35} {
+		i := i
+		tw.AfterFunc(d, func() {
+			mu.Lock()
+			fired = append(fired, i)
+			mu.Unlock()
+		})
+	}
+	clock.add(time.Second)
+	tw.Poll()
+	require.Equal(t, []int{2, 0, 1}, fired)
+}
+
+func Test_bucketHeap_peekSecond(t *testing.T) {
+	h := &bucketHeap{mu: new(sync.Mutex)}
+	_, ok := h.peekSecond()
+	require.False(t, ok)
+	h.push(nil, 5)
+	_, ok = h.peekSecond()
+	require.False(t, ok)
+	h.push(nil, 9)
+	h.push(nil, 1)
+	h.push(nil, 7)
+	expiration, ok := h.peekSecond()
+	require.True(t, ok)
+	require.Equal(t, int64(5), expiration)
+}
+
+func TestTimeWheel_orderReady_Cutoff(t *testing.T) {
+	tw := New(time.Millisecond, 8, WithDeadlineOrder())
+	ms := int64(time.Millisecond)
+	for _, e := range []int64{7, 3, 5, 9, 1} {
+		tw.ready = append(tw.ready, &Timer{expiration: e*ms + 1})
+	}
+
+	// The limit is reached while the bucket at 5ms is still overdue.
+	tw.held, tw.cutoff = overdueMerge-1, 5*ms
+	require.Equal(t, 2, tw.orderReady())
+	require.Equal(t, 1, tw.held)
+	require.Equal(t, int64(0), tw.cutoff)
+	var got []int64
+	for _, timer := range tw.ready {
+		got = append(got, timer.expiration/ms)
+	}
+	require.Equal(t, []int64{1, 3, 5, 7, 9}, got)
+
+	// Caught up.
+	require.Equal(t, 5, tw.orderReady())
+	require.Equal(t, 0, tw.held)
+}
+
+func TestWithDeadlineOrder_StopHeld(t *testing.T) {
+	clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	tw := New(time.Millisecond, 8, WithClock(clock), WithDispatchPolicy(DispatchInline), WithDeadlineOrder())
+	tw.Start()
+
+	var fired int
+	for i := 1; i <= 3; i++ {
+		tw.AfterFunc(time.Millisecond*time.Duration(i), func() { fired++ })
+	}
+	clock.add(time.Millisecond * 10)
+
+	// Processes the buckets one by one like the consumer goroutine, the
+	// timers of the first are held since the next one is overdue as well.
+	b, expiration, ok := tw.queue.manual.pop(tw.root.now())
+	require.True(t, ok)
+	tw.process(b, expiration)
+	require.Equal(t, 1, tw.root.held)
+	require.Equal(t, 0, fired)
+
+	// The shutdown is deferred while dispatching.
+	tw.Stop()
+	b, expiration, ok = tw.queue.manual.pop(tw.root.now())
+	require.True(t, ok)
+	tw.process(b, expiration)
+
+	doneC := make(chan struct{})
+	go func() {
+		tw.Wait()
+		close(doneC)
+	}()
+	waitC(t, doneC)
+	// The held timer is dispatched before the shutdown.
+	require.Equal(t, 1, fired)
+}
@@ -0,0 +1,207 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// OverlapPolicy decides what to do when a recurring timer expires while its
+// previous execution is still running.
+type OverlapPolicy int
+
+const (
+	// OverlapAllow dispatches the execution regardless of the previous one,
+	// thus the executions may overlap. It's the default policy.
+	OverlapAllow OverlapPolicy = iota
+	// OverlapSkip skips the execution if the previous one has not returned.
+	OverlapSkip
+	// OverlapQueue runs the execution immediately after the previous one
+	// returns. The executions missed while one is already queued collapse into
+	// the queued one, i.e. at most one execution is pending, and they're
+	// counted as skipped.
+	OverlapQueue
+)
+
+// overlap tracks the executions of a recurring timer, to make sure that no
+// two executions of the timer are running at the same time.
+type overlap struct {
+	policy OverlapPolicy
+	onSkip func(t *Timer)
+
+	mu sync.Mutex
+	// Whether an execution is running.
+	running bool
+	// Whether an execution is queued to run after the running one, and the
+	// time that it's scheduled at.
+	queued   bool
+	queuedAt int64
+	// Whether the execution plan ended while an execution is running, the
+	// running execution completes the timer after it returns.
+	final bool
+
+	skipped     uint64
+	queuedCount uint64
+}
+
+// getOverlap returns the overlap of the timer t, it's created if not exists.
+func (t *Timer) getOverlap() *overlap {
+	a := t.setAttrs()
+	if a.overlap == nil {
+		a.overlap = &overlap{}
+	}
+	return a.overlap
+}
+
+// WithOverlap sets the OverlapPolicy of the recurring timer, default is
+// OverlapAllow. The policy is exact even if the executions are dispatched in
+// their own goroutines, the skipped and queued executions are counted by
+// Timer.Skipped and Timer.Queued. It has no effect on the run-once timers.
+func WithOverlap(p OverlapPolicy) TimerOption {
+	return func(t *Timer) {
+		t.getOverlap().policy = p
+	}
+}
+
+// SkipIfRunning is a shorthand for WithOverlap(OverlapSkip). An execution
+// that panicked or was refused by the Executor is not running any more, thus
+// it never makes the later executions skipped.
+func SkipIfRunning() TimerOption {
+	return WithOverlap(OverlapSkip)
+}
+
+// OnSkip registers f to be called each time an execution of the recurring
+// timer is skipped by OverlapSkip or collapsed by OverlapQueue. The f is
+// called in the consumer goroutine of the TimeWheel, so it must return
+// quickly and must not block.
+func OnSkip(f func(t *Timer)) TimerOption {
+	return func(t *Timer) {
+		t.getOverlap().onSkip = f
+	}
+}
+
+// Skipped returns the number of executions skipped by OverlapSkip or
+// collapsed by OverlapQueue.
+func (t *Timer) Skipped() uint64 {
+	o := t.getAttrs().overlap
+	if o == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&o.skipped)
+}
+
+// Queued returns the number of executions queued by OverlapQueue to run
+// after the previous one returned.
+func (t *Timer) Queued() uint64 {
+	o := t.getAttrs().overlap
+	if o == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&o.queuedCount)
+}
+
+// runRecurring dispatches an execution of the recurring timer t that scheduled
+// at the time at, the last is true if it's the last execution of the plan.
+func (tw *TimeWheel) runRecurring(t *Timer, sh Scheduler, at int64, last bool) {
+	if tw.root.opts.onExpireBatch != nil {
+		// The executions never overlap since none is executed.
+		tw.handOver(t, last)
+		return
+	}
+	o := t.getAttrs().overlap
+	if o == nil || o.policy == OverlapAllow {
+		if !last {
+			tw.dispatch(context.Background(), t, func(ctx context.Context) {
+				if tw.guard(ctx, t, at) {
+					sh.Run()
+				}
+			})
+			return
+		}
+		tw.dispatch(context.Background(), t, func(ctx context.Context) {
+			if tw.guard(ctx, t, at) {
+				sh.Run()
+			}
+			t.complete()
+		})
+		return
+	}
+
+	o.mu.Lock()
+	if o.running {
+		o.final = o.final || last
+		if o.policy == OverlapQueue && !o.queued {
+			o.queued = true
+			o.queuedAt = at
+			o.mu.Unlock()
+
+			atomic.AddUint64(&o.queuedCount, 1)
+			atomic.AddUint64(&tw.root.queued, 1)
+			return
+		}
+		o.mu.Unlock()
+
+		// Skip the current execution.
+		atomic.AddUint64(&o.skipped, 1)
+		atomic.AddUint64(&tw.root.skipped, 1)
+		if o.onSkip != nil {
+			o.onSkip(t)
+		}
+		return
+	}
+	o.running = true
+	o.mu.Unlock()
+
+	tw.dispatch(context.Background(), t, func(ctx context.Context) {
+		returned := false
+		defer func() {
+			if !returned {
+				// The task panicked, the timer is completed like it returned
+				// if it's the last execution, see recovered.
+				if o.release() || last {
+					t.complete()
+				}
+			}
+		}()
+		for {
+			if tw.guard(ctx, t, at) {
+				sh.Run()
+			}
+
+			o.mu.Lock()
+			if o.queued {
+				// Run the queued execution in place.
+				o.queued = false
+				at = o.queuedAt
+				o.mu.Unlock()
+				continue
+			}
+			o.running = false
+			final := o.final || last
+			o.mu.Unlock()
+
+			returned = true
+			if final {
+				t.complete()
+			}
+			return
+		}
+	})
+}
+
+// release ends the running execution that never returns, i.e. its task
+// panicked or it's refused by the Executor, and drops the queued one, thus
+// the later executions are not skipped. It returns whether the execution plan
+// ended meanwhile.
+func (o *overlap) release() (final bool) {
+	o.mu.Lock()
+	o.running, o.queued = false, false
+	final = o.final
+	o.mu.Unlock()
+	return final
+}
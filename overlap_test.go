@@ -0,0 +1,176 @@
+package timewheel
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// concurrency tracks the maximum number of concurrent executions.
+type concurrency struct {
+	current int32
+	max     int32
+	runs    int32
+}
+
+func (c *concurrency) run(d time.Duration) {
+	n := atomic.AddInt32(&c.current, 1)
+	for {
+		max := atomic.LoadInt32(&c.max)
+		if n <= max || atomic.CompareAndSwapInt32(&c.max, max, n) {
+			break
+		}
+	}
+	atomic.AddInt32(&c.runs, 1)
+	time.Sleep(d)
+	atomic.AddInt32(&c.current, -1)
+}
+
+func TestSkipIfRunning(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	c := new(concurrency)
+	var onSkip uint64
+	timer, err := tw.Every(time.Millisecond).Do(func() { c.run(time.Millisecond * 10) },
+		SkipIfRunning(), OnSkip(func(*Timer) { atomic.AddUint64(&onSkip, 1) }))
+	require.NoError(t, err)
+
+	time.Sleep(time.Millisecond * 60)
+	timer.Close()
+
+	require.Equal(t, int32(1), atomic.LoadInt32(&c.max))
+	require.Greater(t, timer.Skipped(), uint64(0))
+	require.Equal(t, timer.Skipped(), atomic.LoadUint64(&onSkip))
+	require.Equal(t, timer.Skipped(), tw.Stats().Skipped)
+}
+
+func TestSkipIfRunning_Last(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	c := new(concurrency)
+	// The executions after the first one are all skipped, the timer is
+	// completed after the first execution returns.
+	timer, err := tw.Every(time.Millisecond).Times(3).Do(func() { c.run(time.Millisecond * 30) }, SkipIfRunning())
+	require.NoError(t, err)
+
+	<-timer.Done()
+	require.Equal(t, StateCompleted, timer.State())
+	require.Equal(t, int32(1), atomic.LoadInt32(&c.runs))
+	require.Equal(t, int32(0), atomic.LoadInt32(&c.current))
+	require.Equal(t, uint64(2), timer.Skipped())
+}
+
+func TestSkipIfRunning_Abandoned(t *testing.T) {
+	runs, timer := abandonedRuns(t, false, SkipIfRunning())
+	require.Equal(t, int32(40), runs)
+	require.Equal(t, uint64(0), timer.Skipped())
+	require.Equal(t, StateCompleted, timer.State())
+
+	runs, timer = abandonedRuns(t, true, SkipIfRunning())
+	require.Equal(t, int32(39), runs)
+	require.Equal(t, uint64(0), timer.Skipped())
+	require.Equal(t, StateCompleted, timer.State())
+}
+
+func TestSkipIfRunning_Allow(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	c := new(concurrency)
+	timer, err := tw.Every(time.Millisecond).Times(10).Do(func() { c.run(time.Millisecond * 10) })
+	require.NoError(t, err)
+
+	<-timer.Done()
+	require.Greater(t, atomic.LoadInt32(&c.max), int32(1))
+	require.Equal(t, uint64(0), timer.Skipped())
+}
+
+func TestOverlapQueue(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	c := new(concurrency)
+	var onSkip uint64
+	timer, err := tw.Every(time.Millisecond*5).Times(4).Do(func() { c.run(time.Millisecond * 12) },
+		OnSkip(func(*Timer) { atomic.AddUint64(&onSkip, 1) }), WithOverlap(OverlapQueue))
+	require.NoError(t, err)
+
+	<-timer.Done()
+	require.Equal(t, StateCompleted, timer.State())
+	require.Equal(t, int32(1), atomic.LoadInt32(&c.max))
+	require.Equal(t, int32(0), atomic.LoadInt32(&c.current))
+
+	// Each occurrence either runs, or is collapsed into the queued one.
+	runs := uint64(atomic.LoadInt32(&c.runs))
+	require.Greater(t, timer.Queued(), uint64(0))
+	require.Equal(t, 1+timer.Queued(), runs)
+	require.Equal(t, uint64(4), runs+timer.Skipped())
+	require.Equal(t, timer.Skipped(), atomic.LoadUint64(&onSkip))
+
+	stats := tw.Stats()
+	require.Equal(t, timer.Queued(), stats.Queued)
+	require.Equal(t, timer.Skipped(), stats.Skipped)
+}
+
+// flakyExecutor refuses the first n tasks, and runs the others inline.
+type flakyExecutor struct {
+	refuse int32
+}
+
+func (e *flakyExecutor) Execute(f func()) error {
+	if atomic.AddInt32(&e.refuse, -1) >= 0 {
+		return errors.New("refused")
+	}
+	f()
+	return nil
+}
+
+// abandonedRuns runs a recurring timer of 40 executions with the opt, its
+// first execution panics, or is refused by the Executor if refuse is true.
+func abandonedRuns(t *testing.T, refuse bool, opt TimerOption) (runs int32, timer *Timer) {
+	clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	opts := []Option{WithClock(clock), WithDispatchPolicy(DispatchInline)}
+	if refuse {
+		opts = append(opts, WithExecutor(&flakyExecutor{refuse: 1}))
+	}
+	tw := New(time.Millisecond, 8, opts...)
+	tw.Start()
+	defer tw.Stop()
+
+	timer, err := tw.Every(time.Millisecond).Times(40).Do(func() {
+		if atomic.AddInt32(&runs, 1) == 1 && !refuse {
+			panic("boom")
+		}
+	}, opt)
+	require.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		clock.add(time.Millisecond)
+		for n, _ := tw.Poll(); n != 0; n, _ = tw.Poll() {
+		}
+	}
+	return atomic.LoadInt32(&runs), timer
+}
+
+func TestOverlapQueue_Abandoned(t *testing.T) {
+	// An execution that panics or is refused never returns, the later ones
+	// must not be collapsed as if it's still running.
+	runs, timer := abandonedRuns(t, false, WithOverlap(OverlapQueue))
+	require.Equal(t, int32(40), runs)
+	require.Equal(t, uint64(0), timer.Skipped())
+	require.Equal(t, uint64(0), timer.Queued())
+	require.Equal(t, StateCompleted, timer.State())
+
+	runs, timer = abandonedRuns(t, true, WithOverlap(OverlapQueue))
+	require.Equal(t, int32(39), runs)
+	require.Equal(t, uint64(0), timer.Skipped())
+	require.Equal(t, StateCompleted, timer.State())
+}
@@ -0,0 +1,271 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yu31/timewheel/timerspec"
+)
+
+// SaveTo writes the pending timers of the named tasks (see AfterTask) to w in
+// the stream format of timerspec, and returns the number of timers written.
+// The timers dispatched but not acknowledged (see Timer.Ack) are written as
+// in flight, so that they're fired again by RestoreFrom.
+// The timers created with a func can't be restored by another process, thus
+// they are not written.
+//
+// The timers are left in the TimeWheel, close or stop it after saved if they
+// are being moved elsewhere. The buckets are visited one by one in chunks of
+// the timers, yielding the processor between them, and a bucket is locked only
+// while the references to a chunk are copied out, never while encoding or
+// writing to w. Thus, the stall of the scheduling funcs is bounded by copying
+// a single chunk, regardless of the number of the pending timers and how they
+// are spread over the buckets, see BenchmarkSaveTo_AddLatency.
+//
+// The result is a point-in-time copy per bucket rather than for the whole
+// TimeWheel: each timer pending during the whole call is written exactly once,
+// even if it's moved between the buckets meanwhile, e.g. by Reset or by the
+// expiration of an overflow bucket; the moves into the visited buckets are
+// logged and reconciled at the end. The timers that expired or closed during
+// the call may or may not be written, and so are the new ones. The calls of
+// SaveTo are serialized, and so are ExportJSON.
+func (tw *TimeWheel) SaveTo(w io.Writer) (int, error) {
+	sw := timerspec.NewWriter(w)
+	var n int
+	err := tw.scanNamed(func(timers []*Timer) error {
+		for _, t := range timers {
+			spec := specOf(t, false)
+			if err := sw.Write(&spec); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+
+	for _, t := range tw.root.inflight.snapshot() {
+		spec := specOf(t, true)
+		if err := sw.Write(&spec); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, sw.Flush()
+}
+
+// scanNamed passes the pending timers of the named tasks to f chunk by chunk,
+// until f returns an error. See SaveTo for the consistency, the f is never
+// called while holding any lock of the TimeWheel.
+func (tw *TimeWheel) scanNamed(f func(timers []*Timer) error) error {
+	s := tw.root.save
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	epoch := s.begin()
+	var timers []*Timer
+	err := tw.root.eachBucket(func(b *bucket) error {
+		var e *timerElement
+		for first := true; first || e != nil; first = false {
+			timers = timers[:0]
+			b.mu.Lock()
+			if first {
+				b.saved = epoch
+				e = b.timers.Front()
+			} else {
+				e = b.cursor
+			}
+			for n := 0; e != nil && n < saveChunk; n++ {
+				t := e.Value
+				if a := t.getAttrs(); a.task != "" && a.saved != epoch && t.State() == StateScheduled {
+					a.saved = epoch
+					timers = append(timers, t)
+				}
+				e = e.Next()
+			}
+			b.cursor = e
+			b.mu.Unlock()
+
+			if err := f(timers); err != nil {
+				return err
+			}
+			runtime.Gosched()
+		}
+		return nil
+	})
+	logged := s.end(tw)
+	if err != nil {
+		return err
+	}
+
+	// The timers moved into the visited buckets during the scan.
+	timers = timers[:0]
+	for _, t := range logged {
+		if a := t.getAttrs(); a.task != "" && a.saved != epoch && t.State() == StateScheduled {
+			a.saved = epoch
+			timers = append(timers, t)
+		}
+	}
+	return f(timers)
+}
+
+// saveChunk is the number of the timers of a bucket visited by SaveTo at a time.
+const saveChunk = 1024
+
+// saveState is the state of the SaveTo in progress, shared by all the planes.
+//
+// While active, the timers inserted into the buckets that have been visited
+// are recorded by the insertion under the lock of the bucket, thus once the
+// active is cleared and every bucket is locked once, no insertion that saw it
+// is still in progress, and the log is complete.
+type saveState struct {
+	// The mu serializes the SaveTo.
+	mu sync.Mutex
+	// The epoch of the latest SaveTo, and the one in progress or 0. They're
+	// accessed atomically.
+	epoch  uint64
+	active uint64
+
+	logMu sync.Mutex
+	log   []*Timer
+}
+
+// begin starts a scan, and returns its epoch.
+func (s *saveState) begin() uint64 {
+	epoch := atomic.AddUint64(&s.epoch, 1)
+	atomic.StoreUint64(&s.active, epoch)
+	return epoch
+}
+
+// record logs the timer t inserted into a visited bucket.
+func (s *saveState) record(t *Timer) {
+	if t.getAttrs().task == "" {
+		return
+	}
+	s.logMu.Lock()
+	s.log = append(s.log, t)
+	s.logMu.Unlock()
+}
+
+// end ends the scan of tw, and returns the timers logged during it.
+func (s *saveState) end(tw *TimeWheel) []*Timer {
+	atomic.StoreUint64(&s.active, 0)
+	_ = tw.root.eachBucket(func(b *bucket) error {
+		// Waits for the insertion holding the lock.
+		b.mu.Lock()
+		b.mu.Unlock()
+		return nil
+	})
+	s.logMu.Lock()
+	defer s.logMu.Unlock()
+	log := s.log
+	s.log = nil
+	return log
+}
+
+// eachBucket calls f with each allocated bucket of all the levels of all the
+// planes, until f returns an error.
+func (tw *TimeWheel) eachBucket(f func(b *bucket) error) error {
+	for _, plane := range []*TimeWheel{tw, tw.critical} {
+		for l := plane; l != nil; l = l.getOverflow() {
+			for i := range l.buckets {
+				if b := l.loadBucket(int64(i)); b != nil {
+					if err := f(b); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// specOf returns the spec of the named timer t.
+func specOf(t *Timer, inFlight bool) timerspec.Spec {
+	a := t.getAttrs()
+	payload, _ := a.payload.([]byte)
+	return timerspec.Spec{
+		ID:           t.ID(),
+		Expiration:   t.getExpiration(),
+		Tag:          a.tag,
+		Task:         a.task,
+		Payload:      payload,
+		InFlight:     inFlight,
+		Redeliveries: a.redeliveries,
+		Unknown:      a.unknown,
+	}
+}
+
+// RestoreFrom reads the timers written by SaveTo from r and schedules them by
+// the TaskRegistry set by WithTaskRegistry, and returns the number of timers
+// scheduled. The timers are assigned new IDs, and those already expired are
+// handled by the PastPolicy, see WithPastPolicy. The timers saved in flight
+// are fired again at their expiration, with the Redeliveries increased. The unknown fields of each
+// spec are preserved for the next SaveTo.
+//
+// It stops at the first error, such as a task not registered. The recurrence
+// descriptor is reserved for the recurring named tasks, a spec with it is
+// rejected with ErrInvalidSchedule.
+func (tw *TimeWheel) RestoreFrom(r io.Reader) (int, error) {
+	sr := timerspec.NewReader(r)
+	var n int
+	for {
+		spec, err := sr.Read()
+		if errors.Is(err, io.EOF) {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		if spec.Recurrence != "" {
+			return n, &ScheduleError{Op: "RestoreFrom", Expiration: time.Unix(0, spec.Expiration), Tag: spec.Tag,
+				Err: fmt.Errorf("%w: unsupported recurrence %q", ErrInvalidSchedule, spec.Recurrence)}
+		}
+
+		if !spec.InFlight {
+			if ok, err := tw.admitPast("RestoreFrom", spec.Expiration, spec.Tag); !ok {
+				if err != nil {
+					return n, err
+				}
+				continue
+			}
+		}
+
+		var opts []TimerOption
+		if spec.Tag != "" {
+			opts = append(opts, WithTag(spec.Tag))
+		}
+		if _, err := tw.expireTask("RestoreFrom", spec.Expiration, spec.Task, spec.Payload, spec, opts); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// admitPast applies the PastPolicy to the restored timer of the expiration,
+// it returns false if the timer is not to be scheduled, with the error to
+// stop the restoring if any.
+func (tw *TimeWheel) admitPast(op string, expiration int64, tag string) (bool, error) {
+	if expiration > tw.timeNow().UnixNano() {
+		return true, nil
+	}
+	switch tw.root.opts.pastPolicy {
+	case PastSkip:
+		return false, nil
+	case PastReject:
+		return false, &ScheduleError{Op: op, Expiration: time.Unix(0, expiration), Tag: tag, Err: ErrExpired}
+	}
+	return true, nil
+}
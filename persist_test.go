@@ -0,0 +1,288 @@
+package timewheel
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"runtime"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/yu31/timewheel/timerspec"
+)
+
+func TestTimeWheel_SaveTo_RestoreFrom(t *testing.T) {
+	var mu sync.Mutex
+	var got []string
+	r := NewTaskRegistry()
+	r.Register("record", func(_ context.Context, payload []byte) {
+		mu.Lock()
+		got = append(got, string(payload))
+		mu.Unlock()
+	})
+
+	// The draining one.
+	src := New(time.Millisecond, 8, WithTaskRegistry(r))
+	_, err := src.AfterTask(time.Millisecond*20, "record", []byte("a"), WithTag("ta"))
+	require.NoError(t, err)
+	_, err = src.AfterTask(time.Hour, "record", []byte("b"))
+	require.NoError(t, err)
+	src.AfterFunc(time.Millisecond*20, func() {})
+
+	var buf bytes.Buffer
+	n, err := src.SaveTo(&buf)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	src.Stop()
+
+	// The replacement.
+	dst := New(time.Millisecond, 8, WithTaskRegistry(r))
+	dst.Start()
+	defer dst.Stop()
+
+	n, err = dst.RestoreFrom(&buf)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.Equal(t, int64(2), dst.Pending())
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(got) == 1
+	}, time.Second, time.Millisecond)
+	require.Equal(t, []string{"a"}, got)
+
+	buf.Reset()
+	n, err = dst.SaveTo(&buf)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	spec, err := timerspec.NewReader(&buf).Read()
+	require.NoError(t, err)
+	require.Equal(t, "record", spec.Task)
+	require.Equal(t, []byte("b"), spec.Payload)
+}
+
+func TestTimeWheel_SaveTo_Tag(t *testing.T) {
+	r := NewTaskRegistry()
+	r.Register("noop", func(context.Context, []byte) {})
+	tw := New(time.Millisecond, 8, WithTaskRegistry(r))
+	defer tw.Stop()
+	timer, err := tw.AfterTask(time.Hour, "noop", nil, WithTag("foo"))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	_, err = tw.SaveTo(&buf)
+	require.NoError(t, err)
+
+	tw2 := New(time.Millisecond, 8, WithTaskRegistry(r))
+	defer tw2.Stop()
+	_, err = tw2.RestoreFrom(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	infos := tw2.upcoming(1)
+	require.Equal(t, "foo", infos[0].Tag)
+	require.Equal(t, timer.getExpiration(), infos[0].Expiration.UnixNano())
+
+	spec, err := timerspec.NewReader(&buf).Read()
+	require.NoError(t, err)
+	require.Equal(t, timer.ID(), spec.ID)
+}
+
+func TestTimeWheel_RestoreFrom_Expired(t *testing.T) {
+	done := make(chan struct{})
+	r := NewTaskRegistry()
+	r.Register("done", func(context.Context, []byte) { close(done) })
+
+	var buf bytes.Buffer
+	w := timerspec.NewWriter(&buf)
+	require.NoError(t, w.Write(&timerspec.Spec{Expiration: time.Now().Add(-time.Hour).UnixNano(), Task: "done"}))
+	require.NoError(t, w.Flush())
+
+	tw := New(time.Millisecond, 8, WithTaskRegistry(r))
+	tw.Start()
+	defer tw.Stop()
+
+	n, err := tw.RestoreFrom(&buf)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	<-done
+}
+
+func TestTimeWheel_RestoreFrom_PastPolicy(t *testing.T) {
+	r := NewTaskRegistry()
+	r.Register("noop", func(context.Context, []byte) {})
+	var buf bytes.Buffer
+	w := timerspec.NewWriter(&buf)
+	require.NoError(t, w.Write(&timerspec.Spec{Expiration: time.Now().Add(-time.Hour).UnixNano(), Task: "noop"}))
+	require.NoError(t, w.Write(&timerspec.Spec{Expiration: time.Now().Add(-time.Hour).UnixNano(), Task: "noop", InFlight: true}))
+	require.NoError(t, w.Write(&timerspec.Spec{Expiration: time.Now().Add(time.Hour).UnixNano(), Task: "noop"}))
+	require.NoError(t, w.Flush())
+
+	// The one in flight is always fired again.
+	tw := New(time.Millisecond, 8, WithTaskRegistry(r), WithPastPolicy(PastSkip))
+	n, err := tw.RestoreFrom(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	tw = New(time.Millisecond, 8, WithTaskRegistry(r), WithPastPolicy(PastReject))
+	n, err = tw.RestoreFrom(bytes.NewReader(buf.Bytes()))
+	require.True(t, errors.Is(err, ErrExpired))
+	require.Equal(t, 0, n)
+}
+
+func TestTimeWheel_RestoreFrom_Unknown(t *testing.T) {
+	r := NewTaskRegistry()
+	r.Register("noop", func(context.Context, []byte) {})
+	expiration := time.Now().Add(time.Hour).UnixNano()
+	unknown := []byte{0x78, 0x01}
+
+	var buf bytes.Buffer
+	w := timerspec.NewWriter(&buf)
+	require.NoError(t, w.Write(&timerspec.Spec{Expiration: expiration, Task: "noop", Unknown: unknown}))
+	require.NoError(t, w.Write(&timerspec.Spec{Expiration: expiration, Task: "missing"}))
+	require.NoError(t, w.Flush())
+
+	tw := New(time.Millisecond, 8, WithTaskRegistry(r))
+	defer tw.Stop()
+
+	n, err := tw.RestoreFrom(&buf)
+	require.True(t, errors.Is(err, ErrUnknownTask))
+	require.Equal(t, 1, n)
+
+	// The unknown fields survive through the TimeWheel.
+	buf.Reset()
+	n, err = tw.SaveTo(&buf)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	spec, err := timerspec.NewReader(&buf).Read()
+	require.NoError(t, err)
+	require.Equal(t, unknown, spec.Unknown)
+	require.Equal(t, expiration, spec.Expiration)
+}
+
+func TestTimeWheel_RestoreFrom_Recurrence(t *testing.T) {
+	var buf bytes.Buffer
+	w := timerspec.NewWriter(&buf)
+	require.NoError(t, w.Write(&timerspec.Spec{Task: "noop", Recurrence: "@hourly"}))
+	require.NoError(t, w.Flush())
+
+	tw := New(time.Millisecond, 8, WithTaskRegistry(NewTaskRegistry()))
+	defer tw.Stop()
+
+	_, err := tw.RestoreFrom(&buf)
+	require.True(t, errors.Is(err, ErrInvalidSchedule))
+}
+
+func TestTimeWheel_SaveTo_Moving(t *testing.T) {
+	r := NewTaskRegistry()
+	r.Register("noop", func(context.Context, []byte) {})
+	tw := New(time.Millisecond, 8, WithTaskRegistry(r))
+	tw.Start()
+	defer tw.Stop()
+
+	const count = 2000
+	timers := make([]*Timer, count)
+	for i := range timers {
+		var err error
+		timers[i], err = tw.AfterTask(time.Hour+time.Duration(i)*time.Second, "noop", nil)
+		require.NoError(t, err)
+	}
+
+	// Move the timers between the buckets and the levels meanwhile.
+	stopC, doneC := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(doneC)
+		rnd := rand.New(rand.NewSource(1))
+		for {
+			select {
+			case <-stopC:
+				return
+			default:
+			}
+			timers[rnd.Intn(count)].Reset(time.Hour + time.Duration(rnd.Intn(count))*time.Second)
+			runtime.Gosched()
+		}
+	}()
+	defer func() {
+		close(stopC)
+		<-doneC
+	}()
+
+	for i := 0; i < 20; i++ {
+		var buf bytes.Buffer
+		n, err := tw.SaveTo(&buf)
+		require.NoError(t, err)
+		require.Equal(t, count, n)
+
+		// Each timer is written exactly once.
+		ids := make(map[uint64]bool, count)
+		sr := timerspec.NewReader(&buf)
+		for {
+			spec, err := sr.Read()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			require.NoError(t, err)
+			require.False(t, ids[spec.ID], spec.ID)
+			ids[spec.ID] = true
+		}
+		require.Len(t, ids, count)
+	}
+}
+
+// BenchmarkSaveTo_AddLatency measures the latency of AfterTask while SaveTo of
+// 5M pending timers is running over and over, and reports its p99.
+func BenchmarkSaveTo_AddLatency(b *testing.B) {
+	const pending = 5000000
+	r := NewTaskRegistry()
+	r.Register("noop", func(context.Context, []byte) {})
+	tw := New(time.Millisecond, 512, WithTaskRegistry(r))
+	tw.Start()
+	defer tw.Stop()
+	for i := 0; i < pending; i++ {
+		if _, err := tw.AfterTask(time.Hour+time.Duration(i)*time.Microsecond, "noop", nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	stopC, doneC := make(chan struct{}), make(chan struct{})
+	var saves int
+	go func() {
+		defer close(doneC)
+		for {
+			select {
+			case <-stopC:
+				return
+			default:
+			}
+			if _, err := tw.SaveTo(io.Discard); err != nil {
+				b.Error(err)
+				return
+			}
+			saves++
+		}
+	}()
+
+	latencies := make([]time.Duration, b.N)
+	b.ResetTimer()
+	for i := range latencies {
+		d := time.Duration(i%pending) * time.Microsecond
+		start := time.Now()
+		timer, _ := tw.AfterTask(time.Hour+d, "noop", nil)
+		latencies[i] = time.Since(start)
+		timer.Close()
+	}
+	b.StopTimer()
+	close(stopC)
+	<-doneC
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	b.ReportMetric(float64(latencies[len(latencies)*99/100].Nanoseconds()), "p99-ns")
+	b.ReportMetric(float64(latencies[len(latencies)-1].Nanoseconds()), "max-ns")
+	b.ReportMetric(float64(saves), "saves")
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// WorkerPool is a bounded Executor, a fixed number of worker goroutines run
+// the tasks from a run queue of a fixed capacity. A task is refused with
+// ErrPoolFull once the queue is full, it's dropped by the TimeWheel then, see
+// WithExecutor: it's counted by Stats.Dropped, passed to the OnDrop and logged
+// by the WithLogger, and kept in the dead letter buffer to be driven again
+// later if the WithDeadLetterBuffer is set.
+//
+// The occupancy of the queue is reported by Stats.ExecutorQueue and
+// Stats.ExecutorQueueHighWater, to size the pool from the data. Like the
+// DispatchGoroutine, the panic of a task is not recovered.
+type WorkerPool struct {
+	// The maximum number of the queued tasks, it's accessed atomically and
+	// placed first to be 64-bit aligned on 32-bit platforms.
+	highWater int64
+
+	tasks chan func()
+	wg    sync.WaitGroup
+
+	// The mu makes the Close exclusive with the sending to the tasks.
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewWorkerPool creates a WorkerPool of the workers goroutines and a run queue
+// of the capacity queue, the queue of 0 accepts a task only if a worker is
+// idle. It panics if the workers is less than 1 or the queue is negative.
+func NewWorkerPool(workers, queue int) *WorkerPool {
+	if workers < 1 {
+		panic("timewheel: workers of pool must be greater than 0")
+	}
+	if queue < 0 {
+		panic("timewheel: queue of pool must not be negative")
+	}
+	p := &WorkerPool{tasks: make(chan func(), queue)}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *WorkerPool) work() {
+	defer p.wg.Done()
+	for f := range p.tasks {
+		f()
+	}
+}
+
+// Execute implements the Executor, it returns ErrPoolFull if the run queue is
+// full, or ErrPoolClosed if the pool is closed.
+func (p *WorkerPool) Execute(f func()) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return ErrPoolClosed
+	}
+	select {
+	case p.tasks <- f:
+	default:
+		return ErrPoolFull
+	}
+	n := int64(len(p.tasks))
+	for {
+		high := atomic.LoadInt64(&p.highWater)
+		if n <= high || atomic.CompareAndSwapInt64(&p.highWater, high, n) {
+			return nil
+		}
+	}
+}
+
+// QueueLen returns the number of the tasks in the run queue.
+func (p *WorkerPool) QueueLen() int {
+	return len(p.tasks)
+}
+
+// QueueHighWater returns the maximum QueueLen since the pool is created.
+func (p *WorkerPool) QueueHighWater() int {
+	return int(atomic.LoadInt64(&p.highWater))
+}
+
+// Close refuses the further tasks, and waits for the queued ones to return.
+// Close the pool after the TimeWheel is stopped, since the tasks of the timers
+// expired after Close are dropped.
+func (p *WorkerPool) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.tasks)
+	p.mu.Unlock()
+	p.wg.Wait()
+}
@@ -0,0 +1,75 @@
+package timewheel
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkerPool(t *testing.T) {
+	p := NewWorkerPool(1, 2)
+	startedC, releaseC := make(chan struct{}), make(chan struct{})
+	var ran int32
+	require.NoError(t, p.Execute(func() {
+		close(startedC)
+		<-releaseC
+		atomic.AddInt32(&ran, 1)
+	}))
+	<-startedC
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, p.Execute(func() { atomic.AddInt32(&ran, 1) }))
+	}
+	require.True(t, errors.Is(p.Execute(func() {}), ErrPoolFull))
+	require.Equal(t, 2, p.QueueLen())
+	require.Equal(t, 2, p.QueueHighWater())
+
+	// Close waits for the queued tasks.
+	close(releaseC)
+	p.Close()
+	require.Equal(t, int32(3), atomic.LoadInt32(&ran))
+	require.Equal(t, 0, p.QueueLen())
+	require.Equal(t, 2, p.QueueHighWater())
+	require.True(t, errors.Is(p.Execute(func() {}), ErrPoolClosed))
+	p.Close()
+
+	require.Panics(t, func() { NewWorkerPool(0, 1) })
+	require.Panics(t, func() { NewWorkerPool(1, -1) })
+}
+
+func TestWithExecutor_WorkerPool(t *testing.T) {
+	p := NewWorkerPool(1, 1)
+	defer p.Close()
+	droppedC := make(chan *Timer, 2)
+	tw := New(time.Millisecond, 8, WithExecutor(p), WithDeadLetterBuffer(8), OnDrop(func(timer *Timer, err error) {
+		require.True(t, errors.Is(err, ErrPoolFull))
+		droppedC <- timer
+	}))
+	tw.Start()
+	defer tw.Stop()
+
+	// The expired timers are handed to the pool by the caller, in order.
+	startedC, releaseC := make(chan struct{}), make(chan struct{})
+	defer close(releaseC)
+	tw.AfterFunc(-time.Millisecond, func() {
+		close(startedC)
+		<-releaseC
+	})
+	<-startedC
+	tw.AfterFunc(-time.Millisecond, func() {})
+	dropped := tw.AfterFunc(-time.Millisecond, func() { t.Error("executed") })
+
+	require.Equal(t, dropped, <-droppedC)
+	require.Equal(t, EndDropped, dropped.EndReason())
+	s := tw.Stats()
+	require.Equal(t, uint64(1), s.Dropped)
+	require.Equal(t, 1, s.ExecutorQueue)
+	require.Equal(t, 1, s.ExecutorQueueHighWater)
+	// Kept to be driven again once the pool has room.
+	letters := tw.DeadLetters()
+	require.Len(t, letters, 1)
+	require.Equal(t, dropped.ID(), letters[0].ID)
+}
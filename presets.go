@@ -0,0 +1,43 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"time"
+)
+
+// ForConnectionTimeouts creates a TimeWheel for the timeouts of connections
+// and requests, e.g. the idle, read and handshake timeouts, that range from
+// seconds to minutes and are mostly reset or closed before expired.
+//
+// The tick is 100ms, which is fine enough for such timeouts and keeps the
+// consumer goroutine quiet, and the size is 4096, thus the delays up to about
+// 6.8 minutes stay in the root level: a Reset never moves a timer between the
+// levels, and no timer is ever cascaded down. See also NewDeadlineManager.
+func ForConnectionTimeouts(opts ...Option) *TimeWheel {
+	return New(time.Millisecond*100, 4096, opts...)
+}
+
+// ForSubSecondScheduling creates a TimeWheel for the delays that must be
+// precise to the millisecond, e.g. retries, debouncing and rate limiting.
+//
+// The tick is 1ms, the minimum, and the size is 65536, thus the delays under
+// about 65 seconds stay in the root level and are fired by the tick they're
+// due, without being cascaded down from a coarser level.
+func ForSubSecondScheduling(opts ...Option) *TimeWheel {
+	return New(time.Millisecond, 65536, opts...)
+}
+
+// ForJobScheduling creates a TimeWheel for the jobs that are due in minutes to
+// days, e.g. the reminders and the periodic tasks, see Every and Repeating.
+//
+// The tick is 1s, since such jobs don't care for the sub-second precision, and
+// the size is 512, thus the delays under about 8.5 minutes stay in the root
+// level, and the ones under about 3 days (512^2 seconds) in the first two
+// levels; a job due tomorrow is cascaded down once.
+func ForJobScheduling(opts ...Option) *TimeWheel {
+	return New(time.Second, 512, opts...)
+}
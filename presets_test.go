@@ -0,0 +1,63 @@
+package timewheel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// levelOf returns the level of the wheel that the timer t is in, or -1.
+func levelOf(tw *TimeWheel, t *Timer) int {
+	b := t.getBucket()
+	for level, l := 0, tw.root; l != nil; level, l = level+1, l.getOverflow() {
+		for i := range l.buckets {
+			if l.loadBucket(int64(i)) == b {
+				return level
+			}
+		}
+	}
+	return -1
+}
+
+func TestPresets(t *testing.T) {
+	cases := []struct {
+		name   string
+		create func(opts ...Option) *TimeWheel
+		tick   time.Duration
+		levels map[time.Duration]int
+	}{
+		{"ForConnectionTimeouts", ForConnectionTimeouts, time.Millisecond * 100, map[time.Duration]int{
+			time.Second:      0,
+			time.Second * 30: 0,
+			time.Minute * 5:  0,
+			time.Minute * 10: 1,
+		}},
+		{"ForSubSecondScheduling", ForSubSecondScheduling, time.Millisecond, map[time.Duration]int{
+			time.Millisecond * 10:  0,
+			time.Millisecond * 500: 0,
+			time.Second * 59:       0,
+			time.Minute * 2:        1,
+		}},
+		{"ForJobScheduling", ForJobScheduling, time.Second, map[time.Duration]int{
+			time.Minute:     0,
+			time.Minute * 5: 0,
+			time.Hour:       1,
+			time.Hour * 24:  1,
+			time.Hour * 96:  2,
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tw := c.create(WithName(c.name))
+			defer tw.Stop()
+			require.Contains(t, tw.String(), c.name)
+			require.Equal(t, int64(c.tick), tw.tick)
+			for d, level := range c.levels {
+				timer := tw.AfterFunc(d, func() {})
+				require.Equal(t, level, levelOf(tw, timer), d.String())
+				timer.Close()
+			}
+		})
+	}
+}
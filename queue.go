@@ -0,0 +1,248 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"container/heap"
+	"log/slog"
+	"math"
+	"sync"
+
+	"github.com/yu31/dqueue"
+)
+
+// bucketQueue is a delay queue that carries buckets, it wraps the DQueue
+// to provide a typed API, thus the TimeWheel never deal with the dqueue.Message.
+//
+// The bucket pointer stored in dqueue.Message.Value is no allocation since the
+// pointer fits into the interface word directly.
+type bucketQueue struct {
+	// It's nil if the TimeWheel is driven by a Clock, see WithClock.
+	dq *dqueue.DQueue
+	// The buckets offered while the dq is nil, they're taken by Poll in the
+	// order they expire. The pollMu serializes the Poll.
+	manual *bucketHeap
+	pollMu *sync.Mutex
+	// The expirations offered to the dq that not consumed yet, since the DQueue
+	// can't be peeked. It's nil for the manual queue, see peek.
+	offered *bucketHeap
+
+	// The handler for messages that not enqueued by the TimeWheel if the
+	// DQueue is shared with others. It may be nil.
+	foreign func(msg *dqueue.Message)
+
+	// The logger of the TimeWheel, it may be nil.
+	logger *slog.Logger
+
+	// The mu protects the closed, to make sure no bucket is offered to the
+	// DQueue during or after it's closing.
+	mu     *sync.RWMutex
+	closed bool
+}
+
+// newBucketQueue creates a bucketQueue that wraps dq, or a manual one that
+// is drained by Poll if dq is nil.
+func newBucketQueue(dq *dqueue.DQueue, foreign func(msg *dqueue.Message)) *bucketQueue {
+	q := &bucketQueue{dq: dq, foreign: foreign, mu: new(sync.RWMutex), closed: false}
+	if dq == nil {
+		q.manual = &bucketHeap{mu: new(sync.Mutex)}
+		q.pollMu = new(sync.Mutex)
+	} else {
+		q.offered = &bucketHeap{mu: new(sync.Mutex)}
+	}
+	return q
+}
+
+// offer adds the bucket b with the given expiration to the queue.
+// The b is discarded if the queue has been closed.
+func (q *bucketQueue) offer(b *bucket, expiration int64) {
+	q.mu.RLock()
+	closed := q.closed
+	if !closed {
+		if q.manual != nil {
+			q.manual.push(b, expiration)
+		} else {
+			q.offered.push(b, expiration)
+			q.dq.Expire(expiration, b)
+		}
+	}
+	q.mu.RUnlock()
+
+	if closed && q.logger != nil {
+		q.logger.Warn("timewheel: bucket enqueued after close", slog.Int64("expiration", expiration))
+	}
+}
+
+// consume register a func in its own goroutine to consume the expired buckets.
+// The f receives the expiration that the bucket was offered with, it may be
+// earlier than the current expiration of the bucket if the bucket was reused
+// for a later rotation after offered.
+//
+// It does nothing for the manual queue, whose buckets are taken by Poll.
+func (q *bucketQueue) consume(f func(b *bucket, expiration int64)) {
+	if q.manual != nil {
+		return
+	}
+	q.dq.Consume(func(msg *dqueue.Message) {
+		if b, ok := msg.Value.(*bucket); ok {
+			f(b, msg.Expiration)
+			// The DQueue yields in the order of expiration, but the next one
+			// may have been taken while f was offering an earlier one, e.g. a
+			// lower-level bucket during the catch-up. Thus the earliest is
+			// removed rather than the consumed one, it keeps the number of
+			// the expirations not consumed that are at or before any time,
+			// see overdue. It's removed after the timers of b are flushed, so
+			// that they're covered by peek in the meantime.
+			q.offered.pop(math.MaxInt64)
+			return
+		}
+		// The message is not enqueued by the TimeWheel since the DQueue is shared
+		// with others, pass it to the foreign handler or drop it.
+		if q.foreign != nil {
+			q.foreign(msg)
+			return
+		}
+		if q.logger != nil {
+			q.logger.Warn("timewheel: foreign message dropped", slog.Any("value", msg.Value))
+		}
+	})
+}
+
+// close closes the queue and waits for the consumer goroutine to exit.
+// It's safe to call close multiple times.
+func (q *bucketQueue) close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	q.mu.Unlock()
+
+	if q.manual == nil {
+		q.dq.Close()
+	}
+}
+
+// len returns the number of buckets in the queue.
+func (q *bucketQueue) len() int {
+	if q.manual != nil {
+		return q.manual.len()
+	}
+	return q.dq.Len()
+}
+
+// peek returns the earliest expiration of the buckets in the queue, the ok is
+// false if the queue is empty.
+func (q *bucketQueue) peek() (expiration int64, ok bool) {
+	if q.manual != nil {
+		return q.manual.peek()
+	}
+	return q.offered.peek()
+}
+
+// nextOverdue returns the expiration of the next bucket other than the one
+// being processed, ok is false unless it has expired at now as well. It's
+// called by the consumer during the processing.
+func (q *bucketQueue) nextOverdue(now int64) (expiration int64, ok bool) {
+	if q.manual != nil {
+		// The one being processed has been popped by Poll.
+		expiration, ok = q.manual.peek()
+	} else {
+		// The one being processed is counted by the offered until it's
+		// flushed, and it has expired, see consume.
+		expiration, ok = q.offered.peekSecond()
+	}
+	return expiration, ok && expiration <= now
+}
+
+// bucketHeap is a min-heap of the offered buckets ordered by the expiration,
+// it's safe for concurrent use.
+type bucketHeap struct {
+	mu      *sync.Mutex
+	entries bucketEntries
+}
+
+// bucketEntry is a bucket with the expiration that it was offered with.
+type bucketEntry struct {
+	b          *bucket
+	expiration int64
+}
+
+func (h *bucketHeap) push(b *bucket, expiration int64) {
+	h.mu.Lock()
+	// Not by heap.Push, since boxing the entry into an interface allocates.
+	h.entries = append(h.entries, bucketEntry{b: b, expiration: expiration})
+	heap.Fix(&h.entries, len(h.entries)-1)
+	h.mu.Unlock()
+}
+
+// pop removes and returns the earliest bucket if it's expired at now.
+func (h *bucketHeap) pop(now int64) (*bucket, int64, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.entries) == 0 || h.entries[0].expiration > now {
+		return nil, 0, false
+	}
+	e := h.entries[0]
+	n := len(h.entries) - 1
+	h.entries.Swap(0, n)
+	h.entries[n] = bucketEntry{}
+	h.entries = h.entries[:n]
+	if n > 0 {
+		heap.Fix(&h.entries, 0)
+	}
+	return e.b, e.expiration, true
+}
+
+// peek returns the earliest expiration, the ok is false if the heap is empty.
+func (h *bucketHeap) peek() (expiration int64, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.entries) == 0 {
+		return 0, false
+	}
+	return h.entries[0].expiration, true
+}
+
+// peekSecond returns the earliest expiration but the root, the ok is false
+// if the heap has only one bucket or less.
+func (h *bucketHeap) peekSecond() (expiration int64, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch n := len(h.entries); {
+	case n < 2:
+		return 0, false
+	case n == 2 || h.entries[1].expiration <= h.entries[2].expiration:
+		return h.entries[1].expiration, true
+	default:
+		return h.entries[2].expiration, true
+	}
+}
+
+func (h *bucketHeap) len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.entries)
+}
+
+// bucketEntries implements the heap.Interface.
+type bucketEntries []bucketEntry
+
+func (es bucketEntries) Len() int           { return len(es) }
+func (es bucketEntries) Less(i, j int) bool { return es[i].expiration < es[j].expiration }
+func (es bucketEntries) Swap(i, j int)      { es[i], es[j] = es[j], es[i] }
+
+func (es *bucketEntries) Push(x interface{}) { *es = append(*es, x.(bucketEntry)) }
+
+func (es *bucketEntries) Pop() interface{} {
+	old := *es
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = bucketEntry{}
+	*es = old[:n-1]
+	return e
+}
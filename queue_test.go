@@ -0,0 +1,117 @@
+package timewheel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/yu31/dqueue"
+)
+
+func Test_bucketQueue(t *testing.T) {
+	q := newBucketQueue(dqueue.Default(), nil)
+
+	retC := make(chan *bucket, 2)
+	q.consume(func(b *bucket, expiration int64) {
+		require.Equal(t, b.getExpiration(), expiration)
+		retC <- b
+	})
+	defer q.close()
+
+	// Non-bucket values are dropped rather than panic in the consumer.
+	q.dq.After(0, "foreign")
+
+	b := newBucket()
+	b.setExpiration(time.Now().UnixNano())
+	q.offer(b, b.getExpiration())
+
+	require.Equal(t, <-retC, b)
+	require.Equal(t, q.len(), 0)
+
+	select {
+	case <-retC:
+		t.Fatal("unexpected bucket")
+	case <-time.After(time.Millisecond * 10):
+	}
+}
+
+func Test_bucketQueue_foreign(t *testing.T) {
+	msgC := make(chan *dqueue.Message, 1)
+	q := newBucketQueue(dqueue.Default(), func(msg *dqueue.Message) { msgC <- msg })
+
+	q.consume(func(b *bucket, _ int64) { t.Fatal("unexpected bucket") })
+	defer q.close()
+
+	q.dq.After(0, "foreign")
+	require.Equal(t, (<-msgC).Value, "foreign")
+}
+
+func Test_bucketQueue_close(t *testing.T) {
+	q := newBucketQueue(dqueue.Default(), nil)
+	q.consume(func(b *bucket, _ int64) {})
+
+	q.close()
+	require.NotPanics(t, func() {
+		q.close()
+	})
+
+	// Offer after closed is discarded.
+	b := newBucket()
+	b.setExpiration(time.Now().UnixNano())
+	q.offer(b, b.getExpiration())
+	require.Equal(t, q.len(), 0)
+}
+
+func Test_bucketQueue_manual(t *testing.T) {
+	q := newBucketQueue(nil, nil)
+	q.consume(func(b *bucket, _ int64) { t.Fatal("unexpected consumer") })
+
+	b1, b2, b3 := newBucket(), newBucket(), newBucket()
+	q.offer(b2, 20)
+	q.offer(b1, 10)
+	q.offer(b3, 30)
+	require.Equal(t, 3, q.len())
+
+	expiration, ok := q.manual.peek()
+	require.True(t, ok)
+	require.Equal(t, int64(10), expiration)
+
+	b, expiration, ok := q.manual.pop(20)
+	require.True(t, ok)
+	require.Equal(t, b1, b)
+	require.Equal(t, int64(10), expiration)
+	b, _, ok = q.manual.pop(20)
+	require.True(t, ok)
+	require.Equal(t, b2, b)
+	_, _, ok = q.manual.pop(20)
+	require.False(t, ok)
+
+	q.close()
+	q.offer(b1, 40)
+	require.Equal(t, 1, q.len())
+}
+
+func Test_bucketQueue_peek(t *testing.T) {
+	q := newBucketQueue(dqueue.Default(), nil)
+	retC := make(chan *bucket, 2)
+	q.consume(func(b *bucket, expiration int64) { retC <- b })
+	defer q.close()
+
+	_, ok := q.peek()
+	require.False(t, ok)
+
+	now := time.Now().UnixNano()
+	b1, b2 := newBucket(), newBucket()
+	q.offer(b2, now+int64(time.Hour))
+	q.offer(b1, now+int64(time.Millisecond))
+	expiration, ok := q.peek()
+	require.True(t, ok)
+	require.Equal(t, now+int64(time.Millisecond), expiration)
+
+	// The consumed bucket is removed.
+	require.Equal(t, b1, <-retC)
+	require.Eventually(t, func() bool {
+		expiration, ok = q.peek()
+		return ok && expiration == now+int64(time.Hour)
+	}, time.Second, time.Millisecond)
+}
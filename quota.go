@@ -0,0 +1,136 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// quota limits the number of pending timers with a tag.
+type quota struct {
+	max  int64 // negative means unlimited.
+	used int64
+}
+
+// quotaTable holds the quotas of the tags.
+type quotaTable struct {
+	// The number of quotas that ever set, the lookup is skipped if it's zero.
+	n int32
+
+	mu     sync.RWMutex
+	quotas map[string]*quota
+}
+
+func newQuotaTable() *quotaTable {
+	return &quotaTable{quotas: make(map[string]*quota)}
+}
+
+// lookup returns the quota of the tag, or nil if not set.
+func (qt *quotaTable) lookup(tag string) *quota {
+	if atomic.LoadInt32(&qt.n) == 0 {
+		return nil
+	}
+	qt.mu.RLock()
+	q := qt.quotas[tag]
+	qt.mu.RUnlock()
+	return q
+}
+
+// SetQuota limits the number of pending timers with the tag (see WithTag) to
+// max, the scheduling funcs reject a new timer with the tag once the limit is
+// reached, and the timers with other tags are unaffected. A negative max
+// removes the limit.
+//
+// A timer counts toward the quota from it's scheduled until it's fired or
+// closed, a recurring timer counts as one until its execution plan ends. The
+// timers already scheduled are never rejected by lowering the max.
+//
+// The rejected timer is returned finished with EndRejected by the scheduling
+// funcs that return no error, such as AfterFunc. The others, such as AfterTask
+// and Recurrence.Do, return a *ScheduleError that wraps ErrQuotaExceeded.
+func (tw *TimeWheel) SetQuota(tag string, max int64) {
+	if max < 0 {
+		max = -1
+	}
+	qt := tw.root.quotas
+	qt.mu.Lock()
+	defer qt.mu.Unlock()
+	if q, ok := qt.quotas[tag]; ok {
+		atomic.StoreInt64(&q.max, max)
+		return
+	}
+	qt.quotas[tag] = &quota{max: max}
+	atomic.AddInt32(&qt.n, 1)
+}
+
+// QuotaUsage returns the number of pending timers counted toward the quota of
+// the tag, and its max. The max is -1 if no quota is set for the tag.
+func (tw *TimeWheel) QuotaUsage(tag string) (used, max int64) {
+	q := tw.root.quotas.lookup(tag)
+	if q == nil {
+		return 0, -1
+	}
+	return atomic.LoadInt64(&q.used), atomic.LoadInt64(&q.max)
+}
+
+// admit checks the rate and the limits of the pending and acquires the quota
+// of the new timer t, it returns ErrDelayTooLarge, ErrRateLimited, ErrFull,
+// ErrShed, ErrQuotaExceeded or ErrScopeClosed if rejected.
+func (tw *TimeWheel) admit(t *Timer) error {
+	root := tw.root
+	if limit, now := int64(tw.MaxDelay()), root.now(); t.getExpiration()-now > limit {
+		if !root.opts.delayClamp {
+			return ErrDelayTooLarge
+		}
+		t.setExpiration(now + limit)
+	}
+	if err := tw.admitRate(t); err != nil {
+		return err
+	}
+	if err := tw.admitLoad(t); err != nil {
+		return err
+	}
+	if err := tw.admitQuota(t); err != nil {
+		return err
+	}
+	if s := t.getAttrs().scope; s != nil && !s.add(t) {
+		return ErrScopeClosed
+	}
+	return nil
+}
+
+// admitQuota acquires the quota of the tag of the new timer t if any.
+func (tw *TimeWheel) admitQuota(t *Timer) error {
+	q := tw.root.quotas.lookup(t.Tag())
+	if q == nil {
+		return nil
+	}
+	for {
+		used, max := atomic.LoadInt64(&q.used), atomic.LoadInt64(&q.max)
+		if max >= 0 && used >= max {
+			return ErrQuotaExceeded
+		}
+		if atomic.CompareAndSwapInt64(&q.used, used, used+1) {
+			break
+		}
+	}
+	a := t.setAttrs()
+	a.quota = q
+	a.quotaHeld = 1
+	return nil
+}
+
+// releaseQuota releases the quota held by t, only the first call takes effect.
+func (t *Timer) releaseQuota() {
+	a := t.attrs
+	if a == nil || a.quota == nil {
+		return
+	}
+	if atomic.CompareAndSwapInt32(&a.quotaHeld, 1, 0) {
+		atomic.AddInt64(&a.quota.used, -1)
+	}
+}
@@ -0,0 +1,128 @@
+package timewheel
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeWheel_SetQuota(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	used, max := tw.QuotaUsage("a")
+	require.Equal(t, int64(0), used)
+	require.Equal(t, int64(-1), max)
+
+	tw.SetQuota("a", 2)
+	t1 := tw.AfterFunc(time.Hour, func() {}, WithTag("a"))
+	t2 := tw.AfterFunc(time.Hour, func() {}, WithTag("a"))
+	t3 := tw.AfterFunc(time.Hour, func() {}, WithTag("a"))
+	used, max = tw.QuotaUsage("a")
+	require.Equal(t, int64(2), used)
+	require.Equal(t, int64(2), max)
+
+	// The exceeded one is finished without running.
+	<-t3.Done()
+	require.Equal(t, EndRejected, t3.EndReason())
+	require.Equal(t, StateCancelled, t3.State())
+	require.True(t, errors.Is(t3.rejected(), ErrQuotaExceeded))
+	require.Equal(t, uint64(1), tw.Stats().Rejected)
+	require.Equal(t, int64(2), tw.Pending())
+
+	// The other tags are unaffected.
+	for i := 0; i < 10; i++ {
+		tw.AfterFunc(time.Hour, func() {}, WithTag("b"))
+		tw.AfterFunc(time.Hour, func() {})
+	}
+	require.Equal(t, int64(22), tw.Pending())
+
+	// Decreased on cancel.
+	t1.Close()
+	used, _ = tw.QuotaUsage("a")
+	require.Equal(t, int64(1), used)
+	t4 := tw.AfterFunc(time.Millisecond, func() {}, WithTag("a"))
+	require.Equal(t, EndNone, t4.EndReason())
+
+	// Decreased on fire.
+	<-t4.Done()
+	used, _ = tw.QuotaUsage("a")
+	require.Equal(t, int64(1), used)
+
+	// Removed.
+	tw.SetQuota("a", -5)
+	for i := 0; i < 3; i++ {
+		require.Equal(t, EndNone, tw.AfterFunc(time.Hour, func() {}, WithTag("a")).EndReason())
+	}
+	used, max = tw.QuotaUsage("a")
+	require.Equal(t, int64(4), used)
+	require.Equal(t, int64(-1), max)
+
+	// Lowered below the usage.
+	tw.SetQuota("a", 1)
+	require.Equal(t, EndRejected, tw.AfterFunc(time.Hour, func() {}, WithTag("a")).EndReason())
+	t2.Close()
+	used, _ = tw.QuotaUsage("a")
+	require.Equal(t, int64(3), used)
+}
+
+func TestTimeWheel_SetQuota_Levels(t *testing.T) {
+	tw := New(time.Millisecond, 4)
+	tw.Start()
+	defer tw.Stop()
+	tw.SetQuota("a", 1)
+
+	// The timer migrates from an overflow wheel down to the root before fired.
+	timer := tw.AfterFunc(time.Millisecond*50, func() {}, WithTag("a"))
+	require.Greater(t, tw.Stats().Levels, 2)
+	for timer.State() == StateScheduled {
+		used, _ := tw.QuotaUsage("a")
+		require.Equal(t, int64(1), used)
+		require.Equal(t, EndRejected, tw.AfterFunc(time.Hour, func() {}, WithTag("a")).EndReason())
+		time.Sleep(time.Millisecond * 5)
+	}
+	<-timer.Done()
+	used, _ := tw.QuotaUsage("a")
+	require.Equal(t, int64(0), used)
+}
+
+func TestTimeWheel_SetQuota_Recurring(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+	tw.SetQuota("a", 1)
+
+	timer, err := tw.Every(time.Millisecond).Times(5).Do(func() {}, WithTag("a"))
+	require.NoError(t, err)
+
+	// Counted as one until the plan ends.
+	_, err = tw.Every(time.Millisecond).Do(func() {}, WithTag("a"))
+	require.True(t, errors.Is(err, ErrQuotaExceeded))
+	var se *ScheduleError
+	require.True(t, errors.As(err, &se))
+	require.Equal(t, "a", se.Tag)
+
+	_, err = OnDays(time.Monday).At("09:00").Do(tw, func() {}, WithTag("a"))
+	require.True(t, errors.Is(err, ErrQuotaExceeded))
+
+	<-timer.Done()
+	used, _ := tw.QuotaUsage("a")
+	require.Equal(t, int64(0), used)
+}
+
+func TestTimeWheel_SetQuota_Task(t *testing.T) {
+	r := NewTaskRegistry()
+	r.Register("noop", func(context.Context, []byte) {})
+	tw := New(time.Millisecond, 8, WithTaskRegistry(r))
+	defer tw.Stop()
+	tw.SetQuota("a", 0)
+
+	_, err := tw.AfterTask(time.Hour, "noop", nil, WithTag("a"))
+	require.True(t, errors.Is(err, ErrQuotaExceeded))
+	_, err = tw.AfterTask(time.Hour, "noop", nil)
+	require.NoError(t, err)
+}
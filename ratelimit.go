@@ -0,0 +1,141 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// WithScheduleRateLimit limits the rate of the new timers to r per second,
+// with the bursts of at most burst timers, like a token bucket. The timer
+// beyond the rate is rejected with ErrRateLimited, and it's counted in
+// Stats.RateLimited as well as Stats.Rejected, see OnReject.
+//
+// It bounds the CPU spent on scheduling by a runaway client, which the limits
+// of the pending don't. The timers with a tag that has its own limit (see
+// SetRateLimit) are not counted toward this one. The executions of a recurring
+// timer that has been admitted are never limited. The rate is measured by the
+// Clock of the TimeWheel.
+//
+// It panics if the r or the burst is not positive.
+func WithScheduleRateLimit(r float64, burst int) Option {
+	// Validates the arguments.
+	newRateLimiter(r, burst)
+	return func(o *options) {
+		o.rateLimit = r
+		o.rateBurst = burst
+	}
+}
+
+// rateLimiter is a lock-free token bucket by the generic cell rate algorithm:
+// it tracks the theoretical arrival time of the next timer, that advances by
+// the interval for each timer admitted, and the timer is admitted only if it
+// arrives no later than the burst of intervals ahead of now.
+type rateLimiter struct {
+	// The theoretical arrival time in nanoseconds, accessed atomically. It's
+	// placed first to be 64-bit aligned on 32-bit platforms.
+	tat int64
+	// The interval between two timers, and the tolerance of the burst, both
+	// are in nanoseconds.
+	interval  int64
+	tolerance int64
+}
+
+func newRateLimiter(r float64, burst int) *rateLimiter {
+	if !(r > 0) || burst < 1 {
+		panic("timewheel: rate and burst of rate limit must be greater than 0")
+	}
+	interval := math.Ceil(1e9 / r)
+	if interval >= math.MaxInt64/float64(burst) {
+		panic("timewheel: rate of rate limit is too small")
+	}
+	i := int64(interval)
+	return &rateLimiter{interval: i, tolerance: i * int64(burst)}
+}
+
+// allow reports whether a timer is admitted at now.
+func (l *rateLimiter) allow(now int64) bool {
+	for {
+		tat := atomic.LoadInt64(&l.tat)
+		next := tat
+		if next < now {
+			next = now
+		}
+		next += l.interval
+		if next-now > l.tolerance {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&l.tat, tat, next) {
+			return true
+		}
+	}
+}
+
+// rateTable holds the rate limits of the tags.
+type rateTable struct {
+	// The number of limits that ever set, the lookup is skipped if it's zero.
+	n int32
+
+	mu     sync.RWMutex
+	limits map[string]*rateLimiter
+}
+
+func newRateTable() *rateTable {
+	return &rateTable{limits: make(map[string]*rateLimiter)}
+}
+
+// lookup returns the rate limit of the tag, or nil if not set.
+func (rt *rateTable) lookup(tag string) *rateLimiter {
+	if atomic.LoadInt32(&rt.n) == 0 {
+		return nil
+	}
+	rt.mu.RLock()
+	l := rt.limits[tag]
+	rt.mu.RUnlock()
+	return l
+}
+
+// SetRateLimit limits the rate of the new timers with the tag (see WithTag) to
+// r per second with the bursts of at most burst timers, like the
+// WithScheduleRateLimit does for all the timers. The timers with the tag are
+// only counted toward this limit, thus the abuse of a tag never throttles the
+// others, and vice versa. A non-positive r removes the limit of the tag, and
+// the timers with the tag fall back to the WithScheduleRateLimit if any.
+//
+// It panics if the r is positive but the burst is not.
+func (tw *TimeWheel) SetRateLimit(tag string, r float64, burst int) {
+	var l *rateLimiter
+	if r > 0 {
+		l = newRateLimiter(r, burst)
+	}
+	rt := tw.root.rates
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if l == nil {
+		delete(rt.limits, tag)
+		return
+	}
+	rt.limits[tag] = l
+	atomic.AddInt32(&rt.n, 1)
+}
+
+// admitRate checks the rate limit of the new timer t.
+func (tw *TimeWheel) admitRate(t *Timer) error {
+	root := tw.root
+	l := root.rates.lookup(t.Tag())
+	if l == nil {
+		if l = root.rateLimit; l == nil {
+			return nil
+		}
+	}
+	if !l.allow(root.now()) {
+		atomic.AddUint64(&root.rateLimited, 1)
+		return ErrRateLimited
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package timewheel
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithScheduleRateLimit(t *testing.T) {
+	require.Panics(t, func() { WithScheduleRateLimit(0, 1) })
+	require.Panics(t, func() { WithScheduleRateLimit(1, 0) })
+
+	clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	var rejected []error
+	tw := New(time.Millisecond, 8, WithClock(clock), WithScheduleRateLimit(10, 3),
+		OnReject(func(t *Timer, err error) { rejected = append(rejected, err) }))
+
+	// The burst is admitted at once, then one per 100ms.
+	for i := 0; i < 3; i++ {
+		require.Equal(t, EndNone, tw.AfterFunc(time.Hour, func() {}).EndReason())
+	}
+	timer := tw.AfterFunc(time.Hour, func() {})
+	require.Equal(t, EndRejected, timer.EndReason())
+	require.True(t, errors.Is(timer.rejected(), ErrRateLimited))
+	_, err := tw.TryAfterFunc(time.Hour, func() {})
+	require.True(t, errors.Is(err, ErrRateLimited))
+
+	clock.add(time.Millisecond * 100)
+	require.Equal(t, EndNone, tw.AfterFunc(time.Hour, func() {}).EndReason())
+	require.Equal(t, EndRejected, tw.AfterFunc(time.Hour, func() {}).EndReason())
+
+	// Refilled up to the burst.
+	clock.add(time.Hour)
+	for i := 0; i < 3; i++ {
+		require.Equal(t, EndNone, tw.AfterFunc(time.Hour, func() {}).EndReason())
+	}
+	require.Equal(t, EndRejected, tw.AfterFunc(time.Hour, func() {}).EndReason())
+
+	stats := tw.Stats()
+	require.Equal(t, uint64(4), stats.RateLimited)
+	require.Equal(t, uint64(4), stats.Rejected)
+	require.Equal(t, int64(7), stats.Pending)
+	require.Equal(t, []error{ErrRateLimited, ErrRateLimited, ErrRateLimited, ErrRateLimited}, rejected)
+}
+
+func TestTimeWheel_SetRateLimit(t *testing.T) {
+	require.Panics(t, func() { New(time.Millisecond, 8).SetRateLimit("a", 1, 0) })
+
+	clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	tw := New(time.Millisecond, 8, WithClock(clock), WithScheduleRateLimit(1, 1))
+	tw.SetRateLimit("abuser", 1, 2)
+	tw.SetRateLimit("vip", 1000, 100)
+
+	// The tags are limited apart from each other, and from the others.
+	for i := 0; i < 2; i++ {
+		require.Equal(t, EndNone, tw.AfterFunc(time.Hour, func() {}, WithTag("abuser")).EndReason())
+	}
+	require.Equal(t, EndRejected, tw.AfterFunc(time.Hour, func() {}, WithTag("abuser")).EndReason())
+	for i := 0; i < 100; i++ {
+		require.Equal(t, EndNone, tw.AfterFunc(time.Hour, func() {}, WithTag("vip")).EndReason())
+	}
+	require.Equal(t, EndNone, tw.AfterFunc(time.Hour, func() {}, WithTag("other")).EndReason())
+	require.Equal(t, EndRejected, tw.AfterFunc(time.Hour, func() {}).EndReason())
+
+	// Falls back to the limit of all once removed.
+	tw.SetRateLimit("abuser", 0, 0)
+	require.Equal(t, EndRejected, tw.AfterFunc(time.Hour, func() {}, WithTag("abuser")).EndReason())
+	require.Equal(t, uint64(3), tw.Stats().RateLimited)
+}
+
+func TestWithScheduleRateLimit_Concurrent(t *testing.T) {
+	clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	tw := New(time.Millisecond, 8, WithClock(clock), WithScheduleRateLimit(1, 100))
+
+	var admitted int64
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if tw.AfterFunc(time.Hour, func() {}).EndReason() == EndNone {
+					atomic.AddInt64(&admitted, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int64(100), admitted)
+	require.Equal(t, uint64(700), tw.Stats().RateLimited)
+}
@@ -0,0 +1,373 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// maxJitter is the upper limit of the jitter factor, it makes sure that the
+// jittered occurrences never reorder.
+const maxJitter = 0.5
+
+// Recurrence builds a recurring schedule fluently, it's created by TimeWheel.Every,
+// e.g.:
+//
+//	timer, err := tw.Every(30 * time.Second).Jitter(0.1).Times(5).Do(f)
+//	timer, err := tw.Every(time.Hour).StartingAt(t).Do(f)
+//
+// It's sugar over the Scheduler, the Do validates the parameters and passes
+// the execution plan to Schedule. A Recurrence can be reused to call Do more
+// than once, but it's not safe for concurrent use.
+type Recurrence struct {
+	tw       *TimeWheel
+	op       string
+	interval time.Duration
+	min, max time.Duration // for RandomSchedule.
+	rnd      *rand.Rand
+	jitter   float64
+	times    int
+	limited  bool // Whether the times is set by Times.
+	until    time.Time
+	start    time.Time
+	catchUp  CatchUpPolicy
+	// Whether the start is the anchor of the occurrences, see Repeating.
+	anchored bool
+	// The anchor of the first execution and its location, see Descriptor.
+	anchor int
+	loc    *time.Location
+	// The error found while building, it's reported by Do.
+	err error
+	// The options applied before the ones passed to Do, see Scope.Every.
+	opts []TimerOption
+
+	onComplete func(ran int)
+}
+
+// CatchUpPolicy decides what to do with the occurrences of a recurrence that
+// are missed, i.e. their nominal time has passed when the next execution is
+// planned, e.g. the TimeWheel was stalled or the StartingAt is in the past.
+type CatchUpPolicy int
+
+const (
+	// CatchUpAll executes each missed occurrence immediately one by one.
+	// It's the default policy.
+	CatchUpAll CatchUpPolicy = iota
+	// CatchUpSkip skips the missed occurrences, the next execution is at the
+	// first nominal time that has not passed. The skipped occurrences don't
+	// count toward the Times.
+	CatchUpSkip
+)
+
+// Every starts building a recurrence that executes every interval d.
+func (tw *TimeWheel) Every(d time.Duration) *Recurrence {
+	return &Recurrence{tw: tw, op: "Every", interval: d}
+}
+
+// RandomSchedule starts building a recurrence that each execution is at a
+// uniformly random point in [min, max] after the previous one, e.g. for the
+// cache refresh and gossip. The min must be >= the tick of the TimeWheel, and
+// <= the max. It can't be combined with Jitter.
+//
+// With CatchUpSkip, a missed execution is skipped by planning the next one at
+// a random point in [min, max] after the time it's noticed.
+func (tw *TimeWheel) RandomSchedule(min, max time.Duration) *Recurrence {
+	return &Recurrence{tw: tw, op: "RandomSchedule", min: min, max: max}
+}
+
+// Rand sets the source of the random numbers used by Jitter and RandomSchedule,
+// e.g. a rand.New(rand.NewSource(seed)) for deterministic tests. Default is the
+// top-level functions of math/rand. The rnd is used by Next of the timer only,
+// thus it must not be shared with the others since a rand.Rand is not safe for
+// concurrent use.
+func (r *Recurrence) Rand(rnd *rand.Rand) *Recurrence {
+	r.rnd = rnd
+	return r
+}
+
+// Jitter randomizes each execution time by up to ±f of the interval, the f
+// must be in [0, 0.5]. It's used to spread the executions of many timers
+// with the same interval. The jitter never accumulates, each execution time
+// is jittered around its nominal time.
+func (r *Recurrence) Jitter(f float64) *Recurrence {
+	r.jitter = f
+	return r
+}
+
+// Times limits the recurrence to execute n times at most, the n must be >= 1.
+func (r *Recurrence) Times(n int) *Recurrence {
+	r.times, r.limited = n, true
+	return r
+}
+
+// Until ends the recurrence before the time t, i.e. no execution is at or
+// after t. It can be combined with Times, the recurrence ends by which
+// comes first.
+//
+// A missed occurrence before t that is noticed after t follows the
+// CatchUpPolicy, i.e. it's executed by CatchUpAll, or ends the recurrence
+// by CatchUpSkip.
+func (r *Recurrence) Until(t time.Time) *Recurrence {
+	r.until = t
+	return r
+}
+
+// CatchUp sets the CatchUpPolicy of the missed occurrences, default is CatchUpAll.
+func (r *Recurrence) CatchUp(p CatchUpPolicy) *Recurrence {
+	r.catchUp = p
+	return r
+}
+
+// OnComplete registers f to be called once the recurrence ends, either all
+// the executions planned by Times or Until have returned, or the timer is
+// closed. The ran is the number of executions that have started, each
+// execution counts toward the Times, thus ran equals to n unless closed.
+//
+// The f is called in the goroutine of the last execution, or of the caller
+// of Timer.Close. If the timer is closed while an execution is running, f may
+// be called before that execution returns.
+func (r *Recurrence) OnComplete(f func(ran int)) *Recurrence {
+	r.onComplete = f
+	return r
+}
+
+// StartingAt sets the nominal time of the first execution, default is one
+// interval after Do is called, or the next boundary if the TimeWheel is
+// aligned by AlignTo.
+func (r *Recurrence) StartingAt(t time.Time) *Recurrence {
+	r.start = t
+	return r
+}
+
+// Do validates the recurrence and schedules f to execute according to it.
+// It returns a *ScheduleError that wraps ErrInvalidSchedule if the parameters
+// are invalid, ErrStopped if the TimeWheel has been stopped, or ErrQuotaExceeded
+// if the quota of the tag is reached (see SetQuota).
+func (r *Recurrence) Do(f func(), opts ...TimerOption) (*Timer, error) {
+	if r.opts != nil {
+		opts = append(r.opts[:len(r.opts):len(r.opts)], opts...)
+	}
+	var start time.Time
+	fail := func(err error) (*Timer, error) {
+		return nil, scheduleError(r.op, start, opts, err)
+	}
+
+	if err := r.validate(); err != nil {
+		return fail(err)
+	}
+
+	sh := &recurrence{
+		interval: r.interval,
+		min:      r.min,
+		max:      r.max,
+		rnd:      r.rnd,
+		jitter:   r.jitter,
+		times:    r.times,
+		until:    r.until,
+		catchUp:  r.catchUp,
+		run:      f,
+		now:      r.tw.timeNow,
+	}
+	now := r.tw.timeNow()
+	start = r.start
+	if start.IsZero() {
+		if r.anchor != anchorNone {
+			start = r.nextAnchor(now)
+		} else if r.tw.root.opts.align > 0 && !sh.random() {
+			// The first partial period of the alignment, see AlignTo.
+			start = r.tw.nextBoundary(now)
+		} else {
+			start = now.Add(sh.step())
+		}
+	}
+	sh.first = start
+	if sh.days = r.anchorDays(); sh.days != 0 {
+		sh.loc = r.location()
+		local := start.In(sh.loc)
+		sh.wall = time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute +
+			time.Duration(local.Second())*time.Second + time.Duration(local.Nanosecond())
+	}
+	if r.anchored {
+		if !sh.fastForward(now) {
+			return fail(fmt.Errorf("%w: all the %d repetitions have passed", ErrInvalidSchedule, r.times))
+		}
+		start = sh.first
+	}
+
+	if !r.until.IsZero() && !r.until.After(start) {
+		return fail(fmt.Errorf("%w: until %s must be after the first execution %s",
+			ErrInvalidSchedule, r.until.Format(time.RFC3339Nano), start.Format(time.RFC3339Nano)))
+	}
+	if r.tw.stoppedNow() {
+		return fail(ErrStopped)
+	}
+
+	if probeOptions(opts).getAttrs().immediate {
+		// The immediate execution is planned by Schedule.
+		sh.planned = 1
+	}
+	if onComplete := r.onComplete; onComplete != nil {
+		opts = append(opts[:len(opts):len(opts)], withOnFinish(func() {
+			onComplete(int(atomic.LoadInt32(&sh.ran)))
+		}))
+	}
+	t := r.tw.Schedule(sh, opts...)
+	if err := t.rejected(); err != nil {
+		return fail(err)
+	}
+	return t, nil
+}
+
+// validate checks the parameters of the recurrence, except the until that
+// depends on the start time.
+func (r *Recurrence) validate() error {
+	if r.err != nil {
+		return r.err
+	}
+	if r.op == "RandomSchedule" {
+		if tick := time.Duration(r.tw.tick); r.min < tick {
+			return fmt.Errorf("%w: min %s must be greater than or equal to the tick %s", ErrInvalidSchedule, r.min, tick)
+		}
+		if r.min > r.max {
+			return fmt.Errorf("%w: min %s must be less than or equal to max %s", ErrInvalidSchedule, r.min, r.max)
+		}
+		if r.jitter != 0 {
+			return fmt.Errorf("%w: jitter can't be combined with random schedule", ErrInvalidSchedule)
+		}
+	} else if r.interval <= 0 {
+		return fmt.Errorf("%w: interval %s must be greater than 0", ErrInvalidSchedule, r.interval)
+	}
+	if r.jitter < 0 || r.jitter > maxJitter {
+		return fmt.Errorf("%w: jitter %v must be in [0, %v]", ErrInvalidSchedule, r.jitter, maxJitter)
+	}
+	if r.times < 0 || r.limited && r.times < 1 {
+		return fmt.Errorf("%w: times %d must be greater than 0", ErrInvalidSchedule, r.times)
+	}
+	return nil
+}
+
+// recurrence is the Scheduler built by Recurrence.
+type recurrence struct {
+	interval time.Duration
+	min, max time.Duration // the random interval is used if max > 0.
+	rnd      *rand.Rand
+	jitter   float64
+	times    int // 0 means no limit.
+	until    time.Time
+	catchUp  CatchUpPolicy
+	first    time.Time
+	// The number of days between the occurrences at the wall time of the day
+	// in the loc, e.g. for the @daily of Descriptor; the interval is fixed if
+	// it's 0.
+	days int
+	wall time.Duration
+	loc  *time.Location
+	run  func()
+	// The clock of the TimeWheel, it's the time.Now if nil.
+	now func() time.Time
+
+	// The following fields are only accessed by Next and endReason, which
+	// are never called concurrently for a timer.
+	//
+	// The nominal time of the next occurrence, it starts from the first.
+	nominal time.Time
+	// The number of executions planned.
+	planned int
+	// The reason why the recurrence ended, it's set once Next returns a zero time.
+	reason EndReason
+
+	// The number of executions that started.
+	ran int32
+}
+
+// timeNow returns the current time of the clock.
+func (r *recurrence) timeNow() time.Time {
+	if r.now == nil {
+		return time.Now()
+	}
+	return r.now()
+}
+
+func (r *recurrence) Next(time.Time) time.Time {
+	if r.times > 0 && r.planned >= r.times {
+		r.reason = EndTimes
+		return time.Time{}
+	}
+
+	if r.nominal.IsZero() {
+		r.nominal = r.first
+	}
+	if r.catchUp == CatchUpSkip {
+		if now := r.timeNow(); now.After(r.nominal) {
+			if r.random() {
+				r.nominal = now.Add(r.step())
+			} else if r.days != 0 {
+				for now.After(r.nominal) {
+					r.nominal = r.advance(r.nominal)
+				}
+			} else {
+				// Skip to the first occurrence that has not passed.
+				late := int64(now.Sub(r.nominal))
+				missed := (late + int64(r.interval) - 1) / int64(r.interval)
+				r.nominal = r.nominal.Add(time.Duration(missed) * r.interval)
+			}
+		}
+	}
+
+	next := r.nominal
+	if r.jitter > 0 {
+		span := int64(float64(r.interval) * r.jitter)
+		next = next.Add(time.Duration(r.int63n(2*span+1) - span))
+	}
+	if !r.until.IsZero() && !next.Before(r.until) {
+		r.reason = EndUntil
+		return time.Time{}
+	}
+	r.nominal = r.advance(r.nominal)
+	r.planned++
+	return next
+}
+
+// advance returns the nominal time of the occurrence after the one at t.
+func (r *recurrence) advance(t time.Time) time.Time {
+	if r.days == 0 {
+		return t.Add(r.step())
+	}
+	y, m, d := t.In(r.loc).Date()
+	return wallTime(y, m, d+r.days, r.wall.Truncate(time.Second), r.loc).Add(r.wall % time.Second)
+}
+
+// random returns whether the recurrence is built by RandomSchedule.
+func (r *recurrence) random() bool {
+	return r.max > 0
+}
+
+// step returns the interval between the nominal times of two occurrences.
+func (r *recurrence) step() time.Duration {
+	if !r.random() {
+		return r.interval
+	}
+	return r.min + time.Duration(r.int63n(int64(r.max-r.min)+1))
+}
+
+func (r *recurrence) int63n(n int64) int64 {
+	if r.rnd != nil {
+		return r.rnd.Int63n(n)
+	}
+	return rand.Int63n(n)
+}
+
+func (r *recurrence) endReason() EndReason {
+	return r.reason
+}
+
+func (r *recurrence) Run() {
+	atomic.AddInt32(&r.ran, 1)
+	r.run()
+}
@@ -0,0 +1,244 @@
+package timewheel
+
+import (
+	"errors"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecurrence_Times(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	var runs int32
+	timer, err := tw.Every(time.Millisecond * 2).Times(3).Do(func() { atomic.AddInt32(&runs, 1) })
+	require.NoError(t, err)
+	<-timer.Done()
+	require.Equal(t, int32(3), atomic.LoadInt32(&runs))
+	require.Equal(t, StateCompleted, timer.State())
+}
+
+func TestRecurrence_OnComplete(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	for _, n := range []int{1, 2, 5} {
+		var runs int32
+		ranC := make(chan int, 1)
+		timer, err := tw.Every(time.Millisecond).Times(n).OnComplete(func(ran int) {
+			ranC <- ran
+		}).Do(func() { atomic.AddInt32(&runs, 1) })
+		require.NoError(t, err)
+
+		// The n counts the executions, and the OnComplete is called once
+		// after the last one.
+		require.Equal(t, n, <-ranC)
+		<-timer.Done()
+		require.Equal(t, int32(n), atomic.LoadInt32(&runs))
+		require.Len(t, ranC, 0)
+	}
+}
+
+func TestRecurrence_OnComplete_Close(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	ranC := make(chan int, 2)
+	runC := make(chan struct{}, 10)
+	timer, err := tw.Every(time.Millisecond * 5).Times(10).OnComplete(func(ran int) {
+		ranC <- ran
+	}).Do(func() { runC <- struct{}{} })
+	require.NoError(t, err)
+
+	<-runC
+	<-runC
+	timer.Close()
+	ran := <-ranC
+	require.GreaterOrEqual(t, ran, 2)
+	require.Less(t, ran, 10)
+
+	// Never called twice.
+	timer.Close()
+	require.Len(t, ranC, 0)
+
+	// The OnComplete is called even if closed before the first execution.
+	timer, err = tw.Every(time.Hour).OnComplete(func(ran int) {
+		ranC <- ran
+	}).Do(func() {})
+	require.NoError(t, err)
+	timer.Close()
+	require.Equal(t, 0, <-ranC)
+}
+
+func TestRecurrence_StartingAt(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	start := time.Now().Add(time.Millisecond * 20)
+	firstC := make(chan time.Time, 1)
+	timer, err := tw.Every(time.Hour).StartingAt(start).Do(func() {
+		select {
+		case firstC <- time.Now():
+		default:
+		}
+	}, WithTag("hourly"))
+	require.NoError(t, err)
+	defer timer.Close()
+	require.Equal(t, "hourly", timer.Tag())
+
+	first := <-firstC
+	require.False(t, first.Before(start.Truncate(time.Millisecond)))
+	require.Equal(t, StateScheduled, timer.State())
+}
+
+func TestRecurrence_Until(t *testing.T) {
+	now := time.Now()
+	r := &recurrence{interval: time.Second, first: now, until: now.Add(time.Second * 3)}
+	require.Equal(t, now, r.Next(now))
+	require.Equal(t, now.Add(time.Second), r.Next(now))
+	require.Equal(t, now.Add(time.Second*2), r.Next(now))
+	// The execution at until is excluded.
+	require.True(t, r.Next(now).IsZero())
+
+	// The Times and Until ends by which comes first.
+	r = &recurrence{interval: time.Second, first: now, until: now.Add(time.Hour), times: 2}
+	require.False(t, r.Next(now).IsZero())
+	require.False(t, r.Next(now).IsZero())
+	require.True(t, r.Next(now).IsZero())
+}
+
+func TestRecurrence_Jitter(t *testing.T) {
+	now := time.Now()
+	r := &recurrence{interval: time.Second, first: now, jitter: 0.1}
+	for i := 0; i < 1000; i++ {
+		nominal := now.Add(time.Duration(i) * time.Second)
+		next := r.Next(now)
+		require.False(t, next.Before(nominal.Add(-time.Millisecond*100)), "occurrence %d", i)
+		require.False(t, next.After(nominal.Add(time.Millisecond*100)), "occurrence %d", i)
+	}
+}
+
+func TestRecurrence_Validate(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+
+	cases := []*Recurrence{
+		tw.Every(0),
+		tw.Every(-time.Second),
+		tw.Every(time.Second).Jitter(-0.1),
+		tw.Every(time.Second).Jitter(0.6),
+		tw.Every(time.Second).Times(-1),
+		tw.Every(time.Second).Times(0),
+		tw.Every(time.Second).Until(time.Now()),
+		tw.Every(time.Second).StartingAt(time.Now().Add(time.Hour)).Until(time.Now().Add(time.Minute)),
+	}
+	for i, r := range cases {
+		timer, err := r.Do(func() {}, WithTag("bad"))
+		require.Nil(t, timer, "case %d", i)
+		require.True(t, errors.Is(err, ErrInvalidSchedule), "case %d: %v", i, err)
+
+		var se *ScheduleError
+		require.True(t, errors.As(err, &se), "case %d", i)
+		require.Equal(t, "Every", se.Op)
+		require.Equal(t, "bad", se.Tag)
+	}
+	require.Equal(t, int64(0), tw.Pending())
+
+	tw.Stop()
+	_, err := tw.Every(time.Second).Do(func() {})
+	require.True(t, errors.Is(err, ErrStopped))
+}
+
+func TestRecurrence_CatchUp(t *testing.T) {
+	now := time.Now()
+
+	// The missed occurrences before until are executed by CatchUpAll.
+	r := &recurrence{interval: time.Second, first: now.Add(-time.Second * 10), until: now.Add(-time.Second * 5)}
+	for i := 0; i < 5; i++ {
+		require.Equal(t, now.Add(time.Duration(i-10)*time.Second), r.Next(now))
+	}
+	require.True(t, r.Next(now).IsZero())
+	require.Equal(t, EndUntil, r.endReason())
+
+	// And skipped by CatchUpSkip, which ends the recurrence.
+	r = &recurrence{interval: time.Second, first: now.Add(-time.Second * 10), until: now.Add(-time.Second * 5), catchUp: CatchUpSkip}
+	require.True(t, r.Next(now).IsZero())
+	require.Equal(t, EndUntil, r.endReason())
+
+	// The CatchUpSkip skips to the first occurrence that has not passed.
+	r = &recurrence{interval: time.Second, first: now.Add(-time.Millisecond * 10500), catchUp: CatchUpSkip, times: 2}
+	next := r.Next(now)
+	require.Equal(t, now.Add(time.Millisecond*500), next)
+	require.Equal(t, now.Add(time.Millisecond*1500), r.Next(now))
+	// The skipped occurrences don't count toward the times.
+	require.True(t, r.Next(now).IsZero())
+	require.Equal(t, EndTimes, r.endReason())
+}
+
+func TestRecurrence_RandomSchedule(t *testing.T) {
+	now := time.Now()
+	newRecurrence := func() *recurrence {
+		return &recurrence{min: time.Second, max: time.Second * 2, first: now, rnd: rand.New(rand.NewSource(1))}
+	}
+
+	r1, r2 := newRecurrence(), newRecurrence()
+	prev := r1.Next(now)
+	require.Equal(t, now, prev)
+	require.Equal(t, prev, r2.Next(now))
+	for i := 0; i < 1000; i++ {
+		next := r1.Next(now)
+		// The same source generates the same sequence.
+		require.Equal(t, next, r2.Next(now))
+		d := next.Sub(prev)
+		require.True(t, d >= time.Second && d <= time.Second*2, "occurrence %d: %s", i, d)
+		prev = next
+	}
+
+	// The missed occurrence is skipped to a random point after now.
+	r := newRecurrence()
+	r.first = now.Add(-time.Hour)
+	r.catchUp = CatchUpSkip
+	next := r.Next(now)
+	require.True(t, next.After(now.Add(time.Second-time.Millisecond)))
+	require.True(t, next.Before(time.Now().Add(time.Second*2+time.Millisecond)))
+}
+
+func TestTimeWheel_RandomSchedule(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	var runs int32
+	timer, err := tw.RandomSchedule(time.Millisecond, time.Millisecond*3).Rand(rand.New(rand.NewSource(1))).
+		Times(3).Do(func() { atomic.AddInt32(&runs, 1) }, SkipIfRunning())
+	require.NoError(t, err)
+	<-timer.Done()
+	require.Equal(t, int32(3), atomic.LoadInt32(&runs))
+	require.Equal(t, EndTimes, timer.EndReason())
+
+	cases := []*Recurrence{
+		tw.RandomSchedule(time.Microsecond, time.Second),
+		tw.RandomSchedule(time.Second*2, time.Second),
+		tw.RandomSchedule(time.Second, time.Second*2).Jitter(0.1),
+	}
+	for i, r := range cases {
+		_, err = r.Do(func() {})
+		require.True(t, errors.Is(err, ErrInvalidSchedule), "case %d: %v", i, err)
+		var se *ScheduleError
+		require.True(t, errors.As(err, &se))
+		require.Equal(t, "RandomSchedule", se.Op)
+	}
+
+	// The min is allowed to equal to the max.
+	timer, err = tw.RandomSchedule(time.Second, time.Second).Do(func() {})
+	require.NoError(t, err)
+	timer.Close()
+}
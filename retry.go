@@ -0,0 +1,195 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AfterFuncErr waits for the duration to elapse and then calls f like the
+// AfterFuncContext, but f reports its failure by the returned error. The error
+// is retried if the WithRetry is set, otherwise it's handed to the OnError.
+func (tw *TimeWheel) AfterFuncErr(d time.Duration, f func(ctx context.Context) error, opts ...TimerOption) *Timer {
+	return tw.expireFunc(context.Background(), tw.expireAfter(d), func(ctx context.Context, t *Timer) {
+		tw.runErr(ctx, t, f)
+	}, opts)
+}
+
+// OnError registers f to be called with the error that a task of AfterFuncErr
+// finally failed with, i.e. the error returned by the task, or a *RetryError
+// once the attempts of its RetryPolicy are exhausted. The f is called in the
+// goroutine of the task.
+func OnError(f func(t *Timer, err error)) Option {
+	return func(o *options) {
+		o.onError = f
+	}
+}
+
+// Backoff returns the delay before the next attempt, after the given number
+// of attempts failed, it starts from 1.
+type Backoff func(failed int) time.Duration
+
+// ConstantBackoff returns a Backoff that always waits for d.
+func ConstantBackoff(d time.Duration) Backoff {
+	return func(int) time.Duration { return d }
+}
+
+// Exponential returns a Backoff that waits for initial after the first failure,
+// and multiplies the delay by factor after each failure, up to max.
+func Exponential(initial time.Duration, factor float64, max time.Duration) Backoff {
+	if initial <= 0 || factor < 1 || max < initial {
+		panic("timewheel: invalid exponential backoff")
+	}
+	return func(failed int) time.Duration {
+		d := float64(initial) * math.Pow(factor, float64(failed-1))
+		if d >= float64(max) {
+			return max
+		}
+		return time.Duration(d)
+	}
+}
+
+// RetryPolicy describes how a failed task of AfterFuncErr is retried.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first one.
+	MaxAttempts int
+	// Backoff returns the delay before each retry, the retry is as soon as
+	// possible if it's nil.
+	Backoff Backoff
+}
+
+// WithRetry sets the RetryPolicy of the timer created by AfterFuncErr: once
+// its task returns an error, the timer is scheduled again after the backoff,
+// until the task succeeds or the attempts are exhausted. The timer isn't
+// finished until the last attempt returned, thus Done and Wait cover all the
+// attempts, and Close stops the further attempts. Each attempt is counted and
+// observed as a fire.
+//
+// It panics if the MaxAttempts is less than 1.
+func WithRetry(p RetryPolicy) TimerOption {
+	if p.MaxAttempts < 1 {
+		panic("timewheel: max attempts of retry policy must be greater than 0")
+	}
+	return func(t *Timer) {
+		t.setAttrs().retry = &retry{policy: p}
+	}
+}
+
+// Attempt is an attempt of a task that failed.
+type Attempt struct {
+	// At is the time that the attempt returned.
+	At time.Time
+	// Err is the error returned by the attempt.
+	Err error
+}
+
+// RetryError is passed to the OnError once the attempts of a task are
+// exhausted, it records all the failed attempts in order.
+type RetryError struct {
+	Attempts []Attempt
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("timewheel: task failed after %d attempts: %v", len(e.Attempts), e.Unwrap())
+}
+
+// Unwrap returns the error of the last attempt.
+func (e *RetryError) Unwrap() error {
+	if len(e.Attempts) == 0 {
+		return nil
+	}
+	return e.Attempts[len(e.Attempts)-1].Err
+}
+
+// retry is the state of the RetryPolicy of a timer, it's kept in the attrs
+// thus it follows the timer wherever it moves.
+type retry struct {
+	policy RetryPolicy
+
+	// The mu protects the attempts, since they're recorded by the task and
+	// read by the Attempts.
+	mu       sync.Mutex
+	attempts []Attempt
+	// Set by Close, accessed atomically.
+	stopped int32
+}
+
+// record records the failed attempt, and returns the number of attempts.
+func (r *retry) record(a Attempt) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.attempts = append(r.attempts, a)
+	return len(r.attempts)
+}
+
+func (r *retry) history() []Attempt {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Attempt(nil), r.attempts...)
+}
+
+func (r *retry) isStopped() bool {
+	return atomic.LoadInt32(&r.stopped) == 1
+}
+
+// stop stops the further attempts of the running timer t, it's called by Close.
+func (r *retry) stop(t *Timer) {
+	atomic.StoreInt32(&r.stopped, 1)
+	if t.unreset() {
+		t.setEndReason(EndCancelled)
+	}
+}
+
+// Attempts returns the attempts of the task of t that have failed so far, it's
+// nil unless the WithRetry is set.
+func (t *Timer) Attempts() []Attempt {
+	if r := t.getAttrs().retry; r != nil {
+		return r.history()
+	}
+	return nil
+}
+
+// runErr executes an attempt of the task f of t, and re-arms t if it fails
+// and can be retried.
+func (tw *TimeWheel) runErr(ctx context.Context, t *Timer, f func(ctx context.Context) error) {
+	r := t.getAttrs().retry
+	if r != nil && r.isStopped() {
+		// Closed while the previous attempt was running.
+		t.setEndReason(EndCancelled)
+		return
+	}
+	err := f(ctx)
+	if err == nil {
+		return
+	}
+	if r != nil {
+		n := r.record(Attempt{At: tw.timeNow(), Err: err})
+		if r.isStopped() {
+			t.setEndReason(EndCancelled)
+			return
+		}
+		if n < r.policy.MaxAttempts {
+			var d time.Duration
+			if r.policy.Backoff != nil {
+				d = r.policy.Backoff(n)
+			}
+			// Re-armed once this attempt returns, see Timer.Reset.
+			if t.Reset(d) {
+				return
+			}
+		}
+		err = &RetryError{Attempts: r.history()}
+	}
+	if h := tw.root.opts.onError; h != nil {
+		h(t, err)
+	}
+	tw.deadLetter(t, err)
+}
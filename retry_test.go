@@ -0,0 +1,123 @@
+package timewheel
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExponential(t *testing.T) {
+	require.Panics(t, func() { Exponential(0, 2, time.Second) })
+	require.Panics(t, func() { Exponential(time.Second, 0.5, time.Minute) })
+
+	b := Exponential(time.Millisecond*100, 2, time.Second)
+	for i, want := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		require.Equal(t, int64(want*time.Millisecond), int64(b(i+1)))
+	}
+	require.Equal(t, int64(time.Second), int64(ConstantBackoff(time.Second)(3)))
+}
+
+func TestWithRetry(t *testing.T) {
+	require.Panics(t, func() { WithRetry(RetryPolicy{}) })
+
+	errFoo := errors.New("foo")
+	var failed []error
+	clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	// The level spans 4ms, thus the retries migrate through the overflow wheels.
+	tw := New(time.Millisecond, 4, WithClock(clock), WithDispatchPolicy(DispatchInline),
+		OnError(func(_ *Timer, err error) { failed = append(failed, err) }))
+	tw.Start()
+	defer tw.Stop()
+	start := clock.Now()
+	run := func(timer *Timer) {
+		for timer.State() != StateCompleted && timer.State() != StateCancelled {
+			clock.add(time.Millisecond)
+			tw.Poll()
+		}
+	}
+
+	// It succeeds on the 4th attempt.
+	var at []time.Duration
+	timer := tw.AfterFuncErr(time.Millisecond*5, func(ctx context.Context) error {
+		at = append(at, clock.Now().Sub(start))
+		if len(at) < 4 {
+			return errFoo
+		}
+		return nil
+	}, WithRetry(RetryPolicy{MaxAttempts: 5, Backoff: Exponential(time.Millisecond*10, 2, time.Second)}))
+	run(timer)
+	require.Equal(t, []time.Duration{5 * time.Millisecond, 15 * time.Millisecond, 35 * time.Millisecond, 75 * time.Millisecond}, at)
+	require.Equal(t, EndCompleted, timer.EndReason())
+	require.Len(t, timer.Attempts(), 3)
+	require.Empty(t, failed)
+	require.Equal(t, uint64(4), tw.Stats().Fired)
+	require.Equal(t, int64(0), tw.Pending())
+
+	// The error is handed to the OnError once exhausted.
+	var n int
+	timer = tw.AfterFuncErr(time.Millisecond, func(ctx context.Context) error {
+		n++
+		return errFoo
+	}, WithRetry(RetryPolicy{MaxAttempts: 3}))
+	run(timer)
+	require.Equal(t, 3, n)
+	require.Len(t, failed, 1)
+	var re *RetryError
+	require.True(t, errors.As(failed[0], &re))
+	require.Len(t, re.Attempts, 3)
+	require.True(t, errors.Is(failed[0], errFoo))
+
+	// Without the WithRetry, the error is handed as is.
+	timer = tw.AfterFuncErr(time.Millisecond, func(ctx context.Context) error { return errFoo })
+	run(timer)
+	require.Len(t, failed, 2)
+	require.Equal(t, errFoo, failed[1])
+	require.Nil(t, timer.Attempts())
+}
+
+func TestWithRetry_Close(t *testing.T) {
+	errFoo := errors.New("foo")
+	var failed int
+	clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	tw := New(time.Millisecond, 4, WithClock(clock), WithDispatchPolicy(DispatchInline),
+		OnError(func(*Timer, error) { failed++ }))
+	tw.Start()
+	defer tw.Stop()
+	policy := WithRetry(RetryPolicy{MaxAttempts: 10, Backoff: ConstantBackoff(time.Millisecond * 10)})
+
+	// Closed between the attempts.
+	var n int
+	timer := tw.AfterFuncErr(time.Millisecond, func(ctx context.Context) error {
+		n++
+		return errFoo
+	}, policy)
+	for n < 2 {
+		clock.add(time.Millisecond)
+		tw.Poll()
+	}
+	timer.Close()
+	require.Equal(t, EndCancelled, timer.EndReason())
+
+	// Closed while an attempt is running.
+	var m int
+	var self *Timer
+	self = tw.AfterFuncErr(time.Millisecond, func(ctx context.Context) error {
+		if m++; m == 2 {
+			self.Close()
+		}
+		return errFoo
+	}, policy)
+	for i := 0; i < 100; i++ {
+		clock.add(time.Millisecond)
+		tw.Poll()
+	}
+	require.Equal(t, 2, n)
+	require.Equal(t, 2, m)
+	require.Equal(t, EndCancelled, self.EndReason())
+	require.Equal(t, StateCompleted, self.State())
+	require.Equal(t, 0, failed)
+	require.Equal(t, int64(0), tw.Pending())
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReusableTimer is a timer that is allocated once and armed many times, such
+// as the idle timeout of a connection that is re-armed on every read. It's
+// created by NewStoppedTimer.
+//
+// Each Start is an arming of the same timer, the arming is counted by a
+// generation. Since the timer may be re-armed while the consumer goroutine
+// still holds the previous arming (e.g. it has expired but not dispatched),
+// the state of the timer alone can't tell whether the running task belongs to
+// the latest arming. Thus, the task of a stale arming never completes the
+// latest one, and an arming is either fired or stopped exactly once.
+type ReusableTimer struct {
+	// The generation of the latest arming, it's increased by each Start.
+	// It's placed first to be 64-bit aligned on 32-bit platforms.
+	gen uint64
+
+	timer Timer
+	f     func()
+
+	// The mu serializes the Start, Stop and the completion of a task.
+	mu sync.Mutex
+}
+
+// NewStoppedTimer creates a ReusableTimer that calls f each time it fires, it
+// isn't armed until Start is called. The f is dispatched by the DispatchPolicy
+// like AfterFunc.
+//
+// Unlike the scheduling funcs, the ReusableTimer is not subject to SetQuota,
+// WithMaxPending and WithLoadShedding, since it's allocated up front.
+func (tw *TimeWheel) NewStoppedTimer(f func(), opts ...TimerOption) *ReusableTimer {
+	rt := &ReusableTimer{f: f}
+	t := &rt.timer
+	t.meta = newMeta(tw.nextID(), 0, EndNone)
+	t.tw = tw
+	t.setAttrs().reusable = true
+	t.apply(opts)
+	tw.route(t)
+	t.task = func() {
+		gen := atomic.LoadUint64(&rt.gen)
+		tw.dispatch(context.Background(), t, func(context.Context) {
+			rt.f()
+			rt.complete(gen)
+		})
+	}
+	return rt
+}
+
+// Start arms the timer to fire after the duration d, the previous arming is
+// stopped if it's still active. It returns true if the previous arming was
+// active, like the Reset of the standard time.Timer.
+//
+// Start and Stop are safe for concurrent use, including from the f. Each
+// arming only allocates the list element in the bucket, and each fire a small
+// closure, the timer and its task are allocated once by NewStoppedTimer.
+func (rt *ReusableTimer) Start(d time.Duration) bool {
+	t := &rt.timer
+	tw := t.tw
+
+	rt.mu.Lock()
+	atomic.AddUint64(&rt.gen, 1)
+	active := t.cancel(false)
+	t.setExpiration(tw.expireAfter(d))
+	t.arm()
+	tw.incPending()
+	tw.observeSchedule(t)
+	rt.mu.Unlock()
+
+	// Submit outside the lock, since an expired timer may be executed inline.
+	tw.submit(t)
+	return active
+}
+
+// Stop prevents the latest arming from firing. It returns true if the call
+// stops the arming, and false if the timer has already fired or been stopped,
+// or it has never been started. Stop does not wait for f to complete.
+func (rt *ReusableTimer) Stop() bool {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return rt.timer.cancel(false)
+}
+
+// complete moves the running timer to StateCompleted after the task of the
+// arming gen returned, unless the timer has been re-armed in the meantime.
+func (rt *ReusableTimer) complete(gen uint64) {
+	rt.mu.Lock()
+	if atomic.LoadUint64(&rt.gen) == gen {
+		rt.timer.transit(StateRunning, StateCompleted)
+	}
+	rt.mu.Unlock()
+}
+
+// ID returns the unique ID of the timer, it's the same for all the armings.
+func (rt *ReusableTimer) ID() uint64 {
+	return rt.timer.ID()
+}
+
+// State returns the state of the latest arming, it's zero if never started.
+func (rt *ReusableTimer) State() State {
+	return rt.timer.State()
+}
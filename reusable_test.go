@@ -0,0 +1,133 @@
+package timewheel
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReusableTimer(t *testing.T) {
+	clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	tw := New(time.Millisecond, 8, WithClock(clock), WithDispatchPolicy(DispatchInline))
+	defer tw.Stop()
+
+	var fired int
+	rt := tw.NewStoppedTimer(func() { fired++ }, WithTag("conn"))
+	require.Equal(t, State(0), rt.State())
+	require.False(t, rt.Stop())
+	require.Equal(t, int64(0), tw.Pending())
+
+	require.False(t, rt.Start(time.Millisecond*5))
+	require.Equal(t, StateScheduled, rt.State())
+	require.Equal(t, int64(1), tw.Pending())
+
+	// Re-armed before expired, the previous arming never fires.
+	clock.add(time.Millisecond * 3)
+	tw.Poll()
+	require.True(t, rt.Start(time.Millisecond*5))
+	clock.add(time.Millisecond * 3)
+	tw.Poll()
+	require.Equal(t, 0, fired)
+
+	clock.add(time.Millisecond * 2)
+	tw.Poll()
+	require.Equal(t, 1, fired)
+	require.Equal(t, StateCompleted, rt.State())
+	require.Equal(t, int64(0), tw.Pending())
+	require.False(t, rt.Stop())
+
+	// Armed again after fired.
+	require.False(t, rt.Start(time.Millisecond))
+	require.True(t, rt.Stop())
+	clock.add(time.Millisecond)
+	tw.Poll()
+	require.Equal(t, 1, fired)
+	require.Equal(t, StateCancelled, rt.State())
+	require.Equal(t, EndNone, rt.timer.EndReason())
+
+	stats := tw.Stats()
+	require.Equal(t, uint64(3), stats.Scheduled)
+	require.Equal(t, uint64(1), stats.Fired)
+	require.Equal(t, uint64(2), stats.Cancelled)
+	require.Equal(t, int64(0), stats.Pending)
+}
+
+func TestReusableTimer_StartInTask(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	var n int32
+	doneC := make(chan struct{})
+	var rt *ReusableTimer
+	rt = tw.NewStoppedTimer(func() {
+		if atomic.AddInt32(&n, 1) < 5 {
+			rt.Start(time.Millisecond)
+			return
+		}
+		close(doneC)
+	})
+	rt.Start(time.Millisecond)
+	<-doneC
+	require.Eventually(t, func() bool { return tw.Pending() == 0 }, time.Second, time.Millisecond)
+	require.Equal(t, int32(5), atomic.LoadInt32(&n))
+}
+
+func TestReusableTimer_Allocs(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	defer tw.Stop()
+	rt := tw.NewStoppedTimer(func() {})
+
+	// Only the list element of the bucket is allocated by each arming.
+	allocs := testing.AllocsPerRun(100, func() {
+		rt.Start(time.Hour)
+		rt.Stop()
+	})
+	require.Equal(t, float64(1), allocs)
+}
+
+// TestReusableTimer_ABA stresses the Start and Stop cycles racing with the
+// expiration, each arming must be either fired or stopped exactly once.
+func TestReusableTimer_ABA(t *testing.T) {
+	for _, policy := range []DispatchPolicy{DispatchGoroutine, DispatchInline} {
+		tw := New(time.Millisecond, 4, WithDispatchPolicy(policy))
+		tw.Start()
+
+		var started, stopped, fired int64
+		rt := tw.NewStoppedTimer(func() { atomic.AddInt64(&fired, 1) })
+
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func(seed int64) {
+				defer wg.Done()
+				rnd := rand.New(rand.NewSource(seed))
+				for j := 0; j < 2000; j++ {
+					atomic.AddInt64(&started, 1)
+					if rt.Start(time.Duration(rnd.Intn(3)) * time.Millisecond) {
+						atomic.AddInt64(&stopped, 1)
+					}
+					if rnd.Intn(4) == 0 {
+						time.Sleep(time.Duration(rnd.Intn(2000)) * time.Microsecond)
+					}
+					if rnd.Intn(2) == 0 && rt.Stop() {
+						atomic.AddInt64(&stopped, 1)
+					}
+				}
+			}(int64(i))
+		}
+		wg.Wait()
+
+		require.Eventually(t, func() bool {
+			return atomic.LoadInt64(&fired)+atomic.LoadInt64(&stopped) == atomic.LoadInt64(&started)
+		}, time.Second*5, time.Millisecond, "policy=%d fired=%d stopped=%d started=%d", policy,
+			atomic.LoadInt64(&fired), atomic.LoadInt64(&stopped), atomic.LoadInt64(&started))
+		require.Eventually(t, func() bool { return tw.Pending() == 0 }, time.Second, time.Millisecond)
+		require.Equal(t, uint64(atomic.LoadInt64(&fired)), tw.Stats().Fired)
+		tw.Stop()
+	}
+}
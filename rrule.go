@@ -0,0 +1,672 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Frequency is the FREQ of an RRule.
+type Frequency int
+
+// The frequencies of RRule.
+const (
+	Secondly Frequency = iota + 1
+	Minutely
+	Hourly
+	Daily
+	Weekly
+	Monthly
+	Yearly
+)
+
+var frequencyNames = [...]string{
+	Secondly: "SECONDLY",
+	Minutely: "MINUTELY",
+	Hourly:   "HOURLY",
+	Daily:    "DAILY",
+	Weekly:   "WEEKLY",
+	Monthly:  "MONTHLY",
+	Yearly:   "YEARLY",
+}
+
+// String returns the name of the Frequency in RFC 5545, such as "MONTHLY".
+func (f Frequency) String() string {
+	if f >= Secondly && f <= Yearly {
+		return frequencyNames[f]
+	}
+	return "Frequency(" + strconv.Itoa(int(f)) + ")"
+}
+
+// RRuleDay is an entry of the BYDAY of an RRule, such as "-1FR" for the last
+// Friday.
+type RRuleDay struct {
+	// N is the ordinal of the weekday within the month, or the year for a
+	// YEARLY rule without BYMONTH, the negative counts from the end. The 0
+	// means every such weekday.
+	N       int
+	Weekday time.Weekday
+}
+
+// RRule is a recurrence rule of RFC 5545 (iCalendar), such as
+// "FREQ=MONTHLY;BYDAY=-1FR" for the last Friday of each month, it's parsed by
+// ParseRRule. The zero of a field means it's not given.
+type RRule struct {
+	Freq Frequency
+	// Interval is the number of periods of the Freq between two occurrences,
+	// the 0 means 1.
+	Interval int
+	// Count is the number of occurrences, and Until is the last time that an
+	// occurrence may be, at most one of them is given.
+	Count int
+	Until time.Time
+
+	ByMonth    []time.Month
+	ByMonthDay []int
+	ByDay      []RRuleDay
+	ByHour     []int
+	ByMinute   []int
+	BySecond   []int
+	// WeekStart is the WKST, the first day of the week for a WEEKLY rule,
+	// default is Monday.
+	WeekStart time.Weekday
+
+	// Start is the DTSTART that the occurrences are derived from, the rule is
+	// evaluated in its location. It's counted as the first occurrence only if
+	// it matches the rule.
+	Start time.Time
+}
+
+// rruleShortDays are the weekdays in RFC 5545.
+var rruleShortDays = [...]string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// ParseRRule parses the recurrence rule of RFC 5545 s, with or without the
+// "RRULE:" prefix, that starts at start; start is the DTSTART, and its
+// location is the one the rule is evaluated in, e.g.:
+//
+//	r, err := timewheel.ParseRRule("FREQ=MONTHLY;BYDAY=-1FR;BYHOUR=17", time.Now().In(loc))
+//
+// The FREQ, INTERVAL, COUNT, UNTIL, BYMONTH, BYMONTHDAY, BYDAY, BYHOUR,
+// BYMINUTE, BYSECOND and WKST are supported. The UNTIL is in UTC with the
+// suffix "Z", in the location of start without it, or a date. The ordinals of
+// BYDAY are only allowed with the MONTHLY and YEARLY frequencies.
+//
+// The errors wrap ErrInvalidSchedule and tell which part is invalid.
+func ParseRRule(s string, start time.Time) (RRule, error) {
+	r := RRule{Start: start, WeekStart: time.Monday}
+	fail := func(format string, args ...interface{}) (RRule, error) {
+		return RRule{}, fmt.Errorf("%w: rrule %q: %s", ErrInvalidSchedule, s, fmt.Sprintf(format, args...))
+	}
+
+	rule := strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(rule, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return fail("part %q must be in the form of NAME=VALUE", part)
+		}
+		name, value := strings.ToUpper(kv[0]), strings.ToUpper(kv[1])
+		if seen[name] {
+			return fail("%s is given more than once", name)
+		}
+		seen[name] = true
+
+		var err error
+		switch name {
+		case "FREQ":
+			r.Freq = 0
+			for f := Secondly; f <= Yearly; f++ {
+				if frequencyNames[f] == value {
+					r.Freq = f
+				}
+			}
+			if r.Freq == 0 {
+				return fail("unknown FREQ %q", value)
+			}
+		case "INTERVAL":
+			r.Interval, err = strconv.Atoi(value)
+			if err != nil || r.Interval < 1 {
+				return fail("INTERVAL %q must be a positive integer", value)
+			}
+		case "COUNT":
+			r.Count, err = strconv.Atoi(value)
+			if err != nil || r.Count < 1 {
+				return fail("COUNT %q must be a positive integer", value)
+			}
+		case "UNTIL":
+			if r.Until, err = parseRRuleUntil(value, start.Location()); err != nil {
+				return fail("%v", err)
+			}
+		case "BYMONTH":
+			months, err := parseRRuleInts(name, value, 1, 12, false)
+			if err != nil {
+				return fail("%v", err)
+			}
+			for _, m := range months {
+				r.ByMonth = append(r.ByMonth, time.Month(m))
+			}
+		case "BYMONTHDAY":
+			if r.ByMonthDay, err = parseRRuleInts(name, value, 1, 31, true); err != nil {
+				return fail("%v", err)
+			}
+		case "BYDAY":
+			for _, day := range strings.Split(value, ",") {
+				d, err := parseRRuleDay(day)
+				if err != nil {
+					return fail("%v", err)
+				}
+				r.ByDay = append(r.ByDay, d)
+			}
+		case "BYHOUR":
+			if r.ByHour, err = parseRRuleInts(name, value, 0, 23, false); err != nil {
+				return fail("%v", err)
+			}
+		case "BYMINUTE":
+			if r.ByMinute, err = parseRRuleInts(name, value, 0, 59, false); err != nil {
+				return fail("%v", err)
+			}
+		case "BYSECOND":
+			if r.BySecond, err = parseRRuleInts(name, value, 0, 59, false); err != nil {
+				return fail("%v", err)
+			}
+		case "WKST":
+			d, err := parseRRuleDay(value)
+			if err != nil || d.N != 0 {
+				return fail("invalid WKST %q", value)
+			}
+			r.WeekStart = d.Weekday
+		default:
+			return fail("%s is not supported", name)
+		}
+	}
+	if err := r.validate(); err != nil {
+		return RRule{}, fmt.Errorf("%w: rrule %q: %s", ErrInvalidSchedule, s, err)
+	}
+	return r, nil
+}
+
+// parseRRuleUntil parses the UNTIL of a rule in the location loc.
+func parseRRuleUntil(value string, loc *time.Location) (time.Time, error) {
+	layouts := [...]string{"20060102T150405Z", "20060102T150405", "20060102"}
+	for i, layout := range layouts {
+		l := loc
+		if i == 0 {
+			l = time.UTC
+		}
+		if t, err := time.ParseInLocation(layout, value, l); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("UNTIL %q must be a date or a date-time", value)
+}
+
+// parseRRuleInts parses the list of integers in [min, max] of the part name,
+// or in [-max, -min] as well if negative.
+func parseRRuleInts(name, value string, min, max int, negative bool) ([]int, error) {
+	var ns []int
+	for _, s := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(s)
+		abs := n
+		if negative && n < 0 {
+			abs = -n
+		}
+		if err != nil || abs < min || abs > max || (negative && n == 0) {
+			return nil, fmt.Errorf("invalid %s %q", name, s)
+		}
+		ns = append(ns, n)
+	}
+	return ns, nil
+}
+
+// parseRRuleDay parses an entry of the BYDAY such as "MO", "+2TU" or "-1FR".
+func parseRRuleDay(s string) (RRuleDay, error) {
+	if len(s) >= 2 {
+		for wd, name := range rruleShortDays {
+			if !strings.HasSuffix(s, name) {
+				continue
+			}
+			d := RRuleDay{Weekday: time.Weekday(wd)}
+			if ord := s[:len(s)-2]; ord != "" {
+				n, err := strconv.Atoi(ord)
+				if err != nil || n == 0 || n < -53 || n > 53 {
+					break
+				}
+				d.N = n
+			}
+			return d, nil
+		}
+	}
+	return RRuleDay{}, fmt.Errorf("invalid BYDAY %q", s)
+}
+
+// validate checks the fields of the rule.
+func (r *RRule) validate() error {
+	switch {
+	case r.Freq < Secondly || r.Freq > Yearly:
+		return fmt.Errorf("FREQ is required")
+	case r.Start.IsZero():
+		return fmt.Errorf("no start")
+	case r.Interval < 0 || r.Count < 0:
+		return fmt.Errorf("INTERVAL and COUNT must not be negative")
+	case r.Count > 0 && !r.Until.IsZero():
+		return fmt.Errorf("COUNT and UNTIL must not be both given")
+	case r.Freq == Weekly && len(r.ByMonthDay) > 0:
+		return fmt.Errorf("BYMONTHDAY must not be given with FREQ=WEEKLY")
+	}
+	for _, d := range r.ByDay {
+		if d.N != 0 && r.Freq != Monthly && r.Freq != Yearly {
+			return fmt.Errorf("ordinal of BYDAY is only allowed with FREQ=MONTHLY or FREQ=YEARLY")
+		}
+		if d.N != 0 && (r.Freq == Monthly || len(r.ByMonth) > 0) && (d.N < -5 || d.N > 5) {
+			return fmt.Errorf("ordinal of BYDAY %d is out of month", d.N)
+		}
+	}
+	return nil
+}
+
+// Next returns the first occurrence after the given time, or a zero time if
+// there is none or the rule is invalid.
+//
+// It's computed from the period of the Freq that the given time is in, the
+// time spent doesn't grow with the distance from the Start, except for the
+// rule with a COUNT, whose occurrences must be counted from the Start. The
+// nonexistent occurrences are ignored as RFC 5545 requires, e.g. the 31th of
+// a month of 30 days, or the wall time skipped by the daylight saving
+// transition. It gives up if there are no occurrences in 400 years, which is
+// a cycle of the Gregorian calendar.
+func (r *RRule) Next(after time.Time) time.Time {
+	it, err := newRRuleIter(r)
+	if err != nil {
+		return time.Time{}
+	}
+	return it.next(after)
+}
+
+// Do validates the rule and schedules f to execute at its occurrences. The
+// occurrences counted by the plan are remembered, thus each execution of a
+// rule with a COUNT costs O(1).
+//
+// It returns a *ScheduleError that wraps ErrInvalidSchedule if the rule is
+// invalid or has no occurrence in the future, ErrStopped if the TimeWheel has
+// been stopped, or ErrQuotaExceeded if the quota of the tag is reached (see
+// SetQuota).
+func (r *RRule) Do(tw *TimeWheel, f func(), opts ...TimerOption) (*Timer, error) {
+	it, err := newRRuleIter(r)
+	if err != nil {
+		err = fmt.Errorf("%w: rrule: %s", ErrInvalidSchedule, err)
+	}
+	return tw.doPlan("RRule", it.next, err, f, opts)
+}
+
+// rruleIter computes the occurrences of a validated rule, the defaults of the
+// rule are resolved.
+type rruleIter struct {
+	freq     Frequency
+	interval int
+	count    int
+	until    time.Time
+	start    time.Time
+	loc      *time.Location
+
+	months    [13]bool // unless the anyMonth.
+	anyMonth  bool
+	monthDays []int
+	days      []RRuleDay
+	yearScope bool // whether the ordinals of the days are within the year.
+	hours     []int
+	minutes   []int
+	seconds   []int
+
+	// The period 0, it's the date of the period for the Daily and longer, or
+	// the first instant for the shorter.
+	y0, d0 int
+	m0     time.Month
+	p0     time.Time
+	unit   time.Duration
+
+	// The cursor of a rule with a COUNT: the n occurrences precede the period
+	// k, and all of them precede the bound. The mu protects it.
+	mu     sync.Mutex
+	cursor struct {
+		k     int64
+		n     int
+		bound time.Time
+		valid bool
+	}
+}
+
+func newRRuleIter(r *RRule) (*rruleIter, error) {
+	if err := r.validate(); err != nil {
+		return nil, err
+	}
+	start := r.Start.Truncate(time.Second)
+	if start.Before(r.Start) {
+		start = start.Add(time.Second)
+	}
+	it := &rruleIter{
+		freq:      r.Freq,
+		interval:  r.Interval,
+		count:     r.Count,
+		until:     r.Until,
+		start:     start,
+		loc:       r.Start.Location(),
+		anyMonth:  len(r.ByMonth) == 0,
+		monthDays: r.ByMonthDay,
+		days:      r.ByDay,
+		yearScope: r.Freq == Yearly && len(r.ByMonth) == 0,
+		hours:     sortedInts(r.ByHour),
+		minutes:   sortedInts(r.ByMinute),
+		seconds:   sortedInts(r.BySecond),
+	}
+	if it.interval == 0 {
+		it.interval = 1
+	}
+	for _, m := range r.ByMonth {
+		it.months[m] = true
+	}
+
+	local := start.In(it.loc)
+	y, m, d := local.Date()
+	// The defaults are derived from the start.
+	switch r.Freq {
+	case Yearly:
+		if len(r.ByMonthDay) == 0 && len(r.ByDay) == 0 {
+			if it.anyMonth {
+				it.anyMonth = false
+				it.months[m] = true
+			}
+			it.monthDays = []int{d}
+		}
+	case Monthly:
+		if len(r.ByMonthDay) == 0 && len(r.ByDay) == 0 {
+			it.monthDays = []int{d}
+		}
+	case Weekly:
+		if len(r.ByDay) == 0 {
+			it.days = []RRuleDay{{Weekday: local.Weekday()}}
+		}
+	}
+	if r.Freq >= Daily && it.hours == nil {
+		it.hours = []int{local.Hour()}
+	}
+	if r.Freq >= Hourly && it.minutes == nil {
+		it.minutes = []int{local.Minute()}
+	}
+	if r.Freq >= Minutely && it.seconds == nil {
+		it.seconds = []int{local.Second()}
+	}
+
+	switch r.Freq {
+	case Yearly:
+		it.y0, it.m0, it.d0 = y, time.January, 1
+	case Monthly:
+		it.y0, it.m0, it.d0 = y, m, 1
+	case Weekly:
+		offset := (int(local.Weekday()) - int(r.WeekStart) + 7) % 7
+		it.y0, it.m0, it.d0 = y, m, d-offset
+	case Daily:
+		it.y0, it.m0, it.d0 = y, m, d
+	case Hourly:
+		it.p0, it.unit = time.Date(y, m, d, local.Hour(), 0, 0, 0, it.loc), time.Hour
+	case Minutely:
+		it.p0, it.unit = time.Date(y, m, d, local.Hour(), local.Minute(), 0, 0, it.loc), time.Minute
+	case Secondly:
+		it.p0, it.unit = start, time.Second
+	}
+	return it, nil
+}
+
+func sortedInts(ns []int) []int {
+	if len(ns) == 0 {
+		return nil
+	}
+	s := append([]int(nil), ns...)
+	sort.Ints(s)
+	return s
+}
+
+// rruleGiveUp is the years without an occurrence that the search gives up.
+const rruleGiveUp = 400
+
+// next returns the first occurrence after the given time, or a zero time.
+func (it *rruleIter) next(after time.Time) time.Time {
+	var k int64
+	n := 0
+	if it.count > 0 {
+		it.mu.Lock()
+		defer it.mu.Unlock()
+		if c := it.cursor; c.valid && !after.Before(c.bound) {
+			k, n = c.k, c.n
+		}
+	} else if after.After(it.start) {
+		k = it.periodOf(after) / int64(it.interval) * int64(it.interval)
+	}
+
+	last := it.start
+	if after.After(last) {
+		last = after
+	}
+	giveUp := last.AddDate(rruleGiveUp, 0, 0)
+	var buf []time.Time
+	for {
+		begin := it.periodStart(k)
+		if begin.After(giveUp) || (!it.until.IsZero() && begin.After(it.until)) {
+			return time.Time{}
+		}
+		var skip time.Time
+		buf, skip = it.occurrences(buf[:0], begin)
+		before, first := n, true
+		for _, t := range buf {
+			if t.Before(it.start) {
+				continue
+			}
+			if !it.until.IsZero() && t.After(it.until) {
+				return time.Time{}
+			}
+			n++
+			if it.count > 0 && n > it.count {
+				return time.Time{}
+			}
+			if first {
+				if it.count > 0 {
+					it.cursor.k, it.cursor.n, it.cursor.bound, it.cursor.valid = k, before, t, true
+				}
+				first = false
+			}
+			if t.After(after) {
+				return t
+			}
+			giveUp = t.AddDate(rruleGiveUp, 0, 0)
+		}
+		if it.count > 0 && n >= it.count {
+			return time.Time{}
+		}
+		if skip.IsZero() {
+			k += int64(it.interval)
+		} else {
+			// The periods before skip never match.
+			units := it.periodOf(skip.Add(it.unit - time.Second))
+			k = (units + int64(it.interval) - 1) / int64(it.interval) * int64(it.interval)
+		}
+	}
+}
+
+// periodOf returns the index of the period that t is in. It's an int64, since
+// the seconds overflow the int on the 32-bit platforms in 68 years.
+func (it *rruleIter) periodOf(t time.Time) int64 {
+	local := t.In(it.loc)
+	y, m, d := local.Date()
+	switch it.freq {
+	case Yearly:
+		return int64(y - it.y0)
+	case Monthly:
+		return int64((y-it.y0)*12 + int(m-it.m0))
+	case Weekly:
+		return daysBetween(it.y0, it.m0, it.d0, y, m, d) / 7
+	case Daily:
+		return daysBetween(it.y0, it.m0, it.d0, y, m, d)
+	}
+	// In seconds, since the duration overflows in 292 years.
+	return (t.Unix() - it.p0.Unix()) / int64(it.unit/time.Second)
+}
+
+// daysBetween returns the number of days from the date 1 to the date 2.
+func daysBetween(y1 int, m1 time.Month, d1 int, y2 int, m2 time.Month, d2 int) int64 {
+	t1 := time.Date(y1, m1, d1, 0, 0, 0, 0, time.UTC)
+	t2 := time.Date(y2, m2, d2, 0, 0, 0, 0, time.UTC)
+	return (t2.Unix() - t1.Unix()) / 86400
+}
+
+// periodStart returns the beginning of the period k.
+func (it *rruleIter) periodStart(k int64) time.Time {
+	switch it.freq {
+	case Yearly:
+		return time.Date(it.y0+int(k), it.m0, it.d0, 0, 0, 0, 0, it.loc)
+	case Monthly:
+		return time.Date(it.y0, it.m0+time.Month(k), it.d0, 0, 0, 0, 0, it.loc)
+	case Weekly:
+		return time.Date(it.y0, it.m0, it.d0+7*int(k), 0, 0, 0, 0, it.loc)
+	case Daily:
+		return time.Date(it.y0, it.m0, it.d0+int(k), 0, 0, 0, 0, it.loc)
+	}
+	return time.Unix(it.p0.Unix()+k*int64(it.unit/time.Second), 0).In(it.loc)
+}
+
+// occurrences appends the candidates of the period beginning at begin to buf
+// in order. For the periods shorter than a day, the skip is the time before
+// which no period matches if the period doesn't match.
+func (it *rruleIter) occurrences(buf []time.Time, begin time.Time) ([]time.Time, time.Time) {
+	local := begin.In(it.loc)
+	y, m, d := local.Date()
+	if it.freq < Daily {
+		if !it.matchDate(y, m, d) {
+			return buf, time.Date(y, m, d+1, 0, 0, 0, 0, it.loc)
+		}
+		h, mi, s := local.Clock()
+		if it.hours != nil && !containsInt(it.hours, h) {
+			if it.freq == Hourly {
+				return buf, time.Time{}
+			}
+			return buf, time.Date(y, m, d, h+1, 0, 0, 0, it.loc)
+		}
+		if it.freq == Hourly {
+			for _, mi := range it.minutes {
+				for _, s := range it.seconds {
+					buf = append(buf, begin.Add(time.Duration(mi)*time.Minute+time.Duration(s)*time.Second))
+				}
+			}
+			return buf, time.Time{}
+		}
+		if it.minutes != nil && !containsInt(it.minutes, mi) {
+			if it.freq == Minutely {
+				return buf, time.Time{}
+			}
+			return buf, time.Date(y, m, d, h, mi+1, 0, 0, it.loc)
+		}
+		if it.freq == Minutely {
+			for _, s := range it.seconds {
+				buf = append(buf, begin.Add(time.Duration(s)*time.Second))
+			}
+			return buf, time.Time{}
+		}
+		if it.seconds == nil || containsInt(it.seconds, s) {
+			buf = append(buf, begin)
+		}
+		return buf, time.Time{}
+	}
+
+	var days int
+	switch it.freq {
+	case Yearly:
+		days = int(daysBetween(y, time.January, 1, y+1, time.January, 1))
+	case Monthly:
+		days = daysIn(y, m)
+	case Weekly:
+		days = 7
+	case Daily:
+		days = 1
+	}
+	for i := 0; i < days; i++ {
+		date := time.Date(y, m, d+i, 0, 0, 0, 0, time.UTC)
+		dy, dm, dd := date.Date()
+		if !it.matchDate(dy, dm, dd) {
+			continue
+		}
+		for _, h := range it.hours {
+			for _, mi := range it.minutes {
+				for _, s := range it.seconds {
+					t := time.Date(dy, dm, dd, h, mi, s, 0, it.loc)
+					if th, tm, ts := t.Clock(); th != h || tm != mi || ts != s {
+						// Skipped by the daylight saving transition.
+						continue
+					}
+					buf = append(buf, t)
+				}
+			}
+		}
+	}
+	return buf, time.Time{}
+}
+
+// matchDate reports whether the date matches the BYMONTH, BYMONTHDAY and BYDAY.
+func (it *rruleIter) matchDate(y int, m time.Month, d int) bool {
+	if !it.anyMonth && !it.months[m] {
+		return false
+	}
+	dim := daysIn(y, m)
+	if len(it.monthDays) > 0 {
+		matched := false
+		for _, md := range it.monthDays {
+			if md == d || md == d-dim-1 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(it.days) == 0 {
+		return true
+	}
+	date := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	wd := date.Weekday()
+	for _, rd := range it.days {
+		if rd.Weekday != wd {
+			continue
+		}
+		if rd.N == 0 {
+			return true
+		}
+		// The ordinal of the date within the month or the year.
+		pos, total := d, dim
+		if it.yearScope {
+			pos, total = date.YearDay(), int(daysBetween(y, time.January, 1, y+1, time.January, 1))
+		}
+		if rd.N > 0 && (pos-1)/7+1 == rd.N {
+			return true
+		}
+		if rd.N < 0 && -((total-pos)/7+1) == rd.N {
+			return true
+		}
+	}
+	return false
+}
+
+// daysIn returns the number of days of the month m of year y.
+func daysIn(y int, m time.Month) int {
+	return time.Date(y, m+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+func containsInt(ns []int, n int) bool {
+	i := sort.SearchInts(ns, n)
+	return i < len(ns) && ns[i] == n
+}
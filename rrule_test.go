@@ -0,0 +1,258 @@
+package timewheel
+
+import (
+	"errors"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrequency_String(t *testing.T) {
+	require.Equal(t, "MONTHLY", Monthly.String())
+	require.Equal(t, "Frequency(0)", Frequency(0).String())
+}
+
+func TestParseRRule(t *testing.T) {
+	start := time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)
+	r, err := ParseRRule("RRULE:FREQ=MONTHLY;INTERVAL=2;COUNT=10;BYDAY=1SU,-1SU,tu;BYHOUR=9,17;WKST=SU", start)
+	require.NoError(t, err)
+	require.Equal(t, Monthly, r.Freq)
+	require.Equal(t, 2, r.Interval)
+	require.Equal(t, 10, r.Count)
+	require.Equal(t, []RRuleDay{{1, time.Sunday}, {-1, time.Sunday}, {0, time.Tuesday}}, r.ByDay)
+	require.Equal(t, []int{9, 17}, r.ByHour)
+	require.Equal(t, time.Sunday, r.WeekStart)
+	require.Equal(t, start, r.Start)
+
+	r, err = ParseRRule("FREQ=DAILY;UNTIL=19971224T000000Z;BYMONTH=1,12;BYMONTHDAY=-1,15", start)
+	require.NoError(t, err)
+	require.Equal(t, time.Date(1997, 12, 24, 0, 0, 0, 0, time.UTC).UnixNano(), r.Until.UnixNano())
+	require.Equal(t, []time.Month{time.January, time.December}, r.ByMonth)
+	require.Equal(t, []int{-1, 15}, r.ByMonthDay)
+	require.Equal(t, time.Monday, r.WeekStart)
+
+	for _, s := range []string{
+		"",
+		"FREQ=FORTNIGHTLY",
+		"INTERVAL=2",
+		"FREQ=DAILY;FREQ=DAILY",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;COUNT=x",
+		"FREQ=DAILY;COUNT=2;UNTIL=19971224T000000Z",
+		"FREQ=DAILY;UNTIL=1997-12-24",
+		"FREQ=DAILY;BYMONTH=13",
+		"FREQ=DAILY;BYMONTHDAY=0",
+		"FREQ=DAILY;BYMONTHDAY=32",
+		"FREQ=DAILY;BYDAY=XX",
+		"FREQ=DAILY;BYDAY=1MO",
+		"FREQ=MONTHLY;BYDAY=6MO",
+		"FREQ=YEARLY;BYDAY=54MO",
+		"FREQ=WEEKLY;BYMONTHDAY=1",
+		"FREQ=DAILY;BYHOUR=24",
+		"FREQ=DAILY;BYMINUTE=60",
+		"FREQ=DAILY;BYSECOND=60",
+		"FREQ=DAILY;WKST=1MO",
+		"FREQ=DAILY;BYSETPOS=1",
+		"FREQ=DAILY;BYDAY",
+	} {
+		_, err := ParseRRule(s, start)
+		require.True(t, errors.Is(err, ErrInvalidSchedule), s)
+	}
+	_, err = ParseRRule("FREQ=DAILY", time.Time{})
+	require.True(t, errors.Is(err, ErrInvalidSchedule))
+}
+
+// The expansions of the examples in RFC 5545 3.8.5.3, and a few others.
+func TestRRule_Next(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	at := func(y int, m time.Month, d, h, mi int) time.Time {
+		return time.Date(y, m, d, h, mi, 0, 0, ny)
+	}
+	days := func(y int, m time.Month, ds ...int) []time.Time {
+		var ts []time.Time
+		for _, d := range ds {
+			ts = append(ts, at(y, m, d, 9, 0))
+		}
+		return ts
+	}
+	concat := func(tss ...[]time.Time) []time.Time {
+		var all []time.Time
+		for _, ts := range tss {
+			all = append(all, ts...)
+		}
+		return all
+	}
+
+	cases := []struct {
+		rule  string
+		start time.Time
+		want  []time.Time
+		// The want are all the occurrences.
+		all bool
+	}{
+		{"FREQ=DAILY;COUNT=10", at(1997, 9, 2, 9, 0), days(1997, 9, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11), true},
+		{"FREQ=DAILY;INTERVAL=2", at(1997, 9, 2, 9, 0), days(1997, 9, 2, 4, 6, 8, 10, 12, 14), false},
+		{"FREQ=DAILY;INTERVAL=10;COUNT=5", at(1997, 9, 2, 9, 0), concat(days(1997, 9, 2, 12, 22), days(1997, 10, 2, 12)), true},
+		{"FREQ=WEEKLY;COUNT=10", at(1997, 9, 2, 9, 0), concat(days(1997, 9, 2, 9, 16, 23, 30), days(1997, 10, 7, 14, 21, 28), days(1997, 11, 4)), true},
+		{"FREQ=WEEKLY;UNTIL=19971007T000000Z;WKST=SU;BYDAY=TU,TH", at(1997, 9, 2, 9, 0), concat(days(1997, 9, 2, 4, 9, 11, 16, 18, 23, 25, 30), days(1997, 10, 2)), true},
+		{"FREQ=WEEKLY;INTERVAL=2;COUNT=8;WKST=SU;BYDAY=TU,TH", at(1997, 9, 2, 9, 0), concat(days(1997, 9, 2, 4, 16, 18, 30), days(1997, 10, 2, 14, 16)), true},
+		{"FREQ=WEEKLY;INTERVAL=2;COUNT=4;BYDAY=TU,SU;WKST=MO", at(1997, 8, 5, 9, 0), days(1997, 8, 5, 10, 19, 24), true},
+		{"FREQ=WEEKLY;INTERVAL=2;COUNT=4;BYDAY=TU,SU;WKST=SU", at(1997, 8, 5, 9, 0), days(1997, 8, 5, 17, 19, 31), true},
+		{"FREQ=MONTHLY;COUNT=10;BYDAY=1FR", at(1997, 9, 5, 9, 0), concat(days(1997, 9, 5), days(1997, 10, 3), days(1997, 11, 7), days(1997, 12, 5),
+			days(1998, 1, 2), days(1998, 2, 6), days(1998, 3, 6), days(1998, 4, 3), days(1998, 5, 1), days(1998, 6, 5)), true},
+		{"FREQ=MONTHLY;INTERVAL=2;COUNT=10;BYDAY=1SU,-1SU", at(1997, 9, 7, 9, 0), concat(days(1997, 9, 7, 28), days(1997, 11, 2, 30),
+			days(1998, 1, 4, 25), days(1998, 3, 1, 29), days(1998, 5, 3, 31)), true},
+		{"FREQ=MONTHLY;COUNT=6;BYDAY=-2MO", at(1997, 9, 22, 9, 0), concat(days(1997, 9, 22), days(1997, 10, 20), days(1997, 11, 17), days(1997, 12, 22),
+			days(1998, 1, 19), days(1998, 2, 16)), true},
+		{"FREQ=MONTHLY;BYMONTHDAY=-3", at(1997, 9, 28, 9, 0), concat(days(1997, 9, 28), days(1997, 10, 29), days(1997, 11, 28), days(1997, 12, 29),
+			days(1998, 1, 29), days(1998, 2, 26)), false},
+		{"FREQ=MONTHLY;COUNT=10;BYMONTHDAY=2,15", at(1997, 9, 2, 9, 0), concat(days(1997, 9, 2, 15), days(1997, 10, 2, 15), days(1997, 11, 2, 15),
+			days(1997, 12, 2, 15), days(1998, 1, 2, 15)), true},
+		{"FREQ=MONTHLY;COUNT=10;BYMONTHDAY=1,-1", at(1997, 9, 30, 9, 0), concat(days(1997, 9, 30), days(1997, 10, 1, 31), days(1997, 11, 1, 30),
+			days(1997, 12, 1, 31), days(1998, 1, 1, 31), days(1998, 2, 1)), true},
+		{"FREQ=MONTHLY;INTERVAL=18;COUNT=10;BYMONTHDAY=10,11,12,13,14,15", at(1997, 9, 10, 9, 0), concat(days(1997, 9, 10, 11, 12, 13, 14, 15),
+			days(1999, 3, 10, 11, 12, 13)), true},
+		{"FREQ=MONTHLY;INTERVAL=2;BYDAY=TU", at(1997, 9, 2, 9, 0), concat(days(1997, 9, 2, 9, 16, 23, 30), days(1997, 11, 4, 11, 18, 25),
+			days(1998, 1, 6, 13, 20, 27), days(1998, 3, 3, 10, 17, 24, 31)), false},
+		{"FREQ=MONTHLY;BYMONTHDAY=31", at(2024, 1, 31, 9, 0), concat(days(2024, 1, 31), days(2024, 3, 31), days(2024, 5, 31), days(2024, 7, 31, 0)[:1]), false},
+		{"FREQ=YEARLY;COUNT=10;BYMONTH=6,7", at(1997, 6, 10, 9, 0), concat(days(1997, 6, 10), days(1997, 7, 10), days(1998, 6, 10), days(1998, 7, 10),
+			days(1999, 6, 10), days(1999, 7, 10), days(2000, 6, 10), days(2000, 7, 10), days(2001, 6, 10), days(2001, 7, 10)), true},
+		{"FREQ=YEARLY;INTERVAL=2;COUNT=10;BYMONTH=1,2,3", at(1997, 3, 10, 9, 0), concat(days(1997, 3, 10), days(1999, 1, 10), days(1999, 2, 10),
+			days(1999, 3, 10), days(2001, 1, 10), days(2001, 2, 10), days(2001, 3, 10), days(2003, 1, 10), days(2003, 2, 10), days(2003, 3, 10)), true},
+		{"FREQ=YEARLY;BYDAY=20MO", at(1997, 5, 19, 9, 0), concat(days(1997, 5, 19), days(1998, 5, 18), days(1999, 5, 17)), false},
+		{"FREQ=YEARLY;BYMONTH=3;BYDAY=TH", at(1997, 3, 13, 9, 0), concat(days(1997, 3, 13, 20, 27), days(1998, 3, 5, 12, 19, 26), days(1999, 3, 4, 11, 18, 25)), false},
+		{"FREQ=YEARLY", at(2000, 2, 29, 9, 0), concat(days(2000, 2, 29), days(2004, 2, 29), days(2008, 2, 29)), false},
+		{"FREQ=MONTHLY;BYDAY=FR;BYMONTHDAY=13", at(1997, 9, 2, 9, 0), concat(days(1998, 2, 13), days(1998, 3, 13), days(1998, 11, 13),
+			days(1999, 8, 13), days(2000, 10, 13)), false},
+		{"FREQ=MONTHLY;BYDAY=SA;BYMONTHDAY=7,8,9,10,11,12,13", at(1997, 9, 13, 9, 0), concat(days(1997, 9, 13), days(1997, 10, 11), days(1997, 11, 8),
+			days(1997, 12, 13), days(1998, 1, 10), days(1998, 2, 7), days(1998, 3, 7), days(1998, 4, 11), days(1998, 5, 9), days(1998, 6, 13)), false},
+		{"FREQ=YEARLY;INTERVAL=4;BYMONTH=11;BYDAY=TU;BYMONTHDAY=2,3,4,5,6,7,8", at(1996, 11, 5, 9, 0), concat(days(1996, 11, 5), days(2000, 11, 7),
+			days(2004, 11, 2)), false},
+		{"FREQ=MONTHLY;BYDAY=-1FR", at(2024, 1, 1, 9, 0), concat(days(2024, 1, 26), days(2024, 2, 23), days(2024, 3, 29), days(2024, 4, 26),
+			days(2024, 5, 31), days(2024, 6, 28)), false},
+		{"FREQ=MINUTELY;INTERVAL=15;COUNT=6", at(1997, 9, 2, 9, 0), []time.Time{at(1997, 9, 2, 9, 0), at(1997, 9, 2, 9, 15), at(1997, 9, 2, 9, 30),
+			at(1997, 9, 2, 9, 45), at(1997, 9, 2, 10, 0), at(1997, 9, 2, 10, 15)}, true},
+		{"FREQ=MINUTELY;INTERVAL=90;COUNT=4", at(1997, 9, 2, 9, 0), []time.Time{at(1997, 9, 2, 9, 0), at(1997, 9, 2, 10, 30), at(1997, 9, 2, 12, 0),
+			at(1997, 9, 2, 13, 30)}, true},
+		{"FREQ=DAILY;BYHOUR=9,16;BYMINUTE=0,40", at(1997, 9, 2, 9, 0), []time.Time{at(1997, 9, 2, 9, 0), at(1997, 9, 2, 9, 40), at(1997, 9, 2, 16, 0),
+			at(1997, 9, 2, 16, 40), at(1997, 9, 3, 9, 0)}, false},
+		{"FREQ=MINUTELY;INTERVAL=20;BYHOUR=9,16", at(1997, 9, 2, 9, 0), []time.Time{at(1997, 9, 2, 9, 0), at(1997, 9, 2, 9, 20), at(1997, 9, 2, 9, 40),
+			at(1997, 9, 2, 16, 0), at(1997, 9, 2, 16, 20), at(1997, 9, 2, 16, 40), at(1997, 9, 3, 9, 0)}, false},
+		{"FREQ=HOURLY;INTERVAL=3;BYDAY=SA;BYMINUTE=30", at(1997, 9, 5, 23, 0), []time.Time{at(1997, 9, 6, 2, 30), at(1997, 9, 6, 5, 30),
+			at(1997, 9, 6, 8, 30), at(1997, 9, 6, 11, 30), at(1997, 9, 6, 14, 30), at(1997, 9, 6, 17, 30), at(1997, 9, 6, 20, 30),
+			at(1997, 9, 6, 23, 30), at(1997, 9, 13, 2, 30)}, false},
+		// The 02:30 doesn't exist on 2024-03-10 in New York.
+		{"FREQ=DAILY;BYHOUR=2;BYMINUTE=30", at(2024, 3, 9, 0, 0), []time.Time{at(2024, 3, 9, 2, 30), at(2024, 3, 11, 2, 30)}, false},
+		// The start that doesn't match is not an occurrence.
+		{"FREQ=WEEKLY;BYDAY=MO", at(2024, 1, 3, 9, 0), days(2024, 1, 8, 15, 22), false},
+	}
+	for _, c := range cases {
+		r, err := ParseRRule(c.rule, c.start)
+		require.NoError(t, err, c.rule)
+
+		// Expanded one by one from before the start.
+		var got []time.Time
+		prev := c.start.Add(-time.Nanosecond)
+		for len(got) < len(c.want)+1 {
+			next := r.Next(prev)
+			if next.IsZero() {
+				break
+			}
+			got = append(got, next)
+			prev = next
+		}
+		if !c.all {
+			got = got[:len(c.want)]
+		}
+		require.Equal(t, unixNanos(c.want), unixNanos(got), c.rule)
+
+		// From an arbitrary time in between.
+		for i, want := range c.want {
+			require.Equal(t, want.UnixNano(), r.Next(want.Add(-time.Second)).UnixNano(), c.rule)
+			if i > 0 {
+				require.Equal(t, want.UnixNano(), r.Next(c.want[i-1].Add(time.Second)).UnixNano(), c.rule)
+			}
+		}
+		if c.all {
+			require.True(t, r.Next(c.want[len(c.want)-1]).IsZero(), c.rule)
+		}
+
+		// The iterator that remembers the counted occurrences agrees.
+		it, err := newRRuleIter(&r)
+		require.NoError(t, err)
+		prev = c.start.Add(-time.Nanosecond)
+		for _, want := range c.want {
+			prev = it.next(prev)
+			require.Equal(t, want.UnixNano(), prev.UnixNano(), c.rule)
+		}
+	}
+}
+
+func unixNanos(ts []time.Time) []int64 {
+	ns := make([]int64, len(ts))
+	for i, t := range ts {
+		ns[i] = t.UnixNano()
+	}
+	return ns
+}
+
+func TestRRule_Next_Far(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	r, err := ParseRRule("FREQ=SECONDLY;INTERVAL=7;BYMONTH=2;BYMONTHDAY=29;BYHOUR=12;BYMINUTE=30", start)
+	require.NoError(t, err)
+	// Jumps to the period of the given time, rather than iterating from the start.
+	after := time.Date(2399, 6, 1, 0, 0, 0, 0, time.UTC)
+	next := r.Next(after)
+	require.Equal(t, 2400, next.Year())
+	require.Equal(t, time.February, next.Month())
+	require.Equal(t, 29, next.Day())
+	require.Equal(t, 12, next.Hour())
+	require.Equal(t, 30, next.Minute())
+	require.Equal(t, int64(0), (next.Unix()-start.Unix())%7)
+
+	// No occurrence at all.
+	r, err = ParseRRule("FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=30", start)
+	require.NoError(t, err)
+	require.True(t, r.Next(start).IsZero())
+	r, err = ParseRRule("FREQ=MINUTELY;BYMONTH=4;BYMONTHDAY=31", start)
+	require.NoError(t, err)
+	require.True(t, r.Next(start).IsZero())
+}
+
+func TestRRule_Do(t *testing.T) {
+	clock := &manualClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	tw := New(time.Millisecond, 8, WithClock(clock), WithDispatchPolicy(DispatchInline))
+	tw.Start()
+	defer tw.Stop()
+
+	r, err := ParseRRule("FREQ=SECONDLY;INTERVAL=2;COUNT=3", clock.Now().Add(time.Second))
+	require.NoError(t, err)
+	var at []time.Time
+	timer, err := r.Do(tw, func() { at = append(at, clock.Now()) })
+	require.NoError(t, err)
+	for timer.State() != StateCompleted {
+		clock.add(time.Millisecond * 100)
+		tw.Poll()
+	}
+	require.Len(t, at, 3)
+	require.Equal(t, int64(2*time.Second), at[1].Sub(at[0]).Nanoseconds())
+	require.Equal(t, int64(2*time.Second), at[2].Sub(at[1]).Nanoseconds())
+
+	// All the occurrences have passed.
+	r, err = ParseRRule("FREQ=DAILY;COUNT=1", clock.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	_, err = r.Do(tw, func() {})
+	var se *ScheduleError
+	require.True(t, errors.As(err, &se))
+	require.Equal(t, "RRule", se.Op)
+	require.True(t, errors.Is(err, ErrInvalidSchedule))
+
+	tw.Stop()
+	r, err = ParseRRule("FREQ=DAILY", clock.Now())
+	require.NoError(t, err)
+	_, err = r.Do(tw, func() {})
+	require.True(t, errors.Is(err, ErrStopped))
+}
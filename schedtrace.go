@@ -0,0 +1,221 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// schedTraceMagic is the header of a schedule trace, the last byte is the version.
+const schedTraceMagic = "twtrace\x01"
+
+// The kinds of the records of a schedule trace.
+const (
+	recordSchedule byte = 1
+	recordCancel   byte = 2
+)
+
+// schedTraceBufferSize is the size of the records buffered by the TraceRecorder.
+const schedTraceBufferSize = 64 << 10
+
+// TraceRecorder is an Observer that records the schedules and cancellations
+// of the timers into a compact binary stream, which can be replayed against a
+// TimeWheel of another configuration by Replay, e.g. to try a new tick and
+// size with the real load before deploying it to the production. It records
+// the time, the ID and the delay of the timers only, neither the tags nor the
+// payloads.
+//
+// It's registered by WithObserver, and costs nothing unless registered. Each
+// event is encoded into a buffer in memory under a mutex, which is written to
+// the underlying writer once it's full, thus a slow writer blocks the thread
+// that schedules or closes a timer meanwhile. Call Close to flush the buffer
+// once done.
+//
+// The first error of writing is kept and returned by Flush and Close, the
+// events are dropped since then.
+type TraceRecorder struct {
+	mu   sync.Mutex
+	w    io.Writer
+	buf  []byte
+	last int64 // the time of the last event, in nanoseconds.
+	err  error
+
+	closed bool
+}
+
+// NewTraceRecorder creates a TraceRecorder that writes to w.
+func NewTraceRecorder(w io.Writer) *TraceRecorder {
+	buf := make([]byte, 0, schedTraceBufferSize)
+	return &TraceRecorder{w: w, buf: append(buf, schedTraceMagic...)}
+}
+
+// OnSchedule implements the Observer.
+func (r *TraceRecorder) OnSchedule(t *Timer) {
+	now := t.tw.root.now()
+	r.record(recordSchedule, now, t.ID(), t.getExpiration()-now)
+}
+
+// OnFire implements the Observer, the fires are not recorded since they're
+// reproduced by the replay.
+func (r *TraceRecorder) OnFire(*Timer) {}
+
+// OnCancel implements the Observer.
+func (r *TraceRecorder) OnCancel(t *Timer) {
+	r.record(recordCancel, t.tw.root.now(), t.ID(), 0)
+}
+
+// record appends a record of the kind, it's the kind, the time elapsed since
+// the last event, the ID and the delay of a schedule as varints.
+func (r *TraceRecorder) record(kind byte, now int64, id uint64, delay int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil || r.closed {
+		return
+	}
+	if len(r.buf)+1+3*binary.MaxVarintLen64 > cap(r.buf) {
+		r.flush()
+	}
+	if r.last == 0 {
+		r.last = now
+	}
+	elapsed := now - r.last
+	if elapsed < 0 {
+		// The clock went backwards.
+		elapsed = 0
+	}
+	r.last += elapsed
+
+	r.buf = append(r.buf, kind)
+	r.buf = binary.AppendUvarint(r.buf, uint64(elapsed))
+	r.buf = binary.AppendUvarint(r.buf, id)
+	if kind == recordSchedule {
+		r.buf = binary.AppendVarint(r.buf, delay)
+	}
+}
+
+func (r *TraceRecorder) flush() {
+	if r.err == nil && !r.closed && len(r.buf) != 0 {
+		_, r.err = r.w.Write(r.buf)
+	}
+	r.buf = r.buf[:0]
+}
+
+// Flush writes the buffered records to the underlying writer.
+func (r *TraceRecorder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.flush()
+	return r.err
+}
+
+// Close flushes the buffered records like Flush, and closes the underlying
+// writer if it's an io.Closer. The events since closed are dropped.
+func (r *TraceRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return r.err
+	}
+	r.flush()
+	r.closed = true
+	if c, ok := r.w.(io.Closer); ok {
+		if err := c.Close(); r.err == nil {
+			r.err = err
+		}
+	}
+	return r.err
+}
+
+// Replay re-issues the schedules and cancellations recorded by TraceRecorder
+// from r against tw, with the no-op tasks, and returns the number of the
+// events replayed. The time between the events and the delays of the timers
+// are divided by the speedup, e.g. 10 means replaying a trace of an hour in 6
+// minutes; the Stats, the MetricsSink and the ConsumerJitter of tw capture the
+// lag and memory meanwhile.
+//
+// It's paced by the real time, and returns once all the events are issued,
+// without waiting for the timers to fire. A timer that is recorded as
+// scheduled again with the same ID, e.g. a recurring timer or Reset, replaces
+// the previous one. It returns ErrInvalidTrace if r is not a schedule trace or
+// is corrupted, a truncated trace is replayed up to the last complete event.
+func Replay(r io.Reader, tw *TimeWheel, speedup float64) (int, error) {
+	if speedup <= 0 {
+		return 0, fmt.Errorf("%w: speedup %v must be greater than 0", ErrInvalidTrace, speedup)
+	}
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(schedTraceMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != schedTraceMagic {
+		return 0, ErrInvalidTrace
+	}
+
+	type entry struct{ t *Timer }
+	var mu sync.Mutex
+	timers := make(map[uint64]*entry)
+
+	var elapsed time.Duration // of the trace.
+	start := time.Now()
+	n := 0
+	for {
+		kind, err := br.ReadByte()
+		if errors.Is(err, io.EOF) {
+			return n, nil
+		}
+		gap, err1 := binary.ReadUvarint(br)
+		id, err2 := binary.ReadUvarint(br)
+		var delay int64
+		var err3 error
+		if kind == recordSchedule {
+			delay, err3 = binary.ReadVarint(br)
+		}
+		if err := errors.Join(err1, err2, err3); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				// The trace is truncated.
+				return n, nil
+			}
+			return n, fmt.Errorf("%w: %v", ErrInvalidTrace, err)
+		}
+
+		elapsed += time.Duration(gap)
+		if wait := time.Duration(float64(elapsed)/speedup) - time.Since(start); wait > 0 {
+			time.Sleep(wait)
+		}
+		switch kind {
+		case recordSchedule:
+			e := &entry{}
+			mu.Lock()
+			old := timers[id]
+			timers[id] = e
+			mu.Unlock()
+			if old != nil {
+				old.t.Close()
+			}
+			e.t = tw.AfterFunc(time.Duration(float64(delay)/speedup), func() {
+				mu.Lock()
+				if timers[id] == e {
+					delete(timers, id)
+				}
+				mu.Unlock()
+			})
+		case recordCancel:
+			mu.Lock()
+			e := timers[id]
+			delete(timers, id)
+			mu.Unlock()
+			if e != nil {
+				e.t.Close()
+			}
+		default:
+			return n, fmt.Errorf("%w: unknown kind %d", ErrInvalidTrace, kind)
+		}
+		n++
+	}
+}
@@ -0,0 +1,98 @@
+package timewheel
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func recordTrace(t *testing.T) []byte {
+	var buf bytes.Buffer
+	rec := NewTraceRecorder(&buf)
+	clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	tw := New(time.Millisecond, 64, WithClock(clock), WithDispatchPolicy(DispatchInline), WithObserver(rec))
+	tw.Start()
+	defer tw.Stop()
+
+	tw.AfterFunc(time.Millisecond*10, func() {})
+	clock.add(time.Millisecond * 5)
+	long := tw.AfterFunc(time.Hour, func() {})
+	clock.add(time.Millisecond * 5)
+	tw.Poll()
+	long.Close()
+	tw.AfterFunc(time.Millisecond*20, func() {})
+
+	require.NoError(t, rec.Close())
+	return buf.Bytes()
+}
+
+func TestTraceRecorder(t *testing.T) {
+	data := recordTrace(t)
+	require.True(t, bytes.HasPrefix(data, []byte(schedTraceMagic)))
+	// 3 schedules and 1 cancellation of at most 10 bytes each.
+	require.Less(t, len(data), len(schedTraceMagic)+4*10)
+
+	var buf bytes.Buffer
+	rec := NewTraceRecorder(&buf)
+	require.NoError(t, rec.Close())
+	// The events since closed are dropped.
+	tw := New(time.Millisecond, 64, WithObserver(rec))
+	tw.Start()
+	defer tw.Stop()
+	tw.AfterFunc(time.Hour, func() {}).Close()
+	require.NoError(t, rec.Flush())
+	require.Equal(t, schedTraceMagic, buf.String())
+}
+
+func TestReplay(t *testing.T) {
+	data := recordTrace(t)
+
+	tw := New(time.Millisecond, 64)
+	tw.Start()
+	defer tw.Stop()
+	start := time.Now()
+	n, err := Replay(bytes.NewReader(data), tw, 10)
+	require.NoError(t, err)
+	require.Equal(t, 4, n)
+	// The events are 10ms apart in the trace.
+	require.True(t, time.Since(start) >= time.Millisecond)
+
+	require.Eventually(t, func() bool { return tw.Pending() == 0 }, time.Second, time.Millisecond)
+	stats := tw.Stats()
+	require.Equal(t, uint64(3), stats.Scheduled)
+	require.Equal(t, uint64(2), stats.Fired)
+	require.Equal(t, uint64(1), stats.Cancelled)
+}
+
+func TestReplay_Truncated(t *testing.T) {
+	data := recordTrace(t)
+
+	tw := New(time.Millisecond, 64)
+	tw.Start()
+	defer tw.Stop()
+	n, err := Replay(bytes.NewReader(data[:len(data)-1]), tw, 100)
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+}
+
+func TestReplay_Invalid(t *testing.T) {
+	tw := New(time.Millisecond, 64)
+	tw.Start()
+	defer tw.Stop()
+
+	data := recordTrace(t)
+	_, err := Replay(bytes.NewReader(data), tw, 0)
+	require.True(t, errors.Is(err, ErrInvalidTrace))
+
+	_, err = Replay(bytes.NewReader([]byte("not a trace")), tw, 1)
+	require.True(t, errors.Is(err, ErrInvalidTrace))
+
+	corrupted := append([]byte(schedTraceMagic), 9, 0, 1)
+	n, err := Replay(bytes.NewReader(corrupted), tw, 1)
+	require.True(t, errors.Is(err, ErrInvalidTrace))
+	require.Equal(t, 0, n)
+	require.Equal(t, int64(0), tw.Pending())
+}
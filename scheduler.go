@@ -6,6 +6,8 @@
 package timewheel
 
 import (
+	"context"
+	"runtime/trace"
 	"time"
 )
 
@@ -18,7 +20,7 @@ type Scheduler interface {
 	Run()
 }
 
-// Schedule calls the sh.Run (in its own goroutine) according to the execution
+// Schedule calls the sh.Run (in its own goroutine by default) according to the execution
 // plan scheduled by sh.Next. It returns a Timer that can be used to cancel the
 // call using its Close method.
 //
@@ -31,66 +33,243 @@ type Scheduler interface {
 // sh.Next) initially, and create a timer if the execution time is non-zero.
 // Afterwards, it will ask the next execution time each time task is about to
 // be executed, and task will be called at the next execution time if the time
-// is non-zero.
-func (tw *TimeWheel) Schedule(sh Scheduler) *Timer {
-	next := sh.Next(time.Now())
-	if next.IsZero() {
-		// No time is scheduled, return empty timer..
-		return &Timer{}
-	}
+// is non-zero. If the Immediately is given, the first execution time is the
+// current time instead, and the sh.Next is asked first when it's executed.
+func (tw *TimeWheel) Schedule(sh Scheduler, opts ...TimerOption) *Timer {
 	var t *Timer
 	t = &Timer{
-		expiration: next.UnixNano(),
 		task: func() {
 			// Schedule the task to execute at the next time if possible.
-			next := sh.Next(time.Unix(0, t.expiration))
+			at := t.getExpiration()
+			next := sh.Next(time.Unix(0, at))
 			if !next.IsZero() {
 				// Resubmit the timer to next cycle.
-				t.expiration = next.UnixNano()
-				tw.submit(t)
+				t.setExpiration(next.UnixNano())
+				if trace.IsEnabled() {
+					traceSchedule(context.Background(), t)
+				}
+				// The t.tw is the plane that t is routed to.
+				t.tw.schedule(t)
+
+				// Actually execute the task func.
+				t.tw.runRecurring(t, sh, at, false)
+				return
 			}
 
-			// Actually execute the task func.
-			//
-			// Like the standard time.AfterFunc (https://golang.org/pkg/time/#AfterFunc),
-			// always execute the timer's task in its own goroutine.
-			go sh.Run()
+			// The execution plan ends after the last task.
+			t.setEndReason(endReasonOf(sh))
+			t.tw.runRecurring(t, sh, at, true)
 		},
+		tw:      tw,
 		b:       nil,
 		element: nil,
 	}
+	t.apply(opts)
 
-	tw.submit(t)
+	next := tw.timeNow()
+	if !t.getAttrs().immediate {
+		next = sh.Next(next)
+	}
+	if next.IsZero() {
+		// No time is scheduled, return empty timer that has been finished.
+		*t = Timer{meta: newMeta(0, StateCompleted, endReasonOf(sh))}
+		t.apply(opts)
+		t.finish()
+		return t
+	}
+	t.expiration = next.UnixNano()
+	t.meta = newMeta(tw.nextID(), 0, EndNone)
+
+	if trace.IsEnabled() {
+		traceSchedule(context.Background(), t)
+	}
+	tw.scheduleNew(t, true)
 	return t
 }
 
-// TimeFunc waits until the appointed time and then calls f in its own goroutine.
+// TimeFunc waits until the appointed time and then calls f in its own goroutine by default.
 // It returns a Timer that can be used to cancel the call using its Close method.
-func (tw *TimeWheel) TimeFunc(t time.Time, f func()) *Timer {
-	return tw.expireFunc(t.UnixNano(), f)
+func (tw *TimeWheel) TimeFunc(t time.Time, f func(), opts ...TimerOption) *Timer {
+	return tw.expireFunc(context.Background(), t.UnixNano(), func(context.Context, *Timer) { f() }, opts)
+}
+
+// TimeFuncContext is like TimeFunc, but f receives a context derived from ctx,
+// see AfterFuncContext.
+func (tw *TimeWheel) TimeFuncContext(ctx context.Context, t time.Time, f func(ctx context.Context), opts ...TimerOption) *Timer {
+	return tw.expireFunc(ctx, t.UnixNano(), func(ctx context.Context, _ *Timer) { f(ctx) }, opts)
 }
 
-// AfterFunc waits for the duration to elapse and then calls f in its own goroutine.
+// AfterFunc waits for the duration to elapse and then calls f in its own goroutine by default.
 // It returns a Timer that can be used to cancel the call using its Close method.
-func (tw *TimeWheel) AfterFunc(d time.Duration, f func()) *Timer {
-	return tw.expireFunc(time.Now().Add(d).UnixNano(), f)
+func (tw *TimeWheel) AfterFunc(d time.Duration, f func(), opts ...TimerOption) *Timer {
+	return tw.expireFunc(context.Background(), tw.expireAfter(d), func(context.Context, *Timer) { f() }, opts)
+}
+
+// AfterFuncContext is like AfterFunc, but the f receives a context that derived
+// from the ctx. The context is cancelled after the task timeout elapsed if the
+// WithTaskTimeout is set, or the TimeWheel is stopped if the WithBaseContext
+// is set, thus the f should return promptly once it's done.
+func (tw *TimeWheel) AfterFuncContext(ctx context.Context, d time.Duration, f func(ctx context.Context), opts ...TimerOption) *Timer {
+	return tw.expireFunc(ctx, tw.expireAfter(d), func(ctx context.Context, _ *Timer) { f(ctx) }, opts)
+}
+
+// TryAfterFunc is like AfterFunc, but it never queues behind the contended
+// locks of the TimeWheel. It returns a *ScheduleError that wraps ErrStopped
+// (or ErrIdleShutdown), ErrDelayTooLarge, ErrRateLimited, ErrFull, ErrShed,
+// ErrQuotaExceeded or ErrBusy immediately if the timer can't be scheduled
+// right now, so that the caller can apply the backpressure.
+//
+// The timer is inserted only if the lock of its bucket is acquired within a
+// bounded spin, otherwise it's rejected with ErrBusy. Thus, under heavy
+// contention on the same bucket (i.e. many timers expire at the same tick are
+// scheduled concurrently), a small fraction of the calls fail even if the
+// TimeWheel has room, the caller may retry or fall back to AfterFunc.
+// NOTICE: the first timer of a bucket enqueues the bucket into the delay
+// queue, which may wait briefly for the lock of the queue.
+func (tw *TimeWheel) TryAfterFunc(d time.Duration, f func(), opts ...TimerOption) (*Timer, error) {
+	expiration := tw.expireAfter(d)
+	run := func(context.Context, *Timer) { f() }
+	t := tw.newFuncTimer(context.Background(), expiration, run, opts)
+	tw.setRedrive(context.Background(), t, run, opts)
+	tw = tw.route(t)
+
+	err := tw.enter()
+	if err != nil {
+		tw.reject(t, err)
+	} else {
+		if err = ErrStopped; !tw.stoppedNow() {
+			if err = tw.admit(t); err != nil {
+				tw.reject(t, err)
+			} else if err = ErrBusy; tw.trySchedule(t) {
+				err = nil
+			}
+		}
+		tw.leave()
+	}
+	if err == nil {
+		return t, nil
+	}
+	return nil, &ScheduleError{Op: "TryAfterFunc", Expiration: time.Unix(0, expiration), Tag: t.Tag(), Err: err}
 }
 
 // expireFunc help creates a Timer of run-once by giving an expiration timestamp.
-func (tw *TimeWheel) expireFunc(expiration int64, f func()) *Timer {
+// The f receives the timer itself, since the timer may expire before returned.
+func (tw *TimeWheel) expireFunc(ctx context.Context, expiration int64, f func(ctx context.Context, t *Timer), opts []TimerOption) *Timer {
+	t := tw.newFuncTimer(ctx, expiration, f, opts)
+	tw.setRedrive(ctx, t, f, opts)
+	tw.scheduleNew(t, false)
+	return t
+}
+
+// newFuncTimer creates an unscheduled Timer of run-once that executes f.
+func (tw *TimeWheel) newFuncTimer(ctx context.Context, expiration int64, f func(ctx context.Context, t *Timer), opts []TimerOption) *Timer {
 	t := &Timer{
 		expiration: expiration,
-		task: func() {
+		meta:       newMeta(tw.nextID(), 0, EndNone),
+		tw:         tw,
+		b:          nil,
+		element:    nil,
+	}
+	t.apply(opts)
+
+	if trace.IsEnabled() {
+		var run func(ctx context.Context)
+		ctx, run = traceTask(ctx, t, func(ctx context.Context) {
+			if tw.guard(ctx, t, t.getExpiration()) {
+				f(ctx, t)
+			}
+			t.complete()
+		})
+		t.task = func() {
+			tw.dispatch(ctx, t, run)
+		}
+	} else {
+		// Only the task is allocated while the timer is pending, the func
+		// that dispatched is allocated once the timer expired.
+		t.task = func() {
 			// Actually execute the task func.
-			//
-			// Like the standard time.AfterFunc (https://golang.org/pkg/time/#AfterFunc),
-			// always execute the timer's task in its own goroutine.
-			go f()
-		},
-		b:       nil,
-		element: nil,
+			tw.dispatch(ctx, t, func(ctx context.Context) {
+				if tw.guard(ctx, t, t.getExpiration()) {
+					f(ctx, t)
+				}
+				t.complete()
+			})
+		}
 	}
+	return t
+}
 
-	tw.submit(t)
+// At waits until the appointed time and then sends the timer to the channel
+// returned by tw.Expired. The timer carries the payload that can be retrieved
+// by its Payload method, and it can be used to cancel the delivery using its
+// Close method.
+//
+// It panics if the TimeWheel is created without the WithExpiredChannel or the
+// OnExpireBatch option.
+func (tw *TimeWheel) At(t time.Time, payload interface{}, opts ...TimerOption) *Timer {
+	return tw.expireDeliver(t.UnixNano(), payload, opts)
+}
+
+// After waits for the duration to elapse and then sends the timer to the
+// channel returned by tw.Expired. See At for details.
+func (tw *TimeWheel) After(d time.Duration, payload interface{}, opts ...TimerOption) *Timer {
+	return tw.expireDeliver(tw.expireAfter(d), payload, opts)
+}
+
+// expireDeliver help creates a Timer of channel-based delivery by giving an expiration timestamp.
+func (tw *TimeWheel) expireDeliver(expiration int64, payload interface{}, opts []TimerOption) *Timer {
+	if tw.root.expiredC == nil && tw.root.opts.onExpireBatch == nil {
+		panic("timewheel: delivery requires the WithExpiredChannel option")
+	}
+	t := &Timer{
+		expiration: expiration,
+		meta:       newMeta(tw.nextID(), 0, EndNone),
+		tw:         tw,
+		b:          nil,
+		element:    nil,
+	}
+	if payload != nil {
+		t.setAttrs().payload = payload
+	}
+	t.apply(opts)
+
+	t.task = func() {
+		if tw.root.opts.onExpireBatch != nil {
+			tw.handOver(t, true)
+			return
+		}
+		if tw.deliver(t) {
+			t.complete()
+		} else {
+			tw.drop(t, ErrChannelFull)
+		}
+	}
+
+	tw.scheduleNew(t, false)
 	return t
 }
+
+// deliver sends the expired timer t to the expired channel according to the
+// DeliveryPolicy, it returns false if t is dropped since the channel is full.
+//
+// It's usually called in the consumer goroutine, or in the caller's goroutine
+// if the timer is already expired when it's created.
+func (tw *TimeWheel) deliver(t *Timer) (delivered bool) {
+	root := tw.root
+	if root.opts.expiredPolicy == DeliveryDrop {
+		select {
+		case root.expiredC <- t:
+			return true
+		default:
+			return false
+		}
+	}
+	select {
+	case root.expiredC <- t:
+	case <-root.stopC:
+		if l := root.opts.logger; l != nil {
+			l.Warn("timewheel: timer dropped at shutdown", timerAttr(t))
+		}
+	}
+	return true
+}
@@ -1,7 +1,9 @@
 package timewheel
 
 import (
+	"errors"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -77,14 +79,204 @@ func TestTimeWheel_Schedule_Next(t *testing.T) {
 	tw.Start()
 
 	timer := tw.Schedule(task)
-	require.Equal(t, tw.queue.Len(), 1)
+	require.Equal(t, tw.queue.len(), 1)
 
 	task.wg.Wait()
 
 	require.True(t, task.zero)
 	require.Equal(t, task.count, 0)
 	// The task not be re-insert to the queue if return zero time in task.Next.
-	require.Equal(t, tw.queue.Len(), 0)
+	require.Equal(t, tw.queue.len(), 0)
 
 	timer.Close()
 }
+
+func TestTimeWheel_After(t *testing.T) {
+	tw := New(time.Millisecond, 8, WithExpiredChannel(4, DeliveryBlock))
+	tw.Start()
+	defer tw.Stop()
+
+	t1 := tw.After(time.Millisecond*5, "t1")
+	t2 := tw.At(time.Now().Add(time.Millisecond*10), "t2")
+	t3 := tw.After(time.Millisecond*15, "t3")
+	t3.Close()
+
+	require.Equal(t, <-tw.Expired(), t1)
+	require.Equal(t, <-tw.Expired(), t2)
+	require.Equal(t, t2.Payload(), "t2")
+
+	select {
+	case <-tw.Expired():
+		t.Fatal("closed timer is delivered")
+	case <-time.After(time.Millisecond * 30):
+	}
+	require.Equal(t, tw.Pending(), int64(0))
+}
+
+func TestTimeWheel_After_Panic(t *testing.T) {
+	tw := Default()
+	require.Nil(t, tw.Expired())
+	require.Panics(t, func() {
+		tw.After(time.Millisecond, nil)
+	})
+}
+
+func TestTimeWheel_After_Drop(t *testing.T) {
+	var drops int32
+	tw := New(time.Millisecond, 8, WithExpiredChannel(1, DeliveryDrop), OnDrop(func(t *Timer, err error) {
+		if errors.Is(err, ErrChannelFull) {
+			atomic.AddInt32(&drops, 1)
+		}
+	}))
+	tw.Start()
+	defer tw.Stop()
+	events, cancel := tw.Watch(16)
+	defer cancel()
+
+	timers := make([]*Timer, 3)
+	for i := range timers {
+		timers[i] = tw.After(time.Millisecond*time.Duration(i+1), i)
+	}
+	// No one receive from the channel, so the timers after the first are dropped.
+	require.Eventually(t, func() bool { return tw.Pending() == 0 }, time.Second, time.Millisecond)
+	for _, timer := range timers {
+		<-timer.Done()
+	}
+
+	require.Equal(t, (<-tw.Expired()).Payload(), 0)
+	require.Equal(t, len(tw.Expired()), 0)
+	require.Equal(t, EndCompleted, timers[0].EndReason())
+	require.Equal(t, EndDropped, timers[1].EndReason())
+	require.Equal(t, EndDropped, timers[2].EndReason())
+	require.Equal(t, uint64(2), tw.Stats().Dropped)
+	require.Equal(t, int32(2), atomic.LoadInt32(&drops))
+
+	var dropped int
+	for len(events) != 0 {
+		if e := <-events; e.Type == EventDropped {
+			dropped++
+		}
+	}
+	require.Equal(t, 2, dropped)
+}
+
+func TestTimeWheel_After_Block_Stop(t *testing.T) {
+	tw := New(time.Millisecond, 8, WithExpiredChannel(0, DeliveryBlock))
+	tw.Start()
+
+	tw.After(time.Millisecond, nil)
+	// Waits for the timer expired and the delivery blocked.
+	time.Sleep(time.Millisecond * 10)
+
+	// The consumer goroutine is blocked by the delivery, Stop must not deadlock.
+	doneC := make(chan struct{})
+	go func() {
+		tw.Stop()
+		close(doneC)
+	}()
+	select {
+	case <-doneC:
+	case <-time.After(time.Second):
+		t.Fatal("Stop is blocked by the delivery")
+	}
+}
+
+func TestTimeWheel_TryAfterFunc(t *testing.T) {
+	tw := New(time.Millisecond, 8, WithMaxPending(2))
+	tw.Start()
+	defer tw.Stop()
+
+	ranC := make(chan struct{}, 1)
+	timer, err := tw.TryAfterFunc(time.Millisecond, func() { ranC <- struct{}{} })
+	require.NoError(t, err)
+	<-ranC
+	<-timer.Done()
+
+	_, err = tw.TryAfterFunc(time.Hour, func() {})
+	require.NoError(t, err)
+	_, err = tw.TryAfterFunc(time.Hour, func() {})
+	require.NoError(t, err)
+	_, err = tw.TryAfterFunc(time.Hour, func() {}, WithTag("full"))
+	require.True(t, errors.Is(err, ErrFull))
+	var se *ScheduleError
+	require.True(t, errors.As(err, &se))
+	require.Equal(t, "TryAfterFunc", se.Op)
+	require.Equal(t, "full", se.Tag)
+
+	tw.Stop()
+	_, err = tw.TryAfterFunc(time.Hour, func() {})
+	require.True(t, errors.Is(err, ErrStopped))
+}
+
+func TestTimeWheel_TryAfterFunc_Quota(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	defer tw.Stop()
+
+	tw.SetQuota("a", 1)
+	_, err := tw.TryAfterFunc(time.Hour, func() {}, WithTag("a"))
+	require.NoError(t, err)
+	_, err = tw.TryAfterFunc(time.Hour, func() {}, WithTag("a"))
+	require.True(t, errors.Is(err, ErrQuotaExceeded))
+	require.Equal(t, uint64(1), tw.Stats().Rejected)
+}
+
+func TestTimeWheel_TryAfterFunc_Busy(t *testing.T) {
+	clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	var rejected error
+	tw := New(time.Millisecond, 8, WithClock(clock), OnReject(func(_ *Timer, err error) { rejected = err }))
+	defer tw.Stop()
+	tw.SetQuota("a", 1)
+
+	// Hold the lock of the bucket that the timer goes to.
+	b := tw.ensureBucket((clock.Now().Add(time.Millisecond*5).UnixNano() / tw.tick) & tw.mask)
+	b.mu.Lock()
+	_, err := tw.TryAfterFunc(time.Millisecond*5, func() { t.Fatal("unexpected execution") }, WithTag("a"))
+	b.mu.Unlock()
+	require.True(t, errors.Is(err, ErrBusy))
+	require.Equal(t, ErrBusy, rejected)
+
+	// It's rolled back.
+	require.Equal(t, int64(0), tw.Pending())
+	require.Equal(t, uint64(1), tw.Stats().Rejected)
+	used, _ := tw.QuotaUsage("a")
+	require.Equal(t, int64(0), used)
+	require.Equal(t, 0, b.timers.Len())
+	clock.add(time.Millisecond * 5)
+	processed, _ := tw.Poll()
+	require.Equal(t, 0, processed)
+
+	// Succeeds once the lock is released.
+	_, err = tw.TryAfterFunc(time.Millisecond*5, func() {}, WithTag("a"))
+	require.NoError(t, err)
+}
+
+func TestTimeWheel_TryAfterFunc_Contended(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var scheduled, busy int
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				_, err := tw.TryAfterFunc(time.Hour, func() {})
+				mu.Lock()
+				if err == nil {
+					scheduled++
+				} else {
+					require.True(t, errors.Is(err, ErrBusy))
+					busy++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, 8000, scheduled+busy)
+	require.Equal(t, int64(scheduled), tw.Pending())
+	require.Equal(t, uint64(busy), tw.Stats().Rejected)
+}
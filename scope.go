@@ -0,0 +1,120 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Scope groups the timers scheduled through it, so that they can be cancelled
+// all at once by Close, e.g. the timers of a request or a session. It's created
+// by TimeWheel.Scope.
+//
+// A Scope is only the bookkeeping of its timers, they're held by the buckets
+// and expired by the consumer goroutine of the TimeWheel like any other timer.
+// Thus, it's cheap to create a Scope per request. It's safe for concurrent use.
+type Scope struct {
+	tw *TimeWheel
+
+	// The mu protects the timers and closed. A timer is removed from the
+	// timers once it's finished.
+	mu     sync.Mutex
+	timers map[*Timer]struct{}
+	closed bool
+}
+
+// Scope creates a Scope whose timers are scheduled in the TimeWheel.
+func (tw *TimeWheel) Scope() *Scope {
+	return &Scope{tw: tw, timers: make(map[*Timer]struct{})}
+}
+
+// AfterFunc is like TimeWheel.AfterFunc, but the timer belongs to the scope.
+// If the scope has been closed, the timer is rejected with ErrScopeClosed.
+func (s *Scope) AfterFunc(d time.Duration, f func(), opts ...TimerOption) *Timer {
+	return s.tw.AfterFunc(d, f, s.with(opts)...)
+}
+
+// AfterFuncContext is like TimeWheel.AfterFuncContext, but the timer belongs to the scope.
+func (s *Scope) AfterFuncContext(ctx context.Context, d time.Duration, f func(ctx context.Context), opts ...TimerOption) *Timer {
+	return s.tw.AfterFuncContext(ctx, d, f, s.with(opts)...)
+}
+
+// TimeFunc is like TimeWheel.TimeFunc, but the timer belongs to the scope.
+func (s *Scope) TimeFunc(t time.Time, f func(), opts ...TimerOption) *Timer {
+	return s.tw.TimeFunc(t, f, s.with(opts)...)
+}
+
+// Schedule is like TimeWheel.Schedule, but the timer belongs to the scope.
+func (s *Scope) Schedule(sh Scheduler, opts ...TimerOption) *Timer {
+	return s.tw.Schedule(sh, s.with(opts)...)
+}
+
+// Every is like TimeWheel.Every, but the timer scheduled by Do belongs to the
+// scope. The Do returns a *ScheduleError that wraps ErrScopeClosed if the
+// scope has been closed.
+func (s *Scope) Every(d time.Duration) *Recurrence {
+	r := s.tw.Every(d)
+	r.opts = []TimerOption{s.option}
+	return r
+}
+
+// Close cancels all the timers of the scope like Timer.Close, and rejects the
+// timers scheduled through the scope afterwards. It returns the number of
+// timers cancelled, the timers whose tasks have been dispatched are not
+// counted. It's safe to call Close multiple times.
+func (s *Scope) Close() int {
+	s.mu.Lock()
+	timers := s.timers
+	s.timers = nil
+	s.closed = true
+	s.mu.Unlock()
+
+	n := 0
+	for t := range timers {
+		if t.sweep() {
+			n++
+		}
+	}
+	return n
+}
+
+// Len returns the number of timers of the scope that are not finished.
+func (s *Scope) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.timers)
+}
+
+// with returns the opts with the option that binds the timer to the scope.
+func (s *Scope) with(opts []TimerOption) []TimerOption {
+	return append([]TimerOption{s.option}, opts...)
+}
+
+// option is the TimerOption that binds the timer to the scope, the timer is
+// added to the scope once it's admitted, see admit.
+func (s *Scope) option(t *Timer) {
+	t.setAttrs().scope = s
+}
+
+// add adds the timer t to the scope, it returns false if the scope is closed.
+func (s *Scope) add(t *Timer) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.timers[t] = struct{}{}
+	return true
+}
+
+// remove removes the finished timer t from the scope.
+func (s *Scope) remove(t *Timer) {
+	s.mu.Lock()
+	delete(s.timers, t)
+	s.mu.Unlock()
+}
@@ -0,0 +1,74 @@
+package timewheel
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScope(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	s := tw.Scope()
+	ranC := make(chan struct{}, 1)
+	fired := s.AfterFunc(time.Millisecond, func() { ranC <- struct{}{} })
+	<-ranC
+	<-fired.Done()
+	require.Equal(t, 0, s.Len())
+
+	a := s.AfterFunc(time.Hour, func() {})
+	b := s.AfterFuncContext(context.Background(), time.Hour, func(context.Context) {})
+	c := s.TimeFunc(time.Now().Add(time.Hour), func() {})
+	d, err := s.Every(time.Minute).Do(func() {})
+	require.NoError(t, err)
+	e := s.Schedule(&planScheduler{next: func(prev time.Time) time.Time { return prev.Add(time.Hour) }, run: func() {}})
+	other := tw.AfterFunc(time.Hour, func() {})
+	require.Equal(t, 5, s.Len())
+	require.Equal(t, int64(6), tw.Pending())
+
+	a.Close()
+	require.Equal(t, 4, s.Len())
+
+	require.Equal(t, 4, s.Close())
+	for _, timer := range []*Timer{b, c, d, e} {
+		<-timer.Done()
+		require.Equal(t, EndCancelled, timer.EndReason())
+	}
+	require.Equal(t, 0, s.Len())
+	require.Equal(t, StateScheduled, other.State())
+	require.Equal(t, int64(1), tw.Pending())
+	require.Equal(t, 0, s.Close())
+
+	// The scope rejects the new timers once closed.
+	rejected := s.AfterFunc(time.Hour, func() {})
+	require.Equal(t, EndRejected, rejected.EndReason())
+	require.Equal(t, ErrScopeClosed, rejected.rejected())
+	_, err = s.Every(time.Minute).Do(func() {})
+	require.True(t, errors.Is(err, ErrScopeClosed))
+	require.Equal(t, int64(1), tw.Pending())
+}
+
+func TestScope_Quota(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	defer tw.Stop()
+	tw.SetQuota("a", 1)
+
+	s := tw.Scope()
+	s.AfterFunc(time.Hour, func() {}, WithTag("a"))
+	rejected := s.AfterFunc(time.Hour, func() {}, WithTag("a"))
+	require.Equal(t, ErrQuotaExceeded, rejected.rejected())
+	require.Equal(t, 1, s.Len())
+
+	// The rejected by the closed scope releases the quota.
+	s.Close()
+	used, _ := tw.QuotaUsage("a")
+	require.Equal(t, int64(0), used)
+	s.AfterFunc(time.Hour, func() {}, WithTag("a"))
+	used, _ = tw.QuotaUsage("a")
+	require.Equal(t, int64(0), used)
+}
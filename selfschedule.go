@@ -0,0 +1,156 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ScheduleSelf schedules f to execute after duration d, and then again after
+// the duration returned by each execution, e.g. a poller that backs off while
+// idle. The series ends once f returns zero or a negative duration, or the
+// timer is closed; the timer is finished then, thus Done and Wait cover the
+// whole series.
+//
+// The next execution is measured from the scheduled time of the current one
+// rather than its completion, thus the series doesn't drift by the running
+// time of f; it's measured from the completion with FixedDelay. If the next
+// time has passed when f returns, it's executed immediately. The next
+// execution is planned only after f returns, thus the executions never overlap
+// and the OverlapPolicy has nothing to apply.
+//
+// Use Recurrence.DoSelf to limit the series by Times or Until, or to be called
+// OnComplete, e.g. tw.Every(d).Times(5).OnComplete(g).DoSelf(f).
+func (tw *TimeWheel) ScheduleSelf(d time.Duration, f func() time.Duration, opts ...TimerOption) *Timer {
+	return tw.scheduleSelf(tw.expireAfter(d), &selfSchedule{f: f}, opts)
+}
+
+// FixedDelay makes the next execution of the timer created by ScheduleSelf
+// measured from the completion of the current one, rather than its scheduled
+// time. It has no effect on the other timers.
+func FixedDelay() TimerOption {
+	return func(t *Timer) {
+		t.setAttrs().fixedDelay = true
+	}
+}
+
+// DoSelf validates the recurrence and schedules f like TimeWheel.ScheduleSelf,
+// the first execution is one interval after DoSelf is called or at the
+// StartingAt. The series also ends by the Times and Until, and the OnComplete
+// is called once it ends; each execution counts toward the Times.
+//
+// Only the Every can be combined with DoSelf, with neither Jitter nor CatchUp,
+// since the time of each execution is decided by f. It returns the same errors
+// as Do.
+func (r *Recurrence) DoSelf(f func() time.Duration, opts ...TimerOption) (*Timer, error) {
+	if r.opts != nil {
+		opts = append(r.opts[:len(r.opts):len(r.opts)], opts...)
+	}
+	var start time.Time
+	fail := func(err error) (*Timer, error) {
+		return nil, scheduleError(r.op, start, opts, err)
+	}
+
+	if err := r.validate(); err != nil {
+		return fail(err)
+	}
+	if r.op != "Every" || r.jitter != 0 || r.catchUp != CatchUpAll {
+		return fail(fmt.Errorf("%w: only the Every without jitter and catch-up can be combined with DoSelf", ErrInvalidSchedule))
+	}
+	start = r.start
+	if start.IsZero() {
+		start = r.tw.timeNow().Add(r.interval)
+	}
+	if !r.until.IsZero() && !r.until.After(start) {
+		return fail(fmt.Errorf("%w: until %s must be after the first execution %s",
+			ErrInvalidSchedule, r.until.Format(time.RFC3339Nano), start.Format(time.RFC3339Nano)))
+	}
+	if r.tw.stoppedNow() {
+		return fail(ErrStopped)
+	}
+
+	s := &selfSchedule{f: f, times: r.times}
+	if !r.until.IsZero() {
+		s.until = r.until.UnixNano()
+	}
+	if onComplete := r.onComplete; onComplete != nil {
+		opts = append(opts[:len(opts):len(opts)], withOnFinish(func() {
+			onComplete(int(atomic.LoadInt32(&s.ran)))
+		}))
+	}
+	t := r.tw.scheduleSelf(start.UnixNano(), s, opts)
+	if err := t.rejected(); err != nil {
+		return fail(err)
+	}
+	return t, nil
+}
+
+// selfSchedule is the state of a timer created by ScheduleSelf, it's kept in
+// the attrs like the retry, since Close must stop the further executions.
+type selfSchedule struct {
+	f     func() time.Duration
+	times int   // 0 means no limit.
+	until int64 // 0 means no limit.
+
+	// The number of executions that started, accessed atomically.
+	ran int32
+	// Set by Close, accessed atomically.
+	stopped int32
+}
+
+func (tw *TimeWheel) scheduleSelf(expiration int64, s *selfSchedule, opts []TimerOption) *Timer {
+	opts = append(opts[:len(opts):len(opts)], func(t *Timer) {
+		t.setAttrs().self = s
+	})
+	return tw.expireFunc(context.Background(), expiration, s.run, opts)
+}
+
+func (s *selfSchedule) isStopped() bool {
+	return atomic.LoadInt32(&s.stopped) == 1
+}
+
+// stop stops the further executions of the running timer t, it's called by Close.
+func (s *selfSchedule) stop(t *Timer) {
+	atomic.StoreInt32(&s.stopped, 1)
+	if t.unreset() {
+		t.setEndReason(EndCancelled)
+	}
+}
+
+// run executes f, and re-arms t at the next time unless the series ends.
+func (s *selfSchedule) run(_ context.Context, t *Timer) {
+	if s.isStopped() {
+		// Closed while the previous execution was running.
+		t.setEndReason(EndCancelled)
+		return
+	}
+	n := atomic.AddInt32(&s.ran, 1)
+	d := s.f()
+	switch {
+	case d <= 0:
+		return
+	case s.isStopped():
+		t.setEndReason(EndCancelled)
+		return
+	case s.times > 0 && int(n) >= s.times:
+		t.setEndReason(EndTimes)
+		return
+	}
+	base := t.getExpiration()
+	if t.getAttrs().fixedDelay {
+		base = t.tw.timeNow().UnixNano()
+	}
+	next := base + int64(d)
+	if s.until != 0 && next >= s.until {
+		t.setEndReason(EndUntil)
+		return
+	}
+	// Re-armed once this execution returns, see Timer.Reset.
+	t.resetAt(next)
+}
@@ -0,0 +1,116 @@
+package timewheel
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// pollFor moves the clock by a millisecond and polls tw, n times.
+func pollFor(clock *manualClock, tw *TimeWheel, n int) {
+	for i := 0; i < n; i++ {
+		clock.add(time.Millisecond)
+		tw.Poll()
+	}
+}
+
+func TestTimeWheel_ScheduleSelf(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		opts []TimerOption
+		runs []time.Duration
+	}{
+		// Measured from the scheduled time, the running time doesn't drift.
+		{name: "Rate", runs: []time.Duration{10, 20, 30}},
+		{name: "FixedDelay", opts: []TimerOption{FixedDelay()}, runs: []time.Duration{10, 23, 36}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+			clock := &manualClock{now: start}
+			tw := New(time.Millisecond, 8, WithClock(clock), WithDispatchPolicy(DispatchInline))
+			tw.Start()
+			defer tw.Stop()
+
+			var runs []time.Duration
+			timer := tw.ScheduleSelf(time.Millisecond*10, func() time.Duration {
+				runs = append(runs, clock.Now().Sub(start)/time.Millisecond)
+				// Runs for 3ms.
+				clock.add(time.Millisecond * 3)
+				if len(runs) == 3 {
+					return 0
+				}
+				return time.Millisecond * 10
+			}, c.opts...)
+			pollFor(clock, tw, 50)
+
+			require.Equal(t, c.runs, runs)
+			require.Equal(t, StateCompleted, timer.State())
+			require.Equal(t, EndCompleted, timer.EndReason())
+			require.Equal(t, int64(0), tw.Pending())
+			<-timer.Done()
+		})
+	}
+}
+
+func TestTimeWheel_ScheduleSelf_Close(t *testing.T) {
+	clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	tw := New(time.Millisecond, 8, WithClock(clock), WithDispatchPolicy(DispatchInline))
+	tw.Start()
+	defer tw.Stop()
+
+	var runs int
+	var timer *Timer
+	timer = tw.ScheduleSelf(time.Millisecond, func() time.Duration {
+		runs++
+		if runs == 2 {
+			// Closed while running, the returned delay is ignored.
+			timer.Close()
+		}
+		return time.Millisecond
+	})
+	pollFor(clock, tw, 10)
+
+	require.Equal(t, 2, runs)
+	require.Equal(t, EndCancelled, timer.EndReason())
+	require.Equal(t, int64(0), tw.Pending())
+	<-timer.Done()
+}
+
+func TestRecurrence_DoSelf(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := &manualClock{now: start}
+	tw := New(time.Millisecond, 8, WithClock(clock), WithDispatchPolicy(DispatchInline))
+	tw.Start()
+	defer tw.Stop()
+	next := func() time.Duration { return time.Millisecond * 2 }
+
+	// Ends by the Times.
+	completed := -1
+	timer, err := tw.Every(time.Millisecond).Times(3).OnComplete(func(ran int) { completed = ran }).DoSelf(next)
+	require.NoError(t, err)
+	pollFor(clock, tw, 20)
+	require.Equal(t, 3, completed)
+	require.Equal(t, EndTimes, timer.EndReason())
+	require.Equal(t, int64(0), tw.Pending())
+
+	// Ends by the Until, no execution is at or after it.
+	var runs int
+	now := clock.Now()
+	timer, err = tw.Every(time.Millisecond).Until(now.Add(time.Millisecond * 5)).DoSelf(func() time.Duration {
+		runs++
+		return next()
+	})
+	require.NoError(t, err)
+	pollFor(clock, tw, 20)
+	require.Equal(t, 2, runs)
+	require.Equal(t, EndUntil, timer.EndReason())
+
+	_, err = tw.Every(time.Millisecond).Jitter(0.1).DoSelf(next)
+	require.True(t, errors.Is(err, ErrInvalidSchedule))
+	_, err = tw.RandomSchedule(time.Millisecond, time.Second).DoSelf(next)
+	require.True(t, errors.Is(err, ErrInvalidSchedule))
+	_, err = tw.Every(0).DoSelf(next)
+	require.True(t, errors.Is(err, ErrInvalidSchedule))
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"sync/atomic"
+)
+
+// Priority decides which timers are shed first when the TimeWheel is under
+// pressure, see WithLoadShedding.
+type Priority int8
+
+const (
+	// PriorityLow is for the best-effort timers, such as cache refreshes.
+	PriorityLow Priority = -1
+	// PriorityNormal is the default priority.
+	PriorityNormal Priority = 0
+	// PriorityHigh is for the critical timers, such as lease expirations.
+	PriorityHigh Priority = 1
+	// PriorityCritical is above the PriorityHigh, the timers of it are
+	// isolated from the others if the WithCriticalPlane is set.
+	PriorityCritical Priority = 2
+)
+
+// WithPriority sets the priority of the timer, default is PriorityNormal.
+func WithPriority(p Priority) TimerOption {
+	return func(t *Timer) {
+		t.setAttrs().priority = p
+	}
+}
+
+// Priority returns the priority that set by WithPriority.
+func (t *Timer) Priority() Priority {
+	return t.getAttrs().priority
+}
+
+// WithMaxPending limits the number of pending timers (see Pending) to n, the
+// new timers of any priority are rejected with ErrFull once it's reached.
+//
+// The limit is checked before the new timer is counted, thus the concurrent
+// schedulings may exceed it slightly. The executions of a recurring timer
+// that has been admitted are never rejected.
+func WithMaxPending(n int64) Option {
+	if n < 1 {
+		panic("timewheel: max pending must be greater than 0")
+	}
+	return func(o *options) {
+		o.maxPending = n
+	}
+}
+
+// WithLoadShedding makes the TimeWheel shed the new timers with priority
+// less than or equal to max once the number of pending timers reaches the
+// soft limit, while the timers of higher priority are still admitted up to
+// the limit set by WithMaxPending. The shed timer is rejected with ErrShed,
+// and it's counted in Stats.Shed as well as Stats.Rejected, see OnReject.
+//
+// The shedding costs nothing more than a comparison with the pending, no
+// pending timer is ever cancelled to make room.
+func WithLoadShedding(soft int64, max Priority) Option {
+	if soft < 1 {
+		panic("timewheel: soft limit of load shedding must be greater than 0")
+	}
+	return func(o *options) {
+		o.shedLimit = soft
+		o.shedPriority = max
+	}
+}
+
+// admitLoad checks the limits of the pending for the new timer t.
+func (tw *TimeWheel) admitLoad(t *Timer) error {
+	root := tw.root
+	o := &root.opts
+	if o.maxPending == 0 && o.shedLimit == 0 {
+		return nil
+	}
+	pending := atomic.LoadInt64(&root.pending)
+	if o.maxPending > 0 && pending >= o.maxPending {
+		return ErrFull
+	}
+	if o.shedLimit > 0 && pending >= o.shedLimit && t.Priority() <= o.shedPriority {
+		atomic.AddUint64(&root.shed, 1)
+		return ErrShed
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package timewheel
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithLoadShedding(t *testing.T) {
+	var mu sync.Mutex
+	var rejected []error
+	tw := New(time.Millisecond, 8, WithMaxPending(5), WithLoadShedding(3, PriorityLow),
+		OnReject(func(t *Timer, err error) {
+			mu.Lock()
+			rejected = append(rejected, err)
+			mu.Unlock()
+		}))
+	tw.Start()
+	defer tw.Stop()
+
+	for i := 0; i < 3; i++ {
+		timer := tw.AfterFunc(time.Hour, func() {}, WithPriority(PriorityLow))
+		require.Equal(t, EndNone, timer.EndReason())
+		require.Equal(t, PriorityLow, timer.Priority())
+	}
+
+	// Over the soft limit, the low priority ones are shed.
+	timer := tw.AfterFunc(time.Hour, func() {}, WithPriority(PriorityLow))
+	require.Equal(t, EndRejected, timer.EndReason())
+	require.True(t, errors.Is(timer.rejected(), ErrShed))
+
+	// The others are admitted up to the hard limit.
+	require.Equal(t, EndNone, tw.AfterFunc(time.Hour, func() {}).EndReason())
+	require.Equal(t, EndNone, tw.AfterFunc(time.Hour, func() {}, WithPriority(PriorityHigh)).EndReason())
+	timer = tw.AfterFunc(time.Hour, func() {}, WithPriority(PriorityHigh))
+	require.Equal(t, EndRejected, timer.EndReason())
+	require.True(t, errors.Is(timer.rejected(), ErrFull))
+
+	stats := tw.Stats()
+	require.Equal(t, int64(5), stats.Pending)
+	require.Equal(t, uint64(2), stats.Rejected)
+	require.Equal(t, uint64(1), stats.Shed)
+
+	mu.Lock()
+	require.Equal(t, []error{ErrShed, ErrFull}, rejected)
+	mu.Unlock()
+}
+
+func TestWithLoadShedding_Recurring(t *testing.T) {
+	tw := New(time.Millisecond, 8, WithLoadShedding(1, PriorityNormal))
+	tw.Start()
+	defer tw.Stop()
+
+	// The admitted recurring timer is never shed.
+	timer, err := tw.Every(time.Millisecond).Times(5).Do(func() {})
+	require.NoError(t, err)
+	_, err = tw.Every(time.Millisecond).Do(func() {})
+	require.True(t, errors.Is(err, ErrShed))
+	<-timer.Done()
+	require.Equal(t, EndTimes, timer.EndReason())
+
+	// Under the soft limit again.
+	require.Equal(t, EndNone, tw.AfterFunc(time.Hour, func() {}).EndReason())
+}
+
+func TestWithLoadShedding_Invalid(t *testing.T) {
+	require.Panics(t, func() { WithMaxPending(0) })
+	require.Panics(t, func() { WithLoadShedding(0, PriorityLow) })
+}
@@ -0,0 +1,220 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"strconv"
+	"sync/atomic"
+)
+
+// State is the lifecycle state of a Timer.
+//
+// The legal transitions are:
+//
+//	Scheduled -> Queued     the timer expired, waiting for its task to be dispatched.
+//	Scheduled -> Cancelled  the timer is closed before expired.
+//	Queued    -> Running    the task is dispatched.
+//	Queued    -> Cancelled  the timer is closed after expired but before dispatched.
+//	Running   -> Completed  the task returned.
+//	Running   -> Scheduled  the recurring timer is re-armed for the next execution,
+//	                        or the run-once timer is reset while running.
+//
+// The Completed and Cancelled are final states. Each transition is made by
+// a compare-and-swap from the expected state, thus an illegal transition is
+// never made, e.g. a cancelled timer is never dispatched, and a dispatched
+// timer is never cancelled.
+//
+// NOTICE: a recurring timer is re-armed before its task is dispatched, thus
+// its state is Scheduled for the next execution while the task is running.
+type State int32
+
+const (
+	// StateScheduled means the timer is waiting to expire.
+	StateScheduled State = iota + 1
+	// StateQueued means the timer has expired, its task is waiting to be dispatched.
+	StateQueued
+	// StateRunning means the task of the timer has been dispatched and not returned.
+	StateRunning
+	// StateCompleted means the task of the timer has returned.
+	StateCompleted
+	// StateCancelled means the timer has been closed before its task dispatched.
+	StateCancelled
+)
+
+func (s State) String() string {
+	switch s {
+	case StateScheduled:
+		return "scheduled"
+	case StateQueued:
+		return "queued"
+	case StateRunning:
+		return "running"
+	case StateCompleted:
+		return "completed"
+	case StateCancelled:
+		return "cancelled"
+	}
+	return "State(" + strconv.Itoa(int(s)) + ")"
+}
+
+// The layout of Timer.meta, from the lowest bit:
+//
+//	bits 0-2   the State.
+//	bit  3     set if the running timer is reset by Timer.Reset.
+//	bits 4-7   the EndReason.
+//	bits 8-63  the ID, it's set before the timer is scheduled and never changed.
+const (
+	metaStateMask = 0x7
+	metaReset     = 1 << 3
+	metaEndShift  = 4
+	metaEndMask   = 0xf << metaEndShift
+	metaIDShift   = 8
+)
+
+// newMeta returns the Timer.meta of the given parts.
+func newMeta(id uint64, s State, r EndReason) uint64 {
+	return id<<metaIDShift | uint64(r)<<metaEndShift | uint64(s)
+}
+
+// State returns the current state of the timer.
+func (t *Timer) State() State {
+	return State(atomic.LoadUint64(&t.meta) & metaStateMask)
+}
+
+// transit moves the timer from the state from to the state to, it returns
+// false if the current state is not from.
+func (t *Timer) transit(from, to State) bool {
+	for {
+		meta := atomic.LoadUint64(&t.meta)
+		if State(meta&metaStateMask) != from {
+			return false
+		}
+		if atomic.CompareAndSwapUint64(&t.meta, meta, meta&^metaStateMask|uint64(to)) {
+			return true
+		}
+	}
+}
+
+// arm moves the timer to StateScheduled, the timer must be new or running.
+func (t *Timer) arm() {
+	for {
+		meta := atomic.LoadUint64(&t.meta)
+		if atomic.CompareAndSwapUint64(&t.meta, meta, meta&^metaStateMask|uint64(StateScheduled)) {
+			return
+		}
+	}
+}
+
+// complete moves the running timer to StateCompleted, and marks it as finished.
+// If the timer is reset while running, it's scheduled again instead.
+//
+// The check of the Reset and the transition are a single CAS, thus a Reset
+// that succeeds concurrently is never lost: either it's made before and the
+// timer is re-armed, or it fails since the timer is completed.
+func (t *Timer) complete() {
+	for {
+		meta := atomic.LoadUint64(&t.meta)
+		if State(meta&metaStateMask) != StateRunning {
+			break
+		}
+		if meta&metaReset != 0 {
+			if t.rearm() {
+				return
+			}
+			continue
+		}
+		next := meta&^metaStateMask | uint64(StateCompleted)
+		if meta&metaEndMask == 0 {
+			next |= uint64(EndCompleted) << metaEndShift
+		}
+		if atomic.CompareAndSwapUint64(&t.meta, meta, next) {
+			t.finish()
+			return
+		}
+	}
+	t.setEndReason(EndCompleted)
+	t.finish()
+}
+
+// EndReason describes why the execution plan of a timer ended.
+type EndReason int32
+
+const (
+	// EndNone means the execution plan has not ended.
+	EndNone EndReason = iota
+	// EndCompleted means the task of a run-once timer returned, or the
+	// Scheduler of a recurring timer planned no more execution.
+	EndCompleted
+	// EndCancelled means the timer was closed.
+	EndCancelled
+	// EndTimes means the recurrence reached the limit set by Times.
+	EndTimes
+	// EndUntil means the next execution of the recurrence would be at or
+	// after the time set by Until.
+	EndUntil
+	// EndRejected means the timer was rejected when scheduled, e.g. the quota
+	// of its tag is exceeded. Its state is Cancelled and its task never runs.
+	EndRejected
+	// EndDropped means the task of the run-once timer was dropped after
+	// expired, e.g. the Executor refused it, or the channel of the DeliveryDrop
+	// is full. Its state is Completed.
+	EndDropped
+)
+
+func (r EndReason) String() string {
+	switch r {
+	case EndNone:
+		return "none"
+	case EndCompleted:
+		return "completed"
+	case EndCancelled:
+		return "cancelled"
+	case EndTimes:
+		return "times"
+	case EndUntil:
+		return "until"
+	case EndRejected:
+		return "rejected"
+	case EndDropped:
+		return "dropped"
+	}
+	return "EndReason(" + strconv.Itoa(int(r)) + ")"
+}
+
+// EndReason returns why the execution plan of the timer ended, or EndNone if
+// it's not ended. For a recurring timer, it's reported once its Scheduler
+// planned no more execution, the last execution may be still running.
+func (t *Timer) EndReason() EndReason {
+	return EndReason(atomic.LoadUint64(&t.meta) & metaEndMask >> metaEndShift)
+}
+
+// setEndReason sets the EndReason if it's not set.
+func (t *Timer) setEndReason(r EndReason) {
+	for {
+		meta := atomic.LoadUint64(&t.meta)
+		if meta&metaEndMask != 0 {
+			return
+		}
+		if atomic.CompareAndSwapUint64(&t.meta, meta, meta|uint64(r)<<metaEndShift) {
+			return
+		}
+	}
+}
+
+// ender is implemented by the Scheduler that knows why its plan ended.
+type ender interface {
+	endReason() EndReason
+}
+
+// endReasonOf returns why the plan of sh ended after its Next returned a zero time.
+func endReasonOf(sh Scheduler) EndReason {
+	if e, ok := sh.(ender); ok {
+		if r := e.endReason(); r != EndNone {
+			return r
+		}
+	}
+	return EndCompleted
+}
@@ -0,0 +1,232 @@
+package timewheel
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestState_String(t *testing.T) {
+	require.Equal(t, "scheduled", StateScheduled.String())
+	require.Equal(t, "queued", StateQueued.String())
+	require.Equal(t, "running", StateRunning.String())
+	require.Equal(t, "completed", StateCompleted.String())
+	require.Equal(t, "cancelled", StateCancelled.String())
+	require.Equal(t, "State(0)", State(0).String())
+}
+
+func TestTimer_State(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	timer := tw.AfterFunc(time.Millisecond, func() {
+		close(started)
+		<-release
+	})
+	require.Equal(t, StateScheduled, timer.State())
+
+	waitC(t, started)
+	require.Equal(t, StateRunning, timer.State())
+	// Close does nothing once the task is dispatched.
+	timer.Close()
+	require.Equal(t, StateRunning, timer.State())
+
+	close(release)
+	<-timer.Done()
+	require.Equal(t, StateCompleted, timer.State())
+
+	timer = tw.AfterFunc(time.Hour, func() {})
+	timer.Close()
+	require.Equal(t, StateCancelled, timer.State())
+
+	require.Equal(t, StateCompleted, tw.Schedule(&Task3{}).State())
+}
+
+func TestTimer_State_CancelQueued(t *testing.T) {
+	tw := New(time.Millisecond, 8, WithDispatchPolicy(DispatchInline))
+	tw.Start()
+	defer tw.Stop()
+
+	var ran int32
+	secondC := make(chan *Timer, 1)
+
+	// Both timers expire in the same bucket, the second one is queued while
+	// the task of first one is running inline.
+	at := time.Now().Add(time.Millisecond * 20)
+	first := tw.TimeFunc(at, func() {
+		s := <-secondC
+		require.Equal(t, StateQueued, s.State())
+		s.Close()
+	})
+	second := tw.TimeFunc(at, func() { atomic.StoreInt32(&ran, 1) })
+	secondC <- second
+
+	<-first.Done()
+	<-second.Done()
+	require.Equal(t, StateCompleted, first.State())
+	require.Equal(t, StateCancelled, second.State())
+	require.Equal(t, int32(0), atomic.LoadInt32(&ran))
+
+	require.Eventually(t, func() bool { return tw.Pending() == 0 }, time.Second, time.Millisecond)
+	stats := tw.Stats()
+	require.Equal(t, uint64(1), stats.Fired)
+	require.Equal(t, uint64(1), stats.Cancelled)
+}
+
+// countdown is a Scheduler that runs every interval for n times.
+type countdown struct {
+	interval time.Duration
+	n        int32
+	runs     int32
+}
+
+func (c *countdown) Next(prev time.Time) time.Time {
+	if atomic.AddInt32(&c.n, -1) < 0 {
+		return time.Time{}
+	}
+	return prev.Add(c.interval)
+}
+
+func (c *countdown) Run() {
+	atomic.AddInt32(&c.runs, 1)
+}
+
+// legalOnce lists the transitions that may be observed by sampling the state
+// of a run-once timer, the intermediate states may be missed.
+var legalOnce = map[[2]State]bool{
+	{StateScheduled, StateQueued}:    true,
+	{StateScheduled, StateRunning}:   true,
+	{StateScheduled, StateCompleted}: true,
+	{StateScheduled, StateCancelled}: true,
+	{StateQueued, StateRunning}:      true,
+	{StateQueued, StateCompleted}:    true,
+	{StateQueued, StateCancelled}:    true,
+	{StateRunning, StateCompleted}:   true,
+}
+
+func isFinal(s State) bool {
+	return s == StateCompleted || s == StateCancelled
+}
+
+// TestTimer_State_History randomly interleaves the expiring and closing of
+// timers, and validates the state histories observed by sampling.
+func TestTimer_State_History(t *testing.T) {
+	for _, policy := range []DispatchPolicy{DispatchGoroutine, DispatchInline} {
+		testStateHistory(t, policy)
+	}
+}
+
+func testStateHistory(t *testing.T, policy DispatchPolicy) {
+	tw := New(time.Millisecond, 8, WithDispatchPolicy(policy))
+	tw.Start()
+	defer tw.Stop()
+
+	const n = 200
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	type record struct {
+		timer     *Timer
+		recurring *countdown
+		ran       int32
+		history   []State
+	}
+	records := make([]*record, n)
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < n; i++ {
+		r := &record{}
+		records[i] = r
+
+		delay := time.Duration(rnd.Intn(5000)) * time.Microsecond
+		if i%4 == 0 {
+			r.recurring = &countdown{interval: delay + time.Millisecond, n: 3}
+			r.timer = tw.Schedule(r.recurring)
+		} else {
+			r.timer = tw.AfterFunc(delay, func() { atomic.StoreInt32(&r.ran, 1) })
+		}
+
+		closeAfter := time.Duration(rnd.Intn(6000)) * time.Microsecond
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			time.Sleep(closeAfter)
+			r.timer.Close()
+		}()
+		go func() {
+			defer wg.Done()
+			last := r.timer.State()
+			r.history = append(r.history, last)
+			for !isFinal(last) {
+				if s := r.timer.State(); s != last {
+					r.history = append(r.history, s)
+					last = s
+				}
+				time.Sleep(time.Microsecond * 50)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, r := range records {
+		final := r.history[len(r.history)-1]
+		require.True(t, isFinal(final), "timer %d: %v", i, r.history)
+
+		if r.recurring != nil {
+			// A recurring timer cycles until the plan ends or it's cancelled.
+			if final == StateCompleted {
+				// The previous runs may be still running in their own goroutines.
+				require.Eventually(t, func() bool { return atomic.LoadInt32(&r.recurring.runs) == 3 },
+					time.Second, time.Millisecond, "timer %d", i)
+			}
+			continue
+		}
+
+		for j := 1; j < len(r.history); j++ {
+			pair := [2]State{r.history[j-1], r.history[j]}
+			require.True(t, legalOnce[pair], "timer %d: illegal transition in %v", i, r.history)
+		}
+		require.Equal(t, final == StateCompleted, atomic.LoadInt32(&r.ran) == 1, "timer %d: %v", i, r.history)
+	}
+
+	require.Eventually(t, func() bool { return tw.Pending() == 0 }, time.Second, time.Millisecond)
+	require.NoError(t, tw.CheckInvariants())
+}
+
+func TestTimer_EndReason(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	timer, err := tw.Every(time.Millisecond).Times(2).Do(func() {})
+	require.NoError(t, err)
+	<-timer.Done()
+	require.Equal(t, EndTimes, timer.EndReason())
+
+	timer, err = tw.Every(time.Millisecond).Until(time.Now().Add(time.Millisecond * 10)).Do(func() {})
+	require.NoError(t, err)
+	<-timer.Done()
+	require.Equal(t, EndUntil, timer.EndReason())
+
+	timer, err = tw.Every(time.Hour).Do(func() {})
+	require.NoError(t, err)
+	require.Equal(t, EndNone, timer.EndReason())
+	timer.Close()
+	require.Equal(t, EndCancelled, timer.EndReason())
+
+	timer = tw.AfterFunc(time.Millisecond, func() {})
+	<-timer.Done()
+	require.Equal(t, EndCompleted, timer.EndReason())
+
+	require.Equal(t, EndCompleted, tw.Schedule(&Task3{}).EndReason())
+
+	require.Equal(t, "until", EndUntil.String())
+	require.Equal(t, "rejected", EndRejected.String())
+	require.Equal(t, "EndReason(9)", EndReason(9).String())
+}
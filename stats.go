@@ -0,0 +1,232 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a point-in-time view of the TimeWheel's counters.
+type Stats struct {
+	// The number of timers that are scheduled but not yet expired or closed.
+	Pending int64
+	// The number of times that timers have been armed, a recurring timer is
+	// counted once for each execution planned.
+	Scheduled uint64
+	// The number of times that timers have expired.
+	Fired uint64
+	// The number of timers that closed before expired.
+	Cancelled uint64
+	// The number of executions of recurring timers that skipped by OverlapSkip
+	// or collapsed by OverlapQueue.
+	Skipped uint64
+	// The number of executions of recurring timers that queued by OverlapQueue.
+	Queued uint64
+	// The number of executions skipped since the FireGuard denied, see WithGuardID.
+	GuardDenied uint64
+	// The number of timers rejected when scheduled, see EndRejected.
+	Rejected uint64
+	// The number of timers shed under load, see WithLoadShedding. They're
+	// also counted in the Rejected.
+	Shed uint64
+	// The number of timers rejected by the rate limits, see WithScheduleRateLimit.
+	// They're also counted in the Rejected.
+	RateLimited uint64
+	// The number of tasks dropped since the Executor refused them, or the
+	// channel of the DeliveryDrop is full, see OnDrop.
+	Dropped uint64
+	// The number of tasks in the run queue of the Executor, and the maximum
+	// of it so far. They're 0 unless the Executor is a QueuedExecutor, such
+	// as the WorkerPool.
+	ExecutorQueue          int
+	ExecutorQueueHighWater int
+	// The number of errors returned by the Store, see WithStore.
+	StoreErrors uint64
+	// The number of levels, it includes the root and all the overflow wheels.
+	Levels int
+	// The maximum number of levels, see WithMaxLevels.
+	MaxLevels int
+	// The number of buckets in all the levels, and the number of them that
+	// allocated, a bucket is allocated on the first insert into its slot.
+	Buckets          int
+	AllocatedBuckets int
+	// The number of buckets that have expired but not yet processed by the
+	// consumer goroutine, i.e. the backlog of the queue. It grows if the
+	// consumer goroutine falls behind, such as with a slow DispatchInline task.
+	QueueDepth int
+	// The time between the expiration of the latest processed bucket and the
+	// start of its processing. It's the delay of the queue and the consumer
+	// goroutine, excludes the execution of tasks (see MetricFireLag).
+	ConsumerLag time.Duration
+	// The maximum ConsumerLag in the last minute, thus a stall of the consumer
+	// goroutine (e.g. by the GC) is visible for a minute, even if the buckets
+	// processed since then are on time.
+	ConsumerLagMax time.Duration
+	// The statistics of the plane of the critical timers, it's nil unless the
+	// WithCriticalPlane is set. The fields above only cover the other timers.
+	Critical *Stats
+}
+
+// Stats returns the current statistics of the TimeWheel.
+// It's cheap and safe to call concurrently, each field is read atomically.
+func (tw *TimeWheel) Stats() Stats {
+	root := tw.root
+	levels := root.levels()
+	s := Stats{
+		Pending:   atomic.LoadInt64(&root.pending),
+		Scheduled: atomic.LoadUint64(&root.scheduled),
+		Fired:     atomic.LoadUint64(&root.fired),
+		Cancelled: atomic.LoadUint64(&root.cancelled),
+		Skipped:   atomic.LoadUint64(&root.skipped),
+		Queued:    atomic.LoadUint64(&root.queued),
+		Levels:    levels,
+		MaxLevels: root.opts.maxLevels,
+
+		Buckets:          levels * int(root.size),
+		AllocatedBuckets: int(atomic.LoadInt64(&root.allocatedBuckets)),
+
+		GuardDenied: atomic.LoadUint64(&root.guardDenied),
+		Rejected:    atomic.LoadUint64(&root.rejected),
+		Shed:        atomic.LoadUint64(&root.shed),
+		RateLimited: atomic.LoadUint64(&root.rateLimited),
+		Dropped:     atomic.LoadUint64(&root.dropped),
+		StoreErrors: atomic.LoadUint64(&root.storeErrors),
+
+		QueueDepth:     root.queueDepth(),
+		ConsumerLag:    time.Duration(atomic.LoadInt64(&root.consumerLag)),
+		ConsumerLagMax: time.Duration(root.lagWindow.max(root.now())),
+	}
+	if e, ok := root.opts.executor.(QueuedExecutor); ok {
+		s.ExecutorQueue = e.QueueLen()
+		s.ExecutorQueueHighWater = e.QueueHighWater()
+	}
+	if c := root.critical; c != nil {
+		cs := c.Stats()
+		s.Critical = &cs
+	}
+	return s
+}
+
+// levels returns the number of levels of the TimeWheel.
+func (tw *TimeWheel) levels() int {
+	n := 0
+	for l := tw.root; l != nil; l = l.getOverflow() {
+		n++
+	}
+	return n
+}
+
+// queueDepth returns the number of buckets that have expired but not yet
+// processed. The flush resets the expiration of a bucket, thus a bucket with
+// an expiration in the past is waiting in the queue.
+func (tw *TimeWheel) queueDepth() int {
+	now := tw.root.now()
+	n := 0
+	for l := tw.root; l != nil; l = l.getOverflow() {
+		for i := range l.buckets {
+			b := l.loadBucket(int64(i))
+			if b == nil {
+				continue
+			}
+			if e := b.getExpiration(); e != -1 && e <= now {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// TimerInfo is a snapshot of a pending timer.
+type TimerInfo struct {
+	// The unique ID of the timer.
+	ID uint64
+	// The tag that set by WithTag.
+	Tag string
+	// The payload carried by the timer, see Timer.Payload.
+	Payload interface{}
+	// The time that the timer will expire.
+	Expiration time.Time
+	// The level of wheel that the timer is in, 0 for the root.
+	Level int
+	// Redeliveries is the number of times that the timer is fired again since
+	// the previous fires are not acknowledged, see Timer.Ack.
+	Redeliveries uint32
+	// The reason that the timer is dead-lettered, it's only set for the dead
+	// letters, see WithDeadLetter.
+	Reason error
+}
+
+// info returns the TimerInfo of the pending timer t at the level.
+func (t *Timer) info(level int) TimerInfo {
+	return TimerInfo{ID: t.ID(), Tag: t.Tag(), Payload: t.Payload(), Expiration: time.Unix(0, t.getExpiration()), Level: level}
+}
+
+// CountDue returns the number of pending timers that expire within the duration
+// from now, e.g. to estimate the work in the next seconds for the admission.
+//
+// Like CancelBetween, only the buckets that may hold such expirations are
+// visited, and the buckets entirely within the window are counted by their
+// lengths without visiting the timers, thus it's far cheaper than Dump. It
+// locks one bucket at a time, and it's not exact under the concurrent changes:
+// a timer scheduled, fired or closed during the count may or may not be
+// counted, and a timer that moves to a lower level during the count may be
+// counted twice. The timers that have expired but not dispatched are not
+// counted.
+func (tw *TimeWheel) CountDue(within time.Duration) int64 {
+	var n int64
+	tw.due(within, func(_ int, b *bucket, start, end int64, whole int64) bool {
+		n += b.count(start, end, whole)
+		return true
+	})
+	if c := tw.root.critical; c != nil {
+		n += c.CountDue(within)
+	}
+	return n
+}
+
+// DueWithin is like CountDue, but returns the TimerInfo of the timers, at most
+// the limit of them. The timers are in no particular order.
+func (tw *TimeWheel) DueWithin(within time.Duration, limit int) []TimerInfo {
+	var infos []TimerInfo
+	var timers []*Timer
+	tw.due(within, func(level int, b *bucket, start, end int64, _ int64) bool {
+		timers = b.snapshot(timers[:0])
+		for i, t := range timers {
+			if e := t.getExpiration(); e >= start && e < end && len(infos) < limit {
+				infos = append(infos, t.info(level))
+			}
+			timers[i] = nil
+		}
+		return len(infos) < limit
+	})
+	if c := tw.root.critical; c != nil && len(infos) < limit {
+		infos = append(infos, c.DueWithin(within, limit-len(infos))...)
+	}
+	return infos
+}
+
+// due calls f with the buckets that may hold the timers expire within the
+// duration from now, from the highest level, until f returns false.
+func (tw *TimeWheel) due(within time.Duration, f func(level int, b *bucket, start, end int64, whole int64) bool) {
+	if within <= 0 {
+		return
+	}
+	start := tw.root.now()
+	end := start + int64(within)
+	var levels []*TimeWheel
+	for l := tw.root; l != nil; l = l.getOverflow() {
+		levels = append(levels, l)
+	}
+	ok := true
+	for level := len(levels) - 1; level >= 0 && ok; level-- {
+		levels[level].bucketsBetween(start, end, func(b *bucket, whole int64) {
+			if ok {
+				ok = f(level, b, start, end, whole)
+			}
+		})
+	}
+}
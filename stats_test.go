@@ -0,0 +1,120 @@
+package timewheel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeWheel_Stats(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	require.Equal(t, Stats{Levels: 1, MaxLevels: 16, Buckets: 8}, tw.Stats())
+
+	fired := make(chan struct{})
+	tw.AfterFunc(time.Millisecond*5, func() { close(fired) })
+	timer := tw.AfterFunc(time.Hour, func() {})
+
+	stats := tw.Stats()
+	require.Equal(t, int64(2), stats.Pending)
+	require.Equal(t, uint64(2), stats.Scheduled)
+	require.Greater(t, stats.Levels, 1)
+	require.Equal(t, stats.Levels*8, stats.Buckets)
+	// Only the buckets of the two timers are allocated.
+	require.Equal(t, 2, stats.AllocatedBuckets)
+
+	waitC(t, fired)
+	timer.Close()
+
+	require.Eventually(t, func() bool {
+		stats = tw.Stats()
+		return stats.Pending == 0 && stats.Fired == 1
+	}, time.Second, time.Millisecond)
+	require.Equal(t, uint64(1), stats.Cancelled)
+	require.Equal(t, uint64(2), stats.Scheduled)
+}
+
+func TestTimeWheel_Stats_Queue(t *testing.T) {
+	tw := New(time.Millisecond, 8, WithDispatchPolicy(DispatchInline))
+	tw.Start()
+	defer tw.Stop()
+
+	// The slow task executed inline blocks the consumer goroutine.
+	releaseC := make(chan struct{})
+	tw.AfterFunc(time.Millisecond, func() { <-releaseC })
+	var last *Timer
+	for i := 3; i <= 7; i += 2 {
+		last = tw.AfterFunc(time.Millisecond*time.Duration(i), func() {})
+	}
+
+	require.Eventually(t, func() bool { return tw.Stats().QueueDepth == 3 }, time.Second, time.Millisecond)
+	time.Sleep(time.Millisecond * 30)
+	close(releaseC)
+	<-last.Done()
+
+	stats := tw.Stats()
+	require.Equal(t, 0, stats.QueueDepth)
+	require.Greater(t, int64(stats.ConsumerLag), int64(time.Millisecond*20))
+}
+
+func TestTimeWheel_Stats_ConsumerLagMax(t *testing.T) {
+	clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	tw := New(time.Millisecond, 8, WithClock(clock), WithDispatchPolicy(DispatchInline))
+	tw.Start()
+	defer tw.Stop()
+
+	// A stall of 200ms, then the buckets on time.
+	tw.AfterFunc(time.Millisecond*5, func() {})
+	clock.add(time.Millisecond * 205)
+	tw.Poll()
+	for i := 0; i < 10; i++ {
+		clock.add(time.Second * 5)
+		tw.AfterFunc(time.Millisecond, func() {})
+		clock.add(time.Millisecond)
+		tw.Poll()
+	}
+	stats := tw.Stats()
+	require.True(t, stats.ConsumerLag <= time.Millisecond)
+	require.Equal(t, time.Millisecond*200, stats.ConsumerLagMax)
+
+	// The stall is out of the window a minute later.
+	clock.add(time.Second * 10)
+	require.Equal(t, time.Duration(0), tw.Stats().ConsumerLagMax)
+}
+
+func TestTimeWheel_CountDue(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithHashedMode()}, {WithMaxLevels(2)}} {
+		clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+		tw := New(time.Millisecond, 8, append(opts, WithClock(clock), WithDispatchPolicy(DispatchInline))...)
+		tw.Start()
+
+		for i := 1; i <= 60; i++ {
+			tw.AfterFunc(time.Duration(i)*time.Millisecond, func() {}, WithTag("foo"))
+		}
+		require.Equal(t, int64(0), tw.CountDue(0))
+		require.Equal(t, int64(60), tw.CountDue(time.Hour))
+		// The timers within [now, now+d).
+		require.Equal(t, int64(9), tw.CountDue(time.Millisecond*10))
+		require.Equal(t, int64(39), tw.CountDue(time.Millisecond*40))
+
+		infos := tw.DueWithin(time.Millisecond*40, 100)
+		require.Len(t, infos, 39)
+		for _, info := range infos {
+			require.True(t, info.Expiration.Before(clock.Now().Add(time.Millisecond*40)))
+			require.Equal(t, "foo", info.Tag)
+		}
+		require.Len(t, tw.DueWithin(time.Millisecond*40, 5), 5)
+
+		// It's relative to the current time.
+		for i := 0; i < 20; i++ {
+			clock.add(time.Millisecond)
+			tw.Poll()
+		}
+		require.Equal(t, int64(9), tw.CountDue(time.Millisecond*10))
+		require.Equal(t, int64(40), tw.CountDue(time.Hour))
+		tw.Stop()
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yu31/timewheel/timerspec"
+)
+
+// TimerRecord is the durable state of a named timer (see AfterTask) kept in a
+// Store.
+type TimerRecord struct {
+	ID         uint64
+	Expiration time.Time
+	Tag        string
+	Task       string
+	Payload    []byte
+	// InFlight is whether the task has been dispatched, and Redeliveries is
+	// the number of times it has been fired again, see Timer.Redeliveries.
+	InFlight     bool
+	Redeliveries uint32
+}
+
+// RecordIterator iterates the records loaded from a Store.
+type RecordIterator interface {
+	// Next returns the next record, or io.EOF if there is no more.
+	Next() (TimerRecord, error)
+	// Close releases the resources of the iterator.
+	Close() error
+}
+
+// Store is the durable storage of the named timers, see WithStore. The
+// records are identified by the ID of the timers.
+//
+// Its methods are called synchronously by the TimeWheel, the Save and Delete
+// even by the consumer goroutine when the timers are fired, so they should be
+// fast, e.g. by group commits.
+type Store interface {
+	// Save inserts or replaces the record of the timer r.ID.
+	Save(r TimerRecord) error
+	// Delete removes the record of the timer id, it's no error if there is no
+	// such record.
+	Delete(id uint64) error
+	// LoadAll returns the iterator of all the records.
+	LoadAll() (RecordIterator, error)
+}
+
+// WithStore makes the named timers (see AfterTask and TimeTask) durable in the
+// store s: each timer is saved to s before it's scheduled, saved again as in
+// flight once fired, as well as when it's reset, and deleted once it's
+// finished, e.g. its task returned, or it's cancelled or dropped. The timers
+// created with a func are never saved.
+//
+// The records in s are replayed on Start by the TaskRegistry set by
+// WithTaskRegistry like RestoreFrom: the timers are scheduled again with new
+// IDs (the old records are deleted once replaced), those already expired are
+// executed immediately, and the ones in flight are fired with the
+// Redeliveries increased. Thus, a timer is executed at least once across the
+// restarts. A record that can't be replayed, e.g. its task is not registered,
+// is left in s and logged.
+//
+// The errors of s are logged and counted in Stats.StoreErrors, they never fail
+// the operations of the timers.
+func WithStore(s Store) Option {
+	return func(o *options) {
+		o.store = s
+	}
+}
+
+// storeSave saves the named timer t to the store, if any.
+func (tw *TimeWheel) storeSave(t *Timer, inFlight bool) {
+	root := tw.root
+	s := root.opts.store
+	a := t.getAttrs()
+	if s == nil || a.task == "" {
+		return
+	}
+	payload, _ := a.payload.([]byte)
+	r := TimerRecord{
+		ID:           t.ID(),
+		Expiration:   time.Unix(0, t.getExpiration()),
+		Tag:          a.tag,
+		Task:         a.task,
+		Payload:      payload,
+		InFlight:     inFlight,
+		Redeliveries: a.redeliveries,
+	}
+	if err := s.Save(r); err != nil {
+		tw.storeFailed("save", t.ID(), err)
+	}
+}
+
+// storeDelete deletes the named timer t from the store, if any.
+func (tw *TimeWheel) storeDelete(t *Timer) {
+	s := tw.root.opts.store
+	if s == nil || t.getAttrs().task == "" {
+		return
+	}
+	if err := s.Delete(t.ID()); err != nil {
+		tw.storeFailed("delete", t.ID(), err)
+	}
+}
+
+func (tw *TimeWheel) storeFailed(op string, id uint64, err error) {
+	root := tw.root
+	atomic.AddUint64(&root.storeErrors, 1)
+	if l := root.opts.logger; l != nil {
+		l.Error("timewheel: store failed", slog.String("op", op), slog.Uint64("timer", id), slog.Any("error", err))
+	}
+}
+
+// replay schedules the timers in the store of WithStore again, it's called by
+// Start.
+func (tw *TimeWheel) replay() {
+	root := tw.root
+	s := root.opts.store
+	it, err := s.LoadAll()
+	if err != nil {
+		tw.storeFailed("load", 0, err)
+		return
+	}
+	var records []TimerRecord
+	for {
+		r, err := it.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			tw.storeFailed("load", 0, err)
+			break
+		}
+		records = append(records, r)
+	}
+	if err := it.Close(); err != nil {
+		tw.storeFailed("load", 0, err)
+	}
+
+	// The new IDs never collide with the records not yet replaced.
+	for _, r := range records {
+		for {
+			last := atomic.LoadUint64(&root.lastID)
+			if r.ID <= last || atomic.CompareAndSwapUint64(&root.lastID, last, r.ID) {
+				break
+			}
+		}
+	}
+	for _, r := range records {
+		var opts []TimerOption
+		if r.Tag != "" {
+			opts = append(opts, WithTag(r.Tag))
+		}
+		spec := &timerspec.Spec{InFlight: r.InFlight, Redeliveries: r.Redeliveries}
+		if _, err := tw.expireTask("Replay", r.Expiration.UnixNano(), r.Task, r.Payload, spec, opts); err != nil {
+			if l := root.opts.logger; l != nil {
+				l.Warn("timewheel: timer not replayed", slog.Uint64("timer", r.ID), slog.String("task", r.Task), slog.Any("error", err))
+			}
+			continue
+		}
+		if err := s.Delete(r.ID); err != nil {
+			tw.storeFailed("delete", r.ID, err)
+		}
+	}
+}
+
+// MemoryStore is a Store in memory, e.g. for the tests.
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[uint64]TimerRecord
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[uint64]TimerRecord)}
+}
+
+// Save implements the Store.
+func (s *MemoryStore) Save(r TimerRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[r.ID] = r
+	return nil
+}
+
+// Delete implements the Store.
+func (s *MemoryStore) Delete(id uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, id)
+	return nil
+}
+
+// LoadAll implements the Store, the records are in order of the ID.
+func (s *MemoryStore) LoadAll() (RecordIterator, error) {
+	return &sliceIterator{records: s.Records()}, nil
+}
+
+// Records returns a copy of the records in order of the ID.
+func (s *MemoryStore) Records() []TimerRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sortedRecords(s.records)
+}
+
+func sortedRecords(m map[uint64]TimerRecord) []TimerRecord {
+	records := make([]TimerRecord, 0, len(m))
+	for _, r := range m {
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	return records
+}
+
+// sliceIterator is a RecordIterator of the records in memory.
+type sliceIterator struct {
+	records []TimerRecord
+}
+
+func (it *sliceIterator) Next() (TimerRecord, error) {
+	if len(it.records) == 0 {
+		return TimerRecord{}, io.EOF
+	}
+	r := it.records[0]
+	it.records = it.records[1:]
+	return r, nil
+}
+
+func (it *sliceIterator) Close() error {
+	return nil
+}
@@ -0,0 +1,174 @@
+package timewheel
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore()
+	require.NoError(t, s.Save(TimerRecord{ID: 2, Task: "b"}))
+	require.NoError(t, s.Save(TimerRecord{ID: 1, Task: "a"}))
+	require.NoError(t, s.Save(TimerRecord{ID: 2, Task: "c"}))
+	require.NoError(t, s.Delete(3))
+
+	it, err := s.LoadAll()
+	require.NoError(t, err)
+	var got []TimerRecord
+	for {
+		r, err := it.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		got = append(got, r)
+	}
+	require.NoError(t, it.Close())
+	require.Equal(t, []TimerRecord{{ID: 1, Task: "a"}, {ID: 2, Task: "c"}}, got)
+
+	require.NoError(t, s.Delete(1))
+	require.Equal(t, []TimerRecord{{ID: 2, Task: "c"}}, s.Records())
+}
+
+func TestTimeWheel_WithStore(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	r := NewTaskRegistry()
+	r.Register("block", func(context.Context, []byte) {
+		close(started)
+		<-release
+	})
+	r.Register("noop", func(context.Context, []byte) {})
+
+	s := NewMemoryStore()
+	tw := New(time.Millisecond, 8, WithTaskRegistry(r), WithStore(s))
+	tw.Start()
+	defer tw.Stop()
+
+	// The timers of func are never saved.
+	tw.AfterFunc(time.Hour, func() {})
+	require.Empty(t, s.Records())
+
+	pending, err := tw.AfterTask(time.Hour, "noop", []byte("data"), WithTag("foo"))
+	require.NoError(t, err)
+	records := s.Records()
+	require.Len(t, records, 1)
+	require.Equal(t, pending.ID(), records[0].ID)
+	require.Equal(t, "noop", records[0].Task)
+	require.Equal(t, "foo", records[0].Tag)
+	require.Equal(t, []byte("data"), records[0].Payload)
+	require.Equal(t, pending.Expiration().UnixNano(), records[0].Expiration.UnixNano())
+	require.False(t, records[0].InFlight)
+
+	// Saved again once reset.
+	require.True(t, pending.Reset(time.Hour*2))
+	require.Equal(t, pending.Expiration().UnixNano(), s.Records()[0].Expiration.UnixNano())
+
+	// Deleted once cancelled.
+	pending.Close()
+	require.Empty(t, s.Records())
+
+	// Saved as in flight while running, and deleted once acknowledged.
+	running, err := tw.AfterTask(time.Millisecond, "block", nil)
+	require.NoError(t, err)
+	<-started
+	records = s.Records()
+	require.Len(t, records, 1)
+	require.Equal(t, running.ID(), records[0].ID)
+	require.True(t, records[0].InFlight)
+	close(release)
+	<-running.Done()
+	require.Eventually(t, func() bool { return len(s.Records()) == 0 }, time.Second, time.Millisecond)
+	require.Zero(t, tw.Stats().StoreErrors)
+}
+
+func TestTimeWheel_WithStore_Panic(t *testing.T) {
+	r := NewTaskRegistry()
+	r.Register("panic", func(context.Context, []byte) { panic("boom") })
+
+	s := NewMemoryStore()
+	tw := New(time.Millisecond, 8, WithTaskRegistry(r), WithStore(s), WithDispatchPolicy(DispatchInline))
+	tw.Start()
+	defer tw.Stop()
+
+	timer, err := tw.AfterTask(time.Millisecond, "panic", nil)
+	require.NoError(t, err)
+	<-timer.Done()
+
+	// The task panicked stays in flight.
+	records := s.Records()
+	require.Len(t, records, 1)
+	require.True(t, records[0].InFlight)
+	require.True(t, timer.Ack())
+	require.Empty(t, s.Records())
+}
+
+func TestTimeWheel_WithStore_Replay(t *testing.T) {
+	s := NewMemoryStore()
+	past := time.Now().Add(-time.Minute)
+	require.NoError(t, s.Save(TimerRecord{ID: 100, Expiration: past, Task: "job", Payload: []byte("due")}))
+	require.NoError(t, s.Save(TimerRecord{ID: 101, Expiration: past, Task: "job", Payload: []byte("again"), InFlight: true, Redeliveries: 1}))
+	require.NoError(t, s.Save(TimerRecord{ID: 102, Expiration: time.Now().Add(time.Hour), Task: "job", Tag: "later"}))
+	require.NoError(t, s.Save(TimerRecord{ID: 50, Expiration: past, Task: "unknown"}))
+
+	type run struct {
+		payload string
+		info    TimerInfo
+	}
+	runC := make(chan run, 2)
+	r := NewTaskRegistry()
+	r.Register("job", func(ctx context.Context, payload []byte) {
+		info, _ := TimerInfoFromContext(ctx)
+		runC <- run{payload: string(payload), info: info}
+	})
+	tw := New(time.Millisecond, 8, WithTaskRegistry(r), WithStore(s))
+	tw.Start()
+	defer tw.Stop()
+
+	runs := map[string]run{}
+	for i := 0; i < 2; i++ {
+		select {
+		case x := <-runC:
+			runs[x.payload] = x
+		case <-time.After(time.Second):
+			t.Fatal("the due timers are not executed")
+		}
+	}
+	require.Equal(t, uint32(0), runs["due"].info.Redeliveries)
+	require.Equal(t, uint32(2), runs["again"].info.Redeliveries)
+
+	// The timer not yet due is replaced with a new ID, and the unknown task is
+	// left in the store.
+	require.Eventually(t, func() bool { return len(s.Records()) == 2 }, time.Second, time.Millisecond)
+	records := s.Records()
+	require.Equal(t, uint64(50), records[0].ID)
+	require.Greater(t, records[1].ID, uint64(102))
+	require.Equal(t, "later", records[1].Tag)
+	require.Equal(t, int64(1), tw.Pending())
+
+	// The new IDs never collide with the records.
+	require.Greater(t, tw.AfterFunc(time.Hour, func() {}).ID(), records[1].ID)
+}
+
+type failingStore struct{ *MemoryStore }
+
+func (failingStore) Save(TimerRecord) error { return errors.New("full") }
+
+func TestTimeWheel_WithStore_Error(t *testing.T) {
+	r := NewTaskRegistry()
+	r.Register("noop", func(context.Context, []byte) {})
+	tw := New(time.Millisecond, 8, WithTaskRegistry(r), WithStore(failingStore{NewMemoryStore()}))
+	tw.Start()
+	defer tw.Stop()
+
+	// The timer is scheduled anyway.
+	timer, err := tw.AfterTask(time.Millisecond, "noop", nil)
+	require.NoError(t, err)
+	<-timer.Done()
+	require.Equal(t, uint64(2), tw.Stats().StoreErrors)
+}
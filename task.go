@@ -0,0 +1,114 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/yu31/timewheel/timerspec"
+)
+
+// TaskFunc is the task of a named timer, it receives the payload that given
+// when the timer is created.
+type TaskFunc func(ctx context.Context, payload []byte)
+
+// TaskRegistry maps the names to the tasks. A timer that refers to its task
+// by name rather than a func can be saved and restored in another process,
+// see AfterTask and SaveTo.
+type TaskRegistry struct {
+	mu    sync.RWMutex
+	tasks map[string]TaskFunc
+}
+
+// NewTaskRegistry creates an empty TaskRegistry.
+func NewTaskRegistry() *TaskRegistry {
+	return &TaskRegistry{tasks: make(map[string]TaskFunc)}
+}
+
+// Register registers the task f with the name. It panics if the name is empty
+// or already registered, since the name must identify a single task across
+// the processes.
+func (r *TaskRegistry) Register(name string, f TaskFunc) {
+	if name == "" || f == nil {
+		panic("timewheel: task name and func must be non-empty")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.tasks[name]; ok {
+		panic("timewheel: task " + name + " is already registered")
+	}
+	r.tasks[name] = f
+}
+
+// Lookup returns the task registered with the name.
+func (r *TaskRegistry) Lookup(name string) (TaskFunc, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	f, ok := r.tasks[name]
+	return f, ok
+}
+
+// AfterTask waits for the duration to elapse and then calls the task that
+// registered with the name in the TaskRegistry set by WithTaskRegistry. The
+// task is dispatched the same as AfterFunc, and receives the payload.
+//
+// It returns a *ScheduleError with ErrUnknownTask if the name is not registered,
+// or ErrQuotaExceeded if the quota of the tag is reached (see SetQuota).
+func (tw *TimeWheel) AfterTask(d time.Duration, name string, payload []byte, opts ...TimerOption) (*Timer, error) {
+	return tw.expireTask("AfterTask", tw.expireAfter(d), name, payload, nil, opts)
+}
+
+// TimeTask is like AfterTask, but waits until the appointed time.
+func (tw *TimeWheel) TimeTask(t time.Time, name string, payload []byte, opts ...TimerOption) (*Timer, error) {
+	return tw.expireTask("TimeTask", t.UnixNano(), name, payload, nil, opts)
+}
+
+// expireTask help creates a Timer of the named task by giving an expiration
+// timestamp. The spec is the saved timer being restored, it may be nil.
+func (tw *TimeWheel) expireTask(op string, expiration int64, name string, payload []byte, spec *timerspec.Spec, opts []TimerOption) (*Timer, error) {
+	return tw.expireTaskOf(tw.root.opts.registry, op, expiration, name, payload, spec, opts)
+}
+
+// expireTaskOf is expireTask, but the task is looked up in the registry r.
+func (tw *TimeWheel) expireTaskOf(r *TaskRegistry, op string, expiration int64, name string, payload []byte, spec *timerspec.Spec, opts []TimerOption) (*Timer, error) {
+	var f TaskFunc
+	var ok bool
+	if r != nil {
+		f, ok = r.Lookup(name)
+	}
+	if !ok {
+		return nil, scheduleError(op, time.Unix(0, expiration), opts, ErrUnknownTask)
+	}
+
+	opts = append(opts[:len(opts):len(opts)], func(t *Timer) {
+		a := t.setAttrs()
+		a.task = name
+		a.payload = payload
+		if spec != nil {
+			a.unknown = spec.Unknown
+			a.redeliveries = spec.Redeliveries
+			if spec.InFlight {
+				a.redeliveries++
+			}
+		}
+	})
+	t := tw.expireFunc(context.Background(), expiration, func(ctx context.Context, t *Timer) {
+		f(context.WithValue(ctx, timerInfoKey{}, t), payload)
+		t.Ack()
+	}, opts)
+	if err := t.rejected(); err != nil {
+		return nil, &ScheduleError{Op: op, Expiration: time.Unix(0, expiration), Tag: t.Tag(), Err: err}
+	}
+	return t, nil
+}
+
+// TaskName returns the name of the task that given by AfterTask or TimeTask,
+// or the empty string if the timer is not created by them.
+func (t *Timer) TaskName() string {
+	return t.getAttrs().task
+}
@@ -0,0 +1,68 @@
+package timewheel
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTaskRegistry(t *testing.T) {
+	r := NewTaskRegistry()
+	r.Register("foo", func(context.Context, []byte) {})
+
+	_, ok := r.Lookup("foo")
+	require.True(t, ok)
+	_, ok = r.Lookup("bar")
+	require.False(t, ok)
+
+	require.Panics(t, func() { r.Register("foo", func(context.Context, []byte) {}) })
+	require.Panics(t, func() { r.Register("", func(context.Context, []byte) {}) })
+	require.Panics(t, func() { r.Register("bar", nil) })
+}
+
+func TestTimeWheel_AfterTask(t *testing.T) {
+	r := NewTaskRegistry()
+	payloadC := make(chan []byte, 1)
+	r.Register("echo", func(_ context.Context, payload []byte) { payloadC <- payload })
+
+	tw := New(time.Millisecond, 8, WithTaskRegistry(r))
+	tw.Start()
+	defer tw.Stop()
+
+	timer, err := tw.AfterTask(time.Millisecond, "echo", []byte("hello"), WithTag("greet"))
+	require.NoError(t, err)
+	require.Equal(t, "echo", timer.TaskName())
+	require.Equal(t, "greet", timer.Tag())
+	require.Equal(t, []byte("hello"), timer.Payload())
+	require.Equal(t, []byte("hello"), <-payloadC)
+	<-timer.Done()
+
+	timer, err = tw.TimeTask(time.Now().Add(time.Millisecond), "echo", nil)
+	require.NoError(t, err)
+	<-timer.Done()
+	require.Nil(t, <-payloadC)
+
+	require.Equal(t, "", tw.AfterFunc(time.Hour, func() {}).TaskName())
+}
+
+func TestTimeWheel_AfterTask_Unknown(t *testing.T) {
+	tw := New(time.Millisecond, 8, WithTaskRegistry(NewTaskRegistry()))
+	defer tw.Stop()
+
+	_, err := tw.AfterTask(time.Millisecond, "missing", nil, WithTag("x"))
+	require.True(t, errors.Is(err, ErrUnknownTask))
+	var se *ScheduleError
+	require.True(t, errors.As(err, &se))
+	require.Equal(t, "AfterTask", se.Op)
+	require.Equal(t, "x", se.Tag)
+
+	// No registry at all.
+	tw2 := New(time.Millisecond, 8)
+	defer tw2.Stop()
+	_, err = tw2.TimeTask(time.Now(), "missing", nil)
+	require.True(t, errors.Is(err, ErrUnknownTask))
+	require.Equal(t, int64(0), tw2.Pending())
+}
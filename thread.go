@@ -0,0 +1,128 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// WithDedicatedThread makes the consumer goroutine of the TimeWheel run on an
+// OS thread of its own by runtime.LockOSThread, thus it's never descheduled in
+// favor of the other goroutines, which cuts the jitter of the expirations and
+// the tasks executed inline (see DispatchInline). See ConsumerJitter to verify
+// the improvement.
+//
+// The init, if not nil, is called once in the locked thread before any bucket
+// is processed, which is the place to raise the priority of the thread by the
+// OS, e.g. by unix.SchedSetAttr or unix.Setpriority with the unix.Gettid on
+// Linux. The thread is never unlocked, it's terminated once the consumer
+// goroutine exits on Stop, thus the settings of init never leak to the other
+// goroutines.
+//
+// It's a no-op on the platforms without the OS threads, such as the js/wasm,
+// and when the TimeWheel is driven by Poll, see WithClock.
+func WithDedicatedThread(init func()) Option {
+	return func(o *options) {
+		o.dedicatedThread = true
+		o.threadInit = init
+	}
+}
+
+// consumeOnThread starts consuming the queue in the locked thread, the sentinel
+// bucket is offered to lock the thread as soon as the consumer starts.
+func (tw *TimeWheel) consumeOnThread() {
+	root := tw.root
+	init := root.opts.threadInit
+	sentinel := newBucket()
+	locked := false
+	tw.queue.consume(func(b *bucket, expiration int64) {
+		if !locked {
+			locked = true
+			if runtime.GOARCH != "wasm" {
+				runtime.LockOSThread()
+			}
+			if init != nil {
+				init()
+			}
+		}
+		if b != sentinel {
+			tw.process(b, expiration)
+		}
+	})
+	tw.queue.offer(sentinel, root.now())
+}
+
+// jitterBounds are the upper bounds of the buckets of the ConsumerJitter.
+var jitterBounds = []time.Duration{
+	10 * time.Microsecond, 50 * time.Microsecond, 100 * time.Microsecond, 500 * time.Microsecond,
+	time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond, 50 * time.Millisecond,
+}
+
+// ConsumerJitter returns the histogram of the delays between the expiration
+// of each bucket and the start of its processing, i.e. the ConsumerLag of all
+// the buckets processed so far. Its Max is the worst jitter of the consumer
+// goroutine, see WithDedicatedThread.
+func (tw *TimeWheel) ConsumerJitter() DurationHistogram {
+	return tw.root.jitter.snapshot(jitterBounds)
+}
+
+// observeJitter records the lag of processing a bucket handed over at now.
+func (tw *TimeWheel) observeJitter(now, lag int64) {
+	atomic.StoreInt64(&tw.root.consumerLag, lag)
+	tw.root.jitter.observe(jitterBounds, time.Duration(lag))
+	tw.root.lagWindow.observe(now, lag)
+}
+
+// lagWindowSeconds is the span of the lagWindow, see Stats.ConsumerLagMax.
+const lagWindowSeconds = 60
+
+// lagWindow keeps the maximum consumer lag of each second in the last minute,
+// thus a single stall stays visible for a minute however many buckets are
+// processed quickly after it. It's written by the consumer goroutine only, and
+// read by Stats concurrently.
+type lagWindow struct {
+	slots [lagWindowSeconds]lagSlot
+}
+
+// lagSlot is the maximum lag of a second, both fields are accessed atomically.
+type lagSlot struct {
+	second int64 // of the Unix time.
+	max    int64
+}
+
+func newLagWindow() *lagWindow {
+	return &lagWindow{}
+}
+
+// observe records the lag observed at now.
+func (w *lagWindow) observe(now, lag int64) {
+	second := now / int64(time.Second)
+	s := &w.slots[second%lagWindowSeconds]
+	if atomic.LoadInt64(&s.second) != second {
+		// The slot holds the second a minute ago.
+		atomic.StoreInt64(&s.max, lag)
+		atomic.StoreInt64(&s.second, second)
+	} else if lag > atomic.LoadInt64(&s.max) {
+		atomic.StoreInt64(&s.max, lag)
+	}
+}
+
+// max returns the maximum lag observed in the minute before now.
+func (w *lagWindow) max(now int64) int64 {
+	second := now / int64(time.Second)
+	var max int64
+	for i := range w.slots {
+		s := &w.slots[i]
+		if sec := atomic.LoadInt64(&s.second); sec > second-lagWindowSeconds && sec <= second {
+			if lag := atomic.LoadInt64(&s.max); lag > max {
+				max = lag
+			}
+		}
+	}
+	return max
+}
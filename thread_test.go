@@ -0,0 +1,55 @@
+package timewheel
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithDedicatedThread(t *testing.T) {
+	var inits int32
+	initC := make(chan struct{})
+	tw := New(time.Millisecond, 8, WithDispatchPolicy(DispatchInline), WithDedicatedThread(func() {
+		if atomic.AddInt32(&inits, 1) == 1 {
+			close(initC)
+		}
+	}))
+	tw.Start()
+
+	// Locked once the consumer starts, before any timer expires.
+	select {
+	case <-initC:
+	case <-time.After(time.Second):
+		t.Fatal("the init is not called")
+	}
+	for i := 0; i < 5; i++ {
+		<-tw.AfterFunc(time.Millisecond, func() {}).Done()
+	}
+	tw.Stop()
+	require.Equal(t, int32(1), atomic.LoadInt32(&inits))
+
+	jitter := tw.ConsumerJitter()
+	require.Equal(t, uint64(5), jitter.Count)
+	require.Len(t, jitter.Counts, len(jitterBounds)+1)
+	require.GreaterOrEqual(t, int64(jitter.Max), int64(tw.Stats().ConsumerLag))
+}
+
+func TestWithDedicatedThread_Clock(t *testing.T) {
+	clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	called := false
+	tw := New(time.Millisecond, 8, WithClock(clock), WithDispatchPolicy(DispatchInline), WithDedicatedThread(func() { called = true }))
+	tw.Start()
+	defer tw.Stop()
+
+	// No-op when driven by Poll.
+	timer := tw.AfterFunc(time.Millisecond, func() {})
+	clock.add(time.Millisecond * 3)
+	n, _ := tw.Poll()
+	require.Equal(t, 1, n)
+	<-timer.Done()
+	require.False(t, called)
+	require.Equal(t, uint64(1), tw.ConsumerJitter().Count)
+	require.Equal(t, int64(time.Millisecond*2), int64(tw.ConsumerJitter().Max))
+}
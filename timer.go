@@ -6,15 +6,45 @@
 package timewheel
 
 import (
-	"container/list"
+	"context"
+	"fmt"
+	"runtime"
 	"sync/atomic"
+	"time"
 	"unsafe"
 )
 
+// closedC is a closed channel that shared by all finished timers whose
+// Done is not called before finished.
+var closedC = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
 // Timer represents a single event. The given task will be executed when the timer expires.
+//
+// The Timer is kept to 64 bytes on 64-bit platforms, since millions of them
+// may be pending at the same time (see TestTimer_Sizeof). The fields that most
+// timers never use are held by the attrs, which is allocated only if needed.
 type Timer struct {
+	// It aligns the Timer to 8 bytes wherever it's placed, e.g. in the slots of
+	// the arena, thus the atomic 64-bit fields below are aligned on the 32-bit
+	// platforms.
+	_ [0]atomic.Int64
+
+	// NOTICE: This field may be updated and read concurrently, through the
+	// rescheduling of a recurring timer and the diagnostics such as String.
 	expiration int64 // in nanoseconds.
-	task       func()
+
+	// The ID, EndReason and State of the timer packed in a word, see state.go.
+	// It's placed right after the expiration to be 64-bit aligned on 32-bit platforms.
+	meta uint64
+
+	task func()
+
+	// The TimeWheel that the timer belongs to.
+	tw *TimeWheel
 
 	// The bucket that holds the list to which this timer's element belongs.
 	//
@@ -23,7 +53,113 @@ type Timer struct {
 	b unsafe.Pointer // type: *bucket
 
 	// The timer's Element in list.
-	element *list.Element
+	element *timerElement
+
+	// The channel returned by Done, it's allocated lazily.
+	//
+	// NOTICE: This field may be updated and read concurrently,
+	// through Timer.Done() and Timer.finish().
+	done unsafe.Pointer // type: *chan struct{}
+
+	// The optional attributes, it's nil if none is set.
+	attrs *attrs
+}
+
+// attrs holds the optional attributes of a Timer. It's only written before
+// the timer is scheduled, thus it can be read without synchronization.
+//
+// The attrs is referenced by the timer rather than kept in a side table keyed
+// by the ID, so that it's collected together with the timer and still valid
+// after the timer is finished, e.g. the Payload read from the Expired channel.
+type attrs struct {
+	// The user data carried by the timer.
+	payload interface{}
+	// The tag that set by WithTag.
+	tag string
+
+	// The overlap tracking of a recurring timer, it's nil if the executions
+	// are allowed to overlap.
+	overlap *overlap
+
+	// The func called once the timer is finished, it may be nil.
+	onFinish func()
+
+	// The name of the task in the TaskRegistry, it's empty unless the timer
+	// is created by AfterTask or TimeTask. The payload is a []byte if set.
+	task string
+	// The encoded fields of the spec that not known by this version, they
+	// are preserved for SaveTo.
+	unknown []byte
+	// The number of times that the timer is fired again after restored,
+	// since the previous fires are not acknowledged.
+	redeliveries uint32
+	// The epoch of the latest SaveTo that wrote the timer, it's only accessed
+	// by SaveTo, which are serialized.
+	saved uint64
+
+	// The ID passed to the FireGuard, the timer is not guarded if it's empty.
+	guardID string
+
+	// The quota that the timer counts toward, and whether it's still held.
+	// The quotaHeld is accessed atomically.
+	quota     *quota
+	quotaHeld int32
+	// Whether the timer is created by Schedule.
+	recurring bool
+	// The reason that the timer is rejected when scheduled, see EndRejected.
+	err error
+	// The priority that set by WithPriority.
+	priority Priority
+
+	// Whether the first execution of a recurring timer is at scheduling time.
+	immediate bool
+	// Whether the timer is the one of a ReusableTimer, it's never finished.
+	reusable bool
+	// The Scope that the timer belongs to, it may be nil.
+	scope *Scope
+	// Whether the panic of the task is propagated, see NoRecover.
+	noRecover bool
+	// The list element preallocated by the arena, see WithCapacity.
+	element *timerElement
+	// The state of the RetryPolicy, see WithRetry.
+	retry *retry
+	// The state of the series of ScheduleSelf, and whether its next execution
+	// is measured from the completion, see FixedDelay.
+	self       *selfSchedule
+	fixedDelay bool
+	// It creates a timer with the same task and options, it's only set if
+	// the dead letters are handled, see Redrive.
+	redrive func(expiration int64) *Timer
+	// Whether it's the watchdog of a task, see WithWatchdog.
+	watchdog bool
+}
+
+// noAttrs is shared by the timers without any optional attribute.
+var noAttrs = &attrs{}
+
+// getAttrs returns the optional attributes of the timer, it never returns nil.
+func (t *Timer) getAttrs() *attrs {
+	if t.attrs == nil {
+		return noAttrs
+	}
+	return t.attrs
+}
+
+// setAttrs returns the optional attributes of the timer for writing, it's
+// created if not exists. It must be called before the timer is scheduled.
+func (t *Timer) setAttrs() *attrs {
+	if t.attrs == nil {
+		t.attrs = &attrs{}
+	}
+	return t.attrs
+}
+
+func (t *Timer) getExpiration() int64 {
+	return atomic.LoadInt64(&t.expiration)
+}
+
+func (t *Timer) setExpiration(expiration int64) {
+	atomic.StoreInt64(&t.expiration, expiration)
 }
 
 func (t *Timer) getBucket() *bucket {
@@ -34,19 +170,375 @@ func (t *Timer) setBucket(b *bucket) {
 	atomic.StorePointer(&t.b, unsafe.Pointer(b))
 }
 
+// apply applies the opts to the timer t.
+func (t *Timer) apply(opts []TimerOption) {
+	for _, opt := range opts {
+		opt(t)
+	}
+}
+
+// probeOptions returns a Timer that is never scheduled with the opts applied,
+// it reads the options before the timer is created, e.g. the tag of an error.
+func probeOptions(opts []TimerOption) *Timer {
+	probe := &Timer{}
+	probe.apply(opts)
+	return probe
+}
+
+// ID returns the unique ID of the timer in its TimeWheel.
+func (t *Timer) ID() uint64 {
+	return atomic.LoadUint64(&t.meta) >> metaIDShift
+}
+
+// Tag returns the tag that given by WithTag when creates the timer.
+func (t *Timer) Tag() string {
+	return t.getAttrs().tag
+}
+
+// Payload returns the user data that given when creates the timer by After or At.
+func (t *Timer) Payload() interface{} {
+	return t.getAttrs().payload
+}
+
+// Done returns a channel that's closed when the timer is finished, i.e. after
+// its task returns, or it has been closed before the task started.
+//
+// For a recurring timer created by Schedule, the channel is closed only when
+// its execution plan ends, i.e. after the last task returns or it's closed.
+// NOTICE: if the recurring timer is closed while a task is still running, the
+// channel is closed without waiting for that task to return.
+func (t *Timer) Done() <-chan struct{} {
+	if p := atomic.LoadPointer(&t.done); p != nil {
+		return *(*chan struct{})(p)
+	}
+	c := make(chan struct{})
+	if atomic.CompareAndSwapPointer(&t.done, nil, unsafe.Pointer(&c)) {
+		return c
+	}
+	// The timer has finished or Done is called concurrently.
+	return *(*chan struct{})(atomic.LoadPointer(&t.done))
+}
+
+// Wait blocks until the timer is finished (see Done) or the ctx is done.
+// It returns the ctx.Err() if the ctx is done first.
+func (t *Timer) Wait(ctx context.Context) error {
+	select {
+	case <-t.Done():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// finish marks the timer as finished, closes the channel returned by Done
+// and calls the onFinish. Only the first call takes effect.
+func (t *Timer) finish() {
+	p := atomic.SwapPointer(&t.done, unsafe.Pointer(&closedC))
+	if p == unsafe.Pointer(&closedC) {
+		return
+	}
+	t.releaseQuota()
+	if p != nil {
+		close(*(*chan struct{})(p))
+	}
+	a := t.getAttrs()
+	if a.scope != nil {
+		a.scope.remove(t)
+	}
+	if t.tw != nil && t.tw.root.leaks != nil {
+		// Before the onFinish, which may put t back to the arena.
+		t.tw.root.leaks.release(t)
+	}
+	if f := a.onFinish; f != nil {
+		f()
+	}
+	if a.task != "" && !t.tw.root.inflight.contains(t) {
+		// The record of the timer in flight is deleted once acknowledged.
+		t.tw.storeDelete(t)
+	}
+}
+
 // Close prevents the Timer from firing.
 //
-// The func will be block until the timer has finally been removed from the TimeWheel.
-// But, if the timer t has already expired and the t.task has been started in its own
-// goroutine; Close does not wait for t.task to complete before returning. If the caller
-// needs to know whether t.task is completed, it must coordinate with t.task explicitly.
+// The func will be block until the timer has finally been removed from the TimeWheel,
+// or has been cancelled after expired but before its task was dispatched.
+// But, if the task of timer t has already been dispatched (i.e. the state is Running
+// or Completed); Close does not wait for t.task to complete before returning. If the
+// caller needs to know whether t.task is completed, it must coordinate with t.task
+// explicitly, or wait for the channel returned by Done.
 func (t *Timer) Close() {
-	for b := t.getBucket(); b != nil; b = t.getBucket() {
-		// The b.delete may fail if t's bucket has changed due to TimeWheel call the b.flush.
-		// Thus, we re-get t's possibly new bucket and retry until the bucket becomes nil or
-		// delete successful.
-		if ok := b.delete(t); ok {
+	if t.cancel(true) {
+		t.finish()
+		return
+	}
+	if r := t.getAttrs().retry; r != nil {
+		// The attempt is running, stop the further ones.
+		r.stop(t)
+	}
+	if s := t.getAttrs().self; s != nil {
+		// The execution is running, stop the further ones.
+		s.stop(t)
+	}
+}
+
+// Reset changes the run-once timer t to expire after duration d, it returns
+// true if the timer is rescheduled.
+//
+// If t is still pending, it's removed from the TimeWheel and scheduled again
+// with the new expiration. If t is running, e.g. Reset is called from its own
+// task, t is scheduled again once the task returns rather than immediately,
+// thus the task is never executed concurrently with itself; the last Reset
+// made while running wins. It returns false if t is a recurring timer or has
+// been finished, use the ReusableTimer to re-arm a timer after it's finished.
+//
+// It's linearizable with respect to the dispatch of t, which moves t out of
+// StateScheduled and then StateQueued by CAS that Reset contends on: each
+// successful Reset replaces the pending execution, or arms the next one if
+// the task is running, thus the task is executed at most once between two
+// successful Resets, and at least once after the last one.
+func (t *Timer) Reset(d time.Duration) bool {
+	if t.tw == nil || t.getAttrs().recurring {
+		return false
+	}
+	return t.resetAt(t.tw.expireAfter(d))
+}
+
+// resetAt is Reset, but to the expiration in UnixNano.
+func (t *Timer) resetAt(expiration int64) bool {
+	tw := t.tw
+	if t.unlink() {
+		t.reschedule(expiration)
+		return true
+	}
+	if t.cancel(false) {
+		// The timer has expired but not dispatched.
+		t.setExpiration(expiration)
+		tw.schedule(t)
+		return true
+	}
+	for {
+		meta := atomic.LoadUint64(&t.meta)
+		if State(meta&metaStateMask) != StateRunning {
+			return false
+		}
+		t.setExpiration(expiration)
+		if atomic.CompareAndSwapUint64(&t.meta, meta, meta|metaReset) {
+			return true
+		}
+	}
+}
+
+// RescheduleIfPending changes the run-once timer t to expire after duration d
+// only if it's still in StateScheduled, i.e. it's neither expired nor closed.
+// It returns false otherwise, and t is left untouched; unlike Reset, it never
+// re-arms a timer that has expired or is running.
+//
+// It's linearizable with respect to the firing, Close, Reset and
+// CancelIfExpirationIs of t, each of them takes effect atomically in some
+// order: either t is rescheduled before it expires and fires at the new
+// expiration, or RescheduleIfPending fails since t has expired or closed.
+func (t *Timer) RescheduleIfPending(d time.Duration) bool {
+	if t.tw == nil || t.getAttrs().recurring {
+		return false
+	}
+	expiration := t.tw.expireAfter(d)
+	if !t.unlink() {
+		return false
+	}
+	t.reschedule(expiration)
+	return true
+}
+
+// CancelIfExpirationIs closes the timer t like Close, but only if it's still
+// pending with the expiration exp, e.g. the one read by Expiration before. It
+// returns false if t has been rescheduled to another expiration, or has been
+// dispatched or closed, and t is left untouched.
+//
+// It's linearizable like RescheduleIfPending, thus the callers can cancel a
+// timer optimistically without losing a concurrent reschedule.
+func (t *Timer) CancelIfExpirationIs(exp time.Time) bool {
+	if t.tw == nil {
+		return false
+	}
+	tw := t.tw
+	expiration := exp.UnixNano()
+	for {
+		if t.unlink() {
+			if t.getExpiration() != expiration {
+				// Put it back as it was.
+				tw.submit(t)
+				return false
+			}
+			t.transit(StateScheduled, StateCancelled)
+			t.setEndReason(EndCancelled)
+			atomic.AddUint64(&tw.root.cancelled, 1)
+			tw.observeCancel(t)
+			tw.decPending()
+			t.finish()
+			return true
+		}
+		if t.State() != StateQueued {
+			return false
+		}
+		if t.getExpiration() != expiration {
+			return false
+		}
+		// The timer has expired but not dispatched, see cancel.
+		if t.transit(StateQueued, StateCancelled) {
+			t.setEndReason(EndCancelled)
+			atomic.AddUint64(&tw.root.cancelled, 1)
+			tw.observeCancel(t)
+			t.finish()
+			return true
+		}
+	}
+}
+
+// Expiration returns the time that the timer t is scheduled to expire at.
+// For a recurring timer, it's the expiration of the current execution.
+func (t *Timer) Expiration() time.Time {
+	return time.Unix(0, t.getExpiration())
+}
+
+// unlink removes t from its bucket but leaves it in StateScheduled, thus it
+// can't be fired, and the concurrent operations of t (e.g. cancel) wait for
+// it as if it's being inserted, until it's submitted again by the caller.
+// It waits for t to be inserted if it's being inserted or moved, and returns
+// false if t is not scheduled.
+func (t *Timer) unlink() bool {
+	for {
+		if b := t.getBucket(); b != nil {
+			ok, removed := b.delete(t)
+			if !ok {
+				continue
+			}
+			if removed {
+				return true
+			}
+		}
+		if t.State() != StateScheduled {
+			return false
+		}
+		runtime.Gosched()
+	}
+}
+
+// reschedule submits the timer t unlinked by unlink again with the new
+// expiration. It's counted and observed like it's cancelled and scheduled
+// again, the same as Reset.
+func (t *Timer) reschedule(expiration int64) {
+	tw := t.tw
+	atomic.AddUint64(&tw.root.cancelled, 1)
+	tw.observeCancel(t)
+	t.setExpiration(expiration)
+	tw.storeSave(t, false)
+	tw.observeSchedule(t)
+	tw.submit(t)
+}
+
+// rearm schedules the running timer again if it's reset while running,
+// it returns false if the timer is not reset.
+func (t *Timer) rearm() bool {
+	for {
+		meta := atomic.LoadUint64(&t.meta)
+		if meta&metaReset == 0 || State(meta&metaStateMask) != StateRunning {
+			return false
+		}
+		if atomic.CompareAndSwapUint64(&t.meta, meta, meta&^(metaStateMask|metaReset)|uint64(StateScheduled)) {
 			break
 		}
 	}
+	t.tw.incPending()
+	t.tw.storeSave(t, false)
+	t.tw.observeSchedule(t)
+	t.tw.submit(t)
+	return true
+}
+
+// unreset undoes the Reset made while the timer is running, it returns false
+// if the timer is not reset or not running.
+func (t *Timer) unreset() bool {
+	for {
+		meta := atomic.LoadUint64(&t.meta)
+		if meta&metaReset == 0 || State(meta&metaStateMask) != StateRunning {
+			return false
+		}
+		if atomic.CompareAndSwapUint64(&t.meta, meta, meta&^metaReset) {
+			return true
+		}
+	}
+}
+
+// cancel removes the timer from the TimeWheel and moves it to StateCancelled,
+// it returns false if the timer is not scheduled or has been dispatched. The
+// EndReason is set to EndCancelled if final is true.
+func (t *Timer) cancel(final bool) bool {
+	for {
+		if b := t.getBucket(); b != nil {
+			// The b.delete may fail if t's bucket has changed due to TimeWheel call the b.flush.
+			// Thus, we re-get t's possibly new bucket and retry until the bucket becomes nil or
+			// delete successful.
+			ok, removed := b.delete(t)
+			if !ok {
+				continue
+			}
+			if removed && t.transit(StateScheduled, StateCancelled) {
+				if final {
+					t.setEndReason(EndCancelled)
+				}
+				if t.tw != nil {
+					atomic.AddUint64(&t.tw.root.cancelled, 1)
+					t.tw.observeCancel(t)
+					t.tw.decPending()
+				}
+				return true
+			}
+		}
+
+		// The timer is not in any bucket.
+		switch t.State() {
+		case StateQueued:
+			// The timer has expired but not dispatched, the consumer goroutine
+			// will skip it and decrease the pending when it sees the Cancelled.
+			if t.transit(StateQueued, StateCancelled) {
+				if final {
+					t.setEndReason(EndCancelled)
+				}
+				if t.tw != nil {
+					atomic.AddUint64(&t.tw.root.cancelled, 1)
+					t.tw.observeCancel(t)
+				}
+				return true
+			}
+		case StateScheduled:
+			// The timer is being inserted into a bucket or being expired,
+			// e.g. a recurring timer is being re-armed. Retry until it's done.
+			runtime.Gosched()
+		default:
+			return false
+		}
+	}
+}
+
+// String returns a concise description of the timer, such as:
+//
+//	Timer{id=1 tag="foo" expiration=2020-01-01T00:00:01Z state=scheduled remaining=1s}
+//
+// The remaining is truncated to milliseconds, and it's 0 once the timer expired.
+// It's safe to call concurrently with the expiring or rescheduling of the timer.
+func (t *Timer) String() string {
+	expiration := t.getExpiration()
+	if expiration == 0 {
+		return fmt.Sprintf("Timer{id=%d tag=%q state=%s}", t.ID(), t.Tag(), t.State())
+	}
+	now := time.Now().UnixNano()
+	if t.tw != nil {
+		now = t.tw.root.now()
+	}
+	remaining := time.Duration(expiration - now).Truncate(time.Millisecond)
+	if remaining < 0 {
+		remaining = 0
+	}
+	return fmt.Sprintf("Timer{id=%d tag=%q expiration=%s state=%s remaining=%s}",
+		t.ID(), t.Tag(), time.Unix(0, expiration).UTC().Format(time.RFC3339Nano), t.State(), remaining)
 }
@@ -1,11 +1,48 @@
 package timewheel
 
 import (
+	"context"
+	"regexp"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+	"unsafe"
 
 	"github.com/stretchr/testify/require"
 )
 
+func TestTimer_Sizeof(t *testing.T) {
+	if unsafe.Sizeof(uintptr(0)) != 8 {
+		t.Skip("the footprint is only tracked on 64-bit platforms")
+	}
+	// Grow it only with a good reason, it's multiplied by the pending timers.
+	require.Equal(t, uintptr(64), unsafe.Sizeof(Timer{}))
+	require.Equal(t, uintptr(8), unsafe.Offsetof(Timer{}.meta))
+}
+
+func TestTimer_Meta(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	timer := tw.AfterFunc(time.Hour, func() {}, WithTag("foo"))
+	defer tw.Stop()
+
+	require.Equal(t, uint64(1), timer.ID())
+	require.Equal(t, StateScheduled, timer.State())
+	require.Equal(t, EndNone, timer.EndReason())
+
+	require.True(t, timer.transit(StateScheduled, StateQueued))
+	timer.setEndReason(EndCancelled)
+	timer.setEndReason(EndTimes)
+	require.Equal(t, uint64(1), timer.ID())
+	require.Equal(t, StateQueued, timer.State())
+	require.Equal(t, EndCancelled, timer.EndReason())
+	require.Equal(t, "foo", timer.Tag())
+
+	// The timers without optional attributes share the noAttrs.
+	require.Nil(t, tw.AfterFunc(time.Hour, func() {}).attrs)
+}
+
 func TestTimer_Close(t *testing.T) {
 	b := newBucket()
 
@@ -13,7 +50,7 @@ func TestTimer_Close(t *testing.T) {
 	b.insert(timer)
 
 	require.Equal(t, b.timers.Front(), timer.element)
-	require.Equal(t, b.timers.Front().Value.(*Timer), timer)
+	require.Equal(t, b.timers.Front().Value, timer)
 
 	timer.Close()
 
@@ -27,3 +64,396 @@ func TestTimer_Close_With_AfterFunc(t *testing.T) {
 func TestTimer_Close_With_Schedule(t *testing.T) {
 	// TODO:
 }
+
+func TestTimer_Done(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	var returned int32
+	timer := tw.AfterFunc(time.Millisecond*2, func() {
+		time.Sleep(time.Millisecond * 5)
+		atomic.StoreInt32(&returned, 1)
+	})
+	// Same channel each call.
+	require.Equal(t, timer.Done(), timer.Done())
+
+	<-timer.Done()
+	require.Equal(t, atomic.LoadInt32(&returned), int32(1))
+}
+
+func TestTimer_Done_Lazy(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	timer := tw.AfterFunc(time.Millisecond, func() {})
+	require.Eventually(t, func() bool { return atomic.LoadPointer(&timer.done) != nil }, time.Second, time.Millisecond)
+
+	// The channel is not allocated before finished.
+	require.Equal(t, timer.Done(), (<-chan struct{})(closedC))
+}
+
+func TestTimer_Done_Close(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	timer := tw.AfterFunc(time.Hour, func() {})
+	doneC := timer.Done()
+	timer.Close()
+
+	select {
+	case <-doneC:
+	default:
+		t.Fatal("the Done is not closed after Close")
+	}
+}
+
+func TestTimer_Done_Schedule(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	task := &Task2{
+		interval: time.Millisecond * 2,
+		count:    3,
+		mu:       new(sync.Mutex),
+		wg:       new(sync.WaitGroup),
+		t:        t,
+	}
+	task.wg.Add(task.count)
+
+	timer := tw.Schedule(task)
+	<-timer.Done()
+	// The Done is closed after the last run returned.
+	require.Equal(t, task.count, 0)
+
+	// No time is scheduled.
+	<-tw.Schedule(&Task3{}).Done()
+}
+
+func TestTimer_Wait(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	timer := tw.AfterFunc(time.Hour, func() {})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*5)
+	defer cancel()
+	require.Equal(t, timer.Wait(ctx), context.DeadlineExceeded)
+
+	timer.Close()
+	require.Nil(t, timer.Wait(context.Background()))
+}
+
+func TestTimer_String(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	timer := tw.AfterFunc(time.Hour, func() {}, WithTag("foo"))
+	require.Regexp(t, regexp.MustCompile(
+		`^Timer\{id=1 tag="foo" expiration=\S+Z state=scheduled remaining=59m59\.\d+s\}$`), timer.String())
+
+	timer.Close()
+	require.Regexp(t, regexp.MustCompile(`^Timer\{id=1 tag="foo" expiration=\S+ state=cancelled remaining=\S+\}$`), timer.String())
+
+	timer = tw.AfterFunc(0, func() {})
+	<-timer.Done()
+	require.Regexp(t, regexp.MustCompile(`^Timer\{id=2 tag="" expiration=\S+ state=completed remaining=0s\}$`), timer.String())
+
+	require.Equal(t, `Timer{id=0 tag="" state=completed}`, tw.Schedule(&Task3{}).String())
+}
+
+func TestTimer_String_Race(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	timer := tw.Schedule(&Task4{interval: time.Millisecond})
+	defer timer.Close()
+
+	deadline := time.Now().Add(time.Millisecond * 20)
+	for time.Now().Before(deadline) {
+		require.Contains(t, timer.String(), "Timer{id=1 ")
+	}
+}
+
+func TestTimer_Reset(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	var fired int32
+	timer := tw.AfterFunc(time.Hour, func() { atomic.AddInt32(&fired, 1) })
+	require.True(t, timer.Reset(time.Millisecond*5))
+	require.Equal(t, StateScheduled, timer.State())
+	require.Equal(t, int64(1), tw.Pending())
+
+	<-timer.Done()
+	require.Equal(t, int32(1), atomic.LoadInt32(&fired))
+	require.Equal(t, EndCompleted, timer.EndReason())
+	require.Equal(t, int64(0), tw.Pending())
+
+	// The finished timer is never reset.
+	require.False(t, timer.Reset(time.Millisecond))
+	require.Equal(t, StateCompleted, timer.State())
+
+	// The recurring timer is not a subject of Reset.
+	recurring := tw.Schedule(&Task3{})
+	defer recurring.Close()
+	require.False(t, recurring.Reset(time.Millisecond))
+}
+
+func TestTimer_Reset_Recurring(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	// Without the quota of a tag, Reset never turns it into a run-once timer.
+	c := &countdown{interval: time.Millisecond * 5, n: 3}
+	timer := tw.Schedule(c)
+	require.False(t, timer.Reset(time.Hour))
+	require.Equal(t, StateScheduled, timer.State())
+
+	<-timer.Done()
+	require.Equal(t, int32(3), atomic.LoadInt32(&c.runs))
+}
+
+func TestTimer_RescheduleIfPending_Recurring(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	c := &countdown{interval: time.Millisecond * 5, n: 3}
+	timer := tw.Schedule(c)
+	exp := timer.Expiration()
+	require.False(t, timer.RescheduleIfPending(time.Hour))
+	require.Equal(t, exp.UnixNano(), timer.Expiration().UnixNano())
+
+	<-timer.Done()
+	require.Equal(t, int32(3), atomic.LoadInt32(&c.runs))
+}
+
+func TestTimer_Reset_FromTask(t *testing.T) {
+	for _, policy := range []DispatchPolicy{DispatchGoroutine, DispatchInline} {
+		tw := New(time.Millisecond*10, 8, WithDispatchPolicy(policy))
+		tw.Start()
+
+		var fired []time.Time
+		var mu sync.Mutex
+		var timer *Timer
+		// The mu is held until the timer is assigned.
+		mu.Lock()
+		timer = tw.AfterFunc(time.Millisecond*20, func() {
+			mu.Lock()
+			fired = append(fired, time.Now())
+			n := len(fired)
+			timer := timer
+			mu.Unlock()
+			if n < 3 {
+				// Shorter than the tick, it's re-inserted once the task returned.
+				require.True(t, timer.Reset(time.Millisecond))
+				require.Equal(t, StateRunning, timer.State())
+			}
+		})
+		mu.Unlock()
+
+		<-timer.Done()
+		mu.Lock()
+		require.Len(t, fired, 3)
+		mu.Unlock()
+		require.Equal(t, StateCompleted, timer.State())
+		require.Equal(t, uint64(3), tw.Stats().Fired)
+		require.Equal(t, int64(0), tw.Pending())
+		tw.Stop()
+	}
+}
+
+func TestTimer_RescheduleIfPending(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	timer := tw.AfterFunc(time.Hour, func() {})
+	exp := timer.Expiration()
+	require.True(t, timer.RescheduleIfPending(time.Millisecond*5))
+	require.True(t, timer.Expiration().Before(exp))
+	require.Equal(t, StateScheduled, timer.State())
+	require.Equal(t, int64(1), tw.Pending())
+
+	<-timer.Done()
+	require.Equal(t, EndCompleted, timer.EndReason())
+	require.False(t, timer.RescheduleIfPending(time.Millisecond))
+	require.Equal(t, int64(0), tw.Pending())
+
+	closed := tw.AfterFunc(time.Hour, func() {})
+	closed.Close()
+	require.False(t, closed.RescheduleIfPending(time.Millisecond))
+	require.Equal(t, StateCancelled, closed.State())
+
+	// It never re-arms the running timer, unlike Reset.
+	var mu sync.Mutex
+	var running *Timer
+	mu.Lock()
+	running = tw.AfterFunc(time.Millisecond, func() {
+		mu.Lock()
+		running := running
+		mu.Unlock()
+		require.False(t, running.RescheduleIfPending(time.Millisecond))
+	})
+	mu.Unlock()
+	<-running.Done()
+	require.Equal(t, uint64(2), tw.Stats().Fired)
+}
+
+func TestTimer_CancelIfExpirationIs(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	var fired int32
+	timer := tw.AfterFunc(time.Hour, func() { atomic.AddInt32(&fired, 1) })
+	exp := timer.Expiration()
+	require.True(t, timer.RescheduleIfPending(time.Hour*2))
+
+	// The timer has been rescheduled since the expiration is read.
+	require.False(t, timer.CancelIfExpirationIs(exp))
+	require.Equal(t, StateScheduled, timer.State())
+	require.Equal(t, int64(1), tw.Pending())
+
+	require.True(t, timer.CancelIfExpirationIs(timer.Expiration()))
+	require.Equal(t, EndCancelled, timer.EndReason())
+	require.Equal(t, int64(0), tw.Pending())
+	<-timer.Done()
+	require.False(t, timer.CancelIfExpirationIs(timer.Expiration()))
+	require.Equal(t, int32(0), atomic.LoadInt32(&fired))
+}
+
+func TestTimer_CancelIfExpirationIs_Race(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	for i := 0; i < 200; i++ {
+		var fired int32
+		timer := tw.AfterFunc(time.Millisecond*2, func() { atomic.AddInt32(&fired, 1) })
+
+		// Two goroutines push the deadline out while a third cancels the
+		// expiration it has seen optimistically.
+		var wg sync.WaitGroup
+		var rescheduled, cancelled int32
+		for j := 0; j < 2; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if timer.RescheduleIfPending(time.Millisecond * 2) {
+					atomic.AddInt32(&rescheduled, 1)
+				}
+			}()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := 0; k < 3; k++ {
+				if timer.CancelIfExpirationIs(timer.Expiration()) {
+					atomic.AddInt32(&cancelled, 1)
+					return
+				}
+			}
+		}()
+		wg.Wait()
+
+		<-timer.Done()
+		// The timer is either cancelled or fired exactly once.
+		require.Equal(t, int32(1), atomic.LoadInt32(&cancelled)+atomic.LoadInt32(&fired))
+		if atomic.LoadInt32(&cancelled) == 1 {
+			require.Equal(t, EndCancelled, timer.EndReason())
+		} else {
+			require.Equal(t, EndCompleted, timer.EndReason())
+		}
+	}
+	require.Equal(t, int64(0), tw.Pending())
+}
+
+func TestTimer_Reset_LastWins(t *testing.T) {
+	tw := New(time.Millisecond, 64)
+	tw.Start()
+	defer tw.Stop()
+
+	var fired int32
+	var mu sync.Mutex
+	var timer *Timer
+	var start time.Time
+	mu.Lock()
+	timer = tw.AfterFunc(time.Millisecond*5, func() {
+		mu.Lock()
+		timer := timer
+		mu.Unlock()
+		if atomic.AddInt32(&fired, 1) == 1 {
+			start = time.Now()
+			require.True(t, timer.Reset(time.Hour))
+			require.True(t, timer.Reset(time.Millisecond*20))
+		}
+	})
+	mu.Unlock()
+
+	<-timer.Done()
+	require.Equal(t, int32(2), atomic.LoadInt32(&fired))
+	// It may fire a tick earlier since the expiration is truncated to the tick.
+	require.GreaterOrEqual(t, int64(time.Since(start)), int64(time.Millisecond*18))
+	require.Less(t, int64(time.Since(start)), int64(time.Minute))
+	require.Equal(t, EndCompleted, timer.EndReason())
+}
+
+func TestTimer_Reset_FireRace(t *testing.T) {
+	for _, policy := range []DispatchPolicy{DispatchGoroutine, DispatchInline} {
+		tw := New(time.Millisecond, 8, WithDispatchPolicy(policy))
+		tw.Start()
+
+		for i := 0; i < 500; i++ {
+			// The seq orders the starts of the runs and the calls of Reset.
+			var seq, lastRun, lastReset int64
+			var runs, armed int32 = 0, 1
+			timer := tw.AfterFunc(time.Duration(i%3)*time.Millisecond, func() {
+				atomic.StoreInt64(&lastRun, atomic.AddInt64(&seq, 1))
+				atomic.AddInt32(&runs, 1)
+				runtime.Gosched()
+			})
+
+			// The Resets race the flush of the bucket and the dispatch and the
+			// completion of the task, each successful one arms another period
+			// at most, and the last one is always followed by a run.
+			var wg sync.WaitGroup
+			for j := 0; j < 2; j++ {
+				wg.Add(1)
+				go func(j int) {
+					defer wg.Done()
+					for k := 0; k < 3; k++ {
+						time.Sleep(time.Duration(k) * time.Millisecond / 2)
+						called := atomic.AddInt64(&seq, 1)
+						if timer.Reset(time.Duration((i+j+k)%3) * time.Millisecond) {
+							atomic.AddInt32(&armed, 1)
+							for last := atomic.LoadInt64(&lastReset); last < called; last = atomic.LoadInt64(&lastReset) {
+								if atomic.CompareAndSwapInt64(&lastReset, last, called) {
+									break
+								}
+							}
+						}
+					}
+				}(j)
+			}
+			wg.Wait()
+
+			<-timer.Done()
+			n := atomic.LoadInt32(&runs)
+			require.True(t, n >= 1 && n <= atomic.LoadInt32(&armed), "policy %d: %d runs of %d periods", policy, n, armed)
+			require.Greater(t, atomic.LoadInt64(&lastRun), atomic.LoadInt64(&lastReset), "policy %d: the last Reset is lost", policy)
+			require.Equal(t, EndCompleted, timer.EndReason())
+		}
+		require.Equal(t, int64(0), tw.Pending())
+		tw.Stop()
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+// Package timerspec implements a stable and versioned binary encoding of the
+// timer specs, which is used to move the pending timers between processes.
+//
+// A Spec is encoded in the protobuf wire format as described in timerspec.proto,
+// thus it can be decoded by any protobuf implementation. A stream of specs is
+// prefixed by a header that carries the version of the stream format, see Writer.
+package timerspec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Spec describes a timer to be restored by its task name rather than a func.
+type Spec struct {
+	// ID is the ID of the timer in the TimeWheel that saved it.
+	ID uint64
+	// Expiration is the expiration in nanoseconds since the Unix epoch.
+	Expiration int64
+	// Tag is the tag that set by WithTag.
+	Tag string
+	// Task is the name of the task in the TaskRegistry.
+	Task string
+	// Payload is the payload passed to the task.
+	Payload []byte
+	// Recurrence is the descriptor of the recurrence, empty for a run-once timer.
+	Recurrence string
+	// InFlight is whether the timer has been dispatched but not acknowledged when saved.
+	InFlight bool
+	// Redeliveries is the number of times that the timer is fired again since
+	// the previous fires are not acknowledged.
+	Redeliveries uint32
+
+	// Unknown holds the encoded fields that not known by this version, they
+	// are written back as is by MarshalBinary, thus no field is lost when the
+	// spec is passed through an older process.
+	Unknown []byte
+}
+
+// The field numbers in timerspec.proto.
+const (
+	fieldID           = 1
+	fieldExpiration   = 2
+	fieldTag          = 3
+	fieldTask         = 4
+	fieldPayload      = 5
+	fieldRecurrence   = 6
+	fieldInFlight     = 7
+	fieldRedeliveries = 8
+)
+
+// The wire types of protobuf.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// ErrMalformed is returned when the data is not a valid encoded Spec.
+var ErrMalformed = errors.New("timerspec: malformed spec")
+
+// MarshalBinary implements the encoding.BinaryMarshaler, the fields with a
+// zero value are omitted as proto3 does.
+func (s *Spec) MarshalBinary() ([]byte, error) {
+	return s.AppendBinary(nil), nil
+}
+
+// AppendBinary appends the encoded s to b and returns the extended buffer.
+func (s *Spec) AppendBinary(b []byte) []byte {
+	if s.ID != 0 {
+		b = appendTag(b, fieldID, wireVarint)
+		b = binary.AppendUvarint(b, s.ID)
+	}
+	if s.Expiration != 0 {
+		b = appendTag(b, fieldExpiration, wireFixed64)
+		b = binary.LittleEndian.AppendUint64(b, uint64(s.Expiration))
+	}
+	b = appendString(b, fieldTag, s.Tag)
+	b = appendString(b, fieldTask, s.Task)
+	if len(s.Payload) != 0 {
+		b = appendTag(b, fieldPayload, wireBytes)
+		b = binary.AppendUvarint(b, uint64(len(s.Payload)))
+		b = append(b, s.Payload...)
+	}
+	b = appendString(b, fieldRecurrence, s.Recurrence)
+	if s.InFlight {
+		b = appendTag(b, fieldInFlight, wireVarint)
+		b = append(b, 1)
+	}
+	if s.Redeliveries != 0 {
+		b = appendTag(b, fieldRedeliveries, wireVarint)
+		b = binary.AppendUvarint(b, uint64(s.Redeliveries))
+	}
+	return append(b, s.Unknown...)
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler. The s is reset
+// first, and the Payload and Unknown refer to the data rather than copy it.
+func (s *Spec) UnmarshalBinary(data []byte) error {
+	*s = Spec{}
+	for len(data) != 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 || key>>3 == 0 || key>>3 > math.MaxInt32 {
+			return ErrMalformed
+		}
+		field, wire := key>>3, key&7
+
+		size := fieldSize(data[n:], wire)
+		if size < 0 {
+			return fmt.Errorf("%w: field %d with wire type %d", ErrMalformed, field, wire)
+		}
+		value := data[n : n+size]
+		raw := data[:n+size]
+		data = data[n+size:]
+
+		var ok bool
+		switch {
+		case field == fieldID && wire == wireVarint:
+			s.ID, _ = binary.Uvarint(value)
+			ok = true
+		case field == fieldInFlight && wire == wireVarint:
+			v, _ := binary.Uvarint(value)
+			s.InFlight = v != 0
+			ok = true
+		case field == fieldRedeliveries && wire == wireVarint:
+			v, _ := binary.Uvarint(value)
+			s.Redeliveries = uint32(v)
+			ok = true
+		case field == fieldExpiration && wire == wireFixed64:
+			s.Expiration = int64(binary.LittleEndian.Uint64(value))
+			ok = true
+		case wire == wireBytes:
+			ok = s.setBytes(field, value)
+		}
+		if !ok {
+			s.Unknown = append(s.Unknown, raw...)
+		}
+	}
+	return nil
+}
+
+// setBytes sets the length-delimited field, the value excludes the length.
+// It returns false if the field is unknown.
+func (s *Spec) setBytes(field uint64, value []byte) bool {
+	_, n := binary.Uvarint(value)
+	value = value[n:]
+	switch field {
+	case fieldTag:
+		s.Tag = string(value)
+	case fieldTask:
+		s.Task = string(value)
+	case fieldPayload:
+		s.Payload = value
+	case fieldRecurrence:
+		s.Recurrence = string(value)
+	default:
+		return false
+	}
+	return true
+}
+
+// fieldSize returns the size of the field value at the head of data, including
+// the length prefix of a length-delimited field, or -1 if it's malformed.
+func fieldSize(data []byte, wire uint64) int {
+	switch wire {
+	case wireVarint:
+		_, n := binary.Uvarint(data)
+		if n <= 0 {
+			return -1
+		}
+		return n
+	case wireFixed64:
+		if len(data) < 8 {
+			return -1
+		}
+		return 8
+	case wireFixed32:
+		if len(data) < 4 {
+			return -1
+		}
+		return 4
+	case wireBytes:
+		l, n := binary.Uvarint(data)
+		if n <= 0 || l > uint64(len(data)-n) {
+			return -1
+		}
+		return n + int(l)
+	}
+	// The groups are deprecated and never used by timerspec.proto.
+	return -1
+}
+
+func appendTag(b []byte, field, wire uint64) []byte {
+	return binary.AppendUvarint(b, field<<3|wire)
+}
+
+func appendString(b []byte, field uint64, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = appendTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
@@ -0,0 +1,74 @@
+package timerspec
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpec_Golden(t *testing.T) {
+	s := &Spec{ID: 1, Expiration: 2, Tag: "a", Task: "b", Payload: []byte{0xff}, Recurrence: "c", InFlight: true, Redeliveries: 3}
+	data, err := s.MarshalBinary()
+	require.NoError(t, err)
+	// Compatible with the code generated from timerspec.proto.
+	require.Equal(t, []byte{
+		0x08, 0x01,
+		0x11, 0x02, 0, 0, 0, 0, 0, 0, 0,
+		0x1a, 0x01, 'a',
+		0x22, 0x01, 'b',
+		0x2a, 0x01, 0xff,
+		0x32, 0x01, 'c',
+		0x38, 0x01,
+		0x40, 0x03,
+	}, data)
+
+	empty, err := (&Spec{}).MarshalBinary()
+	require.NoError(t, err)
+	require.Len(t, empty, 0)
+}
+
+func TestSpec_RoundTrip(t *testing.T) {
+	s := &Spec{ID: 1 << 60, Expiration: -5, Tag: "refresh", Task: "billing.charge", Payload: []byte("hello"), InFlight: true, Redeliveries: 300}
+	data, err := s.MarshalBinary()
+	require.NoError(t, err)
+
+	got := new(Spec)
+	require.NoError(t, got.UnmarshalBinary(data))
+	require.Equal(t, s, got)
+}
+
+func TestSpec_Unknown(t *testing.T) {
+	// The fields 15 (varint), 16 (fixed32) and 17 (bytes) are unknown to this version,
+	// and the field 1 with a mismatched wire type is treated as unknown too.
+	unknown := []byte{
+		0x78, 0x96, 0x01,
+		0x85, 0x01, 1, 2, 3, 4,
+		0x8a, 0x01, 0x02, 'x', 'y',
+		0x0a, 0x00,
+	}
+	data := append((&Spec{Task: "t"}).AppendBinary(nil), unknown...)
+
+	s := new(Spec)
+	require.NoError(t, s.UnmarshalBinary(data))
+	require.Equal(t, "t", s.Task)
+	require.Equal(t, unknown, s.Unknown)
+
+	again, err := s.MarshalBinary()
+	require.NoError(t, err)
+	require.Equal(t, data, again)
+}
+
+func TestSpec_Malformed(t *testing.T) {
+	for _, data := range [][]byte{
+		{0x08},            // truncated varint.
+		{0x11, 0x01},      // truncated fixed64.
+		{0x1a, 0x05, 'a'}, // truncated bytes.
+		{0x00, 0x01},      // field 0.
+		{0x0b},            // group.
+		{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01}, // overflowed key.
+	} {
+		err := new(Spec).UnmarshalBinary(data)
+		require.True(t, errors.Is(err, ErrMalformed), "%x: %v", data, err)
+	}
+}
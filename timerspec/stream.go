@@ -0,0 +1,139 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timerspec
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Version is the version of the stream format written by Writer.
+//
+// It's increased only if the framing of the stream changes, the evolution of
+// the Spec is covered by the protobuf compatibility rules instead.
+const Version = 1
+
+// magic identifies a stream of specs.
+const magic = "TWSPEC"
+
+// MaxSpecSize is the maximum size of an encoded spec in a stream, it protects
+// the Reader from allocating a huge buffer for a corrupted length.
+const MaxSpecSize = 16 << 20
+
+// ErrVersion is returned by Reader if the stream is written in a newer version.
+var ErrVersion = errors.New("timerspec: unsupported stream version")
+
+// Writer writes a stream of specs. The stream starts with the magic "TWSPEC"
+// and the Version as an uvarint, follows by each spec encoded as an uvarint
+// length and the protobuf bytes.
+type Writer struct {
+	w      *bufio.Writer
+	buf    []byte
+	header bool
+}
+
+// NewWriter creates a Writer that writes to w, the Flush must be called after
+// the last spec is written.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// Write writes the spec s to the stream.
+func (w *Writer) Write(s *Spec) error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	w.buf = s.AppendBinary(w.buf[:0])
+	if len(w.buf) > MaxSpecSize {
+		return fmt.Errorf("timerspec: spec of %d bytes exceeds the MaxSpecSize", len(w.buf))
+	}
+	var l [binary.MaxVarintLen64]byte
+	if _, err := w.w.Write(l[:binary.PutUvarint(l[:], uint64(len(w.buf)))]); err != nil {
+		return err
+	}
+	_, err := w.w.Write(w.buf)
+	return err
+}
+
+// Flush writes the buffered data to the underlying writer, an empty stream
+// still has a header after flushed.
+func (w *Writer) Flush() error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	return w.w.Flush()
+}
+
+func (w *Writer) writeHeader() error {
+	if w.header {
+		return nil
+	}
+	w.header = true
+	if _, err := w.w.WriteString(magic); err != nil {
+		return err
+	}
+	_, err := w.w.Write(binary.AppendUvarint(nil, Version))
+	return err
+}
+
+// Reader reads a stream of specs written by Writer.
+type Reader struct {
+	r      *bufio.Reader
+	header bool
+}
+
+// NewReader creates a Reader that reads from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Read reads the next spec, it returns io.EOF at the end of the stream.
+func (r *Reader) Read() (*Spec, error) {
+	if err := r.readHeader(); err != nil {
+		return nil, err
+	}
+	l, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	if l > MaxSpecSize {
+		return nil, fmt.Errorf("%w: length %d exceeds the MaxSpecSize", ErrMalformed, l)
+	}
+	data := make([]byte, l)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return nil, fmt.Errorf("%w: truncated spec: %v", ErrMalformed, err)
+	}
+	s := new(Spec)
+	if err := s.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (r *Reader) readHeader() error {
+	if r.header {
+		return nil
+	}
+	var m [len(magic)]byte
+	if _, err := io.ReadFull(r.r, m[:]); err != nil || string(m[:]) != magic {
+		return fmt.Errorf("%w: missing the stream header", ErrMalformed)
+	}
+	version, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return fmt.Errorf("%w: missing the stream version", ErrMalformed)
+	}
+	if version > Version {
+		return fmt.Errorf("%w: %d", ErrVersion, version)
+	}
+	r.header = true
+	return nil
+}
@@ -0,0 +1,59 @@
+package timerspec
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStream(t *testing.T) {
+	specs := []*Spec{
+		{ID: 1, Expiration: 100, Task: "a"},
+		{ID: 2, Expiration: 200, Task: "b", Payload: []byte("p"), Unknown: []byte{0x78, 0x01}},
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for _, s := range specs {
+		require.NoError(t, w.Write(s))
+	}
+	require.NoError(t, w.Flush())
+	require.True(t, bytes.HasPrefix(buf.Bytes(), []byte("TWSPEC\x01")))
+
+	r := NewReader(&buf)
+	for _, s := range specs {
+		got, err := r.Read()
+		require.NoError(t, err)
+		require.Equal(t, s, got)
+	}
+	_, err := r.Read()
+	require.Equal(t, io.EOF, err)
+}
+
+func TestStream_Empty(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, NewWriter(&buf).Flush())
+
+	_, err := NewReader(&buf).Read()
+	require.Equal(t, io.EOF, err)
+
+	_, err = NewReader(bytes.NewReader(nil)).Read()
+	require.True(t, errors.Is(err, ErrMalformed))
+}
+
+func TestStream_Invalid(t *testing.T) {
+	_, err := NewReader(bytes.NewReader([]byte("TWSPEC\x02"))).Read()
+	require.True(t, errors.Is(err, ErrVersion))
+
+	_, err = NewReader(bytes.NewReader([]byte("JSON{}\x01"))).Read()
+	require.True(t, errors.Is(err, ErrMalformed))
+
+	_, err = NewReader(bytes.NewReader([]byte("TWSPEC\x01\x05\x08"))).Read()
+	require.True(t, errors.Is(err, ErrMalformed))
+
+	_, err = NewReader(bytes.NewReader([]byte("TWSPEC\x01\xff\xff\xff\xff\x0f"))).Read()
+	require.True(t, errors.Is(err, ErrMalformed))
+}
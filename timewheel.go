@@ -6,6 +6,11 @@
 package timewheel
 
 import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
 	"sync/atomic"
 	"time"
 	"unsafe"
@@ -21,34 +26,280 @@ const (
 // TimeWheel is an implementation of Hierarchical Timing Wheels.
 type TimeWheel struct {
 	tick     int64 // in nanoseconds.
-	size     int64 // TimeWheel Size.
+	size     int64 // TimeWheel Size, it is always a power of two.
+	mask     int64 // size - 1, used to locate the bucket instead of modulo.
 	interval int64 // in nanoseconds.
 	current  int64 // in nanoseconds.
 
-	buckets []*bucket
-	queue   *dqueue.DQueue
+	// The number of timers that are scheduled but not yet expired or closed.
+	// It is only maintained in the root TimeWheel.
+	pending int64
+	// The counters of events, only maintained in the root TimeWheel.
+	scheduled uint64
+	fired     uint64
+	cancelled uint64
+	skipped   uint64
+	queued    uint64
+	// The number of executions skipped since the FireGuard denied.
+	guardDenied uint64
+	// The number of timers rejected when scheduled.
+	rejected uint64
+	// The number of timers shed under load, they're also counted in rejected.
+	shed uint64
+	// The number of timers rejected by the rate limits, they're also counted
+	// in rejected.
+	rateLimited uint64
+	// The number of tasks dropped since the Executor refused them, or the
+	// channel of the DeliveryDrop is full.
+	dropped uint64
+	// The number of errors of the Store, see WithStore.
+	storeErrors uint64
+	// The number of buckets allocated in all the levels, see ensureBucket.
+	allocatedBuckets int64
+	// The delay between the expiration of the latest processed bucket and the
+	// start of its processing, in nanoseconds.
+	consumerLag int64
+	// The last ID assigned to a timer, only maintained in the root TimeWheel.
+	lastID uint64
+	// The clock that the current is refreshed from, it returns the Unix time
+	// in nanoseconds. Only set in the root TimeWheel.
+	now func() int64
+	// Whether the TimeWheel has been started, only maintained in the root TimeWheel.
+	started int32
+	// Whether the Store of WithStore has been replayed, only maintained in
+	// the root TimeWheel.
+	replayed int32
+
+	// The buckets of the slots, each of them is allocated on the first insert
+	// into the slot, see ensureBucket.
+	buckets []unsafe.Pointer // type: *bucket
+	queue   *bucketQueue
+
+	// The lowest-level TimeWheel that created by New, it points to itself
+	// if the current TimeWheel is the root.
+	root *TimeWheel
+	// The level of the TimeWheel, 0 for the root.
+	level int
+	// The maximum delay of a new timer, it's the span of the levels allowed
+	// by WithMaxLevels. Only set in the root TimeWheel.
+	maxSpan int64
+	// The optional configuration, only set in the root TimeWheel.
+	opts options
+
+	// The channel to deliver the expired timers created by After and At.
+	// It's nil unless the WithExpiredChannel is set, only set in the root TimeWheel.
+	expiredC chan *Timer
+	// The context derived from the base set by WithBaseContext, it's cancelled
+	// when the TimeWheel is stopped. It's nil unless the WithBaseContext is
+	// set, only set in the root TimeWheel.
+	baseCtx    context.Context
+	baseCancel context.CancelCauseFunc
+	// The stopC is closed when the TimeWheel is stopped, only set in the root TimeWheel.
+	stopC chan struct{}
+	// The doneC is closed when the TimeWheel is fully stopped, i.e. after the
+	// consumer goroutine exited. Only set in the root TimeWheel.
+	doneC chan struct{}
+	// Indicates whether the TimeWheel has been stopped. 1 => true, 0 => false.
+	stopped int32
+
+	// The expired timers collected by the consumer goroutine during flush,
+	// reused for each bucket. Only accessed by the consumer goroutine.
+	ready []*Timer
+	// The number of the overdue buckets whose expired timers are held in the
+	// ready, see WithDeadlineOrder. Only accessed by the consumer goroutine.
+	held int
+	// The expiration of the next overdue bucket once the held reaches the
+	// limit, the ready timers at or after it are kept held, see orderReady.
+	cutoff int64
+	// The fairer reorders the ready timers, it's nil unless the WithFairDispatch
+	// is set. Only accessed by the consumer goroutine of the root TimeWheel.
+	fair *fairer
+	// The deferMu protects the dispatching and deferred. The dispatching is
+	// true while the consumer goroutine is firing the expired timers, and the
+	// timers expired in the meantime (e.g. scheduled by a task that executed
+	// inline) are deferred until the consumer finished the current ones.
+	// Only set in the root TimeWheel.
+	deferMu     *sync.Mutex
+	dispatching bool
+	deferred    []*Timer
+	// Whether the TimeWheel was stopped while dispatching inline, the shutdown
+	// is deferred until the dispatching ends. It's protected by the deferMu.
+	stopDeferred bool
+	// The panic of a NoRecover task recovered while dispatching, it's
+	// re-panicked once the dispatching ended. It's protected by the deferMu.
+	propagated interface{}
+	// The chunk of tasks collected while dispatching if the DispatchBatch is
+	// set, it's protected by the deferMu.
+	batch []batchTask
+	// The timers handed over while dispatching if the OnExpireBatch is set,
+	// it's protected by the deferMu.
+	expireBatch []*Timer
+
+	// The named timers that dispatched but not acknowledged, see Timer.Ack.
+	// Only set in the root TimeWheel.
+	inflight *inflight
+	// The quotas of the tags, only set in the root TimeWheel.
+	quotas *quotaTable
+	// The rate limits of the tags, and the one of all the others which is nil
+	// unless the WithScheduleRateLimit is set. Only set in the root TimeWheel.
+	rates     *rateTable
+	rateLimit *rateLimiter
+	// The sampler of the observers, see WithObserverSampling. Only set in the
+	// root TimeWheel.
+	sampler *sampler
+	// The preallocated timers, it's nil unless the WithCapacity is set.
+	// Only set in the root TimeWheel.
+	arena *arena
+
+	// The driver of the MetricsSink, it's nil unless the WithMetricsSink is
+	// set. Only set in the root TimeWheel.
+	metrics *metrics
+	// The detector of the watermarks, it's nil unless the WithHighWatermark
+	// is set. Only set in the root TimeWheel.
+	watermark *watermark
+	// The notifier of OnIdle and OnActive, it's nil unless any of them is
+	// set. Only set in the root TimeWheel.
+	idle *idleNotifier
+	// The state of the idle shutdown, it's nil unless the WithIdleShutdown
+	// is set. Only set in the root TimeWheel.
+	idleDown *idleShutdown
+	// The task duration histograms, it's nil unless the WithTaskDurations is
+	// set. Only set in the root TimeWheel.
+	durations *taskDurations
+	// The watchers subscribed by Watch. Only set in the root TimeWheel.
+	watch *watchHub
+	// The buffer of the dead letters, it's nil unless the WithDeadLetterBuffer
+	// is set. Only set in the root TimeWheel.
+	deadLetters *deadLetters
+	// The histogram of the consumer lags, see ConsumerJitter.
+	jitter *durationHistogram
+	// The maximums of the consumer lags in the last minute, see
+	// Stats.ConsumerLagMax.
+	lagWindow *lagWindow
+	// The plane of the critical timers, it's nil unless the WithCriticalPlane
+	// is set. Only set in the root TimeWheel.
+	critical *TimeWheel
+	// The accounting of the live timers, it's nil unless the WithLeakTracking
+	// is set. Only set in the root TimeWheel.
+	leaks *leakTracker
+	// The state of the SaveTo in progress. Only set in the root TimeWheel.
+	save *saveState
 
 	// The higher-level overflow TimeWheel.
 	//
 	// NOTICE: This field may be updated and read concurrently, through tw.add().
 	overflow unsafe.Pointer // type: *TimingWheel
+	// The overflowMu serializes the creation of the overflow, thus the buckets
+	// of a level are allocated only once, however many goroutines race for it.
+	overflowMu sync.Mutex
 }
 
 // Default creates an TimeWheel with default parameters.
-func Default() *TimeWheel {
-	return New(defaultTick, defaultSize)
+func Default(opts ...Option) *TimeWheel {
+	return New(defaultTick, defaultSize, opts...)
 }
 
 // New creates an TimeWheel with the given tick and wheel size.
 // The value of tick must >= 1ms, the size must >= 1.
-func New(tick time.Duration, size int64) *TimeWheel {
+//
+// The size will be rounded up to the next power of two internally, you can
+// get the effective size by the Size method.
+//
+// It panics if the tick, size or alignment is invalid, use TryNew if they're
+// not constants.
+func New(tick time.Duration, size int64, opts ...Option) *TimeWheel {
+	tw, err := TryNew(tick, size, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return tw
+}
+
+// TryNew is like New, but it returns ErrInvalidTick, ErrInvalidSize or
+// ErrInvalidAlign instead of panicking if the tick, size or alignment is invalid.
+func TryNew(tick time.Duration, size int64, opts ...Option) (*TimeWheel, error) {
 	if tick < time.Millisecond {
-		panic("timewheel: tick must be greater than or equal to 1ms")
+		return nil, ErrInvalidTick
 	}
 	if size < 1 {
-		panic("timewheel: size must be greater than 0")
+		return nil, ErrInvalidSize
+	}
+	o := options{dumpLimit: defaultDumpLimit, batchSize: defaultBatchSize, maxLevels: defaultMaxLevels, sampleBelow: math.MaxUint64}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.align%tick != 0 {
+		return nil, ErrInvalidAlign
+	}
+
+	now := func() int64 { return time.Now().UnixNano() }
+	dq := o.queue
+	if c := o.clock; c != nil {
+		// Driven by Poll, see WithClock.
+		now = func() int64 { return c.Now().UnixNano() }
+		dq = nil
+	} else if dq == nil {
+		dq = dqueue.Default()
+	}
+	if o.logger != nil {
+		o.logger = o.logger.With(slog.String("wheel", o.name))
+	}
+
+	queue := newBucketQueue(dq, o.onForeignMessage)
+	queue.logger = o.logger
+
+	tw := newTimeWheel(int64(tick), roundPowerOfTwo(size), now(), queue, nil)
+	tw.opts = o
+	tw.now = now
+	tw.maxSpan = spanOf(tw.tick, tw.size, o.maxLevels)
+	if o.hashed {
+		// The hashed wheel has a single level that takes any delay.
+		tw.opts.maxLevels = 1
+		tw.maxSpan = math.MaxInt64
+	}
+	tw.stopC = make(chan struct{})
+	tw.doneC = make(chan struct{})
+	if o.expired {
+		tw.expiredC = make(chan *Timer, o.expiredCap)
+	}
+	if o.metricsSink != nil {
+		tw.metrics = newMetrics(o.metricsSink, o.metricsInterval)
+	}
+	if o.fair {
+		tw.fair = newFairer(o.fairWeights)
+	}
+	if o.onHighWatermark != nil {
+		tw.watermark = newWatermark(&o)
+	}
+	if o.onIdle != nil || o.onActive != nil {
+		tw.idle = newIdleNotifier(&tw.pending, &o)
+	}
+	if o.rateLimit > 0 {
+		tw.rateLimit = newRateLimiter(o.rateLimit, o.rateBurst)
 	}
-	return newTimeWheel(int64(tick), size, time.Now().UnixNano(), dqueue.Default())
+	tw.sampler = &sampler{below: o.sampleBelow}
+	if o.idleShutdown > 0 {
+		tw.idleDown = newIdleShutdown(o.idleShutdown)
+	}
+	if o.durationBounds != nil {
+		tw.durations = newTaskDurations(&o)
+	}
+	if o.baseCtx != nil {
+		tw.baseCtx, tw.baseCancel = context.WithCancelCause(o.baseCtx)
+	}
+	if o.capacity > 0 {
+		tw.arena = newArena(tw, o.capacity)
+	}
+	if o.deadLetterCap > 0 {
+		tw.deadLetters = &deadLetters{cap: o.deadLetterCap}
+	}
+	if o.leakTracking {
+		tw.leaks = newLeakTracker()
+	}
+	if o.criticalPlane {
+		tw.critical = newCriticalPlane(tw)
+	}
+	return tw, nil
 }
 
 // truncate returns the result of rounding x toward zero to a multiple of m.
@@ -60,103 +311,737 @@ func truncate(x, m int64) int64 {
 	return x - x%m
 }
 
+// roundPowerOfTwo returns the smallest power of two that greater than or equal to x.
+func roundPowerOfTwo(x int64) int64 {
+	n := int64(1)
+	for n < x {
+		n <<= 1
+	}
+	return n
+}
+
 // newTimeWheel is an internal helper function that really creates an TimeWheel.
-func newTimeWheel(tick int64, size int64, start int64, queue *dqueue.DQueue) *TimeWheel {
-	return &TimeWheel{
+// The root is nil when creating the root TimeWheel.
+func newTimeWheel(tick int64, size int64, start int64, queue *bucketQueue, root *TimeWheel) *TimeWheel {
+	tw := &TimeWheel{
 		tick:     tick,
 		size:     size,
+		mask:     size - 1,
 		interval: tick * size,
 		current:  truncate(start, tick),
-		buckets:  createBuckets(int(size)),
+		buckets:  make([]unsafe.Pointer, size),
 		queue:    queue,
+		root:     root,
 		overflow: nil,
 	}
+	if root == nil {
+		tw.root = tw
+		tw.deferMu = new(sync.Mutex)
+		tw.inflight = newInflight()
+		tw.quotas = newQuotaTable()
+		tw.rates = newRateTable()
+		tw.watch = new(watchHub)
+		tw.jitter = newDurationHistogram(len(jitterBounds) + 1)
+		tw.lagWindow = newLagWindow()
+		tw.save = new(saveState)
+	}
+	return tw
 }
 
 // Start starts the current time wheel in a goroutine.
-// You can call the Wait method to blocks the main process after.
+// You can call the Wait method to block the main process after.
 func (tw *TimeWheel) Start() {
-	tw.queue.Consume(tw.process)
+	atomic.StoreInt32(&tw.root.started, 1)
+	if m := tw.root.metrics; m != nil {
+		m.start(tw.root)
+	}
+	if w := tw.root.watermark; w != nil {
+		w.start(tw.root.stopC)
+	}
+	if tw.root.opts.dedicatedThread && tw.queue.manual == nil {
+		tw.consumeOnThread()
+	} else {
+		tw.queue.consume(tw.process)
+	}
+	if tw.root.idleDown != nil && tw.Pending() == 0 {
+		tw.armIdleShutdown()
+	}
+	if tw.root.opts.store != nil && atomic.CompareAndSwapInt32(&tw.root.replayed, 0, 1) {
+		tw.replay()
+	}
+	if c := tw.root.critical; c != nil {
+		c.Start()
+	}
 }
 
-// Stop stops the current time wheel.
+// Stop stops the current time wheel. It's safe to call Stop multiple times.
 //
 // If there is any timer's task being running in its own goroutine, Stop does
 // not wait for the task to complete before returning. If the caller needs to
 // know whether the task is completed, it must coordinate with the task explicitly.
+//
+// The timers scheduled after the TimeWheel stopped will never expire.
 func (tw *TimeWheel) Stop() {
-	tw.queue.Close()
+	_ = tw.Close()
 }
 
-// advance push the clock forward.
-func (tw *TimeWheel) advance(expiration int64) {
-	current := atomic.LoadInt64(&tw.current)
-	if expiration >= current+tw.tick {
-		current = truncate(expiration, tw.tick)
-		atomic.StoreInt64(&tw.current, current)
+// Close implements the io.Closer, it stops the TimeWheel the same as Stop.
+// It returns ErrStopped if the TimeWheel has already been stopped.
+//
+// If it's called while the consumer goroutine is executing the tasks by the
+// DispatchInline, e.g. by a task whose job is to stop the TimeWheel, the
+// TimeWheel is marked as stopped immediately, but the consumer goroutine is
+// shut down after the tasks of the current bucket returned, since it can't
+// wait for itself. Thus, Close returns without waiting in that case, use Wait
+// to wait for the shutdown from other goroutines.
+func (tw *TimeWheel) Close() error {
+	root := tw.root
+	if !root.stop() {
+		return ErrStopped
+	}
+	root.closePlane()
+	return nil
+}
+
+// stop marks the TimeWheel as stopped, it returns false if it has already
+// been stopped. The caller must shut it down then.
+func (tw *TimeWheel) stop() bool {
+	root := tw.root
+	if !atomic.CompareAndSwapInt32(&root.stopped, 0, 1) {
+		return false
+	}
+	// Unblock the delivery that may be waiting in the consumer goroutine.
+	close(root.stopC)
+	if root.baseCancel != nil {
+		root.baseCancel(ErrStopped)
+	}
+	if root.idle != nil {
+		root.idle.stop()
+	}
+	if c := root.critical; c != nil {
+		c.stop()
+	}
+	return true
+}
+
+// shutdown closes the queue and waits for the consumer goroutine to exit,
+// then releases the resources of the stopped TimeWheel.
+func (tw *TimeWheel) shutdown() {
+	root := tw.root
+	root.queue.close()
+	if m := root.metrics; m != nil {
+		m.stop()
+	}
+	root.deadLetterPending()
+	if c := root.critical; c != nil {
+		c.closePlane()
+	}
+	root.watch.close()
+
+	if l := root.opts.logger; l != nil {
+		if pending := root.Pending(); pending > 0 {
+			l.Info("timewheel: stopped with pending timers", slog.Int64("pending", pending))
+		}
+	}
+	close(root.doneC)
+}
+
+// Wait blocks until the TimeWheel is fully stopped by Stop or Close, i.e. the
+// consumer goroutine has exited. It returns immediately if the TimeWheel was
+// never started or has already been stopped. It's safe to call Wait from
+// multiple goroutines.
+//
+// Like Stop, Wait does not wait for the tasks that are running in their own goroutines.
+func (tw *TimeWheel) Wait() {
+	root := tw.root
+	if atomic.LoadInt32(&root.started) == 0 {
+		return
+	}
+	<-root.doneC
+}
+
+// String returns a concise description of the TimeWheel, such as:
+//
+//	TimeWheel{name="foo" tick=1ms size=8 pending=2 state=running}
+//
+// The state is one of "created", "running" and "stopped".
+func (tw *TimeWheel) String() string {
+	root := tw.root
+	state := "created"
+	if atomic.LoadInt32(&root.stopped) == 1 {
+		state = "stopped"
+	} else if atomic.LoadInt32(&root.started) == 1 {
+		state = "running"
+	}
+	return fmt.Sprintf("TimeWheel{name=%q tick=%s size=%d pending=%d state=%s}",
+		root.opts.name, time.Duration(root.tick), root.size, root.Pending(), state)
+}
+
+// stoppedNow returns whether the TimeWheel has been stopped.
+func (tw *TimeWheel) stoppedNow() bool {
+	return atomic.LoadInt32(&tw.root.stopped) == 1
+}
+
+// Expired returns the channel that the expired timers created by After and At
+// are delivered to. It returns nil if the TimeWheel is created without the
+// WithExpiredChannel option.
+func (tw *TimeWheel) Expired() <-chan *Timer {
+	return tw.root.expiredC
+}
+
+// Size returns the effective size of each level of the TimeWheel.
+func (tw *TimeWheel) Size() int64 {
+	return tw.size
+}
+
+// MaxDelay returns the longest delay that a new timer is accepted with, i.e.
+// tick * size^n - tick of the n levels allowed by WithMaxLevels; the overflow
+// wheels are created on demand, but the value never changes. The hashed wheel
+// takes any delay, it returns the maximum time.Duration then. A new timer
+// beyond it is rejected with ErrDelayTooLarge, or clamped by WithDelayClamp.
+func (tw *TimeWheel) MaxDelay() time.Duration {
+	root := tw.root
+	if root.maxSpan == math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(root.maxSpan - root.tick)
+}
+
+// Pending returns the number of timers that are scheduled but not yet expired or closed.
+// A recurring timer created by Schedule is counted once until its execution plan ends.
+func (tw *TimeWheel) Pending() int64 {
+	if c := tw.root.critical; c != nil {
+		return atomic.LoadInt64(&tw.root.pending) + c.Pending()
+	}
+	return atomic.LoadInt64(&tw.root.pending)
+}
+
+// NextExpiration returns the time that the earliest pending timer of all the
+// levels expires at, the ok is false if there is no pending timer. E.g. the
+// host may sleep until then if the TimeWheel is the only source of wakeups.
+//
+// It's the head of the delay queue rather than a scan of the buckets, thus
+// it's approximate: it's truncated to the tick, and it may be earlier than the
+// actual one, since the head may be a bucket of an upper level that covers
+// the timer, or a bucket whose timers are all closed. But it's never later
+// than the earliest pending timer by more than a tick. It's the current time
+// if a pending timer is not in any bucket, e.g. it's being inserted.
+func (tw *TimeWheel) NextExpiration() (time.Time, bool) {
+	root := tw.root
+	if c := root.critical; c != nil {
+		if next, ok := c.NextExpiration(); ok {
+			if mine, ok := root.nextExpiration(); !ok || next.Before(mine) {
+				return next, true
+			}
+		}
+	}
+	return root.nextExpiration()
+}
+
+// nextExpiration returns the NextExpiration of the plane tw.
+func (tw *TimeWheel) nextExpiration() (time.Time, bool) {
+	root := tw.root
+	if atomic.LoadInt64(&root.pending) <= 0 {
+		return time.Time{}, false
+	}
+	expiration, ok := root.queue.peek()
+	if !ok {
+		return time.Unix(0, root.now()), true
+	}
+	return time.Unix(0, expiration), true
+}
+
+// nextID returns a unique ID for a new timer, the first ID is 1.
+func (tw *TimeWheel) nextID() uint64 {
+	return atomic.AddUint64(&tw.root.lastID, 1)
+}
+
+// incPending called when a timer is armed.
+func (tw *TimeWheel) incPending() {
+	root := tw.root
+	atomic.AddUint64(&root.scheduled, 1)
+	pending := atomic.AddInt64(&root.pending, 1)
+	if pending == 1 && root.idle != nil {
+		root.idle.rise()
+	}
+	if w := root.watermark; w != nil {
+		w.rise(pending)
+	}
+}
+
+// decPending called when a timer is expired or closed.
+func (tw *TimeWheel) decPending() {
+	root := tw.root
+	pending := atomic.AddInt64(&root.pending, -1)
+	if pending == 0 && root.idle != nil {
+		root.idle.fall()
+	}
+	if pending == 0 && root.idleDown != nil {
+		tw.armIdleShutdown()
+	}
+	if w := root.watermark; w != nil {
+		w.fall(pending)
+	}
+}
+
+// advance push the clock forward to the expiration, it only ever moves the
+// current forward and returns whether it advanced.
+func (tw *TimeWheel) advance(expiration int64) bool {
+	next := truncate(expiration, tw.tick)
+	for {
+		current := atomic.LoadInt64(&tw.current)
+		if next < current+tw.tick {
+			// Stale or out-of-order, the current never moves backwards.
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&tw.current, current, next) {
+			break
+		}
+	}
+
+	// Try to advance the clock of the overflow wheel if present, only by the
+	// goroutine that advanced the current wheel.
+	if overflow := tw.getOverflow(); overflow != nil {
+		overflow.advance(next)
+	}
+	return true
+}
 
-		// Try to advance the clock of the overflow wheel if present
-		overflow := atomic.LoadPointer(&tw.overflow)
-		if overflow != nil {
-			(*TimeWheel)(overflow).advance(current)
+// spanOf returns the span of n levels of the given tick and size, it's
+// saturated at the maximum int64.
+func spanOf(tick, size int64, n int) int64 {
+	span := tick
+	for i := 0; i < n; i++ {
+		if span > math.MaxInt64/size {
+			return math.MaxInt64
 		}
+		span *= size
 	}
+	return span
+}
+
+// getOverflow returns the overflow TimeWheel, it's nil if not created yet.
+func (tw *TimeWheel) getOverflow() *TimeWheel {
+	return (*TimeWheel)(atomic.LoadPointer(&tw.overflow))
 }
 
 // process the expiration's bucket
-func (tw *TimeWheel) process(msg *dqueue.Message) {
-	b := msg.Value.(*bucket)
-	tw.advance(b.getExpiration())
+//
+// The expiration is the one that b was offered with, rather than the current
+// expiration of b. Since a stale add (i.e. it read the current before advanced)
+// may reuse b for the next rotation after b offered but before it expired,
+// advancing to the current expiration of b would move the wheel a rotation
+// ahead, and fire the timers of the next rotation early.
+func (tw *TimeWheel) process(b *bucket, expiration int64) {
+	root := tw.root
+	// The time that the queue handed b over, the lag of the consumer excludes
+	// the processing of b.
+	now := root.now()
+	if root.stoppedNow() {
+		// Stopped but not shut down yet, e.g. stopped by an inline task, or
+		// while the timers are held for b, see WithDeadlineOrder. The held
+		// ones are dispatched, then the shutdown deferred meanwhile is done.
+		root.deferMu.Lock()
+		held := root.dispatching || root.held != 0
+		root.dispatching = held
+		root.deferMu.Unlock()
+		if held {
+			tw.dispatchReady()
+		}
+		return
+	}
+	if s := root.idleDown; s != nil && b == s.b {
+		tw.checkIdleShutdown()
+		if root.held != 0 {
+			// Held for this bucket as it's overdue, see WithDeadlineOrder.
+			tw.dispatchReady()
+		}
+		return
+	}
+	lag := now - expiration
+	if lag < 0 {
+		lag = 0
+	}
+	tw.observeJitter(now, lag)
+	if m := root.metrics; m != nil {
+		m.observeConsumerLag(time.Duration(lag))
+	}
 
-	b.flush(tw.submit)
+	tw.advance(expiration)
+
+	root.deferMu.Lock()
+	root.dispatching = true
+	root.deferMu.Unlock()
+
+	// Re-insert the timers to the lower-level TimeWheel, and collects the
+	// expired timers to fire them after the flush. Thus, the task is never
+	// executed while holding the locks of bucket, and it's safe to schedule
+	// or close any timer even if the task is executed inline.
+	// A stale dequeue flushes nothing, see bucket.flush.
+	b.flush(expiration, func(t *Timer) {
+		if !tw.add(t) {
+			t.transit(StateScheduled, StateQueued)
+			root.ready = append(root.ready, t)
+		}
+	})
+	if tw.holdOverdue(now) {
+		// Merged with the timers of the next bucket, see WithDeadlineOrder.
+		return
+	}
+	tw.dispatchReady()
+}
+
+// dispatchReady executes the ready timers and the deferred ones, and ends the
+// dispatching.
+func (tw *TimeWheel) dispatchReady() {
+	root := tw.root
+	n := tw.orderReady()
+	ready := root.ready[:n]
+	if root.fair != nil {
+		root.fair.reorder(ready)
+	}
+
+	for i, t := range ready {
+		tw.execute(t)
+		ready[i] = nil
+	}
+	// The ones kept held are moved to the front.
+	rest := copy(root.ready, root.ready[n:])
+	for i := rest; i < len(root.ready); i++ {
+		root.ready[i] = nil
+	}
+	root.ready = root.ready[:rest]
+
+	tw.drainDeferred()
+}
+
+// drainDeferred fires the deferred timers until there is nothing left,
+// and ends the dispatching.
+func (tw *TimeWheel) drainDeferred() {
+	root := tw.root
+	for {
+		root.deferMu.Lock()
+		deferred := root.deferred
+		if len(deferred) == 0 {
+			root.dispatching = false
+			batch := root.batch
+			root.batch = nil
+			expireBatch := root.expireBatch
+			root.expireBatch = nil
+			stop := root.stopDeferred
+			root.stopDeferred = false
+			propagated := root.propagated
+			root.propagated = nil
+			root.deferMu.Unlock()
+
+			if len(batch) != 0 {
+				go tw.runBatch(batch)
+			}
+			if len(expireBatch) != 0 {
+				root.opts.onExpireBatch(expireBatch)
+			}
+			if stop {
+				// The shutdown waits for the consumer goroutine to exit,
+				// thus it must be done in another goroutine.
+				go root.shutdown()
+			}
+			if propagated != nil {
+				// All the timers of the bucket have been dispatched, see NoRecover.
+				panic(propagated)
+			}
+			return
+		}
+		root.deferred = nil
+		root.deferMu.Unlock()
+
+		for _, t := range deferred {
+			tw.execute(t)
+		}
+	}
+}
+
+// scheduleNew schedules the new timer t for its first execution after
+// admitted, or rejects it. The recurring is true if t is created by Schedule.
+// It returns false if t is rejected.
+func (tw *TimeWheel) scheduleNew(t *Timer, recurring bool) bool {
+	if c := tw.route(t); c != tw {
+		return c.scheduleNew(t, recurring)
+	}
+	if recurring {
+		// Reset and RescheduleIfPending refuse the recurring timers by it.
+		t.setAttrs().recurring = true
+	}
+	if err := tw.enter(); err != nil {
+		tw.reject(t, err)
+		return false
+	}
+	if err := tw.admit(t); err != nil {
+		tw.leave()
+		tw.reject(t, err)
+		return false
+	}
+	tw.schedule(t)
+	tw.leave()
+	return true
 }
 
-// submit inserts the timer t into the current timing wheel, or run the
-// timer's task if it has been expired.
+// reject finishes the new timer t with EndRejected, its task never runs.
+func (tw *TimeWheel) reject(t *Timer, err error) {
+	t.setAttrs().err = err
+	t.meta = newMeta(t.ID(), StateCancelled, EndRejected)
+	atomic.AddUint64(&tw.root.rejected, 1)
+	t.finish()
+	if f := tw.root.opts.onReject; f != nil {
+		f(t, err)
+	}
+}
+
+// rejected returns the reason that t is rejected when scheduled, or nil.
+func (t *Timer) rejected() error {
+	return t.getAttrs().err
+}
+
+// schedule arms the timer t, it will be counted as pending until expired or closed.
+func (tw *TimeWheel) schedule(t *Timer) {
+	t.arm()
+	tw.incPending()
+	tw.storeSave(t, false)
+	tw.observeSchedule(t)
+	tw.submit(t)
+}
+
+// trySchedule is like schedule, but it gives up if the lock of the bucket of
+// t is contended after a bounded spin. In that case, t is rolled back and
+// rejected with ErrBusy, and false is returned.
+func (tw *TimeWheel) trySchedule(t *Timer) bool {
+	t.arm()
+	tw.incPending()
+	tw.storeSave(t, false)
+	tw.observeSchedule(t)
+	tw.refresh()
+	added, locked := tw.insert(t, trySpins)
+	if !locked {
+		t.transit(StateScheduled, StateCancelled)
+		tw.observeCancel(t)
+		tw.decPending()
+		t.releaseQuota()
+		tw.reject(t, ErrBusy)
+		return false
+	}
+	if !added {
+		tw.fire(t)
+	}
+	return true
+}
+
+// trySpins is the number of retries to acquire a contended bucket lock by the
+// non-blocking scheduling funcs.
+const trySpins = 4
+
+// submit inserts the timer t into the current timing wheel, or fire the
+// timer if it has been expired.
 func (tw *TimeWheel) submit(t *Timer) {
+	tw.refresh()
 	if !tw.add(t) {
+		tw.fire(t)
+	}
+}
+
+// refresh advances the current to the clock if it's stale for more than a
+// tick. The current only moves when a bucket expires, thus it's arbitrarily
+// stale after the TimeWheel has been idle, and the new timer would be slotted
+// against the old position rather than the actual time.
+//
+// The current is advanced to the last tick that has fully elapsed, since the
+// timers before current+tick are regarded as expired, thus the timers within
+// the ongoing tick are never fired early.
+func (tw *TimeWheel) refresh() {
+	root := tw.root
+	if elapsed := root.now() - root.tick; elapsed >= atomic.LoadInt64(&root.current)+root.tick {
+		root.advance(elapsed)
+	}
+}
+
+// fire executes the expired timer t, or defers it if the consumer goroutine
+// is firing timers. It avoids the recursive execution when a task executed
+// inline schedules another expired timer (e.g. the delay is zero).
+func (tw *TimeWheel) fire(t *Timer) {
+	t.transit(StateScheduled, StateQueued)
+
+	root := tw.root
+	root.deferMu.Lock()
+	if root.dispatching {
+		root.deferred = append(root.deferred, t)
+		root.deferMu.Unlock()
+		return
+	}
+	root.deferMu.Unlock()
+
+	tw.execute(t)
+}
+
+// execute runs the timer's task, unless the timer is cancelled after expired.
+func (tw *TimeWheel) execute(t *Timer) {
+	if t.transit(StateQueued, StateRunning) {
+		atomic.AddUint64(&tw.root.fired, 1)
+		tw.observeFire(t)
+		if m := tw.root.metrics; m != nil {
+			// The timer is fired by its bucket, which expires at the tick that
+			// the expiration is truncated to.
+			lag := tw.root.now() - t.getExpiration()
+			if lag < 0 {
+				lag = 0
+			}
+			m.observeLag(time.Duration(lag))
+		}
+		if a := t.attrs; a != nil {
+			if a.task != "" {
+				tw.root.inflight.add(t)
+				tw.storeSave(t, true)
+			}
+			if a.quota != nil && !a.recurring {
+				// The run-once timer is no longer pending once fired.
+				t.releaseQuota()
+			}
+		}
 		t.task()
 	}
+	// The task may re-arm the timer (e.g. by tw.Schedule) before here,
+	// thus the pending is never drops to zero in that case.
+	tw.decPending()
 }
 
 // add inserts the timer t into the current timing wheel.
 // return false means the Timer has been expired.
 func (tw *TimeWheel) add(t *Timer) bool {
+	added, _ := tw.insert(t, -1)
+	return added
+}
+
+// insert inserts the timer t into the current timing wheel like add, but it
+// gives up if the lock of the bucket is still contended after spins retries.
+// The spins < 0 means waiting for the lock. The locked is false if it gave up.
+func (tw *TimeWheel) insert(t *Timer, spins int) (added bool, locked bool) {
 	current := atomic.LoadInt64(&tw.current)
-	if t.expiration < current+tw.tick {
-		// Already expired.
-		return false
-	} else if t.expiration < current+tw.interval {
+	te := t.getExpiration()
+	switch route(te, current, tw.tick, tw.interval, tw.level+1 >= tw.root.opts.maxLevels) {
+	case routeExpired:
+		return false, true
+	case routeBucket:
 		// Put it into its own bucket.
-		virtualID := t.expiration / tw.tick
-		b := tw.buckets[virtualID%tw.size]
-		b.insert(t)
+		virtualID := te / tw.tick
+		if te >= current+tw.interval {
+			// No more overflow TimeWheel is allowed, i.e. the hashed mode (see
+			// WithHashedMode) or the maximum levels reached (see WithMaxLevels).
+			// Put it into the next occurrence of its own bucket, it's inserted
+			// again once the bucket expires, until it's in the range. The
+			// remaining rounds of the timer is derived from its expiration.
+			// The bucket of the current tick may not be flushed yet, the timers
+			// of it are parked in the last bucket of the range instead.
+			cid := current / tw.tick
+			if virtualID = cid + (virtualID-cid)&tw.mask; virtualID == cid {
+				virtualID = cid + tw.size - 1
+			}
+		}
+		b := tw.ensureBucket(virtualID & tw.mask)
+		expiration := virtualID * tw.tick
 
-		// Set the bucket expiration timestamp.
-		if b.setExpiration(virtualID * tw.tick) {
+		// Insert the timer and set the bucket expiration timestamp.
+		var result int
+		if spins < 0 {
+			result = b.push(t, expiration)
+		} else if result, locked = b.tryPush(t, expiration, spins); !locked {
+			return false, false
+		}
+		switch result {
+		case pushEnqueue:
 			// The bucket needs to be enqueued since it was an expired bucket.
-			// We only need to enqueue the bucket when its expiration time has changed,
-			// i.e. the wheel has advanced and this bucket get reused with a new expiration.
-			// Any further calls to set the expiration within the same wheel cycle will
-			// pass in the same value and hence return false, thus the bucket with the
-			// same expiration will not be enqueued multiple times.
-			tw.queue.Expire(b.getExpiration(), b)
-		}
-		return true
-	} else {
+			// Only the push into a bucket out of the queue enqueues it, any
+			// further push, within the same wheel cycle or of a later one
+			// before the bucket expires, joins the enqueued expiration, thus
+			// the bucket is never in the queue twice.
+			tw.queue.offer(b, expiration)
+		case pushStale:
+			// Another goroutine has advanced the current meanwhile, route it
+			// again against the new one.
+			return tw.insert(t, spins)
+		}
+		return true, true
+	default:
 		// Out of the interval. Put it into the overflow TimeWheel.
-		var overflow unsafe.Pointer
-
-		overflow = atomic.LoadPointer(&tw.overflow)
+		overflow := tw.getOverflow()
 		if overflow == nil {
-			// Creates and save overflow TimeWheel.
-			ntw := newTimeWheel(tw.interval, tw.size, current, tw.queue)
-			atomic.CompareAndSwapPointer(&tw.overflow, nil, unsafe.Pointer(ntw))
+			overflow = tw.addOverflow(current)
+		}
+		return overflow.insert(t, spins)
+	}
+}
+
+// The destinations of an expiration in a level, see route.
+const (
+	routeExpired  = iota // already expired, the timer is executed at once.
+	routeBucket          // put into a bucket of the level.
+	routeOverflow        // out of the interval, passed to the overflow level.
+)
+
+// route tells where insert puts the expiration te in a level of the tick and
+// the interval whose current time is current, last is whether no overflow
+// level is allowed above it. It's shared by insert and Resolve.
+func route(te, current, tick, interval int64, last bool) int {
+	if te < current+tick {
+		return routeExpired
+	} else if te < current+interval || last {
+		return routeBucket
+	}
+	return routeOverflow
+}
 
-			// Load safe to avoid concurrent operations.
-			overflow = atomic.LoadPointer(&tw.overflow)
+// Resolve returns the level that a timer scheduled after the duration d now
+// is put into, 0 for the root wheel, and the tick of that level, i.e. the span
+// of the bucket that holds the timer until it's moved down to the lower levels.
+// It follows the same routing as the scheduling without inserting anything, the
+// overflow levels not created yet are resolved as if they were. A delay beyond
+// MaxDelay is resolved as if it's clamped, see WithDelayClamp, though it's
+// rejected by the scheduling without the option.
+//
+// The result holds at the time of the call, a level may advance meanwhile, and
+// it's about the TimeWheel itself rather than its critical plane.
+func (tw *TimeWheel) Resolve(d time.Duration) (level int, effectiveTick time.Duration) {
+	root := tw.root
+	if limit := root.MaxDelay(); d > limit {
+		d = limit
+	}
+	// Like the scheduling, the stale current is advanced to the clock first.
+	root.refresh()
+	te := root.expireAfter(d)
+	l := root
+	current, tick, interval := atomic.LoadInt64(&l.current), l.tick, l.interval
+	for route(te, current, tick, interval, level+1 >= root.opts.maxLevels) == routeOverflow {
+		// The overflow level starts at the current time of the lower level
+		// once it's created, see addOverflow.
+		if l != nil {
+			l = l.getOverflow()
 		}
+		if l != nil {
+			current = atomic.LoadInt64(&l.current)
+		} else {
+			current = truncate(current, interval)
+		}
+		level, tick, interval = level+1, interval, interval*root.size
+	}
+	return level, time.Duration(tick)
+}
 
-		return (*TimeWheel)(overflow).add(t)
+// addOverflow creates the overflow TimeWheel starting at current, or returns
+// the one created by another goroutine meanwhile.
+func (tw *TimeWheel) addOverflow(current int64) *TimeWheel {
+	tw.overflowMu.Lock()
+	defer tw.overflowMu.Unlock()
+	if overflow := tw.getOverflow(); overflow != nil {
+		return overflow
 	}
+	ntw := newTimeWheel(tw.interval, tw.size, current, tw.queue, tw.root)
+	ntw.level = tw.level + 1
+	atomic.StorePointer(&tw.overflow, unsafe.Pointer(ntw))
+	return ntw
 }
@@ -1,6 +1,9 @@
 package timewheel
 
 import (
+	"io"
+	"runtime"
+	"sync"
 	"testing"
 	"time"
 )
@@ -49,6 +52,28 @@ func BenchmarkTimeWheel_AfterFunc(b *testing.B) {
 	})
 }
 
+// BenchmarkTimeWheel_PendingHeap reports the heap bytes retained by each pending timer.
+func BenchmarkTimeWheel_PendingHeap(b *testing.B) {
+	tw := New(time.Millisecond, 512)
+	defer tw.Stop()
+
+	timers := make([]*Timer, b.N)
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		timers[i] = tw.AfterFunc(time.Hour+genInterval(i), func() {})
+	}
+	b.StopTimer()
+
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc)/float64(b.N), "heap-B/timer")
+	runtime.KeepAlive(timers)
+}
+
 func BenchmarkTimer_StartClose(b *testing.B) {
 	tw := New(time.Millisecond, 3)
 	tw.Start()
@@ -77,3 +102,181 @@ func BenchmarkTimer_StartClose(b *testing.B) {
 		}
 	})
 }
+
+func BenchmarkTimeWheel_add(b *testing.B) {
+	tw := New(time.Millisecond, 500)
+
+	now := time.Now().UnixNano()
+	timers := make([]*Timer, b.N)
+	for i := 0; i < b.N; i++ {
+		timers[i] = &Timer{expiration: now + int64(genInterval(i))}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tw.add(timers[i])
+	}
+}
+
+// BenchmarkTimeWheel_addOverflow creates the overflow wheels by the goroutines
+// racing for them, the buckets of each level should be allocated only once.
+func BenchmarkTimeWheel_addOverflow(b *testing.B) {
+	const goroutines = 64
+	now := time.Now().UnixNano()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		tw := New(time.Millisecond, 512)
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		for j := 0; j < goroutines; j++ {
+			t := &Timer{expiration: now + int64(time.Hour)}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				tw.add(t)
+			}()
+		}
+		b.StartTimer()
+		close(start)
+		wg.Wait()
+	}
+}
+
+func BenchmarkTimeWheel_Arm(b *testing.B) {
+	b.Run("arena", func(b *testing.B) {
+		tw := New(time.Millisecond, 3, WithCapacity(b.N))
+		tw.Start()
+		defer tw.Stop()
+		handles := make([]Handle, 0, b.N)
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			h, _ := tw.Arm(genInterval(i), func() {})
+			handles = append(handles, h)
+		}
+		for i := 0; i < b.N; i++ {
+			tw.Disarm(handles[i])
+		}
+	})
+	b.Run("heap", func(b *testing.B) {
+		tw := New(time.Millisecond, 3)
+		tw.Start()
+		defer tw.Stop()
+		timers := make([]*Timer, 0, b.N)
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			timers = append(timers, tw.AfterFunc(genInterval(i), func() {}))
+		}
+		for i := 0; i < b.N; i++ {
+			timers[i].Close()
+		}
+	})
+}
+
+func BenchmarkDeadlineManager_Touch(b *testing.B) {
+	const conns = 100000
+
+	b.Run("touch", func(b *testing.B) {
+		tw := New(time.Millisecond, 64)
+		tw.Start()
+		defer tw.Stop()
+		m := tw.NewDeadlineManager(time.Second * 30)
+		deadlines := make([]*Deadline, conns)
+		for i := range deadlines {
+			deadlines[i] = m.Add(func() {})
+		}
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			i := 0
+			for pb.Next() {
+				deadlines[i%conns].Touch()
+				i++
+			}
+		})
+	})
+	b.Run("reset", func(b *testing.B) {
+		tw := New(time.Millisecond, 64)
+		tw.Start()
+		defer tw.Stop()
+		timers := make([]*Timer, conns)
+		for i := range timers {
+			timers[i] = tw.AfterFunc(time.Second*30, func() {})
+		}
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			i := 0
+			for pb.Next() {
+				timers[i%conns].Reset(time.Second * 30)
+				i++
+			}
+		})
+	})
+}
+
+// BenchmarkHashedMode compares the hierarchical and hashed modes, by the cost
+// per timer from scheduling to firing, of the delays within the span of the
+// wheel (256ms) and the ones far beyond it (up to 10s).
+func BenchmarkHashedMode(b *testing.B) {
+	run := func(b *testing.B, maxDelay int, opts ...Option) {
+		clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+		opts = append(opts, WithClock(clock), WithDispatchPolicy(DispatchInline))
+		tw := New(time.Millisecond, 256, opts...)
+		tw.Start()
+		defer tw.Stop()
+
+		var fired int
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			tw.AfterFunc(time.Duration(i%maxDelay+1)*time.Millisecond, func() { fired++ })
+		}
+		for fired < b.N {
+			clock.add(time.Millisecond)
+			tw.Poll()
+		}
+	}
+
+	for _, c := range []struct {
+		name     string
+		maxDelay int
+	}{
+		{"short", 256},
+		{"long", 10000},
+	} {
+		b.Run(c.name+"/hierarchical", func(b *testing.B) { run(b, c.maxDelay) })
+		b.Run(c.name+"/hashed", func(b *testing.B) { run(b, c.maxDelay, WithHashedMode()) })
+	}
+}
+
+// BenchmarkTimeWheel_CountDue compares CountDue of a short window with Dump,
+// over 100k timers spread in 100s.
+func BenchmarkTimeWheel_CountDue(b *testing.B) {
+	tw := New(time.Millisecond, 512)
+	tw.Start()
+	defer tw.Stop()
+	for i := 0; i < 100000; i++ {
+		tw.AfterFunc(time.Second+time.Duration(i)*time.Millisecond, func() {})
+	}
+
+	b.Run("count", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			tw.CountDue(time.Second * 2)
+		}
+	})
+	b.Run("dump", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = tw.Dump(io.Discard, true)
+		}
+	})
+}
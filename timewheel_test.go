@@ -1,8 +1,12 @@
 package timewheel
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"math/rand"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -15,10 +19,13 @@ func TestNew(t *testing.T) {
 	tw := New(tick, size)
 	require.NotNil(t, tw)
 	require.Equal(t, tw.tick, int64(tick))
-	require.Equal(t, tw.size, size)
-	require.Equal(t, tw.interval, int64(tick)*size)
+	// The size is rounded up to the power of two.
+	require.Equal(t, tw.size, int64(4))
+	require.Equal(t, tw.Size(), int64(4))
+	require.Equal(t, tw.mask, int64(3))
+	require.Equal(t, tw.interval, int64(tick)*4)
 	require.Greater(t, tw.current, int64(0))
-	require.Equal(t, len(tw.buckets), int(size))
+	require.Equal(t, len(tw.buckets), 4)
 	require.NotNil(t, tw.queue)
 	require.True(t, tw.overflow == nil)
 }
@@ -30,6 +37,26 @@ func TestDefault(t *testing.T) {
 	require.Equal(t, tw.tick, int64(defaultTick))
 }
 
+func Test_roundPowerOfTwo(t *testing.T) {
+	cases := []struct {
+		x    int64
+		want int64
+	}{
+		{1, 1},
+		{2, 2},
+		{3, 4},
+		{4, 4},
+		{5, 8},
+		{31, 32},
+		{32, 32},
+		{33, 64},
+		{1000, 1024},
+	}
+	for _, c := range cases {
+		require.Equal(t, roundPowerOfTwo(c.x), c.want, fmt.Sprintf("x: %d", c.x))
+	}
+}
+
 func TestNew_Panic(t *testing.T) {
 	require.Panics(t, func() {
 		New(time.Millisecond-1, 1)
@@ -39,6 +66,20 @@ func TestNew_Panic(t *testing.T) {
 	})
 }
 
+func TestTryNew(t *testing.T) {
+	tw, err := TryNew(time.Millisecond-1, 1)
+	require.Nil(t, tw)
+	require.Equal(t, ErrInvalidTick, err)
+
+	tw, err = TryNew(time.Millisecond, 0)
+	require.Nil(t, tw)
+	require.Equal(t, ErrInvalidSize, err)
+
+	tw, err = TryNew(time.Millisecond, 3)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), tw.Size())
+}
+
 func TestTimeWheel_expireFunc(t *testing.T) {
 	tw := New(time.Millisecond, 3)
 	tw.Start()
@@ -64,7 +105,7 @@ func TestTimeWheel_expireFunc(t *testing.T) {
 			min := start
 			max := start.Add(d + time.Millisecond*5)
 
-			timer := tw.expireFunc(time.Now().Add(d).UnixNano(), func() { retC <- time.Now() })
+			timer := tw.expireFunc(context.Background(), time.Now().Add(d).UnixNano(), func(context.Context, *Timer) { retC <- time.Now() }, nil)
 			require.NotNil(t, timer)
 
 			got := <-retC
@@ -139,3 +180,600 @@ func TestTimeWheel_Schedule(t *testing.T) {
 		require.Less(t, got.UnixNano(), max.UnixNano(), fmt.Sprintf("%s: got: %s, want: %s", d.String(), got.String(), max.String()))
 	}
 }
+
+func TestTimeWheel_Pending(t *testing.T) {
+	tw := New(time.Millisecond, 3)
+	tw.Start()
+	defer tw.Stop()
+
+	require.Equal(t, tw.Pending(), int64(0))
+
+	retC := make(chan struct{}, 1)
+	t1 := tw.AfterFunc(time.Millisecond*10, func() { retC <- struct{}{} })
+	t2 := tw.AfterFunc(time.Hour, func() {})
+	require.Equal(t, tw.Pending(), int64(2))
+
+	// The timer in the overflow wheel is counted by the root wheel.
+	t2.Close()
+	require.Equal(t, tw.Pending(), int64(1))
+
+	<-retC
+	require.Equal(t, tw.Pending(), int64(0))
+
+	// Close an expired timer is no effect.
+	t1.Close()
+	require.Equal(t, tw.Pending(), int64(0))
+}
+
+func TestTimeWheel_Close(t *testing.T) {
+	tw := Default()
+	tw.Start()
+
+	var closer io.Closer = tw
+	require.Nil(t, closer.Close())
+	require.Equal(t, closer.Close(), ErrStopped)
+	require.NotPanics(t, func() {
+		tw.Stop()
+	})
+	// Never started.
+	require.NotPanics(t, func() {
+		Default().Stop()
+	})
+}
+
+func TestTimeWheel_Wait(t *testing.T) {
+	// Never started.
+	tw := Default()
+	tw.Wait()
+
+	tw = Default()
+	tw.Start()
+
+	var waiting int32
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tw.Wait()
+			atomic.AddInt32(&waiting, 1)
+		}()
+	}
+	time.Sleep(time.Millisecond * 10)
+	require.Equal(t, int32(0), atomic.LoadInt32(&waiting))
+
+	tw.AfterFunc(time.Millisecond, tw.Stop)
+	wg.Wait()
+	require.Equal(t, int32(4), atomic.LoadInt32(&waiting))
+	require.Contains(t, tw.String(), "state=stopped")
+
+	// Already stopped.
+	tw.Wait()
+}
+
+func TestTimeWheel_Close_Race(t *testing.T) {
+	tw := New(time.Millisecond, 4)
+	tw.Start()
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				tw.AfterFunc(genInterval(i*1000+j)%(time.Millisecond*20), func() {})
+			}
+		}(i)
+	}
+
+	time.Sleep(time.Millisecond)
+	wg.Add(2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			defer wg.Done()
+			tw.Stop()
+		}()
+	}
+	wg.Wait()
+}
+
+func TestTimeWheel_Stop_FromTask(t *testing.T) {
+	for _, opts := range [][]Option{
+		{WithDispatchPolicy(DispatchInline)},
+		{WithDispatchPolicy(DispatchInline), WithTaskTimeout(time.Second)},
+		{WithDispatchPolicy(DispatchGoroutine)},
+	} {
+		tw := New(time.Millisecond, 8, opts...)
+		tw.Start()
+
+		errC := make(chan error, 2)
+		var after int32
+		tw.AfterFunc(time.Millisecond*2, func() {
+			// The watchdog stops the TimeWheel that is running it.
+			errC <- tw.Close()
+			errC <- tw.Close()
+		})
+		tw.AfterFunc(time.Millisecond*2, func() { atomic.AddInt32(&after, 1) })
+		tw.AfterFunc(time.Hour, func() { atomic.AddInt32(&after, 1) })
+
+		doneC := make(chan struct{})
+		go func() {
+			tw.Wait()
+			close(doneC)
+		}()
+		waitC(t, doneC)
+		require.NoError(t, <-errC)
+		require.Equal(t, ErrStopped, <-errC)
+		require.Contains(t, tw.String(), "state=stopped")
+		require.LessOrEqual(t, atomic.LoadInt32(&after), int32(1))
+	}
+}
+
+func TestTimeWheel_Stop_FromTask_Deferred(t *testing.T) {
+	tw := New(time.Millisecond, 8, WithDispatchPolicy(DispatchInline))
+	tw.Start()
+
+	// The timers of the same bucket are still dispatched after the task stopped the TimeWheel.
+	var ran int32
+	stopped := make(chan struct{})
+	tw.AfterFunc(time.Millisecond*2, func() {
+		tw.Stop()
+		close(stopped)
+		atomic.AddInt32(&ran, 1)
+	})
+	tw.AfterFunc(time.Millisecond*2, func() {
+		<-stopped
+		atomic.AddInt32(&ran, 1)
+		// The schedule after stopped never expires.
+		tw.AfterFunc(time.Millisecond, func() { t.Error("unexpected execution") })
+	})
+
+	doneC := make(chan struct{})
+	go func() {
+		tw.Wait()
+		close(doneC)
+	}()
+	waitC(t, doneC)
+	require.Equal(t, int32(2), atomic.LoadInt32(&ran))
+}
+
+// waitC waits for the c is closed or fails the test if timeout.
+func waitC(t *testing.T, c <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-c:
+	case <-time.After(time.Second * 3):
+		t.Fatal("timeout, may be deadlocked")
+	}
+}
+
+func TestTimeWheel_Reentrant(t *testing.T) {
+	tw := New(time.Millisecond, 4, WithDispatchPolicy(DispatchInline))
+	tw.Start()
+	defer tw.Stop()
+
+	cases := []struct {
+		name  string
+		delay time.Duration
+	}{
+		{"current-tick", 0},
+		{"next-tick", time.Millisecond},
+		{"overflow", time.Millisecond * 20},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			doneC := make(chan struct{})
+			var returned int32
+
+			tw.AfterFunc(time.Millisecond*2, func() {
+				tw.AfterFunc(c.delay, func() {
+					// The timer is never executed recursively inside the task that scheduled it.
+					require.Equal(t, atomic.LoadInt32(&returned), int32(1))
+					close(doneC)
+				})
+				atomic.StoreInt32(&returned, 1)
+			})
+			waitC(t, doneC)
+		})
+	}
+
+	t.Run("close-in-task", func(t *testing.T) {
+		doneC := make(chan struct{})
+		at := time.Now().Add(time.Millisecond * 2)
+
+		timersC := make(chan []*Timer, 1)
+		self := tw.TimeFunc(at, func() {
+			// Both timers are in the bucket that just flushed.
+			for _, timer := range <-timersC {
+				timer.Close()
+			}
+			close(doneC)
+		})
+		sibling := tw.TimeFunc(at, func() {})
+		timersC <- []*Timer{self, sibling}
+		waitC(t, doneC)
+	})
+
+	t.Run("self-perpetuating", func(t *testing.T) {
+		doneC := make(chan struct{})
+		n := 10000
+
+		var f func()
+		f = func() {
+			n--
+			if n == 0 {
+				close(doneC)
+				return
+			}
+			tw.AfterFunc(0, f)
+		}
+		tw.AfterFunc(time.Millisecond, f)
+		waitC(t, doneC)
+	})
+
+	require.Eventually(t, func() bool { return tw.Pending() == 0 }, time.Second, time.Millisecond)
+	require.Nil(t, tw.CheckInvariants())
+}
+
+func TestTimeWheel_String(t *testing.T) {
+	tw := New(time.Millisecond, 8, WithName("foo"))
+	require.Equal(t, `TimeWheel{name="foo" tick=1ms size=8 pending=0 state=created}`, tw.String())
+
+	tw.Start()
+	tw.AfterFunc(time.Hour, func() {})
+	require.Equal(t, `TimeWheel{name="foo" tick=1ms size=8 pending=1 state=running}`, tw.String())
+
+	tw.Stop()
+	require.Equal(t, `TimeWheel{name="foo" tick=1ms size=8 pending=1 state=stopped}`, tw.String())
+}
+
+// levels returns the current of each level of the TimeWheel.
+func levels(tw *TimeWheel) []int64 {
+	var currents []int64
+	for w := tw.root; w != nil; w = w.getOverflow() {
+		currents = append(currents, atomic.LoadInt64(&w.current))
+	}
+	return currents
+}
+
+func TestTimeWheel_advance(t *testing.T) {
+	tw := New(time.Millisecond, 4)
+	// Create the overflow levels.
+	tw.AfterFunc(time.Second, func() {})
+	defer tw.Stop()
+
+	base := tw.current
+	ms := int64(time.Millisecond)
+	prev := levels(tw)
+	require.Greater(t, len(prev), 2)
+
+	cases := []struct {
+		expiration int64
+		advanced   bool
+	}{
+		{base + 5*ms, true},
+		{base + 3*ms, false},
+		{base + 5*ms, false},
+		{base + 5*ms + ms/2, false},
+		{base + 100*ms, true},
+		{base + 50*ms, false},
+		{base + 101*ms + ms/3, true},
+		{base - ms, false},
+	}
+	for _, c := range cases {
+		require.Equal(t, c.advanced, tw.advance(c.expiration), c.expiration-base)
+		currents := levels(tw)
+		for i := range currents {
+			require.GreaterOrEqual(t, currents[i], prev[i])
+		}
+		prev = currents
+	}
+	require.Equal(t, base+101*ms, tw.current)
+	require.Equal(t, truncate(base+101*ms, tw.getOverflow().tick), tw.getOverflow().current)
+}
+
+func TestTimeWheel_advance_Concurrent(t *testing.T) {
+	tw := New(time.Millisecond, 4)
+	tw.AfterFunc(time.Second, func() {})
+	defer tw.Stop()
+
+	base := tw.current
+	ms := int64(time.Millisecond)
+
+	stopC := make(chan struct{})
+	watchC := make(chan error, 1)
+	go func() {
+		prev := levels(tw)
+		for {
+			select {
+			case <-stopC:
+				watchC <- nil
+				return
+			default:
+			}
+			currents := levels(tw)
+			for i := range currents {
+				if i < len(prev) && currents[i] < prev[i] {
+					watchC <- fmt.Errorf("level %d moved backwards from %d to %d", i, prev[i], currents[i])
+					return
+				}
+			}
+			prev = currents
+		}
+	}()
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				tw.advance(base + int64((j*7+i*13)%1000)*ms)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(stopC)
+	require.NoError(t, <-watchC)
+	require.Equal(t, base+999*ms, tw.current)
+}
+
+func TestTimeWheel_process_Stale(t *testing.T) {
+	tw := New(time.Millisecond, 4)
+	defer tw.Stop()
+
+	ms := int64(time.Millisecond)
+	newTimer := func(expiration int64, firedC chan int64) *Timer {
+		timer := &Timer{expiration: expiration, meta: newMeta(tw.nextID(), StateScheduled, EndNone), tw: tw}
+		timer.task = func() { firedC <- expiration }
+		tw.incPending()
+		return timer
+	}
+	firedC := make(chan int64, 2)
+
+	// The timer of the current rotation is pushed, but the bucket is offered
+	// late, e.g. the goroutine of add is preempted before offer.
+	ea := tw.current + 2*ms
+	b := tw.ensureBucket((ea / ms) & tw.mask)
+	require.Equal(t, pushEnqueue, b.push(newTimer(ea, firedC), ea))
+
+	// In the meantime, the wheel advanced past the bucket, and the bucket is
+	// reused for the next rotation. It joins the enqueued expiration rather
+	// than enqueues the bucket twice.
+	tw.advance(ea + ms)
+	eb := ea + tw.interval
+	require.True(t, tw.add(newTimer(eb, firedC)))
+	require.Equal(t, ea, b.getExpiration())
+	require.Equal(t, int32(1), b.enqueued)
+	require.Nil(t, tw.CheckInvariants())
+
+	// The late offer of the current rotation.
+	tw.process(b, ea)
+	require.Equal(t, ea+ms, tw.current)
+	require.Equal(t, ea, <-firedC)
+	select {
+	case e := <-firedC:
+		t.Fatalf("the timer of the next rotation fired early: %d", e-ea)
+	default:
+	}
+	require.Equal(t, eb, b.getExpiration())
+	require.Equal(t, 1, b.timers.Len())
+}
+
+func TestTimeWheel_add_SameSlot_Stress(t *testing.T) {
+	// The goroutines schedule the timers into the same slot of the root wheel,
+	// alternating between the current rotation and the next one, while the
+	// wheel advances. Thus, the expiration of the bucket changes rapidly, a
+	// timer must never be flushed early or twice, or left in the bucket. The
+	// tasks are slow and inline, thus the scheduling advances the current
+	// past the buckets still in the queue, see refresh.
+	tw := New(time.Millisecond, 4, WithDispatchPolicy(DispatchInline))
+	tw.Start()
+	defer tw.Stop()
+
+	ms := int64(time.Millisecond)
+	const goroutines, n = 4, 300
+	fired := make([]int32, goroutines*n)
+	var early int32
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				// The next occurrence of the slot 1, and the one after it.
+				target := (time.Now().UnixNano()/ms + 1) * ms
+				target += ((1 - target/ms%4 + 4) % 4) * ms
+				target += int64(i%2) * 4 * ms
+				k := g*n + i
+				tw.AfterFunc(time.Until(time.Unix(0, target)), func() {
+					if time.Now().UnixNano() < target-ms {
+						atomic.AddInt32(&early, 1)
+					}
+					atomic.AddInt32(&fired[k], 1)
+					time.Sleep(time.Millisecond / 10)
+				})
+				if i%16 == 0 {
+					time.Sleep(time.Duration(i%3) * time.Millisecond / 2)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	require.Eventually(t, func() bool { return tw.Pending() == 0 }, time.Second*5, time.Millisecond)
+	require.Nil(t, tw.CheckInvariants())
+	require.Equal(t, int32(0), atomic.LoadInt32(&early))
+	for k := range fired {
+		require.Eventually(t, func() bool { return atomic.LoadInt32(&fired[k]) == 1 }, time.Second, time.Millisecond, "timer %d", k)
+		require.Equal(t, int32(1), atomic.LoadInt32(&fired[k]), "timer %d", k)
+	}
+}
+
+// TestTimeWheel_Latency_Concurrent schedules the 1-tick timers from many
+// goroutines, none of them may be delayed a rotation (i.e. size*tick).
+func TestTimeWheel_Latency_Concurrent(t *testing.T) {
+	tick := time.Millisecond
+	tw := New(tick, 64)
+	tw.Start()
+	defer tw.Stop()
+
+	var maxLate int64
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				start := time.Now()
+				doneC := make(chan struct{})
+				tw.AfterFunc(tick, func() {
+					late := int64(time.Since(start) - tick)
+					for {
+						old := atomic.LoadInt64(&maxLate)
+						if late <= old || atomic.CompareAndSwapInt64(&maxLate, old, late) {
+							break
+						}
+					}
+					close(doneC)
+				})
+				<-doneC
+			}
+		}()
+	}
+	wg.Wait()
+	require.Less(t, atomic.LoadInt64(&maxLate), int64(tick)*tw.size/2)
+}
+
+func TestTimeWheel_refresh(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	defer tw.Stop()
+	// Create the overflow levels.
+	tw.AfterFunc(time.Hour, func() {})
+
+	// Idle for many intervals.
+	now := tw.current + tw.interval*100 + int64(time.Microsecond*300)
+	tw.now = func() int64 { return now }
+
+	timer := tw.TimeFunc(time.Unix(0, now+tw.tick), func() {})
+	require.Equal(t, truncate(now-tw.tick, tw.tick), tw.current)
+	require.Equal(t, truncate(now-tw.tick, tw.getOverflow().tick), tw.getOverflow().current)
+
+	// Slotted into the bucket of the next tick of the lowest level.
+	b := timer.getBucket()
+	require.NotNil(t, b)
+	require.Equal(t, tw.loadBucket(((now+tw.tick)/tw.tick)&tw.mask), b)
+	require.Equal(t, truncate(now+tw.tick, tw.tick), b.getExpiration())
+
+	// The timer within the ongoing tick is not expired.
+	timer = tw.TimeFunc(time.Unix(0, now+tw.tick/2), func() {})
+	require.NotNil(t, timer.getBucket())
+
+	// Not refreshed within a tick.
+	tw.now = func() int64 { return now + tw.tick/2 }
+	tw.TimeFunc(time.Unix(0, now+tw.tick*2), func() {})
+	require.Equal(t, truncate(now-tw.tick, tw.tick), tw.current)
+}
+
+func TestTimeWheel_refresh_Idle(t *testing.T) {
+	tw := New(time.Millisecond, 4)
+	tw.Start()
+	defer tw.Stop()
+
+	time.Sleep(time.Duration(tw.interval) * 20)
+	start := time.Now()
+	<-tw.AfterFunc(time.Millisecond, func() {}).Done()
+	require.Less(t, int64(time.Since(start)), int64(time.Millisecond*20))
+}
+
+func TestTimeWheel_NextExpiration(t *testing.T) {
+	tw := New(time.Millisecond, 4)
+	tw.Start()
+	defer tw.Stop()
+
+	_, ok := tw.NextExpiration()
+	require.False(t, ok)
+
+	far := tw.AfterFunc(time.Hour, func() {})
+	near := tw.AfterFunc(time.Millisecond*50, func() {})
+	for _, timer := range []*Timer{near, far} {
+		// The head may be a bucket of an upper level that covers the timer.
+		next, ok := tw.NextExpiration()
+		require.True(t, ok)
+		require.False(t, next.After(timer.Expiration()))
+		timer.Close()
+	}
+
+	// The closed timers are not pending.
+	_, ok = tw.NextExpiration()
+	require.False(t, ok)
+
+	clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	manual := New(time.Millisecond, 4, WithClock(clock), WithDispatchPolicy(DispatchInline))
+	manual.Start()
+	defer manual.Stop()
+
+	timer := manual.AfterFunc(time.Millisecond*100, func() {})
+	for timer.State() == StateScheduled {
+		next, ok := manual.NextExpiration()
+		require.True(t, ok)
+		require.False(t, next.After(timer.Expiration()))
+		require.False(t, next.Before(clock.Now().Add(-time.Millisecond)))
+		clock.add(time.Millisecond)
+		manual.Poll()
+	}
+	_, ok = manual.NextExpiration()
+	require.False(t, ok)
+}
+
+func TestTimeWheel_Resolve(t *testing.T) {
+	// levelOf schedules a timer after d and returns the level that holds it.
+	levelOf := func(tw *TimeWheel, d time.Duration) int {
+		timer := tw.AfterFunc(d, func() {})
+		defer timer.Close()
+		for level, n := range tw.LeakReport().LevelTimers {
+			if n != 0 {
+				return level
+			}
+		}
+		return -1
+	}
+	// The year is beyond the MaxDelay of 2 levels, it's resolved as clamped.
+	for _, opts := range [][]Option{nil, {WithMaxLevels(2), WithDelayClamp()}, {WithHashedMode()}} {
+		clock := &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+		opts = append(opts, WithClock(clock), WithDispatchPolicy(DispatchInline), WithLeakTracking())
+		tw := New(time.Millisecond, 8, opts...)
+		tw.Start()
+
+		level, tick := tw.Resolve(0)
+		require.Equal(t, 0, level)
+		require.Equal(t, time.Millisecond, tick)
+
+		// The delays before and after the overflow levels are created, and
+		// at the unaligned current times of the levels.
+		rnd := rand.New(rand.NewSource(1))
+		for i := 0; i < 300; i++ {
+			d := time.Duration(rnd.Int63n(int64(time.Second))) + time.Millisecond
+			if i%50 == 0 {
+				d = time.Hour * 24 * 365
+			}
+			level, tick := tw.Resolve(d)
+			require.Equal(t, levelOf(tw, d), level, "delay %s", d)
+			want := time.Millisecond
+			for j := 0; j < level; j++ {
+				want *= 8
+			}
+			require.Equal(t, want, tick)
+			clock.add(time.Duration(rnd.Int63n(int64(time.Millisecond * 20))))
+			tw.Poll()
+		}
+		tw.Stop()
+	}
+
+	tw := New(time.Millisecond, 8, WithMaxLevels(2))
+	level, tick := tw.Resolve(time.Hour)
+	require.Equal(t, 1, level)
+	require.Equal(t, time.Millisecond*8, tick)
+	level, _ = New(time.Millisecond, 8, WithHashedMode()).Resolve(time.Hour)
+	require.Equal(t, 0, level)
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheeltest
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yu31/timewheel"
+)
+
+// EventKind is the kind of an Event.
+type EventKind int
+
+const (
+	// EventScheduled means the timer was armed, see timewheel.Observer.
+	EventScheduled EventKind = iota + 1
+	// EventFired means the task of the timer was dispatched.
+	EventFired
+	// EventCancelled means the timer was closed before its task dispatched.
+	EventCancelled
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventScheduled:
+		return "scheduled"
+	case EventFired:
+		return "fired"
+	case EventCancelled:
+		return "cancelled"
+	}
+	return "EventKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// Event is a lifecycle event of a timer that captured by the Recorder.
+type Event struct {
+	Kind  EventKind
+	Timer *timewheel.Timer
+	// The time of the clock when the event happened.
+	At time.Time
+}
+
+// Recorder is a timewheel.Observer that captures the events in the order they
+// happened. It's safe for concurrent use.
+type Recorder struct {
+	clock timewheel.Clock
+
+	mu     sync.Mutex
+	events []Event
+}
+
+// NewRecorder creates a Recorder that stamps the events by the clock, or by
+// the system clock if clock is nil.
+func NewRecorder(clock timewheel.Clock) *Recorder {
+	return &Recorder{clock: clock}
+}
+
+// OnSchedule implements the timewheel.Observer.
+func (r *Recorder) OnSchedule(t *timewheel.Timer) { r.record(EventScheduled, t) }
+
+// OnFire implements the timewheel.Observer.
+func (r *Recorder) OnFire(t *timewheel.Timer) { r.record(EventFired, t) }
+
+// OnCancel implements the timewheel.Observer.
+func (r *Recorder) OnCancel(t *timewheel.Timer) { r.record(EventCancelled, t) }
+
+func (r *Recorder) record(kind EventKind, t *timewheel.Timer) {
+	var at time.Time
+	if r.clock != nil {
+		at = r.clock.Now()
+	} else {
+		at = time.Now()
+	}
+	r.mu.Lock()
+	r.events = append(r.events, Event{Kind: kind, Timer: t, At: at})
+	r.mu.Unlock()
+}
+
+// Events returns a copy of the events captured so far.
+func (r *Recorder) Events() []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Event(nil), r.events...)
+}
+
+// Count returns the number of the events of the kind of the timer, or of
+// all the timers if timer is nil.
+func (r *Recorder) Count(kind EventKind, timer *timewheel.Timer) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, e := range r.events {
+		if e.Kind == kind && (timer == nil || e.Timer == timer) {
+			n++
+		}
+	}
+	return n
+}
+
+// Reset discards the events captured so far.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	r.events = nil
+	r.mu.Unlock()
+}
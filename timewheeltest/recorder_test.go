@@ -0,0 +1,55 @@
+package timewheeltest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/yu31/timewheel"
+)
+
+func TestRecorder(t *testing.T) {
+	w := New(time.Millisecond, 8)
+	defer w.Stop()
+
+	t1 := w.AfterFunc(time.Millisecond*2, func() {}, timewheel.WithTag("a"))
+	t2 := w.AfterFunc(time.Millisecond*5, func() {}, timewheel.WithTag("b"))
+	w.Advance(time.Millisecond * 3)
+	t2.Close()
+
+	events := w.Recorder.Events()
+	require.Len(t, events, 4)
+	kinds := []EventKind{events[0].Kind, events[1].Kind, events[2].Kind, events[3].Kind}
+	require.Equal(t, []EventKind{EventScheduled, EventScheduled, EventFired, EventCancelled}, kinds)
+	require.Equal(t, []*timewheel.Timer{t1, t2, t1, t2}, []*timewheel.Timer{events[0].Timer, events[1].Timer, events[2].Timer, events[3].Timer})
+	require.Equal(t, Epoch.UnixNano(), events[0].At.UnixNano())
+	require.Equal(t, Epoch.Add(time.Millisecond*2).UnixNano(), events[2].At.UnixNano())
+	require.Equal(t, Epoch.Add(time.Millisecond*3).UnixNano(), events[3].At.UnixNano())
+
+	require.Equal(t, 2, w.Recorder.Count(EventScheduled, nil))
+	require.Equal(t, 1, w.Recorder.Count(EventFired, t1))
+	require.Equal(t, 0, w.Recorder.Count(EventFired, t2))
+
+	w.Recorder.Reset()
+	require.Len(t, w.Recorder.Events(), 0)
+}
+
+func TestRecorder_SystemClock(t *testing.T) {
+	r := NewRecorder(nil)
+	tw := timewheel.New(time.Millisecond, 8, timewheel.WithObserver(r))
+	defer tw.Stop()
+
+	before := time.Now()
+	tw.AfterFunc(time.Hour, func() {})
+	events := r.Events()
+	require.Len(t, events, 1)
+	require.False(t, events[0].At.Before(before))
+}
+
+func TestEventKind_String(t *testing.T) {
+	require.Equal(t, "scheduled", EventScheduled.String())
+	require.Equal(t, "fired", EventFired.String())
+	require.Equal(t, "cancelled", EventCancelled.String())
+	require.Equal(t, "EventKind(0)", EventKind(0).String())
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+// Package timewheeltest provides utilities for testing the code that uses a
+// TimeWheel, without waiting for the real time, e.g.:
+//
+//	w := timewheeltest.New(time.Millisecond, 64)
+//	defer w.Stop()
+//
+//	timer := w.AfterFunc(time.Second, f)
+//	w.Advance(time.Second)
+//	timewheeltest.ExpectFired(t, timer)
+//
+// It's built on the exported API of the package timewheel only.
+package timewheeltest
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yu31/timewheel"
+)
+
+// Epoch is the initial time of the FakeClock created by New.
+var Epoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// FakeClock is a timewheel.Clock that only moves when told to.
+// It's safe for concurrent use.
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFakeClock creates a FakeClock starts at now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now implements the timewheel.Clock.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Set moves the clock to now. It panics if now is before the current time,
+// since a Clock must never move backwards.
+//
+// NOTICE: Set does not run the tasks that become due, use Wheel.Advance or
+// call TimeWheel.Poll after Set.
+func (c *FakeClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Before(c.now) {
+		panic("timewheeltest: the clock can't move backwards")
+	}
+	c.now = now
+}
+
+// Wheel is a TimeWheel driven by a FakeClock, its tasks are executed
+// synchronously by Advance.
+type Wheel struct {
+	*timewheel.TimeWheel
+
+	// The clock of the TimeWheel, it starts at the Epoch.
+	Clock *FakeClock
+	// The Recorder of the events of all the timers of the TimeWheel.
+	Recorder *Recorder
+}
+
+// New creates and starts a Wheel with the given tick and size, see timewheel.New.
+//
+// The tasks are dispatched by the timewheel.DispatchInline unless another
+// policy is set by opts, thus they have been executed when Advance returns.
+// The timewheel.WithClock in opts is overridden.
+func New(tick time.Duration, size int64, opts ...timewheel.Option) *Wheel {
+	clock := NewFakeClock(Epoch)
+	recorder := NewRecorder(clock)
+
+	all := make([]timewheel.Option, 0, len(opts)+3)
+	all = append(all, timewheel.WithDispatchPolicy(timewheel.DispatchInline))
+	all = append(all, opts...)
+	all = append(all, timewheel.WithClock(clock), timewheel.WithObserver(recorder))
+
+	tw := timewheel.New(tick, size, all...)
+	tw.Start()
+	return &Wheel{TimeWheel: tw, Clock: clock, Recorder: recorder}
+}
+
+// Now returns the current time of the clock.
+func (w *Wheel) Now() time.Time {
+	return w.Clock.Now()
+}
+
+// Advance moves the clock forward by d, and runs the tasks that become due
+// in the order they expire. The clock stops at the expiration of each bucket
+// on the way, thus a task observes the time it expired at (at the precision
+// of the tick), and the timers scheduled by a task within d are also run.
+//
+// It returns the number of tasks fired. It panics if d is negative.
+func (w *Wheel) Advance(d time.Duration) int {
+	if d < 0 {
+		panic("timewheeltest: the clock can't move backwards")
+	}
+	fired := w.Stats().Fired
+	target := w.Clock.Now().Add(d)
+	for {
+		_, next := w.Poll()
+		if next.IsZero() || next.After(target) {
+			break
+		}
+		if next.After(w.Clock.Now()) {
+			w.Clock.Set(next)
+		}
+	}
+	w.Clock.Set(target)
+	w.Poll()
+	return int(w.Stats().Fired - fired)
+}
+
+// ExpectFired fails the test if the task of the run-once timer has not been
+// dispatched. Use the Recorder to check the executions of a recurring timer.
+func ExpectFired(tb testing.TB, timer *timewheel.Timer) {
+	tb.Helper()
+	switch timer.State() {
+	case timewheel.StateRunning, timewheel.StateCompleted:
+	default:
+		tb.Fatalf("timewheeltest: expected %s to be fired", timer)
+	}
+}
+
+// ExpectNotFired fails the test if the task of the timer has been dispatched.
+func ExpectNotFired(tb testing.TB, timer *timewheel.Timer) {
+	tb.Helper()
+	switch timer.State() {
+	case timewheel.StateRunning, timewheel.StateCompleted:
+		tb.Fatalf("timewheeltest: expected %s not to be fired", timer)
+	}
+}
+
+// ExpectPending fails the test if the number of pending timers of tw is not n.
+func ExpectPending(tb testing.TB, tw interface{ Pending() int64 }, n int64) {
+	tb.Helper()
+	if pending := tw.Pending(); pending != n {
+		tb.Fatalf("timewheeltest: expected %d pending timers, but got %d", n, pending)
+	}
+}
@@ -0,0 +1,127 @@
+package timewheeltest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/yu31/timewheel"
+)
+
+// fakeTB records the failure instead of failing the test.
+type fakeTB struct {
+	testing.TB
+	failure string
+}
+
+func (tb *fakeTB) Helper() {}
+
+func (tb *fakeTB) Fatalf(format string, args ...interface{}) {
+	tb.failure = fmt.Sprintf(format, args...)
+}
+
+func TestWheel_Advance(t *testing.T) {
+	w := New(time.Millisecond, 8)
+	defer w.Stop()
+	require.Equal(t, Epoch.UnixNano(), w.Now().UnixNano())
+
+	var at []time.Duration
+	f := func() { at = append(at, w.Now().Sub(Epoch)) }
+	t1 := w.AfterFunc(time.Millisecond*3, f)
+	t2 := w.AfterFunc(time.Millisecond*30, f)
+	t3 := w.AfterFunc(time.Hour, f)
+	ExpectPending(t, w, 3)
+
+	require.Equal(t, 1, w.Advance(time.Millisecond*29))
+	ExpectFired(t, t1)
+	ExpectNotFired(t, t2)
+	ExpectPending(t, w, 2)
+	require.Equal(t, int64(time.Millisecond*29), int64(w.Now().Sub(Epoch)))
+
+	// The 30ms timer is in the overflow wheel, it's moved down on the way.
+	require.Equal(t, 1, w.Advance(time.Millisecond))
+	ExpectFired(t, t2)
+
+	require.Equal(t, 1, w.Advance(time.Hour))
+	ExpectFired(t, t3)
+	ExpectPending(t, w.TimeWheel, 0)
+	require.Equal(t, []int64{int64(time.Millisecond * 3), int64(time.Millisecond * 30), int64(time.Hour)},
+		[]int64{int64(at[0]), int64(at[1]), int64(at[2])})
+}
+
+func TestWheel_Advance_Chain(t *testing.T) {
+	w := New(time.Millisecond, 8)
+	defer w.Stop()
+
+	// The timers scheduled by the tasks within the advance are also run.
+	var n int
+	var f func()
+	f = func() {
+		n++
+		if n < 10 {
+			w.AfterFunc(time.Millisecond*10, f)
+		}
+	}
+	w.AfterFunc(time.Millisecond*10, f)
+	require.Equal(t, 5, w.Advance(time.Millisecond*50))
+	require.Equal(t, 5, w.Advance(time.Millisecond*60))
+	require.Equal(t, 10, n)
+	ExpectPending(t, w, 0)
+}
+
+func TestWheel_Advance_Recurrence(t *testing.T) {
+	w := New(time.Millisecond, 8)
+	defer w.Stop()
+
+	timer, err := w.Every(time.Minute).Do(func() {})
+	require.NoError(t, err)
+	require.Equal(t, 60, w.Advance(time.Hour))
+	require.Equal(t, 60, w.Recorder.Count(EventFired, timer))
+
+	timer.Close()
+	require.Equal(t, 0, w.Advance(time.Hour))
+	ExpectPending(t, w, 0)
+}
+
+func TestWheel_Advance_Negative(t *testing.T) {
+	w := New(time.Millisecond, 8)
+	defer w.Stop()
+	require.Panics(t, func() { w.Advance(-1) })
+}
+
+func TestFakeClock(t *testing.T) {
+	c := NewFakeClock(Epoch)
+	c.Set(Epoch.Add(time.Second))
+	require.Equal(t, Epoch.Add(time.Second).UnixNano(), c.Now().UnixNano())
+	require.Panics(t, func() { c.Set(Epoch) })
+
+	// It can be used with a TimeWheel directly.
+	tw := timewheel.New(time.Millisecond, 8, timewheel.WithClock(c))
+	defer tw.Stop()
+	timer := tw.AfterFunc(time.Millisecond, func() {})
+	c.Set(c.Now().Add(time.Millisecond))
+	processed, _ := tw.Poll()
+	require.Equal(t, 1, processed)
+	<-timer.Done()
+}
+
+func TestExpect(t *testing.T) {
+	w := New(time.Millisecond, 8)
+	defer w.Stop()
+	timer := w.AfterFunc(time.Millisecond, func() {})
+
+	tb := new(fakeTB)
+	ExpectFired(tb, timer)
+	require.Contains(t, tb.failure, "to be fired")
+
+	tb = new(fakeTB)
+	ExpectPending(tb, w, 2)
+	require.Equal(t, "timewheeltest: expected 2 pending timers, but got 1", tb.failure)
+
+	w.Advance(time.Millisecond)
+	tb = new(fakeTB)
+	ExpectNotFired(tb, timer)
+	require.Contains(t, tb.failure, "not to be fired")
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"context"
+	"runtime/trace"
+	"time"
+)
+
+// The trace category and default region name used in runtime/trace.
+const (
+	traceCategory = "timewheel"
+	traceTaskName = "timewheel.task"
+)
+
+// traceName returns the name of t in runtime/trace, it's the tag of t if present.
+func traceName(t *Timer) string {
+	if tag := t.Tag(); tag != "" {
+		return tag
+	}
+	return traceTaskName
+}
+
+// traceTask creates a trace task that covers from now to the end of f, and
+// logs the delay of t. Thus, the trace viewer shows the relationship between
+// the schedule and the execution.
+//
+// It returns the context associated with the trace task and a wrapped f that
+// ends the task after f returned. If t is closed before it fires, the trace
+// task is never ended.
+func traceTask(ctx context.Context, t *Timer, f func(ctx context.Context)) (context.Context, func(ctx context.Context)) {
+	ctx, task := trace.NewTask(ctx, traceName(t))
+	traceSchedule(ctx, t)
+
+	return ctx, func(ctx context.Context) {
+		f(ctx)
+		task.End()
+	}
+}
+
+// traceSchedule logs the delay of t at the time of schedule.
+func traceSchedule(ctx context.Context, t *Timer) {
+	trace.Log(ctx, traceCategory, traceName(t)+": schedule delay="+time.Duration(t.getExpiration()-t.tw.root.now()).String())
+}
+
+// traceRegion wraps f to executes it in a trace region named by t.
+func traceRegion(t *Timer, f func(ctx context.Context)) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		trace.WithRegion(ctx, traceName(t), func() {
+			f(ctx)
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package timewheel
+
+import (
+	"bytes"
+	"runtime/trace"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_traceName(t *testing.T) {
+	require.Equal(t, traceName(&Timer{}), traceTaskName)
+	require.Equal(t, traceName(&Timer{attrs: &attrs{tag: "refresh"}}), "refresh")
+}
+
+func TestTimeWheel_Trace(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	buf := new(bytes.Buffer)
+	require.Nil(t, trace.Start(buf))
+
+	timer := tw.AfterFunc(time.Millisecond, func() {}, WithTag("trace-tag"))
+	<-timer.Done()
+	require.Equal(t, timer.Tag(), "trace-tag")
+
+	trace.Stop()
+	require.True(t, bytes.Contains(buf.Bytes(), []byte("trace-tag")))
+	require.True(t, bytes.Contains(buf.Bytes(), []byte("schedule delay=")))
+}
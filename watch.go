@@ -0,0 +1,221 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventType is the type of an Event.
+type EventType uint8
+
+// The types of the events delivered by Watch.
+const (
+	// EventScheduled is delivered each time a timer is armed, like the
+	// Observer.OnSchedule.
+	EventScheduled EventType = iota + 1
+	// EventFired is delivered each time the task of a timer is dispatched,
+	// like the Observer.OnFire.
+	EventFired
+	// EventCancelled is delivered when a timer is closed before its task
+	// dispatched, like the Observer.OnCancel.
+	EventCancelled
+	// EventDropped is delivered when the task of a timer is dropped since the
+	// Executor refused it or the channel of the DeliveryDrop is full, see
+	// OnDrop.
+	EventDropped
+	// EventGap marks the events that are lost since the watcher fell behind,
+	// its Missed is the number of them. Only the Type, Time and Missed are set.
+	EventGap
+)
+
+var eventTypeNames = [...]string{
+	EventScheduled: "Scheduled",
+	EventFired:     "Fired",
+	EventCancelled: "Cancelled",
+	EventDropped:   "Dropped",
+	EventGap:       "Gap",
+}
+
+// String returns the name of the EventType, such as "Fired".
+func (et EventType) String() string {
+	if int(et) < len(eventTypeNames) && eventTypeNames[et] != "" {
+		return eventTypeNames[et]
+	}
+	return fmt.Sprintf("EventType(%d)", uint8(et))
+}
+
+// Event is a lifecycle event of a timer delivered by Watch.
+type Event struct {
+	Type EventType
+	// The ID, tag and expiration of the timer.
+	ID         uint64
+	Tag        string
+	Expiration time.Time
+	// The time of the event, by the Clock of the TimeWheel.
+	Time time.Time
+	// The number of events lost before this one, it's only set for EventGap.
+	Missed uint64
+}
+
+// minWatchBuffer is the minimum capacity of the channel of a watcher, there
+// must be room for an event besides the gap marker preceding it.
+const minWatchBuffer = 2
+
+// Watch subscribes to the lifecycle events of the timers, it returns a channel
+// with the capacity buf that the events are delivered to, and a func that
+// cancels the subscription. The buf less than 2 is regarded as 2.
+//
+// The TimeWheel is never blocked by a slow watcher: once the channel is full,
+// the oldest events, i.e. all the ones in the channel, are dropped to make room
+// for the new ones, and an EventGap is inserted in place of them that tells how
+// many are lost. Thus the events on either side of the gap are in order. There
+// can be many watchers concurrently, the events are delivered to each of them.
+//
+// The cancel func stops the delivery and closes the channel, it's safe to call
+// more than once. The channel is also closed once the TimeWheel is stopped.
+func (tw *TimeWheel) Watch(buf int) (<-chan Event, func()) {
+	if buf < minWatchBuffer {
+		buf = minWatchBuffer
+	}
+	w := &watcher{c: make(chan Event, buf)}
+
+	h := tw.root.watch
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		w.close()
+		return w.c, func() {}
+	}
+	watchers := make([]*watcher, 0, len(h.get())+1)
+	watchers = append(watchers, h.get()...)
+	watchers = append(watchers, w)
+	h.watchers.Store(&watchers)
+	h.mu.Unlock()
+
+	var once sync.Once
+	return w.c, func() {
+		once.Do(func() {
+			h.remove(w)
+			w.close()
+		})
+	}
+}
+
+// watchHub holds the watchers of a TimeWheel.
+type watchHub struct {
+	// The watchers are replaced as a whole under the mu, and read without lock.
+	watchers atomic.Pointer[[]*watcher]
+	mu       sync.Mutex
+	closed   bool
+}
+
+// get returns the current watchers, the slice is never modified.
+func (h *watchHub) get() []*watcher {
+	if p := h.watchers.Load(); p != nil {
+		return *p
+	}
+	return nil
+}
+
+// remove removes the watcher w.
+func (h *watchHub) remove(w *watcher) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var watchers []*watcher
+	for _, other := range h.get() {
+		if other != w {
+			watchers = append(watchers, other)
+		}
+	}
+	h.watchers.Store(&watchers)
+}
+
+// close closes all the watchers, it's called once the TimeWheel is stopped.
+func (h *watchHub) close() {
+	h.mu.Lock()
+	watchers := h.get()
+	h.watchers.Store(nil)
+	h.closed = true
+	h.mu.Unlock()
+
+	for _, w := range watchers {
+		w.close()
+	}
+}
+
+// notifyWatchers delivers the event of type et of timer t to the watchers.
+// It's a single atomic load if there is no watcher.
+func (tw *TimeWheel) notifyWatchers(et EventType, t *Timer) {
+	watchers := tw.root.watch.get()
+	if len(watchers) == 0 {
+		return
+	}
+	e := Event{
+		Type:       et,
+		ID:         t.ID(),
+		Tag:        t.Tag(),
+		Expiration: time.Unix(0, t.getExpiration()),
+		Time:       tw.timeNow(),
+	}
+	for _, w := range watchers {
+		w.send(e)
+	}
+}
+
+// watcher is a subscription of Watch.
+type watcher struct {
+	c chan Event
+
+	// The mu serializes the sends, thus the room made for an event is never
+	// taken by another one; the receiver only ever frees the room.
+	mu     sync.Mutex
+	closed bool
+}
+
+// send delivers e without blocking. If the channel is full, the events in it
+// are dropped and replaced by an EventGap, which is thus exactly where the
+// events are lost; a gap that is not received yet is merged into the new one.
+func (w *watcher) send(e Event) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	if len(w.c) == cap(w.c) {
+		var missed uint64
+	drain:
+		for {
+			select {
+			case old := <-w.c:
+				if old.Type == EventGap {
+					missed += old.Missed
+				} else {
+					missed++
+				}
+			default:
+				// Drained, or by the receiver meanwhile.
+				break drain
+			}
+		}
+		if missed > 0 {
+			w.c <- Event{Type: EventGap, Time: e.Time, Missed: missed}
+		}
+	}
+	w.c <- e
+}
+
+func (w *watcher) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.closed {
+		w.closed = true
+		close(w.c)
+	}
+}
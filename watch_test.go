@@ -0,0 +1,146 @@
+package timewheel
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// refuseExecutor is an Executor that refuses all the tasks.
+type refuseExecutor struct{}
+
+func (refuseExecutor) Execute(func()) error { return errors.New("refused") }
+
+func TestEventType_String(t *testing.T) {
+	require.Equal(t, "Scheduled", EventScheduled.String())
+	require.Equal(t, "Gap", EventGap.String())
+	require.Equal(t, "EventType(0)", EventType(0).String())
+	require.Equal(t, "EventType(9)", EventType(9).String())
+}
+
+func TestTimeWheel_Watch(t *testing.T) {
+	tw := New(time.Millisecond, 8, WithDispatchPolicy(DispatchInline))
+	tw.Start()
+	defer tw.Stop()
+
+	c1, cancel1 := tw.Watch(16)
+	c2, cancel2 := tw.Watch(16)
+	defer cancel2()
+
+	<-tw.AfterFunc(time.Millisecond, func() {}, WithTag("once")).Done()
+	timer := tw.AfterFunc(time.Hour, func() {}, WithTag("closed"))
+	timer.Close()
+
+	expect := []EventType{EventScheduled, EventFired, EventScheduled, EventCancelled}
+	for _, c := range []<-chan Event{c1, c2} {
+		for i, et := range expect {
+			e := <-c
+			require.Equal(t, et, e.Type, i)
+			require.False(t, e.Time.IsZero())
+		}
+	}
+
+	cancel1()
+	cancel1()
+	_, ok := <-c1
+	require.False(t, ok)
+
+	tw.AfterFunc(time.Hour, func() {}, WithTag("last")).Close()
+	e := <-c2
+	require.Equal(t, EventScheduled, e.Type)
+	require.Equal(t, "last", e.Tag)
+	require.Equal(t, timer.ID()+1, e.ID)
+	require.Len(t, tw.root.watch.get(), 1)
+}
+
+func TestTimeWheel_Watch_Dropped(t *testing.T) {
+	tw := New(time.Millisecond, 8, WithExecutor(refuseExecutor{}))
+	tw.Start()
+	defer tw.Stop()
+
+	c, cancel := tw.Watch(8)
+	defer cancel()
+	<-tw.AfterFunc(time.Millisecond, func() {}, WithTag("dropped")).Done()
+	require.Equal(t, EventScheduled, (<-c).Type)
+	require.Equal(t, EventFired, (<-c).Type)
+	e := <-c
+	require.Equal(t, EventDropped, e.Type)
+	require.Equal(t, "dropped", e.Tag)
+}
+
+func TestTimeWheel_Watch_Gap(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	c, cancel := tw.Watch(4)
+	defer cancel()
+
+	for i := 0; i < 10; i++ {
+		tw.AfterFunc(time.Hour, func() {})
+	}
+	// Never blocked, the oldest are dropped with a gap marker.
+	var missed uint64
+	var ids []uint64
+	for len(c) > 0 {
+		e := <-c
+		if e.Type == EventGap {
+			missed += e.Missed
+			require.Empty(t, ids)
+			continue
+		}
+		ids = append(ids, e.ID)
+	}
+	require.Equal(t, uint64(10), missed+uint64(len(ids)))
+	require.Equal(t, []uint64{8, 9, 10}, ids)
+
+	// Once stopped, the channels are closed and the new ones are closed.
+	tw.Stop()
+	for range c {
+	}
+	c, cancel = tw.Watch(2)
+	_, ok := <-c
+	require.False(t, ok)
+	cancel()
+}
+
+func TestTimeWheel_Watch_Concurrent(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				c, cancel := tw.Watch(2)
+				tw.AfterFunc(time.Hour, func() {}).Close()
+				go func() {
+					for range c {
+					}
+				}()
+				cancel()
+			}
+		}()
+	}
+	var total uint64
+	c, cancel := tw.Watch(8)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for e := range c {
+			if e.Type == EventGap {
+				total += e.Missed
+			} else {
+				total++
+			}
+		}
+	}()
+	wg.Wait()
+	cancel()
+	<-done
+	require.LessOrEqual(t, total, uint64(400))
+	require.Empty(t, tw.root.watch.get())
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"time"
+)
+
+// WithWatchdog watches the tasks that run longer than the hard limit: once a
+// task is still running after the limit, f is called in a new goroutine with
+// the timer and the stacks of the goroutine running the task and the ones it
+// started, while the task is still stuck. The f is called at most once per
+// execution, and the task is never interrupted, see WithTaskTimeout for that.
+//
+// Each dispatch arms a timer of the TimeWheel itself that is closed once the
+// task returns, thus it costs little unless a task gets stuck; these timers
+// are counted in the Pending and the Stats like the others, but they're never
+// reported to the observers and watchers. The task runs with the pprof label
+// WatchdogLabel, which selects its goroutines out of the goroutine profile;
+// all the goroutines are dumped if none is found. The limit is measured by the
+// Clock of the TimeWheel.
+//
+// The tasks executed by the DispatchInline are not watched, since the
+// consumer goroutine that fires the watchdog is stuck with them.
+//
+// It panics if the limit is not positive or f is nil.
+func WithWatchdog(limit time.Duration, f func(t TimerInfo, stack []byte)) Option {
+	if limit <= 0 {
+		panic("timewheel: limit of watchdog must be greater than 0")
+	}
+	if f == nil {
+		panic("timewheel: func of watchdog must not be nil")
+	}
+	return func(o *options) {
+		o.watchdog = limit
+		o.onWatchdog = f
+	}
+}
+
+// WatchdogLabel is the key of the pprof label that the tasks run with if the
+// WithWatchdog is set, its value is unique to each execution.
+const WatchdogLabel = "timewheel.watchdog"
+
+// watched wraps the task func f of the timer t to arm a watchdog for each
+// execution.
+func (tw *TimeWheel) watched(t *Timer, f func(ctx context.Context)) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		w := tw.newWatchdog(t)
+		defer w.Close()
+		pprof.Do(ctx, pprof.Labels(WatchdogLabel, strconv.FormatUint(w.ID(), 10)), f)
+	}
+}
+
+// newWatchdog arms the watchdog of an execution of the timer t, it's a
+// run-once timer that barks once expired.
+func (tw *TimeWheel) newWatchdog(t *Timer) *Timer {
+	root := tw.root
+	w := &Timer{
+		expiration: root.now() + int64(root.opts.watchdog),
+		meta:       newMeta(tw.nextID(), 0, EndNone),
+		tw:         tw,
+	}
+	w.setAttrs().watchdog = true
+	w.task = func() {
+		go tw.bark(t, w.ID())
+		w.complete()
+	}
+	tw.schedule(w)
+	return w
+}
+
+// bark reports the task of the timer t is stuck, the id is the value of its
+// WatchdogLabel.
+func (tw *TimeWheel) bark(t *Timer, id uint64) {
+	root := tw.root
+	stack := goroutineStacks(strconv.FormatUint(id, 10))
+	if l := root.opts.logger; l != nil {
+		l.Warn("timewheel: task exceeded hard limit", timerAttr(t), slog.Duration("limit", root.opts.watchdog))
+	}
+	root.opts.onWatchdog(t.info(0), stack)
+}
+
+// goroutineStacks returns the stacks of the goroutines labeled with the
+// WatchdogLabel of the value, or all the goroutines if none is found.
+func goroutineStacks(value string) []byte {
+	var buf bytes.Buffer
+	_ = pprof.Lookup("goroutine").WriteTo(&buf, 1)
+	// The goroutines of the same stack and labels are grouped into a record,
+	// the records are separated by the blank lines.
+	label := []byte(strconv.Quote(WatchdogLabel) + ":" + strconv.Quote(value))
+	var stacks []byte
+	for _, record := range bytes.Split(buf.Bytes(), []byte("\n\n")) {
+		if bytes.Contains(record, label) {
+			stacks = append(stacks, record...)
+			stacks = append(stacks, '\n')
+		}
+	}
+	if stacks != nil {
+		return stacks
+	}
+
+	stack := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(stack, true)
+		if n < len(stack) {
+			return stack[:n]
+		}
+		stack = make([]byte, len(stack)*2)
+	}
+}
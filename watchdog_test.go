@@ -0,0 +1,82 @@
+package timewheel
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithWatchdog(t *testing.T) {
+	require.Panics(t, func() { WithWatchdog(0, func(TimerInfo, []byte) {}) })
+	require.Panics(t, func() { WithWatchdog(time.Second, nil) })
+}
+
+func TestTimeWheel_Watchdog(t *testing.T) {
+	var mu sync.Mutex
+	var infos []TimerInfo
+	var stacks []string
+	barked := make(chan struct{}, 1)
+	tw := New(time.Millisecond, 8, WithWatchdog(time.Millisecond*20, func(info TimerInfo, stack []byte) {
+		mu.Lock()
+		infos = append(infos, info)
+		stacks = append(stacks, string(stack))
+		mu.Unlock()
+		barked <- struct{}{}
+	}))
+	tw.Start()
+	defer tw.Stop()
+
+	// The fast task never barks.
+	<-tw.AfterFunc(time.Millisecond, func() {}).Done()
+
+	release := make(chan struct{})
+	stuck := tw.AfterFunc(time.Millisecond, func() { stuckTask(release) }, WithTag("stuck"))
+	select {
+	case <-barked:
+	case <-time.After(time.Second * 5):
+		t.Fatal("watchdog never barked")
+	}
+	close(release)
+	<-stuck.Done()
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, infos, 1)
+	require.Equal(t, stuck.ID(), infos[0].ID)
+	require.Equal(t, "stuck", infos[0].Tag)
+	// Only the stuck goroutine is dumped.
+	require.Contains(t, stacks[0], "stuckTask")
+	require.Contains(t, stacks[0], WatchdogLabel)
+	require.False(t, strings.Contains(stacks[0], "TestTimeWheel_Watchdog("))
+
+	// The watchdog timers are gone once the tasks returned.
+	require.Eventually(t, func() bool { return tw.Pending() == 0 }, time.Second, time.Millisecond)
+}
+
+func TestTimeWheel_Watchdog_Observer(t *testing.T) {
+	o := &eventObserver{}
+	tw := New(time.Millisecond, 8, WithObserver(o), WithWatchdog(time.Hour, func(TimerInfo, []byte) {}))
+	tw.Start()
+	defer tw.Stop()
+
+	c, cancel := tw.Watch(8)
+	defer cancel()
+	<-tw.AfterFunc(time.Millisecond, func() {}).Done()
+	require.Equal(t, EventScheduled, (<-c).Type)
+	require.Equal(t, EventFired, (<-c).Type)
+	require.Eventually(t, func() bool { return tw.Pending() == 0 }, time.Second, time.Millisecond)
+	select {
+	case e := <-c:
+		t.Fatalf("unexpected event %v", e.Type)
+	default:
+	}
+	require.Equal(t, []string{"schedule:", "fire:"}, o.get())
+}
+
+//go:noinline
+func stuckTask(release chan struct{}) {
+	<-release
+}
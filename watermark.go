@@ -0,0 +1,142 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// WithHighWatermark registers fn to be called when the number of pending timers
+// (see Pending) rises to the threshold, as an early warning before the wheel
+// is overloaded. It's edge-triggered: fn is called once per crossing rather
+// than on every scheduling, and it's called again only after the pending falls
+// back below the threshold, or to the low watermark if WithLowWatermark is set.
+//
+// The fn receives the pending at the crossing. It's called in a dedicated
+// goroutine from Start until the TimeWheel is stopped, never on the path of
+// scheduling, thus it may block without delaying any timer.
+func WithHighWatermark(threshold int64, fn func(pending int64)) Option {
+	if threshold < 1 {
+		panic("timewheel: threshold of high watermark must be greater than 0")
+	}
+	return func(o *options) {
+		o.highWatermark = threshold
+		o.onHighWatermark = fn
+	}
+}
+
+// WithLowWatermark registers fn to be called when the number of pending timers
+// falls back to the threshold after it rose to the high watermark. It's the
+// hysteresis point of WithHighWatermark, thus the pending that fluctuates around
+// the high watermark never notifies repeatedly. The threshold must be less than
+// the high watermark, and fn is called the same as the one of WithHighWatermark.
+func WithLowWatermark(threshold int64, fn func(pending int64)) Option {
+	if threshold < 0 {
+		panic("timewheel: threshold of low watermark must be greater than or equal to 0")
+	}
+	return func(o *options) {
+		o.lowWatermark = threshold
+		o.onLowWatermark = fn
+	}
+}
+
+// watermarkEvent is a crossing of the watermarks.
+type watermarkEvent struct {
+	high    bool
+	pending int64
+}
+
+// watermark detects the crossings of the watermarks, and notifies them in
+// order by a dedicated goroutine.
+type watermark struct {
+	high, low     int64
+	onHigh, onLow func(pending int64)
+	// 1 if the pending has risen to the high and not yet fallen to the low.
+	raised int32
+
+	// The mu protects the events, the notifyC wakes up the goroutine.
+	mu      sync.Mutex
+	events  []watermarkEvent
+	notifyC chan struct{}
+
+	startOnce sync.Once
+}
+
+func newWatermark(o *options) *watermark {
+	w := &watermark{
+		high:    o.highWatermark,
+		low:     o.highWatermark - 1,
+		onHigh:  o.onHighWatermark,
+		onLow:   o.onLowWatermark,
+		notifyC: make(chan struct{}, 1),
+	}
+	if o.onLowWatermark != nil {
+		if o.lowWatermark >= o.highWatermark {
+			panic("timewheel: low watermark must be less than the high watermark")
+		}
+		w.low = o.lowWatermark
+	}
+	return w
+}
+
+// rise is called with the pending after incremented. Since the pending
+// changes one by one, exactly one goroutine observes the crossing.
+func (w *watermark) rise(pending int64) {
+	if pending == w.high && atomic.CompareAndSwapInt32(&w.raised, 0, 1) {
+		w.post(watermarkEvent{high: true, pending: pending})
+	}
+}
+
+// fall is called with the pending after decremented.
+func (w *watermark) fall(pending int64) {
+	if pending == w.low && atomic.CompareAndSwapInt32(&w.raised, 1, 0) && w.onLow != nil {
+		w.post(watermarkEvent{high: false, pending: pending})
+	}
+}
+
+func (w *watermark) post(e watermarkEvent) {
+	w.mu.Lock()
+	w.events = append(w.events, e)
+	w.mu.Unlock()
+
+	select {
+	case w.notifyC <- struct{}{}:
+	default:
+		// The goroutine has been notified.
+	}
+}
+
+// start starts the goroutine that notifies until the stopC is closed, only
+// the first call takes effect.
+func (w *watermark) start(stopC <-chan struct{}) {
+	w.startOnce.Do(func() {
+		go w.run(stopC)
+	})
+}
+
+func (w *watermark) run(stopC <-chan struct{}) {
+	for {
+		select {
+		case <-stopC:
+			return
+		case <-w.notifyC:
+		}
+
+		w.mu.Lock()
+		events := w.events
+		w.events = nil
+		w.mu.Unlock()
+
+		for _, e := range events {
+			if e.high {
+				w.onHigh(e.pending)
+			} else {
+				w.onLow(e.pending)
+			}
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package timewheel
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// watermarkRecorder records the notifications of the watermarks.
+type watermarkRecorder struct {
+	mu     sync.Mutex
+	events []watermarkEvent
+}
+
+func (r *watermarkRecorder) high(pending int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, watermarkEvent{high: true, pending: pending})
+}
+
+func (r *watermarkRecorder) low(pending int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, watermarkEvent{high: false, pending: pending})
+}
+
+func (r *watermarkRecorder) get() []watermarkEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]watermarkEvent(nil), r.events...)
+}
+
+func TestWithHighWatermark(t *testing.T) {
+	rec := new(watermarkRecorder)
+	tw := New(time.Millisecond, 8, WithHighWatermark(3, rec.high))
+	tw.Start()
+	defer tw.Stop()
+
+	timers := make([]*Timer, 0, 4)
+	for i := 0; i < 4; i++ {
+		timers = append(timers, tw.AfterFunc(time.Hour, func() {}))
+	}
+	require.Eventually(t, func() bool { return len(rec.get()) == 1 }, time.Second, time.Millisecond)
+
+	// Fluctuates above the threshold.
+	timers[3].Close()
+	timers[3] = tw.AfterFunc(time.Hour, func() {})
+
+	// Falls below and rises again.
+	timers[3].Close()
+	timers[2].Close()
+	tw.AfterFunc(time.Hour, func() {})
+	require.Eventually(t, func() bool { return len(rec.get()) == 2 }, time.Second, time.Millisecond)
+
+	time.Sleep(time.Millisecond * 10)
+	require.Equal(t, []watermarkEvent{{high: true, pending: 3}, {high: true, pending: 3}}, rec.get())
+}
+
+func TestWithLowWatermark(t *testing.T) {
+	rec := new(watermarkRecorder)
+	tw := New(time.Millisecond, 8, WithHighWatermark(4, rec.high), WithLowWatermark(1, rec.low))
+
+	// Notified after started.
+	timers := make([]*Timer, 0, 4)
+	for i := 0; i < 4; i++ {
+		timers = append(timers, tw.AfterFunc(time.Hour, func() {}))
+	}
+	time.Sleep(time.Millisecond * 5)
+	require.Len(t, rec.get(), 0)
+	tw.Start()
+	defer tw.Stop()
+	require.Eventually(t, func() bool { return len(rec.get()) == 1 }, time.Second, time.Millisecond)
+
+	// The hysteresis: fluctuates between the low and high watermarks.
+	for i := 0; i < 3; i++ {
+		timers[3].Close()
+		timers[2].Close()
+		timers[2] = tw.AfterFunc(time.Hour, func() {})
+		timers[3] = tw.AfterFunc(time.Hour, func() {})
+	}
+	time.Sleep(time.Millisecond * 10)
+	require.Len(t, rec.get(), 1)
+
+	for _, timer := range timers[1:] {
+		timer.Close()
+	}
+	require.Eventually(t, func() bool { return len(rec.get()) == 2 }, time.Second, time.Millisecond)
+	require.Equal(t, []watermarkEvent{{high: true, pending: 4}, {high: false, pending: 1}}, rec.get())
+}
+
+func TestWithHighWatermark_Concurrent(t *testing.T) {
+	rec := new(watermarkRecorder)
+	tw := New(time.Millisecond, 8, WithHighWatermark(500, rec.high), WithLowWatermark(100, rec.low))
+	tw.Start()
+	defer tw.Stop()
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				tw.AfterFunc(time.Millisecond*time.Duration(1+j%10), func() {})
+			}
+		}()
+	}
+	wg.Wait()
+	require.Eventually(t, func() bool { return tw.Pending() == 0 }, time.Second*5, time.Millisecond)
+	time.Sleep(time.Millisecond * 10)
+
+	// Never more than once per crossing, and alternately.
+	events := rec.get()
+	for i, e := range events {
+		require.Equal(t, i%2 == 0, e.high)
+	}
+}
+
+func TestWithWatermark_Invalid(t *testing.T) {
+	require.Panics(t, func() { WithHighWatermark(0, func(int64) {}) })
+	require.Panics(t, func() { WithLowWatermark(-1, func(int64) {}) })
+	require.Panics(t, func() {
+		New(time.Millisecond, 8, WithHighWatermark(2, func(int64) {}), WithLowWatermark(2, func(int64) {}))
+	})
+}
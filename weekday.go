@@ -0,0 +1,188 @@
+// Copyright (c) 2020, Yu Wu <yu.771991@gmail.com> All rights reserved.
+//
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package timewheel
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WeekdaySchedule is an execution plan on some days of week at some times of
+// day, it's created by OnDays, e.g.:
+//
+//	timer, err := timewheel.OnDays(time.Monday, time.Thursday).At("09:00").In(loc).Do(tw, f)
+//
+// The errors of the parameters are reported by Do.
+type WeekdaySchedule struct {
+	days  [7]bool
+	times []time.Duration // the offsets of the times of day, sorted.
+	loc   *time.Location
+	err   error
+}
+
+// OnDays starts building a WeekdaySchedule on the given days of week.
+func OnDays(days ...time.Weekday) *WeekdaySchedule {
+	ws := &WeekdaySchedule{loc: time.Local}
+	if len(days) == 0 {
+		ws.err = fmt.Errorf("%w: no day of week", ErrInvalidSchedule)
+	}
+	for _, d := range days {
+		if d < time.Sunday || d > time.Saturday {
+			ws.err = fmt.Errorf("%w: invalid day of week %d", ErrInvalidSchedule, d)
+			continue
+		}
+		ws.days[d] = true
+	}
+	return ws
+}
+
+// At adds a time of day in the form of "HH:MM" or "HH:MM:SS" in 24-hour clock,
+// it can be called more than once for multiple times a day. Default is "00:00".
+func (ws *WeekdaySchedule) At(clock string) *WeekdaySchedule {
+	d, err := parseClock(clock)
+	if err != nil {
+		if ws.err == nil {
+			ws.err = err
+		}
+		return ws
+	}
+	i := sort.Search(len(ws.times), func(i int) bool { return ws.times[i] >= d })
+	if i == len(ws.times) || ws.times[i] != d {
+		ws.times = append(ws.times, 0)
+		copy(ws.times[i+1:], ws.times[i:])
+		ws.times[i] = d
+	}
+	return ws
+}
+
+// In sets the location that the days and times are in, default is time.Local.
+func (ws *WeekdaySchedule) In(loc *time.Location) *WeekdaySchedule {
+	if loc == nil {
+		if ws.err == nil {
+			ws.err = fmt.Errorf("%w: nil location", ErrInvalidSchedule)
+		}
+		return ws
+	}
+	ws.loc = loc
+	return ws
+}
+
+// parseClock parses the "HH:MM" or "HH:MM:SS" to the offset from midnight.
+func parseClock(clock string) (time.Duration, error) {
+	parts := strings.Split(clock, ":")
+	if len(parts) != 2 && len(parts) != 3 {
+		return 0, fmt.Errorf("%w: invalid time of day %q, must be HH:MM or HH:MM:SS", ErrInvalidSchedule, clock)
+	}
+	limits := [...]int{24, 60, 60}
+	units := [...]time.Duration{time.Hour, time.Minute, time.Second}
+	var d time.Duration
+	for i, unit := range units[:len(parts)] {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil || len(parts[i]) != 2 || n < 0 || n >= limits[i] {
+			return 0, fmt.Errorf("%w: invalid time of day %q", ErrInvalidSchedule, clock)
+		}
+		d += time.Duration(n) * unit
+	}
+	return d, nil
+}
+
+// Next returns the first execution time after the given time, or a zero time
+// if the schedule is invalid.
+//
+// The days and times are evaluated in the location of the schedule. If a time
+// doesn't exist on a day since it's skipped by a daylight saving transition,
+// it's shifted forward by the length of the transition, e.g. the "02:30"
+// becomes the "03:30" in the day that the clock jumps from 02:00 to 03:00.
+// If a time occurs twice on a day since the clock falls back, the execution
+// is at one of them only.
+func (ws *WeekdaySchedule) Next(after time.Time) time.Time {
+	if ws.err != nil {
+		return time.Time{}
+	}
+	times := ws.times
+	if len(times) == 0 {
+		times = []time.Duration{0}
+	}
+
+	local := after.In(ws.loc)
+	year, month, day := local.Date()
+	// 8 days covers the same day of the next week.
+	for i := 0; i <= 7; i++ {
+		date := time.Date(year, month, day+i, 0, 0, 0, 0, ws.loc)
+		if !ws.days[date.Weekday()] {
+			continue
+		}
+		for _, d := range times {
+			t := wallTime(date.Year(), date.Month(), date.Day(), d, ws.loc)
+			if t.After(after) {
+				return t
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// wallTime returns the time of the wall clock at offset d of the date in loc,
+// a time skipped by the daylight saving transition is shifted forward.
+func wallTime(year int, month time.Month, day int, d time.Duration, loc *time.Location) time.Time {
+	h, m, s := int(d/time.Hour), int(d/time.Minute%60), int(d/time.Second%60)
+	t := time.Date(year, month, day, h, m, s, 0, loc)
+	if t.Hour() == h && t.Minute() == m && t.Second() == s {
+		return t
+	}
+	// The wall time is in a gap, use the offset before the transition.
+	_, offset := t.Add(-3 * time.Hour).Zone()
+	naive := time.Date(year, month, day, h, m, s, 0, time.UTC)
+	return naive.Add(-time.Duration(offset) * time.Second).In(loc)
+}
+
+// Do validates the schedule and schedules f to execute according to it.
+// It returns a *ScheduleError that wraps ErrInvalidSchedule if the parameters
+// are invalid or there is no execution in the future, ErrStopped if the
+// TimeWheel has been stopped, or ErrQuotaExceeded if the quota of the tag is
+// reached (see SetQuota).
+func (ws *WeekdaySchedule) Do(tw *TimeWheel, f func(), opts ...TimerOption) (*Timer, error) {
+	return tw.doPlan("OnDays", ws.Next, ws.err, f, opts)
+}
+
+// doPlan schedules f to execute at the times of the execution plan next, it's
+// the Do of the plans named op, e.g. OnDays. The invalid is the error of the
+// parameters of the plan.
+func (tw *TimeWheel) doPlan(op string, next func(time.Time) time.Time, invalid error, f func(), opts []TimerOption) (*Timer, error) {
+	var first time.Time
+	err := invalid
+	if err == nil {
+		if tw.stoppedNow() {
+			err = ErrStopped
+		} else if first = next(tw.timeNow()); first.IsZero() {
+			err = fmt.Errorf("%w: %s has no execution after now", ErrInvalidSchedule, op)
+		}
+	}
+	if err == nil {
+		t := tw.Schedule(&planScheduler{next: next, run: f}, opts...)
+		if err = t.rejected(); err == nil {
+			return t, nil
+		}
+	}
+	return nil, scheduleError(op, first, opts, err)
+}
+
+// planScheduler is a Scheduler that combines an execution plan and a task.
+type planScheduler struct {
+	next func(time.Time) time.Time
+	run  func()
+}
+
+func (s *planScheduler) Next(prev time.Time) time.Time {
+	return s.next(prev)
+}
+
+func (s *planScheduler) Run() {
+	s.run()
+}
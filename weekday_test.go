@@ -0,0 +1,105 @@
+package timewheel
+
+import (
+	"errors"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWeekdaySchedule_Next(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	at := func(s string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04:05", s, ny)
+		require.NoError(t, err)
+		return v
+	}
+
+	monThu := OnDays(time.Monday, time.Thursday).At("09:00").In(ny)
+	cases := []struct {
+		ws    *WeekdaySchedule
+		after time.Time
+		want  time.Time
+	}{
+		// 2021-03-01 is a Monday.
+		{monThu, at("2021-03-01 08:00:00"), at("2021-03-01 09:00:00")},
+		{monThu, at("2021-03-01 09:00:00"), at("2021-03-04 09:00:00")},
+		{monThu, at("2021-03-04 10:00:00"), at("2021-03-08 09:00:00")},
+		{monThu, at("2021-02-28 23:59:59"), at("2021-03-01 09:00:00")},
+		// Across the month and year boundaries.
+		{monThu, at("2021-04-30 12:00:00"), at("2021-05-03 09:00:00")},
+		{monThu, at("2021-12-31 10:00:00"), at("2022-01-03 09:00:00")},
+		// The same day of the next week.
+		{OnDays(time.Monday).At("09:00").In(ny), at("2021-03-01 09:00:01"), at("2021-03-08 09:00:00")},
+		// Across the daylight saving transition, the wall time is kept.
+		{OnDays(time.Monday).At("09:00").In(ny), at("2021-03-08 09:00:00"), at("2021-03-15 09:00:00")},
+		{OnDays(time.Monday).At("09:00").In(ny), at("2021-11-01 09:00:00"), at("2021-11-08 09:00:00")},
+		// The 02:30 is skipped on 2021-03-14, shifted forward to 03:30.
+		{OnDays(time.Sunday).At("02:30").In(ny), at("2021-03-13 12:00:00"), at("2021-03-14 03:30:00")},
+		{OnDays(time.Sunday).At("02:30").In(ny), at("2021-03-14 03:30:00"), at("2021-03-21 02:30:00")},
+		// Multiple times a day, in any order.
+		{OnDays(time.Monday).At("18:00").At("09:00").In(ny), at("2021-03-01 10:00:00"), at("2021-03-01 18:00:00")},
+		{OnDays(time.Monday).At("18:00").At("09:00").In(ny), at("2021-03-01 18:00:00"), at("2021-03-08 09:00:00")},
+		// With seconds, and the default midnight.
+		{OnDays(time.Friday).At("09:00:30").In(ny), at("2021-03-05 09:00:00"), at("2021-03-05 09:00:30")},
+		{OnDays(time.Friday).In(ny), at("2021-03-05 09:00:00"), at("2021-03-12 00:00:00")},
+		{OnDays(time.Sunday, time.Saturday).At("23:59:59").In(ny), at("2021-03-05 00:00:00"), at("2021-03-06 23:59:59")},
+	}
+	for i, c := range cases {
+		got := c.ws.Next(c.after)
+		require.True(t, c.want.Equal(got), "case %d: want %s, got %s", i, c.want, got)
+	}
+
+	// The 01:30 occurs twice on 2021-11-07, the execution is at one of them only.
+	ws := OnDays(time.Sunday).At("01:30").In(ny)
+	first := ws.Next(at("2021-11-06 12:00:00"))
+	require.Equal(t, "01:30", first.In(ny).Format("15:04"))
+	require.True(t, at("2021-11-14 01:30:00").Equal(ws.Next(first)))
+
+	// In a location other than the argument.
+	ws = OnDays(time.Monday).At("09:00").In(time.UTC)
+	require.True(t, time.Date(2021, 3, 1, 9, 0, 0, 0, time.UTC).Equal(ws.Next(at("2021-03-01 00:00:00"))))
+}
+
+func TestWeekdaySchedule_Invalid(t *testing.T) {
+	cases := []*WeekdaySchedule{
+		OnDays(),
+		OnDays(time.Weekday(7)),
+		OnDays(time.Monday).In(nil),
+	}
+	for _, clock := range []string{"", "9:00", "09", "24:00", "12:60", "12:00:60", "aa:bb", "-1:00", "12:00:00:00", "12:0a"} {
+		cases = append(cases, OnDays(time.Monday).At(clock))
+	}
+
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+	for i, ws := range cases {
+		require.True(t, ws.Next(time.Now()).IsZero(), "case %d", i)
+
+		timer, err := ws.Do(tw, func() {}, WithTag("weekly"))
+		require.Nil(t, timer)
+		require.True(t, errors.Is(err, ErrInvalidSchedule), "case %d: %v", i, err)
+		var se *ScheduleError
+		require.True(t, errors.As(err, &se))
+		require.Equal(t, "OnDays", se.Op)
+		require.Equal(t, "weekly", se.Tag)
+	}
+}
+
+func TestWeekdaySchedule_Do(t *testing.T) {
+	tw := New(time.Millisecond, 8)
+	tw.Start()
+
+	timer, err := OnDays(time.Monday).At("09:00").Do(tw, func() {})
+	require.NoError(t, err)
+	require.Equal(t, StateScheduled, timer.State())
+	timer.Close()
+
+	tw.Stop()
+	_, err = OnDays(time.Monday).Do(tw, func() {})
+	require.True(t, errors.Is(err, ErrStopped))
+}